@@ -26,6 +26,8 @@ func NewRootCmd() *cobra.Command {
 	root.AddCommand(newLaunchCmd())
 	root.AddCommand(newConfigCmd())
 	root.AddCommand(newProfileCmd())
+	root.AddCommand(newMockUpstreamCmd())
+	root.AddCommand(newLogsCmd())
 	return root
 }
 
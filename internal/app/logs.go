@@ -0,0 +1,37 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"spark/internal/integrations"
+)
+
+func newLogsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Manage the compatibility proxies' debug logs",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "purge",
+		Short: "Delete every day's Codex, Claude and chat proxy log",
+		Long: "Deletes the daily proxy logs under ~/.spark/logs (or the paths set by\n" +
+			"AGENT_LAUNCH_COMPAT_LOG, AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG and\n" +
+			"AGENT_LAUNCH_CHAT_COMPAT_LOG). A proxy still running keeps writing to\n" +
+			"the file it has open until it exits.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			removed, err := integrations.PurgeLogs()
+			out := cmd.OutOrStdout()
+			for _, path := range removed {
+				fmt.Fprintf(out, "Removed %s\n", path)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Purged %d log files\n", len(removed))
+			return nil
+		},
+	})
+	return cmd
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"spark/internal/mockupstream"
+)
+
+func newMockUpstreamCmd() *cobra.Command {
+	var addr string
+	var scenario string
+	var scriptPath string
+	var model string
+	var models []string
+
+	cmd := &cobra.Command{
+		Use:   "mock-upstream",
+		Short: "Run a scriptable fake OpenAI-compatible upstream for offline testing",
+		Long: "Serves chat/completions (and Ollama's /api/chat) from scripted scenarios.\n" +
+			"Scenarios from --script are served in order, then --scenario for every\n" +
+			"later request. Built-in scenarios: " + strings.Join(mockupstream.BuiltinNames(), ", ") + ".",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fallback, err := mockupstream.LookupBuiltin(scenario, model)
+			if err != nil {
+				return err
+			}
+			srv := mockupstream.NewServer()
+			srv.SetDefault(fallback)
+			srv.SetModels(append([]string{model}, models...)...)
+			if scriptPath != "" {
+				f, err := os.Open(scriptPath)
+				if err != nil {
+					return err
+				}
+				scripted, err := mockupstream.LoadScript(f)
+				_ = f.Close()
+				if err != nil {
+					return fmt.Errorf("read script %s: %w", scriptPath, err)
+				}
+				srv.Enqueue(scripted...)
+			}
+			if err := srv.Start(addr); err != nil {
+				return err
+			}
+			defer srv.Close()
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Mock upstream listening on %s (scenario %q)\n", srv.URL(), scenario)
+			fmt.Fprintf(out, "Point a profile's openai_base_url at it; press Ctrl+C to stop.\n")
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			<-ctx.Done()
+			fmt.Fprintf(out, "Served %d requests\n", len(srv.Requests()))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", "127.0.0.1:8787", "Listen address")
+	cmd.Flags().StringVar(&scenario, "scenario", "text", "Built-in scenario served once the script is used up")
+	cmd.Flags().StringVar(&scriptPath, "script", "", "JSON file with an array of scenarios to serve first")
+	cmd.Flags().StringVar(&model, "model", "mock-model", "Model name reported in responses and /models")
+	cmd.Flags().StringSliceVar(&models, "models", nil, "Extra model IDs listed on /models")
+	return cmd
+}
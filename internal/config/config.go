@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,15 +12,133 @@ import (
 
 const currentVersion = 1
 
+// Upstream API kinds for Profile.Provider, which names the API spoken at
+// OpenAIBaseURL. An empty provider means OpenAI chat/completions.
+const (
+	ProviderOpenAI = "openai"
+	ProviderGemini = "gemini"
+	ProviderAzure  = "azure"
+	ProviderOllama = "ollama"
+)
+
+// Key selection strategies for Profile.KeyStrategy. Round-robin is the
+// default.
+const (
+	KeyStrategyRoundRobin           = "round-robin"
+	KeyStrategyLeastRecentlyLimited = "least-recently-limited"
+)
+
+// Text tool-call formats for Profile.ToolCallParsers. Auto accepts all of
+// them.
+const (
+	ToolCallParserAuto    = "auto"
+	ToolCallParserHermes  = "hermes"
+	ToolCallParserQwen    = "qwen"
+	ToolCallParserLlama   = "llama"
+	ToolCallParserMistral = "mistral"
+)
+
+// DefaultAzureAPIVersion is used for Azure profiles without azure_api_version.
+const DefaultAzureAPIVersion = "2024-10-21"
+
 type Profile struct {
-	OpenAIBaseURL      string   `json:"openai_base_url"`
-	OpenAIAPIKey       string   `json:"openai_api_key"`
-	OpenAIOrg          string   `json:"openai_org,omitempty"`
-	OpenAIProject      string   `json:"openai_project,omitempty"`
-	AnthropicBaseURL   string   `json:"anthropic_base_url,omitempty"`
-	AnthropicAuthToken string   `json:"anthropic_auth_token,omitempty"`
-	Models             []string `json:"models,omitempty"`
-	DefaultModel       string   `json:"default_model,omitempty"`
+	// Name is the profile's key in RootConfig.Profiles, filled in by
+	// ProfileByName.
+	Name               string            `json:"-"`
+	OpenAIBaseURL      string            `json:"openai_base_url"`
+	OpenAIAPIKey       string            `json:"openai_api_key"`
+	OpenAIAPIKeys      []string          `json:"openai_api_keys,omitempty"`
+	KeyStrategy        string            `json:"key_strategy,omitempty"`
+	OpenAIOrg          string            `json:"openai_org,omitempty"`
+	OpenAIProject      string            `json:"openai_project,omitempty"`
+	Provider           string            `json:"provider,omitempty"`
+	AzureAPIVersion    string            `json:"azure_api_version,omitempty"`
+	AzureDeployments   map[string]string `json:"azure_deployments,omitempty"`
+	OllamaNumCtx       int               `json:"ollama_num_ctx,omitempty"`
+	OllamaKeepAlive    string            `json:"ollama_keep_alive,omitempty"`
+	AnthropicBaseURL   string            `json:"anthropic_base_url,omitempty"`
+	AnthropicAuthToken string            `json:"anthropic_auth_token,omitempty"`
+	Models             []string          `json:"models,omitempty"`
+	DefaultModel       string            `json:"default_model,omitempty"`
+	Fallbacks          []Fallback        `json:"fallbacks,omitempty"`
+	Retry              *RetryPolicy      `json:"retry,omitempty"`
+	Limits             *RateLimits       `json:"limits,omitempty"`
+	Timeouts           *StreamTimeouts   `json:"timeouts,omitempty"`
+	// ToolCallParsers maps model names ("*" for any) to the format the model
+	// writes tool calls in when it lacks native tool calling.
+	ToolCallParsers map[string]string `json:"tool_call_parsers,omitempty"`
+	// WebSearch is the search backend the Codex compat proxy answers the
+	// web_search tool with. Without it web_search calls go to the client.
+	WebSearch *WebSearch `json:"web_search,omitempty"`
+	// LogBodies overrides RootConfig.LogBodies for this profile.
+	LogBodies string `json:"log_bodies,omitempty"`
+
+	// rootLogBodies is RootConfig.LogBodies, filled in by ProfileByName.
+	rootLogBodies string
+}
+
+// RateLimits caps the traffic the compat proxies send to the profile's own
+// upstream. Zero fields are unlimited.
+type RateLimits struct {
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	TokensPerMinute   int `json:"tokens_per_minute,omitempty"`
+	MaxConcurrent     int `json:"max_concurrent,omitempty"`
+}
+
+// StreamTimeouts bounds how long the compat proxies wait on an upstream:
+// FirstByte until the first response bytes arrive, Idle between later
+// chunks. Durations use Go syntax; empty values take the defaults.
+type StreamTimeouts struct {
+	FirstByte string `json:"first_byte,omitempty"`
+	Idle      string `json:"idle,omitempty"`
+}
+
+// WebSearch is a JSON search API queried with GET <URL>?q=<query>. Results
+// are read from a top-level "results" list (SearXNG, Tavily) or from
+// "web.results" (Brave).
+type WebSearch struct {
+	URL    string `json:"url"`
+	APIKey string `json:"api_key,omitempty"`
+	// MaxResults caps the results passed to the model; zero means 5.
+	MaxResults int `json:"max_results,omitempty"`
+}
+
+// RetryPolicy tunes how the compat proxies retry transient upstream errors
+// before answering the client. Durations use Go syntax ("500ms", "30s");
+// zero values take the proxies' defaults.
+type RetryPolicy struct {
+	MaxAttempts    int    `json:"max_attempts,omitempty"`
+	StatusCodes    []int  `json:"status_codes,omitempty"`
+	InitialBackoff string `json:"initial_backoff,omitempty"`
+	MaxBackoff     string `json:"max_backoff,omitempty"`
+	Budget         string `json:"budget,omitempty"`
+}
+
+// Fallback is an upstream the compat proxies try, in order, when the
+// profile's own endpoint is unreachable or failing with 5xx.
+type Fallback struct {
+	BaseURL string `json:"base_url"`
+	APIKey  string `json:"api_key,omitempty"`
+	// Provider defaults to the profile's provider.
+	Provider string `json:"provider,omitempty"`
+	// Model, when set, replaces the requested model on this upstream.
+	Model string `json:"model,omitempty"`
+}
+
+// AzureChatCompletionsURL returns the chat/completions URL serving model on an
+// Azure OpenAI resource. OpenAIBaseURL holds the resource endpoint; the
+// deployment comes from AzureDeployments and defaults to the model name.
+func (p *Profile) AzureChatCompletionsURL(model string) string {
+	deployment := model
+	if d := strings.TrimSpace(p.AzureDeployments[model]); d != "" {
+		deployment = d
+	}
+	version := strings.TrimSpace(p.AzureAPIVersion)
+	if version == "" {
+		version = DefaultAzureAPIVersion
+	}
+	endpoint := strings.TrimSuffix(strings.TrimRight(strings.TrimSpace(p.OpenAIBaseURL), "/"), "/openai")
+	return endpoint + "/openai/deployments/" + url.PathEscape(deployment) + "/chat/completions?api-version=" + url.QueryEscape(version)
 }
 
 type IntegrationConfig struct {
@@ -39,6 +158,9 @@ type RootConfig struct {
 	Profiles       map[string]*Profile           `json:"profiles"`
 	Integrations   map[string]*IntegrationConfig `json:"integrations"`
 	History        History                       `json:"history,omitempty"`
+	// LogBodies is how much request and response content the compatibility
+	// adapters log: metadata, truncated (the default) or full.
+	LogBodies string `json:"log_bodies,omitempty"`
 }
 
 func defaultConfig() *RootConfig {
@@ -150,6 +272,8 @@ func (c *RootConfig) ProfileByName(name string) (*Profile, error) {
 	if p == nil {
 		return nil, fmt.Errorf("profile not found: %s", name)
 	}
+	p.Name = name
+	p.rootLogBodies = c.LogBodies
 	return p, nil
 }
 
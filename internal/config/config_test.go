@@ -99,6 +99,78 @@ func TestSetDefaultProfileRequiresExistingProfile(t *testing.T) {
 	}
 }
 
+func TestAzureChatCompletionsURL(t *testing.T) {
+	p := &Profile{
+		OpenAIBaseURL:    "https://res.openai.azure.com/openai/",
+		AzureDeployments: map[string]string{"gpt-4o": "prod-4o"},
+	}
+	if got, want := p.AzureChatCompletionsURL("gpt-4o"), "https://res.openai.azure.com/openai/deployments/prod-4o/chat/completions?api-version="+DefaultAzureAPIVersion; got != want {
+		t.Fatalf("mapped deployment URL mismatch:\n got %s\nwant %s", got, want)
+	}
+	p.AzureAPIVersion = "2025-01-01-preview"
+	if got, want := p.AzureChatCompletionsURL("o3-mini"), "https://res.openai.azure.com/openai/deployments/o3-mini/chat/completions?api-version=2025-01-01-preview"; got != want {
+		t.Fatalf("unmapped deployment URL mismatch:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestLogBodiesLevelHonoursPolicyCap(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	orig := systemPolicyPath
+	systemPolicyPath = policyPath
+	t.Cleanup(func() { systemPolicyPath = orig })
+	t.Setenv("AGENT_LAUNCH_POLICY_FILE", "")
+
+	cfg := defaultConfig()
+	cfg.LogBodies = LogBodiesFull
+	cfg.Profiles["private"] = &Profile{LogBodies: LogBodiesMetadata}
+	for name, want := range map[string]string{"default": LogBodiesFull, "private": LogBodiesMetadata} {
+		p, _ := cfg.ProfileByName(name)
+		if got, err := p.LogBodiesLevel(); err != nil || got != want {
+			t.Fatalf("profile %s: got %q, %v; want %q", name, got, err, want)
+		}
+	}
+
+	if err := os.WriteFile(policyPath, []byte(`{"log_bodies":"metadata"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := cfg.ProfileByName("default")
+	if got, err := p.LogBodiesLevel(); err != nil || got != LogBodiesMetadata {
+		t.Fatalf("expected the policy to cap full to metadata, got %q, %v", got, err)
+	}
+
+	userPolicy := filepath.Join(t.TempDir(), "user-policy.json")
+	if err := os.WriteFile(userPolicy, []byte(`{"log_bodies":"full"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{userPolicy, filepath.Join(t.TempDir(), "missing.json")} {
+		t.Setenv("AGENT_LAUNCH_POLICY_FILE", path)
+		if got, err := p.LogBodiesLevel(); err != nil || got != LogBodiesMetadata {
+			t.Fatalf("expected %s not to lift the system policy, got %q, %v", path, got, err)
+		}
+	}
+	if err := os.Remove(policyPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(userPolicy, []byte(`{"log_bodies":"truncated"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AGENT_LAUNCH_POLICY_FILE", userPolicy)
+	if got, err := p.LogBodiesLevel(); err != nil || got != LogBodiesTruncated {
+		t.Fatalf("expected AGENT_LAUNCH_POLICY_FILE to tighten the level, got %q, %v", got, err)
+	}
+	t.Setenv("AGENT_LAUNCH_POLICY_FILE", "")
+
+	if err := os.WriteFile(policyPath, []byte(`{"log_bodies":"none"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.LogBodiesLevel(); err == nil {
+		t.Fatalf("expected an invalid policy to be an error")
+	}
+	if _, err := (&Profile{LogBodies: "verbose"}).LogBodiesLevel(); err == nil {
+		t.Fatalf("expected an unknown level to be an error")
+	}
+}
+
 func homeDirFromTest(t *testing.T) string {
 	t.Helper()
 	return os.Getenv("HOME")
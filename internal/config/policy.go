@@ -0,0 +1,100 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Levels for log_bodies, from least to most content logged.
+const (
+	LogBodiesMetadata  = "metadata"
+	LogBodiesTruncated = "truncated"
+	LogBodiesFull      = "full"
+)
+
+var logBodiesLevels = []string{LogBodiesMetadata, LogBodiesTruncated, LogBodiesFull}
+
+// systemPolicyPath is the organisation's policy file. It always applies; it
+// is a variable only so tests can move it.
+var systemPolicyPath = "/etc/spark/policy.json"
+
+// Policy is an organisation-managed file that caps what users may configure.
+type Policy struct {
+	// LogBodies is the most content any profile may log.
+	LogBodies string `json:"log_bodies,omitempty"`
+}
+
+// policyPaths are the policy files to enforce: /etc/spark/policy.json and
+// AGENT_LAUNCH_POLICY_FILE when set.
+func policyPaths() []string {
+	paths := []string{systemPolicyPath}
+	if path := strings.TrimSpace(os.Getenv("AGENT_LAUNCH_POLICY_FILE")); path != "" && path != systemPolicyPath {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// LoadPolicy reads the policy files and keeps the strictest cap of each, so
+// AGENT_LAUNCH_POLICY_FILE can tighten the system policy but never lift it.
+// A missing file is an empty policy.
+func LoadPolicy() (*Policy, error) {
+	merged := &Policy{}
+	for _, path := range policyPaths() {
+		p, err := loadPolicyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if p.LogBodies != "" && (merged.LogBodies == "" ||
+			slices.Index(logBodiesLevels, p.LogBodies) < slices.Index(logBodiesLevels, merged.LogBodies)) {
+			merged.LogBodies = p.LogBodies
+		}
+	}
+	return merged, nil
+}
+
+func loadPolicyFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Policy{}, nil
+		}
+		return nil, fmt.Errorf("read policy %s: %w", path, err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", path, err)
+	}
+	if p.LogBodies != "" && !slices.Contains(logBodiesLevels, p.LogBodies) {
+		return nil, fmt.Errorf("policy %s: unknown log_bodies %q", path, p.LogBodies)
+	}
+	return &p, nil
+}
+
+// LogBodiesLevel is the profile's log_bodies, else the config-wide one,
+// else truncated, lowered to the policy's cap. An unreadable policy is an
+// error rather than no cap.
+func (p *Profile) LogBodiesLevel() (string, error) {
+	level := LogBodiesTruncated
+	if p != nil {
+		switch {
+		case p.LogBodies != "":
+			level = p.LogBodies
+		case p.rootLogBodies != "":
+			level = p.rootLogBodies
+		}
+	}
+	if !slices.Contains(logBodiesLevels, level) {
+		return "", fmt.Errorf("unknown log_bodies %q (want metadata, truncated or full)", level)
+	}
+	policy, err := LoadPolicy()
+	if err != nil {
+		return "", err
+	}
+	if policy.LogBodies != "" && slices.Index(logBodiesLevels, level) > slices.Index(logBodiesLevels, policy.LogBodies) {
+		level = policy.LogBodies
+	}
+	return level, nil
+}
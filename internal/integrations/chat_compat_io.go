@@ -0,0 +1,9 @@
+package integrations
+
+import "io"
+
+var chatCompatLog = proxyLogFile{envKey: "AGENT_LAUNCH_CHAT_COMPAT_LOG", fileName: "chat-compat.log"}
+
+func openChatCompatLogFile() (io.WriteCloser, string, error) {
+	return openProxyLogFile(chatCompatLog)
+}
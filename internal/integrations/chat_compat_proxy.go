@@ -0,0 +1,257 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"spark/internal/config"
+)
+
+// chatCompatProxy serves OpenAI chat/completions on top of an upstream that
+// does not speak it, for editor integrations that can only be pointed at a
+// plain chat/completions base URL.
+type chatCompatProxy struct {
+	server   *http.Server
+	listener net.Listener
+	baseURL  string
+	upstream chatUpstream
+	executor ChatExecutor
+	models   *modelCatalog
+	logFile  io.WriteCloser
+	log      *compatLogger
+	logPath  string
+}
+
+func startChatCompatProxy(profile *config.Profile) (*chatCompatProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	logFile, logPath, err := openChatCompatLogFile()
+	if err != nil {
+		return nil, err
+	}
+	log, err := newCompatLogger(logFile, "chat", profile)
+	if err != nil {
+		_ = ln.Close()
+		_ = logFile.Close()
+		return nil, err
+	}
+	p := &chatCompatProxy{
+		listener: ln,
+		baseURL:  "http://" + ln.Addr().String() + "/v1",
+		logFile:  logFile,
+		log:      log,
+		logPath:  logPath,
+	}
+	p.logf("logger initialized log_bodies=%s", log.bodies)
+	if anthropicOnlyProfile(profile) {
+		p.upstream = &anthropicMessagesUpstream{
+			client:  newStreamingHTTPClient(),
+			baseURL: anthropicBaseURL(profile),
+			token:   profile.AnthropicAuthToken,
+			logf:    p.logf,
+		}
+	} else {
+		client := newStreamingHTTPClient()
+		upstream, err := newChatUpstream(profile, client, p.logf)
+		if err == nil && upstream == nil {
+			if keys := newKeyPool(profile); keys != nil {
+				upstream = &openAIChatUpstream{
+					client:  client,
+					baseURL: strings.TrimRight(profileBase(profile), "/"),
+					key:     profileKey(profile),
+					keys:    keys,
+					logf:    p.logf,
+				}
+			} else {
+				err = fmt.Errorf("profile needs no chat compatibility proxy")
+			}
+		}
+		if err != nil {
+			_ = ln.Close()
+			_ = logFile.Close()
+			return nil, err
+		}
+		p.upstream = upstream
+	}
+	fallbacks, err := newUpstreamChain(profile, newStreamingHTTPClient(), p.logf)
+	var retry *retryPolicy
+	if err == nil {
+		retry, err = newRetryPolicy(profile)
+	}
+	var timeouts *streamTimeouts
+	if err == nil {
+		timeouts, err = newStreamTimeouts(profile)
+	}
+	var replay *cassetteReplayer
+	if err == nil {
+		replay, err = newCassetteReplayer("chat", p.logf)
+	}
+	if err != nil {
+		_ = ln.Close()
+		_ = logFile.Close()
+		return nil, err
+	}
+	fallbacks.wrapFallbacks(timeouts.executor)
+	var executor ChatExecutor = p.upstream
+	executor = timeouts.executor(executor)
+	executor = newUpstreamLimiter(profile).executor(executor, p.logf)
+	executor = fallbacks.executor(executor)
+	executor = retry.executor(executor, p.logf)
+	p.executor = newCassetteRecorder("chat", log.bodies, p.logf).executor(executor)
+	fetchModels := p.upstream.fetchModels
+	if replay != nil {
+		p.executor = replay
+		fetchModels = replay.fetchModels
+	}
+	p.models = newModelCatalog(profileModelIDs(profile), fetchModels)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", p.handleChatCompletions)
+	mux.HandleFunc("/chat/completions", p.handleChatCompletions)
+	mux.HandleFunc("/v1/models", p.handleModels)
+	mux.HandleFunc("/v1/models/", p.handleModels)
+	p.server = &http.Server{Handler: mux}
+	go func() {
+		_ = p.server.Serve(ln)
+	}()
+	return p, nil
+}
+
+func (p *chatCompatProxy) BaseURL() string { return p.baseURL }
+
+func (p *chatCompatProxy) LogPath() string { return p.logPath }
+
+func (p *chatCompatProxy) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := p.server.Shutdown(ctx)
+	if p.logFile != nil {
+		_ = p.logFile.Close()
+	}
+	return err
+}
+
+func (p *chatCompatProxy) logf(format string, args ...any) {
+	p.log.logf(format, args...)
+}
+
+func (p *chatCompatProxy) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	lw, r := p.log.begin(w, r)
+	defer lw.finish()
+	w = lw
+	logf := lw.log.logf
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req, rawBody, err := decodeResponsesRequest(r)
+	if err != nil {
+		logf("decode request failed: %v raw=%s", err, bodyForLog(rawBody, 16*1024))
+		writeJSONError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	logf("incoming request=%s", mustJSONForLog(req))
+	lw.log.setModel(stringValue(req["model"]))
+	resp, err := p.executor.Do(r.Context(), req)
+	if err != nil {
+		logf("upstream request failed: %v", err)
+		writeJSONError(w, http.StatusBadGateway, "upstream request failed: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	if resp.Header.Get("Content-Type") == "text/event-stream" {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+	}
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				logf("client write failed: %v", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				logf("upstream response ended early: %v", readErr)
+			}
+			return
+		}
+	}
+}
+
+// editorChatProfile returns the profile an editor integration should write to
+// its config. A profile with only an Anthropic endpoint, whose provider is not
+// plain OpenAI, or with a pool of API keys gets a local chat/completions proxy
+// in front of it; the returned stop function shuts the proxy down and must be
+// called once the tool exits.
+func editorChatProfile(profile *config.Profile) (*config.Profile, func(), error) {
+	if !anthropicOnlyProfile(profile) && profileProvider(profile) == config.ProviderOpenAI && newKeyPool(profile) == nil {
+		return profile, func() {}, nil
+	}
+	proxy, err := startChatCompatProxy(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !shouldQuietCompatStderr() {
+		fmt.Fprintf(os.Stderr, "Using chat compatibility adapter: %s -> %s\n", proxy.BaseURL(), profileUpstreamURL(profile))
+		fmt.Fprintf(os.Stderr, "Chat compatibility adapter log file: %s\n", proxy.LogPath())
+	}
+	proxied := *profile
+	proxied.OpenAIBaseURL = proxy.BaseURL()
+	proxied.OpenAIAPIKey = "spark-compat"
+	proxied.OpenAIAPIKeys = nil
+	proxied.Provider = ""
+	return &proxied, func() { _ = proxy.Close() }, nil
+}
+
+// editWithChatProfile writes profile into editor's config for a single run,
+// going through editorChatProfile. When a proxy is started, its URL and key
+// only live as long as the run: the returned stop function closes the proxy
+// and restores the config files Edit touched. Interrupts are left to the tool
+// until then so that Ctrl-C in the tool does not skip the restore.
+func editWithChatProfile(editor Editor, profile *config.Profile, model string) (func(), error) {
+	proxied, stopProxy, err := editorChatProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if proxied == profile {
+		if err := editor.Edit(profile, []string{model}); err != nil {
+			return nil, err
+		}
+		return stopProxy, nil
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	snapshot := snapshotEditorConfigs(editor.Paths())
+	stop := func() {
+		stopProxy()
+		if err := snapshot.restore(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not restore %s config: %v\n", editor, err)
+		}
+		signal.Stop(interrupts)
+	}
+	err = editor.Edit(proxied, []string{model})
+	snapshot.recordWritten()
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
@@ -0,0 +1,322 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"spark/internal/config"
+)
+
+func TestChatToAnthropicRequest_MapsMessagesAndTools(t *testing.T) {
+	req := map[string]any{
+		"model":            "claude-sonnet-4",
+		"temperature":      0.2,
+		"stop":             "END",
+		"reasoning_effort": "low",
+		"tool_choice":      "required",
+		"messages": []any{
+			map[string]any{"role": "system", "content": "Be brief."},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "text", "text": "what is this?"},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,AAAA"}},
+			}},
+			map[string]any{"role": "assistant", "content": "", "tool_calls": []any{
+				map[string]any{"id": "call_1", "type": "function", "function": map[string]any{"name": "read", "arguments": `{"path":"a"}`}},
+				map[string]any{"id": "call_2", "type": "function", "function": map[string]any{"name": "read", "arguments": `{"path":"b"}`}},
+			}},
+			map[string]any{"role": "tool", "tool_call_id": "call_1", "content": "A"},
+			map[string]any{"role": "tool", "tool_call_id": "call_2", "content": "B"},
+			map[string]any{"role": "user", "content": "thanks"},
+		},
+		"tools": []any{
+			map[string]any{"type": "function", "function": map[string]any{
+				"name":       "read",
+				"parameters": map[string]any{"type": "object"},
+			}},
+		},
+	}
+	out, err := chatToAnthropicRequest(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	system, _ := out["system"].([]any)
+	if len(system) != 1 || mapValue(system[0])["text"] != "Be brief." {
+		t.Fatalf("unexpected system: %#v", out["system"])
+	}
+	msgs := out["messages"].([]map[string]any)
+	if len(msgs) != 3 {
+		t.Fatalf("expected user/assistant/user turns, got %#v", msgs)
+	}
+	image := mapValue(msgs[0]["content"].([]any)[1])
+	if image["type"] != "image" || mapValue(image["source"])["media_type"] != "image/png" {
+		t.Fatalf("unexpected image block: %#v", image)
+	}
+	toolUses := msgs[1]["content"].([]any)
+	if len(toolUses) != 2 || mapValue(mapValue(toolUses[1])["input"])["path"] != "b" {
+		t.Fatalf("unexpected tool_use blocks: %#v", toolUses)
+	}
+	results := msgs[2]["content"].([]any)
+	if len(results) != 3 || mapValue(results[0])["tool_use_id"] != "call_1" || mapValue(results[2])["text"] != "thanks" {
+		t.Fatalf("expected tool results followed by user text in one turn: %#v", results)
+	}
+	if mapValue(out["tool_choice"])["type"] != "any" {
+		t.Fatalf("unexpected tool_choice: %#v", out["tool_choice"])
+	}
+	if mapValue(out["thinking"])["budget_tokens"] != 4096 || out["max_tokens"] != 8192 {
+		t.Fatalf("unexpected thinking config: %#v %#v", out["thinking"], out["max_tokens"])
+	}
+	if _, ok := out["temperature"]; ok {
+		t.Fatalf("expected temperature dropped with thinking: %#v", out)
+	}
+	if stops, _ := out["stop_sequences"].([]string); len(stops) != 1 || stops[0] != "END" {
+		t.Fatalf("unexpected stop_sequences: %#v", out["stop_sequences"])
+	}
+}
+
+func TestChatToAnthropicRequest_DropsThinkingOnToolLoopFollowUp(t *testing.T) {
+	out, err := chatToAnthropicRequest(map[string]any{
+		"model":            "claude-sonnet-4",
+		"reasoning_effort": "medium",
+		"messages": []any{
+			map[string]any{"role": "user", "content": "list files"},
+			map[string]any{"role": "assistant", "tool_calls": []any{
+				map[string]any{"id": "call_1", "type": "function", "function": map[string]any{"name": "ls", "arguments": "{}"}},
+			}},
+			map[string]any{"role": "tool", "tool_call_id": "call_1", "content": "a.go"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("chatToAnthropicRequest: %v", err)
+	}
+	if _, ok := out["thinking"]; ok {
+		t.Fatalf("expected thinking dropped when the assistant turn has no signed thinking block: %#v", out["thinking"])
+	}
+}
+
+func newFakeAnthropicServer(t *testing.T, handler func(w http.ResponseWriter, req map[string]any)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("x-api-key") != "sk-ant" || r.Header.Get("anthropic-version") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		handler(w, req)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func startTestChatCompatProxy(t *testing.T, upstreamURL string) *chatCompatProxy {
+	t.Helper()
+	t.Setenv("AGENT_LAUNCH_CHAT_COMPAT_LOG", t.TempDir()+"/chat.log")
+	p, err := startChatCompatProxy(&config.Profile{AnthropicBaseURL: upstreamURL, AnthropicAuthToken: "sk-ant"})
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestChatCompatProxy_NonStream(t *testing.T) {
+	upstream := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		if req["model"] != "claude-sonnet-4" || req["stream"] != false {
+			t.Errorf("unexpected upstream request: %#v", req)
+		}
+		_, _ = w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4",` +
+			`"content":[{"type":"text","text":"Reading."},{"type":"tool_use","id":"toolu_1","name":"read","input":{"path":"a"}}],` +
+			`"stop_reason":"tool_use","usage":{"input_tokens":10,"cache_read_input_tokens":90,"output_tokens":5}}`))
+	})
+	p := startTestChatCompatProxy(t, upstream.URL)
+
+	resp, err := http.Post(p.BaseURL()+"/chat/completions", "application/json",
+		strings.NewReader(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"read a"}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	choice := mapValue(out["choices"].([]any)[0])
+	msg := mapValue(choice["message"])
+	if msg["content"] != "Reading." || choice["finish_reason"] != "tool_calls" {
+		t.Fatalf("unexpected choice: %#v", choice)
+	}
+	call := mapValue(mapValue(msg["tool_calls"].([]any)[0])["function"])
+	if call["name"] != "read" || call["arguments"] != `{"path":"a"}` {
+		t.Fatalf("unexpected tool call: %#v", call)
+	}
+	usage := mapValue(out["usage"])
+	if intFromAny(usage["prompt_tokens"]) != 100 || intFromAny(mapValue(usage["prompt_tokens_details"])["cached_tokens"]) != 90 {
+		t.Fatalf("unexpected usage: %#v", usage)
+	}
+}
+
+func TestChatCompatProxy_Stream(t *testing.T) {
+	upstream := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, strings.Join([]string{
+			`event: message_start`,
+			`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4","usage":{"input_tokens":12,"output_tokens":1}}}`,
+			`event: content_block_start`,
+			`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+			`event: content_block_start`,
+			`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"read","input":{}}}`,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}`,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"a\"}"}}`,
+			`event: message_delta`,
+			`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":9}}`,
+			`event: message_stop`,
+			`data: {"type":"message_stop"}`,
+			``,
+		}, "\n\n"))
+	})
+	p := startTestChatCompatProxy(t, upstream.URL)
+
+	resp, err := http.Post(p.BaseURL()+"/chat/completions", "application/json",
+		strings.NewReader(`{"model":"claude-sonnet-4","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	body := string(data)
+	for _, want := range []string{
+		`"delta":{"content":"Hi"}`,
+		`"function":{"arguments":"","name":"read"},"id":"toolu_1","index":0`,
+		`"function":{"arguments":"\"a\"}"},"index":0`,
+		`"finish_reason":"tool_calls"`,
+		`"usage":{"completion_tokens":9,"prompt_tokens":12`,
+		"data: [DONE]",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %s in stream: %q", want, body)
+		}
+	}
+}
+
+func TestChatCompatProxy_MapsUpstreamErrors(t *testing.T) {
+	upstream := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+	})
+	p := startTestChatCompatProxy(t, upstream.URL)
+
+	resp, err := http.Post(p.BaseURL()+"/chat/completions", "application/json",
+		strings.NewReader(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	errObj := mapValue(out["error"])
+	if resp.StatusCode != http.StatusTooManyRequests || errObj["type"] != "rate_limit_error" || errObj["message"] != "slow down" {
+		t.Fatalf("unexpected error response: %d %#v", resp.StatusCode, out)
+	}
+}
+
+func TestEditorChatProfile_ProxiesAnthropicOnlyProfiles(t *testing.T) {
+	t.Setenv("AGENT_LAUNCH_CHAT_COMPAT_LOG", t.TempDir()+"/chat.log")
+	openAI := &config.Profile{OpenAIBaseURL: "https://api.example.com/v1", AnthropicBaseURL: "https://anthropic.example.com"}
+	got, stop, err := editorChatProfile(openAI)
+	if err != nil || got != openAI {
+		t.Fatalf("expected OpenAI profile unchanged, got %#v %v", got, err)
+	}
+	stop()
+
+	anthropicOnly := &config.Profile{AnthropicBaseURL: "https://anthropic.example.com", AnthropicAuthToken: "sk-ant"}
+	got, stop, err = editorChatProfile(anthropicOnly)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stop()
+	if !strings.HasPrefix(got.OpenAIBaseURL, "http://127.0.0.1:") || got.OpenAIAPIKey == "" {
+		t.Fatalf("expected local proxy endpoint, got %#v", got)
+	}
+	if anthropicOnly.OpenAIBaseURL != "" {
+		t.Fatalf("expected original profile untouched, got %#v", anthropicOnly)
+	}
+}
+
+func TestEditWithChatProfile_RestoresEditorConfigOnStop(t *testing.T) {
+	t.Setenv("AGENT_LAUNCH_CHAT_COMPAT_LOG", t.TempDir()+"/chat.log")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	settingsPath := filepath.Join(home, ".factory", "settings.json")
+	original := []byte(`{"theme": "dark", "customModels": [{"model": "mine", "apiKey": "user-key"}]}`)
+	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(settingsPath, original, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := &config.Profile{AnthropicBaseURL: "https://anthropic.example.com", AnthropicAuthToken: "sk-ant"}
+	stop, err := editWithChatProfile(&Droid{}, profile, "claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	during, _ := os.ReadFile(settingsPath)
+	if !strings.Contains(string(during), "http://127.0.0.1:") {
+		t.Fatalf("expected proxy URL in settings during the run, got %s", during)
+	}
+	stop()
+
+	after, err := os.ReadFile(settingsPath)
+	if err != nil || string(after) != string(original) {
+		t.Fatalf("expected settings restored, got %s %v", after, err)
+	}
+	if info, err := os.Stat(settingsPath); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected file mode kept, got %v %v", info, err)
+	}
+}
+
+func TestEditWithChatProfile_KeepsChangesMadeByTheTool(t *testing.T) {
+	t.Setenv("AGENT_LAUNCH_CHAT_COMPAT_LOG", t.TempDir()+"/chat.log")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configPath := filepath.Join(home, ".config", "opencode", "opencode.json")
+	statePath := filepath.Join(home, ".local", "state", "opencode", "model.json")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte(`{"theme": "dark"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := &config.Profile{AnthropicBaseURL: "https://anthropic.example.com", AnthropicAuthToken: "sk-ant"}
+	stop, err := editWithChatProfile(&OpenCode{}, profile, "claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := readMap(configPath)
+	cfg["theme"] = "light"
+	if err := writeJSON(configPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+
+	cfg = readMap(configPath)
+	if cfg["theme"] != "light" || cfg["provider"] != nil || cfg["$schema"] != nil {
+		t.Fatalf("expected the tool's change kept and the proxy provider removed, got %#v", cfg)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Fatalf("expected state file created for the run to be removed, got %v", err)
+	}
+}
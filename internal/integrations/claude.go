@@ -81,7 +81,7 @@ func (c *Claude) Run(profile *config.Profile, model string, args []string) error
 	// If user explicitly configured Anthropic endpoint, respect it.
 	// Otherwise, use OpenAI profile config via local Anthropic->OpenAI proxy.
 	if profile == nil || profile.AnthropicBaseURL == "" {
-		proxy, err := startAnthropicCompatProxy(profileBase(profile), profileKey(profile), effectiveModel)
+		proxy, err := startAnthropicCompatProxy(profile, effectiveModel)
 		if err != nil {
 			return err
 		}
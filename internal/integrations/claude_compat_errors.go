@@ -23,6 +23,36 @@ func writeAnthropicError(w http.ResponseWriter, status int, msg string) {
 	_ = json.NewEncoder(w).Encode(body)
 }
 
+// writeAnthropicUpstreamError relays an upstream error with the Anthropic
+// error type Claude Code retries on, and the upstream retry headers.
+func writeAnthropicUpstreamError(w http.ResponseWriter, upResp *http.Response, data []byte) {
+	errObj, msg := upstreamErrorBody(data)
+	if msg == "" {
+		msg = http.StatusText(upResp.StatusCode)
+	}
+	class := classifyUpstreamError(upResp.StatusCode, errObj)
+	body := map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    class.anthropicType(),
+			"message": anthropicErrorMessage(class, msg),
+		},
+	}
+	forwardRetryHeaders(w.Header(), upResp.Header)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(upResp.StatusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// anthropicErrorMessage words a context window error the way Anthropic
+// does, which is what Claude Code looks for before compacting.
+func anthropicErrorMessage(class upstreamErrorClass, msg string) string {
+	if class == upstreamErrorContextLength && !strings.Contains(strings.ToLower(msg), "prompt is too long") {
+		return "prompt is too long: " + msg
+	}
+	return msg
+}
+
 func mapValue(v any) map[string]any {
 	m, _ := v.(map[string]any)
 	if m == nil {
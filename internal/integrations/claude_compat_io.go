@@ -2,6 +2,8 @@ package integrations
 
 import "io"
 
+var anthropicCompatLog = proxyLogFile{envKey: "AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", fileName: "anthropic-compat.log"}
+
 func openAnthropicCompatLogFile() (io.WriteCloser, string, error) {
-	return openProxyLogFile("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", "anthropic-compat.log", "anthropic-compat")
+	return openProxyLogFile(anthropicCompatLog)
 }
@@ -1,7 +1,6 @@
 package integrations
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -9,25 +8,40 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
-	"sync"
 	"time"
+
+	"spark/internal/config"
+	"spark/internal/tokenizer"
 )
 
 type anthropicCompatProxy struct {
-	server       *http.Server
-	listener     net.Listener
-	baseURL      string
-	upstreamBase string
-	upstreamKey  string
+	server         *http.Server
+	listener       net.Listener
+	baseURL        string
+	upstreamBase   string
+	upstreamKey    string
+	keys           *keyPool
+	upstream       chatUpstream
+	fallbacks      *upstreamChain
+	retry          *retryPolicy
+	limiter        *upstreamLimiter
+	timeouts       *streamTimeouts
+	recorder       *cassetteRecorder
+	toolParsers    *textToolCallParsers
+	replay         *cassetteReplayer
 	preferredModel string
-	client       *http.Client
-	logFile      io.WriteCloser
-	logMu        sync.Mutex
-	logPath      string
+	cacheControl   bool
+	models         *modelCatalog
+	client         *http.Client
+	logFile        io.WriteCloser
+	log            *compatLogger
+	logPath        string
 }
 
-func startAnthropicCompatProxy(upstreamBase, upstreamKey, preferredModel string) (*anthropicCompatProxy, error) {
+func startAnthropicCompatProxy(profile *config.Profile, preferredModel string) (*anthropicCompatProxy, error) {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, err
@@ -37,18 +51,65 @@ func startAnthropicCompatProxy(upstreamBase, upstreamKey, preferredModel string)
 		return nil, err
 	}
 	p := &anthropicCompatProxy{
-		listener:     ln,
-		baseURL:      "http://" + ln.Addr().String(),
-		upstreamBase: strings.TrimRight(upstreamBase, "/"),
-		upstreamKey:  upstreamKey,
+		listener:       ln,
+		baseURL:        "http://" + ln.Addr().String(),
+		upstreamBase:   strings.TrimRight(profileBase(profile), "/"),
+		upstreamKey:    profileKey(profile),
+		keys:           newKeyPool(profile),
+		limiter:        newUpstreamLimiter(profile),
 		preferredModel: strings.TrimSpace(preferredModel),
-		client:       newStreamingHTTPClient(),
-		logFile:      logFile,
-		logPath:      logPath,
+		client:         newStreamingHTTPClient(),
+		logFile:        logFile,
+		logPath:        logPath,
+	}
+	upstream, err := newChatUpstream(profile, p.client, p.logf)
+	if err == nil {
+		p.fallbacks, err = newUpstreamChain(profile, p.client, p.logf)
+	}
+	if err == nil {
+		p.retry, err = newRetryPolicy(profile)
+	}
+	if err == nil {
+		p.timeouts, err = newStreamTimeouts(profile)
+	}
+	if err == nil {
+		p.toolParsers, err = newTextToolCallParsers(profile)
+	}
+	if err == nil {
+		p.replay, err = newCassetteReplayer("anthropic", p.logf)
 	}
+	if err != nil {
+		_ = ln.Close()
+		_ = logFile.Close()
+		return nil, err
+	}
+	p.fallbacks.wrapFallbacks(p.timeouts.executor)
+	fetchModels := p.fetchUpstreamModels
+	if upstream != nil {
+		p.upstream = upstream
+		fetchModels = upstream.fetchModels
+	}
+	if p.replay != nil {
+		fetchModels = p.replay.fetchModels
+	}
+	if p.log, err = newCompatLogger(logFile, "claude", profile); err != nil {
+		_ = ln.Close()
+		_ = logFile.Close()
+		return nil, err
+	}
+	p.logf("logger initialized log_bodies=%s", p.log.bodies)
+	p.recorder = newCassetteRecorder("anthropic", p.log.bodies, p.logf)
+	p.cacheControl = anthropicCacheControlEnabled(p.upstreamBase)
+	// Fetch the rank file now so the first count_tokens call gets a BPE count.
+	tokenizer.Prefetch(p.preferredModel)
+	p.models = newModelCatalog(append([]string{p.preferredModel}, profileModelIDs(profile)...), fetchModels)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/messages", p.handleMessages)
 	mux.HandleFunc("/messages", p.handleMessages)
+	mux.HandleFunc("/v1/messages/count_tokens", p.handleCountTokens)
+	mux.HandleFunc("/messages/count_tokens", p.handleCountTokens)
+	mux.HandleFunc("/v1/models", p.handleModels)
+	mux.HandleFunc("/v1/models/", p.handleModels)
 	p.server = &http.Server{Handler: mux}
 	go func() {
 		_ = p.server.Serve(ln)
@@ -56,6 +117,26 @@ func startAnthropicCompatProxy(upstreamBase, upstreamKey, preferredModel string)
 	return p, nil
 }
 
+// anthropicCacheControlEnabled reports whether cache_control markers should be
+// forwarded upstream. AGENT_LAUNCH_ANTHROPIC_COMPAT_CACHE_CONTROL overrides auto
+// behavior: 1/true/on => always forward, 0/false/off => always strip. By default
+// they are only kept for gateways known to accept them.
+func anthropicCacheControlEnabled(upstreamBase string) bool {
+	v := strings.TrimSpace(strings.ToLower(os.Getenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_CACHE_CONTROL")))
+	switch v {
+	case "1", "true", "on", "yes":
+		return true
+	case "0", "false", "off", "no":
+		return false
+	}
+	u, err := url.Parse(upstreamBase)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "openrouter.ai" || strings.HasSuffix(host, ".openrouter.ai")
+}
+
 func (p *anthropicCompatProxy) BaseURL() string { return p.baseURL }
 
 func (p *anthropicCompatProxy) LogPath() string { return p.logPath }
@@ -71,32 +152,32 @@ func (p *anthropicCompatProxy) Close() error {
 }
 
 func (p *anthropicCompatProxy) logf(format string, args ...any) {
-	line := fmt.Sprintf("[anthropic-compat] "+format, args...)
-	p.logMu.Lock()
-	defer p.logMu.Unlock()
-	if p.logFile != nil {
-		_, _ = fmt.Fprintf(p.logFile, "%s %s\n", time.Now().Format(time.RFC3339), line)
-	}
+	p.log.logf(format, args...)
 }
 
 func (p *anthropicCompatProxy) handleMessages(w http.ResponseWriter, r *http.Request) {
+	lw, r := p.log.begin(w, r)
+	defer lw.finish()
+	w = lw
+	logf := lw.log.logf
 	if r.Method != http.MethodPost {
 		writeAnthropicError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	req, rawBody, err := decodeResponsesRequest(r)
 	if err != nil {
-		p.logf("decode request failed: %v raw=%s", err, rawBody)
+		logf("decode request failed: %v raw=%s", err, bodyForLog(rawBody, 16*1024))
 		writeAnthropicError(w, http.StatusBadRequest, "invalid json body")
 		return
 	}
-	p.logf("incoming request=%s", mustJSONForLog(req))
+	logf("incoming request=%s", mustJSONForLog(req))
+	lw.log.setSession(anthropicSessionID(req))
 
-	reqTranslator := newAnthropicRequestTranslator()
+	reqTranslator := newAnthropicRequestTranslator(p.cacheControl)
 	chatReq, err := reqTranslator.ToChat(req)
 	if err != nil {
-		p.logf("request translate failed: %v", err)
-		writeAnthropicError(w, http.StatusBadRequest, "invalid request")
+		logf("request translate failed: %v", err)
+		writeAnthropicError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	stream := boolValue(req["stream"])
@@ -104,51 +185,56 @@ func (p *anthropicCompatProxy) handleMessages(w http.ResponseWriter, r *http.Req
 	if p.preferredModel != "" {
 		incomingModel := stringValue(chatReq["model"])
 		if incomingModel != p.preferredModel {
-			p.logf("override chat model incoming=%q preferred=%q", incomingModel, p.preferredModel)
+			logf("override chat model incoming=%q preferred=%q", incomingModel, p.preferredModel)
 		}
 		chatReq["model"] = p.preferredModel
 	}
-	p.logf("mapped chat request=%s", mustJSONForLog(chatReq))
+	logf("mapped chat request=%s", mustJSONForLog(chatReq))
+	lw.log.setModel(stringValue(chatReq["model"]))
 	executor := newAnthropicChatExecutor(p)
-	resp, err := executor.Do(r.Context(), chatReq)
+	resp, err := executor.Do(withCassetteRequest(r.Context(), req), chatReq)
 	if err != nil {
-		p.logf("upstream request failed: %v", err)
+		logf("upstream request failed: %v", err)
 		writeAnthropicError(w, http.StatusBadGateway, "upstream request failed")
 		return
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
 		data, _ := io.ReadAll(resp.Body)
-		p.logf("upstream status=%d body=%s", resp.StatusCode, truncateForLog(string(data), 16*1024))
-		writeAnthropicError(w, resp.StatusCode, string(bytes.TrimSpace(data)))
+		logf("upstream status=%d body=%s", resp.StatusCode, bodyForLog(string(data), 16*1024))
+		writeAnthropicUpstreamError(w, resp, data)
 		return
 	}
 	writer := newAnthropicResponseWriter(p)
 	requestedModel := stringValue(chatReq["model"])
+	thinking := anthropicThinkingEnabled(req)
 	if stream {
-		writer.WriteStream(w, resp.Body, requestedModel)
+		writer.WriteStream(w, resp.Body, requestedModel, thinking)
 		return
 	}
-	writer.WriteNonStream(w, resp, requestedModel)
+	writer.WriteNonStream(w, resp, requestedModel, thinking)
 }
 
 func (p *anthropicCompatProxy) postChatCompletions(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(p.logf)
 	doPost := func(payload map[string]any) (*http.Response, error) {
 		body, err := json.Marshal(payload)
 		if err != nil {
 			return nil, err
 		}
 		url := p.upstreamBase + "/chat/completions"
-		upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-		if err != nil {
-			return nil, err
-		}
-		upReq.Header.Set("Content-Type", "application/json")
-		upReq.Header.Set("Accept-Encoding", "identity")
-		if p.upstreamKey != "" {
-			upReq.Header.Set("Authorization", "Bearer "+p.upstreamKey)
-		}
-		return p.client.Do(upReq)
+		return p.keys.do(p.upstreamKey, logf, func(key string) (*http.Response, error) {
+			upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			upReq.Header.Set("Content-Type", "application/json")
+			upReq.Header.Set("Accept-Encoding", "identity")
+			if key != "" {
+				upReq.Header.Set("Authorization", "Bearer "+key)
+			}
+			return p.client.Do(upReq)
+		})
 	}
 
 	resp, err := doPost(chatReq)
@@ -178,7 +264,7 @@ func (p *anthropicCompatProxy) postChatCompletions(ctx context.Context, chatReq
 		return resp, nil
 	}
 
-	p.logf("unknown model from upstream, retrying with variant original=%q retry=%q", model, retryModel)
+	logf("unknown model from upstream, retrying with variant original=%q retry=%q", model, retryModel)
 	retryReq := make(map[string]any, len(chatReq))
 	for k, v := range chatReq {
 		retryReq[k] = v
@@ -220,8 +306,9 @@ func (p *anthropicCompatProxy) writeAnthropicStreamFromMessage(w http.ResponseWr
 		"model":   stringValue(msg["model"]),
 		"content": []any{},
 		"usage": map[string]any{
-			"input_tokens":  intFromAny(usage["input_tokens"]),
-			"output_tokens": 0,
+			"input_tokens":            intFromAny(usage["input_tokens"]),
+			"output_tokens":           0,
+			"cache_read_input_tokens": intFromAny(usage["cache_read_input_tokens"]),
 		},
 	}
 	writeAnthropicSSE(w, "message_start", map[string]any{
@@ -238,6 +325,30 @@ func (p *anthropicCompatProxy) writeAnthropicStreamFromMessage(w http.ResponseWr
 		}
 		blockType := stringValue(block["type"])
 		switch blockType {
+		case "thinking":
+			writeAnthropicSSE(w, "content_block_start", map[string]any{
+				"type":  "content_block_start",
+				"index": i,
+				"content_block": map[string]any{
+					"type":      "thinking",
+					"thinking":  "",
+					"signature": "",
+				},
+			})
+			if thinking := stringValue(block["thinking"]); thinking != "" {
+				writeAnthropicSSE(w, "content_block_delta", map[string]any{
+					"type":  "content_block_delta",
+					"index": i,
+					"delta": map[string]any{
+						"type":     "thinking_delta",
+						"thinking": thinking,
+					},
+				})
+			}
+			writeAnthropicSSE(w, "content_block_stop", map[string]any{
+				"type":  "content_block_stop",
+				"index": i,
+			})
 		case "text":
 			writeAnthropicSSE(w, "content_block_start", map[string]any{
 				"type":  "content_block_start",
@@ -298,8 +409,9 @@ func (p *anthropicCompatProxy) writeAnthropicStreamFromMessage(w http.ResponseWr
 			"stop_sequence": msg["stop_sequence"],
 		},
 		"usage": map[string]any{
-			"input_tokens":  intFromAny(usage["input_tokens"]),
-			"output_tokens": intFromAny(usage["output_tokens"]),
+			"input_tokens":            intFromAny(usage["input_tokens"]),
+			"output_tokens":           intFromAny(usage["output_tokens"]),
+			"cache_read_input_tokens": intFromAny(usage["cache_read_input_tokens"]),
 		},
 	})
 	writeAnthropicSSE(w, "message_stop", map[string]any{
@@ -316,7 +428,11 @@ type toolStreamState struct {
 	closed     bool
 }
 
-func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upBody io.Reader, requestedModel string) {
+// forwardAnthropicStream converts a chat/completions SSE stream into Anthropic
+// message events. When thinking is set, upstream reasoning deltas are surfaced
+// as a thinking block ahead of the text and tool_use blocks.
+func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upBody io.Reader, requestedModel string, thinking bool) {
+	logf := requestLogOf(w).logfOr(p.logf)
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		writeAnthropicError(w, http.StatusInternalServerError, "stream not supported")
@@ -326,8 +442,10 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	scanner := bufio.NewScanner(upBody)
-	scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	lines := newStreamLineReader(upBody)
+	defer lines.stop()
+	ping := time.NewTicker(anthropicPingInterval)
+	defer ping.Stop()
 
 	respID := fmt.Sprintf("msg_%d", time.Now().UnixNano())
 	model := requestedModel
@@ -336,8 +454,12 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 	}
 	textBlockIndex := -1
 	textClosed := false
+	thinkingBlockIndex := -1
+	thinkingClosed := false
 	nextBlockIndex := 0
 	textContent := strings.Builder{}
+	thinkingLen := 0
+	droppedReasoning := 0
 
 	toolStates := map[int]*toolStreamState{}
 	toolOrder := make([]int, 0, 2)
@@ -347,8 +469,10 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 	firstValidChunk := ""
 	lastValidChunk := ""
 	finishReason := ""
+	var failure *streamFailure
 	promptTokens := 0
 	completionTokens := 0
+	cachedTokens := 0
 	messageStarted := false
 
 	startMessage := func() {
@@ -364,10 +488,56 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 				"role":    "assistant",
 				"model":   model,
 				"content": []any{},
-				"usage": map[string]any{
-					"input_tokens":  promptTokens,
-					"output_tokens": 0,
+				"usage":   anthropicUsage(promptTokens, 0, cachedTokens),
+			},
+		})
+		flusher.Flush()
+	}
+
+	closeThinkingBlock := func() {
+		if thinkingBlockIndex < 0 || thinkingClosed {
+			return
+		}
+		thinkingClosed = true
+		writeAnthropicSSE(w, "content_block_stop", map[string]any{
+			"type":  "content_block_stop",
+			"index": thinkingBlockIndex,
+		})
+		flusher.Flush()
+	}
+
+	emitThinkingDelta := func(delta string) {
+		if delta == "" {
+			return
+		}
+		// Anthropic streams one block at a time and thinking always comes first.
+		// Reasoning that arrives after text or tool output has started cannot be
+		// placed in a valid block, so it is only counted for the log.
+		if thinkingClosed || textBlockIndex >= 0 || len(toolOrder) > 0 {
+			droppedReasoning += len(delta)
+			return
+		}
+		if thinkingBlockIndex < 0 {
+			startMessage()
+			thinkingBlockIndex = nextBlockIndex
+			nextBlockIndex++
+			writeAnthropicSSE(w, "content_block_start", map[string]any{
+				"type":  "content_block_start",
+				"index": thinkingBlockIndex,
+				"content_block": map[string]any{
+					"type":      "thinking",
+					"thinking":  "",
+					"signature": "",
 				},
+			})
+		}
+		thinkingLen += len(delta)
+		writeAnthropicSSE(w, "content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": thinkingBlockIndex,
+			"delta": map[string]any{
+				"type":     "thinking_delta",
+				"thinking": delta,
 			},
 		})
 		flusher.Flush()
@@ -377,6 +547,7 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 		if textBlockIndex >= 0 {
 			return
 		}
+		closeThinkingBlock()
 		startMessage()
 		textBlockIndex = nextBlockIndex
 		nextBlockIndex++
@@ -430,6 +601,7 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 			}
 			return st
 		}
+		closeThinkingBlock()
 		startMessage()
 		if callID == "" {
 			callID = fmt.Sprintf("toolu_%d_%d", time.Now().UnixNano(), idx)
@@ -459,8 +631,27 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 		return st
 	}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+scan:
+	for {
+		var raw string
+		select {
+		case <-ping.C:
+			// Keep the connection visibly alive while the upstream is slow.
+			// Until the upstream has sent content the response is left
+			// uncommitted, so a failure can still get a proper error status.
+			if !messageStarted {
+				continue
+			}
+			writeAnthropicSSE(w, "ping", map[string]any{"type": "ping"})
+			flusher.Flush()
+			continue
+		case line, ok := <-lines.lines:
+			if !ok {
+				break scan
+			}
+			raw = line
+		}
+		line := strings.TrimSpace(raw)
 		if line == "" {
 			continue
 		}
@@ -479,14 +670,18 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 		}
 		chunkCount++
 		if firstValidChunk == "" {
-			firstValidChunk = truncateForLog(data, 512)
+			firstValidChunk = data
 		}
-		lastValidChunk = truncateForLog(data, 512)
+		lastValidChunk = data
 		var chunk map[string]any
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			p.logf("stream unmarshal error: %v data=%s", err, truncateForLog(data, 512))
+			logf("stream unmarshal error: %v data=%s", err, bodyForLog(data, 512))
 			continue
 		}
+		if failure = streamErrorChunk(chunk); failure != nil {
+			logf("upstream stream error chunk=%s", bodyForLog(data, 1024))
+			break scan
+		}
 		finalChunk = chunk
 		if id := stringValue(chunk["id"]); id != "" {
 			respID = id
@@ -501,6 +696,9 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 			if v := intFromAny(usage["completion_tokens"]); v > 0 {
 				completionTokens = v
 			}
+			if v := chatCachedPromptTokens(usage); v > 0 {
+				cachedTokens = v
+			}
 		}
 		choices, _ := chunk["choices"].([]any)
 		if len(choices) > 0 {
@@ -511,6 +709,10 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 			}
 		}
 
+		if thinking {
+			emitThinkingDelta(extractChatReasoningDelta(chunk))
+		}
+
 		delta := extractChatDelta(chunk)
 		if delta == "" {
 			delta = extractChatText(chunk)
@@ -559,15 +761,31 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		p.logf("stream scan error: %v", err)
+	scanErr := lines.err()
+	if scanErr != nil {
+		logf("stream scan error: %v", scanErr)
+	}
+	logf("stream parse flags chunks=%d saw_done=%t message_started=%t first_chunk=%q last_chunk=%q",
+		chunkCount, sawDone, messageStarted, bodyForLog(firstValidChunk, 512), bodyForLog(lastValidChunk, 512))
+	if thinking {
+		logf("stream thinking summary thinking_len=%d dropped_reasoning_len=%d", thinkingLen, droppedReasoning)
+	}
+	if failure == nil && scanErr != nil {
+		failure = streamReadFailure(scanErr)
+	}
+	if failure == nil && !sawDone && finishReason == "" && (finalChunk == nil || !chatChunkIsCompletion(finalChunk)) {
+		failure = prematureStreamEnd()
+	}
+	if failure != nil {
+		logf("stream failed type=%s message=%q", failure.class.anthropicType(), bodyForLog(failure.message, 1024))
+		writeAnthropicStreamError(w, failure.class.anthropicType(), anthropicErrorMessage(failure.class, failure.message))
+		flusher.Flush()
+		return
 	}
-	p.logf("stream parse flags chunks=%d saw_done=%t message_started=%t first_chunk=%q last_chunk=%q",
-		chunkCount, sawDone, messageStarted, firstValidChunk, lastValidChunk)
 
 	if !messageStarted {
 		if finalChunk != nil {
-			msg := chatToAnthropicMessage(finalChunk, requestedModel)
+			msg := chatToAnthropicMessage(finalChunk, requestedModel, thinking)
 			p.writeAnthropicStreamFromMessage(w, msg)
 		} else {
 			writeAnthropicError(w, http.StatusBadGateway, "empty upstream stream")
@@ -575,6 +793,7 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 		return
 	}
 
+	closeThinkingBlock()
 	closeTextBlock()
 	for _, idx := range toolOrder {
 		st := toolStates[idx]
@@ -599,16 +818,14 @@ func (p *anthropicCompatProxy) forwardAnthropicStream(w http.ResponseWriter, upB
 	if len(toolOrder) > 0 {
 		stopReason = "tool_use"
 	}
+	requestLogOf(w).setUsage(promptTokens, completionTokens, cachedTokens)
 	writeAnthropicSSE(w, "message_delta", map[string]any{
 		"type": "message_delta",
 		"delta": map[string]any{
 			"stop_reason":   stopReason,
 			"stop_sequence": nil,
 		},
-		"usage": map[string]any{
-			"input_tokens":  promptTokens,
-			"output_tokens": completionTokens,
-		},
+		"usage": anthropicUsage(promptTokens, completionTokens, cachedTokens),
 	})
 	writeAnthropicSSE(w, "message_stop", map[string]any{
 		"type": "message_stop",
@@ -624,3 +841,14 @@ func writeAnthropicSSE(w io.Writer, event string, payload any) {
 	_, _ = io.WriteString(w, "event: "+event+"\n")
 	_, _ = io.WriteString(w, "data: "+string(data)+"\n\n")
 }
+
+// writeAnthropicStreamError ends a Messages stream with an error event.
+func writeAnthropicStreamError(w io.Writer, errType, message string) {
+	writeAnthropicSSE(w, "error", map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
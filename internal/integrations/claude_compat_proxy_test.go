@@ -1,9 +1,21 @@
 package integrations
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"spark/internal/config"
+	"spark/internal/tokenizer"
 )
 
 func TestAnthropicToChatCompletions_BasicMapping(t *testing.T) {
@@ -32,7 +44,10 @@ func TestAnthropicToChatCompletions_BasicMapping(t *testing.T) {
 			"name": "sum",
 		},
 	}
-	out := anthropicToChatCompletions(req)
+	out, err := anthropicToChatCompletions(req)
+	if err != nil {
+		t.Fatalf("translate failed: %v", err)
+	}
 	if out["model"] != "gpt-4.1" {
 		t.Fatalf("model mismatch: %v", out["model"])
 	}
@@ -90,7 +105,7 @@ func TestChatToAnthropicMessage_WithToolCalls(t *testing.T) {
 			"completion_tokens": float64(6),
 		},
 	}
-	msg := chatToAnthropicMessage(chatResp, "")
+	msg := chatToAnthropicMessage(chatResp, "", false)
 	if msg["type"] != "message" || msg["role"] != "assistant" {
 		t.Fatalf("message shape mismatch: %#v", msg)
 	}
@@ -120,7 +135,7 @@ func TestForwardAnthropicStream_RealTimeTextDelta(t *testing.T) {
 		"",
 	}, "\n")
 	rec := &flushResponseRecorder{responseRecorder: responseRecorder{header: make(http.Header)}}
-	p.forwardAnthropicStream(rec, strings.NewReader(upstream), "gpt-4.1")
+	p.forwardAnthropicStream(rec, strings.NewReader(upstream), "gpt-4.1", false)
 	out := rec.body.String()
 	if !strings.Contains(out, "event: message_start") {
 		t.Fatalf("missing message_start event: %q", out)
@@ -135,3 +150,447 @@ func TestForwardAnthropicStream_RealTimeTextDelta(t *testing.T) {
 		t.Fatalf("missing message_stop event: %q", out)
 	}
 }
+
+func TestAnthropicToChatCompletions_ImageBlocksBecomeContentParts(t *testing.T) {
+	req := map[string]any{
+		"model": "gpt-4.1",
+		"messages": []any{
+			map[string]any{
+				"role": "user",
+				"content": []any{
+					map[string]any{"type": "text", "text": "what is this?"},
+					map[string]any{
+						"type": "image",
+						"source": map[string]any{
+							"type":       "base64",
+							"media_type": "image/png",
+							"data":       "iVBORw0KGgo=",
+						},
+					},
+					map[string]any{
+						"type": "image",
+						"source": map[string]any{
+							"type": "url",
+							"url":  "https://example.com/cat.jpg",
+						},
+					},
+				},
+			},
+		},
+	}
+	out, err := anthropicToChatCompletions(req)
+	if err != nil {
+		t.Fatalf("translate failed: %v", err)
+	}
+	msgs := out["messages"].([]map[string]any)
+	if len(msgs) != 1 {
+		t.Fatalf("messages mismatch: %#v", msgs)
+	}
+	parts, ok := msgs[0]["content"].([]map[string]any)
+	if !ok || len(parts) != 3 {
+		t.Fatalf("expected content parts, got %#v", msgs[0]["content"])
+	}
+	if parts[0]["type"] != "text" || parts[0]["text"] != "what is this?" {
+		t.Fatalf("text part mismatch: %#v", parts[0])
+	}
+	if url := mapValue(parts[1]["image_url"])["url"]; url != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Fatalf("base64 image part mismatch: %#v", parts[1])
+	}
+	if url := mapValue(parts[2]["image_url"])["url"]; url != "https://example.com/cat.jpg" {
+		t.Fatalf("url image part mismatch: %#v", parts[2])
+	}
+}
+
+func TestAnthropicToChatCompletions_ToolResultImagesFollowToolMessage(t *testing.T) {
+	req := map[string]any{
+		"model": "gpt-4.1",
+		"messages": []any{
+			map[string]any{
+				"role": "assistant",
+				"content": []any{
+					map[string]any{"type": "tool_use", "id": "toolu_1", "name": "screenshot", "input": map[string]any{}},
+				},
+			},
+			map[string]any{
+				"role": "user",
+				"content": []any{
+					map[string]any{
+						"type":        "tool_result",
+						"tool_use_id": "toolu_1",
+						"content": []any{
+							map[string]any{"type": "text", "text": "captured"},
+							map[string]any{
+								"type": "image",
+								"source": map[string]any{
+									"type":       "base64",
+									"media_type": "image/jpeg",
+									"data":       "/9j/4AAQ",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	out, err := anthropicToChatCompletions(req)
+	if err != nil {
+		t.Fatalf("translate failed: %v", err)
+	}
+	msgs := out["messages"].([]map[string]any)
+	if len(msgs) != 3 {
+		t.Fatalf("expected assistant, tool and user messages, got %#v", msgs)
+	}
+	if msgs[1]["role"] != "tool" || msgs[1]["content"] != "captured" {
+		t.Fatalf("tool message mismatch: %#v", msgs[1])
+	}
+	parts, ok := msgs[2]["content"].([]map[string]any)
+	if msgs[2]["role"] != "user" || !ok || len(parts) != 2 || parts[1]["type"] != "image_url" {
+		t.Fatalf("expected user message carrying tool image, got %#v", msgs[2])
+	}
+}
+
+func TestAnthropicToChatCompletions_Documents(t *testing.T) {
+	req := map[string]any{
+		"model": "gpt-4.1",
+		"messages": []any{
+			map[string]any{
+				"role": "user",
+				"content": []any{
+					map[string]any{
+						"type":  "document",
+						"title": "notes.txt",
+						"source": map[string]any{
+							"type":       "text",
+							"media_type": "text/plain",
+							"data":       "remember the milk",
+						},
+					},
+				},
+			},
+		},
+	}
+	out, err := anthropicToChatCompletions(req)
+	if err != nil {
+		t.Fatalf("translate failed: %v", err)
+	}
+	msgs := out["messages"].([]map[string]any)
+	if msgs[0]["content"] != "Document: notes.txt\nremember the milk" {
+		t.Fatalf("text document mismatch: %#v", msgs[0])
+	}
+
+	req["messages"] = []any{
+		map[string]any{
+			"role": "user",
+			"content": []any{
+				map[string]any{
+					"type": "document",
+					"source": map[string]any{
+						"type":       "base64",
+						"media_type": "application/pdf",
+						"data":       "JVBERi0=",
+					},
+				},
+			},
+		},
+	}
+	if _, err := anthropicToChatCompletions(req); err == nil || !strings.Contains(err.Error(), "application/pdf") {
+		t.Fatalf("expected pdf rejection, got %v", err)
+	}
+}
+
+func TestAnthropicToChatCompletions_ThinkingBudgetMapsToReasoningEffort(t *testing.T) {
+	req := map[string]any{
+		"model":      "deepseek-reasoner",
+		"max_tokens": float64(32000),
+		"thinking": map[string]any{
+			"type":          "enabled",
+			"budget_tokens": float64(10000),
+		},
+		"messages": []any{
+			map[string]any{"role": "user", "content": "hi"},
+		},
+	}
+	out, err := anthropicToChatCompletions(req)
+	if err != nil {
+		t.Fatalf("translate failed: %v", err)
+	}
+	if out["reasoning_effort"] != "medium" {
+		t.Fatalf("reasoning_effort mismatch: %#v", out["reasoning_effort"])
+	}
+	if out["max_tokens"] != 32000 {
+		t.Fatalf("max_tokens mismatch: %#v", out["max_tokens"])
+	}
+	delete(req, "thinking")
+	out, _ = anthropicToChatCompletions(req)
+	if _, ok := out["reasoning_effort"]; ok {
+		t.Fatalf("unexpected reasoning_effort without thinking: %#v", out)
+	}
+}
+
+func TestForwardAnthropicStream_ReasoningBecomesThinkingBlock(t *testing.T) {
+	p := &anthropicCompatProxy{}
+	upstream := strings.Join([]string{
+		`data: {"id":"chatcmpl_1","model":"deepseek-reasoner","choices":[{"delta":{"reasoning_content":"Let me "}}]}`,
+		`data: {"id":"chatcmpl_1","model":"deepseek-reasoner","choices":[{"delta":{"reasoning_content":"think."}}]}`,
+		`data: {"id":"chatcmpl_1","model":"deepseek-reasoner","choices":[{"delta":{"content":"Answer"},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+		"",
+	}, "\n")
+	rec := &flushResponseRecorder{responseRecorder: responseRecorder{header: make(http.Header)}}
+	p.forwardAnthropicStream(rec, strings.NewReader(upstream), "deepseek-reasoner", true)
+	out := rec.body.String()
+	if !strings.Contains(out, `"content_block":{"signature":"","thinking":"","type":"thinking"},"index":0`) {
+		t.Fatalf("expected thinking block at index 0: %q", out)
+	}
+	if !strings.Contains(out, `"delta":{"thinking":"Let me ","type":"thinking_delta"}`) {
+		t.Fatalf("expected thinking_delta: %q", out)
+	}
+	if !strings.Contains(out, `"content_block":{"text":"","type":"text"},"index":1`) {
+		t.Fatalf("expected text block at index 1: %q", out)
+	}
+	thinkingStop := strings.Index(out, `{"index":0,"type":"content_block_stop"}`)
+	textStart := strings.Index(out, `"index":1,"type":"content_block_start"`)
+	if thinkingStop < 0 || textStart < 0 || thinkingStop > textStart {
+		t.Fatalf("thinking block must stop before text starts: %q", out)
+	}
+
+	rec = &flushResponseRecorder{responseRecorder: responseRecorder{header: make(http.Header)}}
+	p.forwardAnthropicStream(rec, strings.NewReader(upstream), "deepseek-reasoner", false)
+	if strings.Contains(rec.body.String(), "thinking") {
+		t.Fatalf("unexpected thinking block when thinking is disabled: %q", rec.body.String())
+	}
+}
+
+func TestChatToAnthropicMessage_ReasoningContentBecomesThinking(t *testing.T) {
+	chatResp := map[string]any{
+		"choices": []any{
+			map[string]any{
+				"finish_reason": "stop",
+				"message": map[string]any{
+					"content":           "42",
+					"reasoning_content": "6 times 7",
+				},
+			},
+		},
+	}
+	msg := chatToAnthropicMessage(chatResp, "deepseek-reasoner", true)
+	content := msg["content"].([]map[string]any)
+	if len(content) != 2 || content[0]["type"] != "thinking" || content[0]["thinking"] != "6 times 7" {
+		t.Fatalf("expected leading thinking block, got %#v", content)
+	}
+	msg = chatToAnthropicMessage(chatResp, "deepseek-reasoner", false)
+	if content := msg["content"].([]map[string]any); len(content) != 1 {
+		t.Fatalf("expected text only without thinking, got %#v", content)
+	}
+}
+
+func TestAnthropicToChatCompletions_PreservesCacheControl(t *testing.T) {
+	ephemeral := map[string]any{"type": "ephemeral"}
+	req := map[string]any{
+		"model": "claude-sonnet-4",
+		"system": []any{
+			map[string]any{"type": "text", "text": "You are Claude Code."},
+			map[string]any{"type": "text", "text": "Project rules.", "cache_control": ephemeral},
+		},
+		"messages": []any{
+			map[string]any{
+				"role": "user",
+				"content": []any{
+					map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": "file body", "cache_control": ephemeral},
+					map[string]any{"type": "text", "text": "continue", "cache_control": ephemeral},
+				},
+			},
+			map[string]any{"role": "user", "content": []any{map[string]any{"type": "text", "text": "plain"}}},
+		},
+	}
+	out, err := anthropicToChatCompletions(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msgs := out["messages"].([]map[string]any)
+	sys, ok := msgs[0]["content"].([]map[string]any)
+	if !ok || len(sys) != 2 || sys[1]["cache_control"] == nil || sys[0]["cache_control"] != nil {
+		t.Fatalf("expected system parts with cache_control on the marked block: %#v", msgs[0]["content"])
+	}
+	tool, ok := msgs[1]["content"].([]map[string]any)
+	if msgs[1]["role"] != "tool" || !ok || tool[0]["text"] != "file body" || tool[0]["cache_control"] == nil {
+		t.Fatalf("expected tool result part with cache_control: %#v", msgs[1])
+	}
+	user, ok := msgs[2]["content"].([]map[string]any)
+	if !ok || user[0]["cache_control"] == nil {
+		t.Fatalf("expected user text part with cache_control: %#v", msgs[2])
+	}
+	if msgs[3]["content"] != "plain" {
+		t.Fatalf("expected unmarked text to stay a string: %#v", msgs[3])
+	}
+
+	stripChatCacheControl(out)
+	if msgs[0]["content"] != "You are Claude Code.\nProject rules." || msgs[1]["content"] != "file body" || msgs[2]["content"] != "continue" {
+		t.Fatalf("expected stripped content collapsed to strings: %#v", msgs)
+	}
+}
+
+func TestAnthropicCacheControlEnabled(t *testing.T) {
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_CACHE_CONTROL", "")
+	if !anthropicCacheControlEnabled("https://openrouter.ai/api/v1") {
+		t.Fatal("expected cache_control to be forwarded to OpenRouter")
+	}
+	if anthropicCacheControlEnabled("https://api.example.com/v1") {
+		t.Fatal("expected cache_control to be stripped for unknown gateways")
+	}
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_CACHE_CONTROL", "on")
+	if !anthropicCacheControlEnabled("https://api.example.com/v1") {
+		t.Fatal("expected env override to enable cache_control")
+	}
+}
+
+func TestChatToAnthropicMessage_ReportsCacheReads(t *testing.T) {
+	chatResp := map[string]any{
+		"id":      "chatcmpl_1",
+		"choices": []any{map[string]any{"message": map[string]any{"content": "ok"}}},
+		"usage": map[string]any{
+			"prompt_tokens":         float64(1000),
+			"completion_tokens":     float64(20),
+			"prompt_tokens_details": map[string]any{"cached_tokens": float64(800)},
+		},
+	}
+	usage := mapValue(chatToAnthropicMessage(chatResp, "m", false)["usage"])
+	if intFromAny(usage["input_tokens"]) != 200 || intFromAny(usage["cache_read_input_tokens"]) != 800 || intFromAny(usage["output_tokens"]) != 20 {
+		t.Fatalf("unexpected usage: %#v", usage)
+	}
+}
+
+func TestForwardAnthropicStream_ReportsCacheReads(t *testing.T) {
+	p := &anthropicCompatProxy{}
+	upstream := strings.Join([]string{
+		`data: {"id":"chatcmpl_1","model":"deepseek-chat","choices":[{"delta":{"content":"ok"},"finish_reason":"stop"}]}`,
+		`data: {"id":"chatcmpl_1","model":"deepseek-chat","choices":[],"usage":{"prompt_tokens":500,"completion_tokens":7,"prompt_cache_hit_tokens":384}}`,
+		`data: [DONE]`,
+		"",
+	}, "\n")
+	rec := &flushResponseRecorder{responseRecorder: responseRecorder{header: make(http.Header)}}
+	p.forwardAnthropicStream(rec, strings.NewReader(upstream), "deepseek-chat", false)
+	out := rec.body.String()
+	if !strings.Contains(out, `"usage":{"cache_read_input_tokens":384,"input_tokens":116,"output_tokens":7}`) {
+		t.Fatalf("expected cache reads in message_delta usage: %q", out)
+	}
+}
+
+func TestCountAnthropicRequestTokens_CoversAllSections(t *testing.T) {
+	counter := tokenizer.Heuristic{}
+	base := map[string]any{
+		"messages": []any{
+			map[string]any{"role": "user", "content": "hello there"},
+		},
+	}
+	baseTokens := countAnthropicRequestTokens(base, counter)
+	if baseTokens <= 0 {
+		t.Fatalf("expected positive token count, got %d", baseTokens)
+	}
+
+	full := map[string]any{
+		"system": []any{map[string]any{"type": "text", "text": "You are a careful coding assistant."}},
+		"messages": []any{
+			map[string]any{"role": "user", "content": "hello there"},
+			map[string]any{
+				"role": "user",
+				"content": []any{
+					map[string]any{
+						"type":   "image",
+						"source": map[string]any{"type": "url", "url": "https://example.com/a.png"},
+					},
+				},
+			},
+		},
+		"tools": []any{
+			map[string]any{
+				"name":         "read_file",
+				"description":  "Read a file from disk",
+				"input_schema": map[string]any{"type": "object", "properties": map[string]any{"path": map[string]any{"type": "string"}}},
+			},
+		},
+	}
+	fullTokens := countAnthropicRequestTokens(full, counter)
+	if fullTokens < baseTokens+anthropicMaxImageTokens+anthropicTokensPerTool {
+		t.Fatalf("expected system, image and tools to add tokens, base=%d full=%d", baseTokens, fullTokens)
+	}
+}
+
+func TestAnthropicImageTokens_UsesImageDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 150, 100))); err != nil {
+		t.Fatal(err)
+	}
+	source := map[string]any{
+		"type":       "base64",
+		"media_type": "image/png",
+		"data":       base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}
+	if got := anthropicImageTokens(source); got != 20 {
+		t.Fatalf("expected 150*100/750=20 tokens, got %d", got)
+	}
+}
+
+func TestHandleCountTokens_ReturnsInputTokens(t *testing.T) {
+	p := &anthropicCompatProxy{}
+	body := `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hello"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	p.handleCountTokens(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status mismatch: %d body=%s", rec.Code, rec.Body.String())
+	}
+	var out map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if intFromAny(out["input_tokens"]) <= 0 {
+		t.Fatalf("expected input_tokens, got %#v", out)
+	}
+}
+
+func TestCountTokensEndpoint_UsesInstalledRankFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AGENT_LAUNCH_TOKENIZER_DIR", dir)
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", t.TempDir()+"/claude.log")
+	var lines []string
+	for b := 0; b < 256; b++ {
+		lines = append(lines, fmt.Sprintf("%s %d", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b))
+	}
+	for i, token := range []string{"he", "ll", "hell", "hello", " h", " he", " hell", " hello"} {
+		lines = append(lines, fmt.Sprintf("%s %d", base64.StdEncoding.EncodeToString([]byte(token)), 256+i))
+	}
+	if err := os.WriteFile(filepath.Join(dir, tokenizer.EncodingO200K+".tiktoken"), []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := startAnthropicCompatProxy(&config.Profile{OpenAIBaseURL: "http://127.0.0.1:1/v1"}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	body := `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"` + strings.TrimSpace(strings.Repeat("hello ", 40)) + `"}]}`
+	status, out := postForBody(t, p.BaseURL()+"/v1/messages/count_tokens", body)
+	if status != http.StatusOK {
+		t.Fatalf("status mismatch: %d body=%s", status, out)
+	}
+	var resp map[string]any
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	var req map[string]any
+	_ = json.Unmarshal([]byte(body), &req)
+	enc, err := tokenizer.LoadEncoding(tokenizer.EncodingO200K)
+	if err != nil {
+		t.Fatalf("load encoding: %v", err)
+	}
+	want := countAnthropicRequestTokens(req, enc)
+	if heuristic := countAnthropicRequestTokens(req, tokenizer.Heuristic{}); want == heuristic {
+		t.Fatalf("test text must count differently under BPE and the heuristic (%d)", want)
+	}
+	if got := intFromAny(resp["input_tokens"]); got != want {
+		t.Fatalf("expected BPE count %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,149 @@
+package integrations
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"spark/internal/tokenizer"
+)
+
+const (
+	// Per-message and per-request framing overhead, matching what OpenAI
+	// documents for chat formats; close enough for context management.
+	anthropicTokensPerMessage = 3
+	anthropicTokensPerRequest = 3
+	anthropicTokensPerTool    = 8
+	// Anthropic bills images at roughly width*height/750 after scaling them to
+	// fit within ~1.15 megapixels, which caps a single image at about 1600.
+	anthropicMaxImageTokens = 1600
+)
+
+func (p *anthropicCompatProxy) handleCountTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAnthropicError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req, rawBody, err := decodeResponsesRequest(r)
+	if err != nil {
+		p.logf("count_tokens decode request failed: %v raw=%s", err, bodyForLog(rawBody, 16*1024))
+		writeAnthropicError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	model := p.preferredModel
+	if model == "" {
+		model = stringValue(req["model"])
+	}
+	counter := tokenizer.ForModel(model)
+	tokens := countAnthropicRequestTokens(req, counter)
+	p.logf("count_tokens model=%q counter=%s input_tokens=%d", model, counter.Name(), tokens)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"input_tokens": tokens,
+	})
+}
+
+// countAnthropicRequestTokens estimates the prompt size of an Anthropic
+// Messages request: system prompt, every message block and tool definitions.
+func countAnthropicRequestTokens(req map[string]any, counter tokenizer.Counter) int {
+	total := anthropicTokensPerRequest
+	if sys := req["system"]; sys != nil {
+		total += anthropicTokensPerMessage + countAnthropicContentTokens(sys, counter)
+	}
+	// Requests the proxies translate carry typed slices rather than []any.
+	for _, msg := range chatMessagesList(req["messages"]) {
+		total += anthropicTokensPerMessage + counter.Count(stringValue(msg["role"]))
+		total += countAnthropicContentTokens(msg["content"], counter)
+	}
+	for _, tool := range chatMessagesList(req["tools"]) {
+		total += anthropicTokensPerTool
+		total += counter.Count(stringValue(tool["name"]))
+		total += counter.Count(stringValue(tool["description"]))
+		if schema, ok := tool["input_schema"]; ok {
+			total += countJSONTokens(schema, counter)
+		}
+	}
+	return total
+}
+
+func countAnthropicContentTokens(raw any, counter tokenizer.Counter) int {
+	switch v := raw.(type) {
+	case nil:
+		return 0
+	case string:
+		return counter.Count(v)
+	case []any:
+		total := 0
+		for _, item := range v {
+			total += countAnthropicContentTokens(item, counter)
+		}
+		return total
+	case map[string]any:
+		switch stringValue(v["type"]) {
+		case "text", "input_text", "output_text":
+			return counter.Count(stringValue(v["text"]))
+		case "thinking":
+			return counter.Count(stringValue(v["thinking"]))
+		case "image":
+			return anthropicImageTokens(mapValue(v["source"]))
+		case "document":
+			text, err := anthropicDocumentToText(v)
+			if err != nil {
+				// Binary documents are rejected on send; count the raw payload
+				// size so callers still see them as large.
+				return counter.Count(stringValue(mapValue(v["source"])["data"])) / 4
+			}
+			return counter.Count(text)
+		case "tool_use":
+			return counter.Count(stringValue(v["name"])) + countJSONTokens(v["input"], counter)
+		case "tool_result":
+			return countAnthropicContentTokens(v["content"], counter)
+		default:
+			return countJSONTokens(v, counter)
+		}
+	default:
+		return countJSONTokens(v, counter)
+	}
+}
+
+func countJSONTokens(v any, counter tokenizer.Counter) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return counter.Count(string(data))
+}
+
+// anthropicImageTokens reads the image header to apply Anthropic's sizing
+// formula. URL images cannot be inspected locally and count as the maximum.
+func anthropicImageTokens(source map[string]any) int {
+	if stringValue(source["type"]) != "base64" {
+		return anthropicMaxImageTokens
+	}
+	data := stringValue(source["data"])
+	// The header is all DecodeConfig needs; avoid decoding multi-megabyte payloads.
+	if len(data) > 64*1024 {
+		data = data[:64*1024]
+	}
+	raw, err := base64.StdEncoding.DecodeString(data[:len(data)/4*4])
+	if err != nil || len(raw) == 0 {
+		return anthropicMaxImageTokens
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return anthropicMaxImageTokens
+	}
+	tokens := cfg.Width * cfg.Height / 750
+	if tokens > anthropicMaxImageTokens {
+		return anthropicMaxImageTokens
+	}
+	if tokens < 1 {
+		return 1
+	}
+	return tokens
+}
@@ -1,16 +1,21 @@
 package integrations
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 )
 
-func anthropicToChatCompletions(req map[string]any) map[string]any {
+func anthropicToChatCompletions(req map[string]any) (map[string]any, error) {
+	messages, err := anthropicMessagesToChatMessages(req)
+	if err != nil {
+		return nil, err
+	}
 	out := map[string]any{
 		"model":    stringValue(req["model"]),
-		"messages": anthropicMessagesToChatMessages(req),
+		"messages": messages,
 		"stream":   false,
 	}
 	if out["model"] == "" {
@@ -34,19 +39,55 @@ func anthropicToChatCompletions(req map[string]any) map[string]any {
 	if tc, ok := anthropicToolChoiceToChatToolChoice(req["tool_choice"]); ok {
 		out["tool_choice"] = tc
 	}
-	return out
+	if budget, ok := anthropicThinkingBudget(req); ok {
+		// budget_tokens has no portable chat/completions equivalent. Bucket it
+		// into reasoning_effort; max_tokens already includes the thinking budget
+		// on the Anthropic side, so it stays the overall output cap.
+		out["reasoning_effort"] = reasoningEffortForBudget(budget)
+	}
+	return out, nil
+}
+
+// anthropicThinkingEnabled reports whether the request opted into extended
+// thinking, which controls whether upstream reasoning is surfaced as thinking
+// blocks.
+func anthropicThinkingEnabled(req map[string]any) bool {
+	_, ok := anthropicThinkingBudget(req)
+	return ok
+}
+
+func anthropicThinkingBudget(req map[string]any) (int, bool) {
+	thinking := mapValue(req["thinking"])
+	if stringValue(thinking["type"]) != "enabled" {
+		return 0, false
+	}
+	budget, _ := intValue(thinking["budget_tokens"])
+	return budget, true
+}
+
+func reasoningEffortForBudget(budget int) string {
+	switch {
+	case budget <= 0:
+		return "medium"
+	case budget <= 4096:
+		return "low"
+	case budget <= 16384:
+		return "medium"
+	default:
+		return "high"
+	}
 }
 
-func anthropicMessagesToChatMessages(req map[string]any) []map[string]any {
+func anthropicMessagesToChatMessages(req map[string]any) ([]map[string]any, error) {
 	out := make([]map[string]any, 0, 8)
-	if sys := anthropicSystemToString(req["system"]); sys != "" {
+	if sys := anthropicSystemToChatContent(req["system"]); sys != nil {
 		out = append(out, map[string]any{
 			"role":    "system",
 			"content": sys,
 		})
 	}
 	items, _ := req["messages"].([]any)
-	for _, raw := range items {
+	for i, raw := range items {
 		msg, ok := raw.(map[string]any)
 		if !ok {
 			continue
@@ -55,11 +96,14 @@ func anthropicMessagesToChatMessages(req map[string]any) []map[string]any {
 		if role == "" {
 			role = "user"
 		}
-		text, toolCalls, toolResults := anthropicContentToChatParts(role, msg["content"])
+		parts, toolCalls, toolResults, err := anthropicContentToChatParts(role, msg["content"])
+		if err != nil {
+			return nil, fmt.Errorf("messages.%d: %w", i, err)
+		}
 		if role == "assistant" {
 			assistant := map[string]any{
 				"role":    "assistant",
-				"content": text,
+				"content": chatTextFromParts(parts),
 			}
 			if len(toolCalls) > 0 {
 				assistant["tool_calls"] = toolCalls
@@ -67,29 +111,36 @@ func anthropicMessagesToChatMessages(req map[string]any) []map[string]any {
 			out = append(out, assistant)
 			continue
 		}
-		if text != "" {
+		// Tool messages must directly follow the assistant tool_calls they answer,
+		// so they go ahead of any user text or images in the same turn.
+		out = append(out, toolResults...)
+		if len(parts) > 0 {
 			out = append(out, map[string]any{
 				"role":    role,
-				"content": text,
+				"content": chatContentFromParts(parts),
 			})
 		}
-		out = append(out, toolResults...)
 	}
 	if len(out) == 0 {
-		return []map[string]any{{"role": "user", "content": ""}}
+		return []map[string]any{{"role": "user", "content": ""}}, nil
 	}
-	return out
+	return out, nil
 }
 
-func anthropicSystemToString(raw any) string {
-	if raw == nil {
-		return ""
-	}
+// anthropicSystemToChatContent returns the system prompt as chat content, or
+// nil when there is none. Text blocks carrying cache_control stay separate
+// parts so the marker survives; otherwise the prompt is flattened to a string.
+func anthropicSystemToChatContent(raw any) any {
 	switch v := raw.(type) {
+	case nil:
+		return nil
 	case string:
-		return strings.TrimSpace(v)
+		if sys := strings.TrimSpace(v); sys != "" {
+			return sys
+		}
+		return nil
 	case []any:
-		parts := make([]string, 0, len(v))
+		parts := make([]map[string]any, 0, len(v))
 		for _, item := range v {
 			m, ok := item.(map[string]any)
 			if !ok {
@@ -97,33 +148,60 @@ func anthropicSystemToString(raw any) string {
 			}
 			if stringValue(m["type"]) == "text" {
 				if t := stringValue(m["text"]); t != "" {
-					parts = append(parts, t)
+					parts = append(parts, withCacheControl(chatTextPart(t), m))
 				}
 			}
 		}
-		return strings.Join(parts, "\n")
+		if len(parts) == 0 {
+			return nil
+		}
+		return chatContentFromParts(parts)
 	default:
-		return normalizeMessageContent(v)
+		if sys := normalizeMessageContent(v); sys != "" {
+			return sys
+		}
+		return nil
 	}
 }
 
-func anthropicContentToChatParts(role string, raw any) (string, []map[string]any, []map[string]any) {
-	textParts := make([]string, 0, 4)
+// anthropicContentToChatParts splits Anthropic content blocks into chat content
+// parts (text and image_url), assistant tool_calls and tool messages. Images
+// returned inside tool_result blocks are carried as content parts because chat
+// tool messages only accept text.
+func anthropicContentToChatParts(role string, raw any) ([]map[string]any, []map[string]any, []map[string]any, error) {
+	parts := make([]map[string]any, 0, 4)
 	toolCalls := make([]map[string]any, 0, 2)
 	toolResults := make([]map[string]any, 0, 2)
 	switch v := raw.(type) {
 	case string:
-		return v, nil, nil
+		if v == "" {
+			return nil, nil, nil, nil
+		}
+		return []map[string]any{chatTextPart(v)}, nil, nil, nil
 	case []any:
 		for idx, item := range v {
 			m, ok := item.(map[string]any)
 			if !ok {
 				continue
 			}
-			switch stringValue(m["type"]) {
+			switch blockType := stringValue(m["type"]); blockType {
 			case "text", "input_text", "output_text":
 				if t := stringValue(m["text"]); t != "" {
-					textParts = append(textParts, t)
+					parts = append(parts, withCacheControl(chatTextPart(t), m))
+				}
+			case "image":
+				part, err := anthropicImageToChatPart(m)
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("content.%d: %w", idx, err)
+				}
+				parts = append(parts, part)
+			case "document":
+				text, err := anthropicDocumentToText(m)
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("content.%d: %w", idx, err)
+				}
+				if text != "" {
+					parts = append(parts, withCacheControl(chatTextPart(text), m))
 				}
 			case "tool_use":
 				name := stringValue(m["name"])
@@ -151,19 +229,188 @@ func anthropicContentToChatParts(role string, raw any) (string, []map[string]any
 				if toolCallID == "" {
 					continue
 				}
-				content := normalizeMessageContent(m["content"])
+				content, images, err := anthropicToolResultContent(m["content"])
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("content.%d: %w", idx, err)
+				}
 				if content == "" {
 					content = "{}"
 				}
 				toolResults = append(toolResults, map[string]any{
 					"role":         "tool",
 					"tool_call_id": toolCallID,
-					"content":      content,
+					"content":      chatContentFromParts([]map[string]any{withCacheControl(chatTextPart(content), m)}),
 				})
+				if len(images) > 0 {
+					parts = append(parts, chatTextPart(fmt.Sprintf("Image output of tool call %s:", toolCallID)))
+					parts = append(parts, images...)
+				}
 			}
 		}
 	}
-	return strings.Join(textParts, "\n"), toolCalls, toolResults
+	return parts, toolCalls, toolResults, nil
+}
+
+// anthropicToolResultContent returns the text of a tool_result block together
+// with any image blocks it carries, already converted to chat image_url parts.
+func anthropicToolResultContent(raw any) (string, []map[string]any, error) {
+	items, ok := raw.([]any)
+	if !ok {
+		return normalizeMessageContent(raw), nil, nil
+	}
+	texts := make([]string, 0, len(items))
+	images := make([]map[string]any, 0, 1)
+	for _, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch stringValue(m["type"]) {
+		case "text", "input_text", "output_text":
+			if t := stringValue(m["text"]); t != "" {
+				texts = append(texts, t)
+			}
+		case "image":
+			part, err := anthropicImageToChatPart(m)
+			if err != nil {
+				return "", nil, err
+			}
+			images = append(images, part)
+		case "document":
+			text, err := anthropicDocumentToText(m)
+			if err != nil {
+				return "", nil, err
+			}
+			if text != "" {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return strings.Join(texts, "\n"), images, nil
+}
+
+func anthropicImageToChatPart(block map[string]any) (map[string]any, error) {
+	source := mapValue(block["source"])
+	url := ""
+	switch sourceType := stringValue(source["type"]); sourceType {
+	case "base64":
+		mediaType := stringValue(source["media_type"])
+		data := stringValue(source["data"])
+		if mediaType == "" || data == "" {
+			return nil, fmt.Errorf("image source requires media_type and data")
+		}
+		url = "data:" + mediaType + ";base64," + data
+	case "url":
+		url = stringValue(source["url"])
+		if url == "" {
+			return nil, fmt.Errorf("image source requires url")
+		}
+	default:
+		return nil, fmt.Errorf("image source type %q is not supported by the compatibility adapter", sourceType)
+	}
+	return map[string]any{
+		"type": "image_url",
+		"image_url": map[string]any{
+			"url": url,
+		},
+	}, nil
+}
+
+// anthropicDocumentToText flattens plain-text documents into text. PDFs and
+// other binary documents cannot be represented in chat/completions and are
+// rejected so the client sees why instead of the model silently missing them.
+func anthropicDocumentToText(block map[string]any) (string, error) {
+	source := mapValue(block["source"])
+	text := ""
+	switch sourceType := stringValue(source["type"]); sourceType {
+	case "text":
+		text = stringValue(source["data"])
+	case "content":
+		text = normalizeMessageContent(source["content"])
+	case "base64":
+		mediaType := stringValue(source["media_type"])
+		if !strings.HasPrefix(mediaType, "text/") {
+			return "", unsupportedDocumentError(mediaType)
+		}
+		data, err := base64.StdEncoding.DecodeString(stringValue(source["data"]))
+		if err != nil {
+			return "", fmt.Errorf("document source has invalid base64 data")
+		}
+		text = string(data)
+	case "url":
+		return "", unsupportedDocumentError("application/pdf")
+	default:
+		return "", fmt.Errorf("document source type %q is not supported by the compatibility adapter", sourceType)
+	}
+	if title := stringValue(block["title"]); title != "" && text != "" {
+		text = "Document: " + title + "\n" + text
+	}
+	return text, nil
+}
+
+func unsupportedDocumentError(mediaType string) error {
+	if mediaType == "" {
+		mediaType = "unknown"
+	}
+	return fmt.Errorf("document blocks with media type %s are not supported by the OpenAI-compatible upstream; send the document as text instead", mediaType)
+}
+
+func chatTextPart(text string) map[string]any {
+	return map[string]any{
+		"type": "text",
+		"text": text,
+	}
+}
+
+// chatContentFromParts collapses all-text content to a plain string, keeping
+// the part array only when it holds images or prompt caching markers.
+func chatContentFromParts(parts []map[string]any) any {
+	for _, part := range parts {
+		if stringValue(part["type"]) != "text" {
+			return parts
+		}
+		if _, ok := part["cache_control"]; ok {
+			return parts
+		}
+	}
+	return chatTextFromParts(parts)
+}
+
+// withCacheControl copies an Anthropic block's cache_control marker onto the
+// chat content part translated from it. Gateways with prompt caching
+// (OpenRouter and Anthropic-backed OpenAI endpoints) read it from there.
+func withCacheControl(part map[string]any, block map[string]any) map[string]any {
+	if cc := mapValue(block["cache_control"]); len(cc) > 0 {
+		part["cache_control"] = cc
+	}
+	return part
+}
+
+// stripChatCacheControl removes cache_control markers from a translated chat
+// request for upstreams that reject unknown content part fields, collapsing
+// content back to strings where that is all it needed parts for.
+func stripChatCacheControl(chatReq map[string]any) {
+	msgs, _ := chatReq["messages"].([]map[string]any)
+	for _, msg := range msgs {
+		parts, ok := msg["content"].([]map[string]any)
+		if !ok {
+			continue
+		}
+		for _, part := range parts {
+			delete(part, "cache_control")
+		}
+		msg["content"] = chatContentFromParts(parts)
+	}
+}
+
+func chatTextFromParts(parts []map[string]any) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if stringValue(part["type"]) == "text" {
+			texts = append(texts, stringValue(part["text"]))
+		}
+	}
+	return strings.Join(texts, "\n")
 }
 
 func anthropicToolsToChatTools(raw any) []map[string]any {
@@ -236,7 +483,7 @@ func anthropicToolChoiceToChatToolChoice(raw any) (any, bool) {
 	}
 }
 
-func chatToAnthropicMessage(chatResp map[string]any, requestedModel string) map[string]any {
+func chatToAnthropicMessage(chatResp map[string]any, requestedModel string, thinking bool) map[string]any {
 	id := stringValue(chatResp["id"])
 	if id == "" {
 		id = fmt.Sprintf("msg_%d", time.Now().UnixNano())
@@ -247,7 +494,14 @@ func chatToAnthropicMessage(chatResp map[string]any, requestedModel string) map[
 	}
 	text := extractChatText(chatResp)
 	toolCalls := extractChatToolCalls(chatResp)
-	content := make([]map[string]any, 0, 1+len(toolCalls))
+	content := make([]map[string]any, 0, 2+len(toolCalls))
+	if reasoning := extractChatReasoning(chatResp); thinking && reasoning != "" {
+		content = append(content, map[string]any{
+			"type":      "thinking",
+			"thinking":  reasoning,
+			"signature": "",
+		})
+	}
 	if text != "" {
 		content = append(content, map[string]any{
 			"type": "text",
@@ -283,10 +537,31 @@ func chatToAnthropicMessage(chatResp map[string]any, requestedModel string) map[
 		"content":       content,
 		"stop_reason":   stopReason,
 		"stop_sequence": nil,
-		"usage": map[string]any{
-			"input_tokens":  intFromAny(usage["prompt_tokens"]),
-			"output_tokens": intFromAny(usage["completion_tokens"]),
-		},
+		"usage":         anthropicUsage(intFromAny(usage["prompt_tokens"]), intFromAny(usage["completion_tokens"]), chatCachedPromptTokens(usage)),
+	}
+}
+
+// chatCachedPromptTokens returns how many prompt tokens the upstream served
+// from its prompt cache: OpenAI-style prompt_tokens_details.cached_tokens, or
+// DeepSeek's prompt_cache_hit_tokens.
+func chatCachedPromptTokens(usage map[string]any) int {
+	if v := intFromAny(mapValue(usage["prompt_tokens_details"])["cached_tokens"]); v > 0 {
+		return v
+	}
+	return intFromAny(usage["prompt_cache_hit_tokens"])
+}
+
+// anthropicUsage builds Anthropic usage from chat token counts. Chat
+// prompt_tokens includes cached tokens while Anthropic reports them separately
+// from input_tokens, so cache reads are moved out of the input count.
+func anthropicUsage(promptTokens, completionTokens, cachedTokens int) map[string]any {
+	if cachedTokens > promptTokens {
+		cachedTokens = promptTokens
+	}
+	return map[string]any{
+		"input_tokens":            promptTokens - cachedTokens,
+		"output_tokens":           completionTokens,
+		"cache_read_input_tokens": cachedTokens,
 	}
 }
 
@@ -32,10 +32,9 @@ func (c *Codex) Run(profile *config.Profile, model string, args []string) error
 		return fmt.Errorf("codex is not installed, install with: npm install -g @openai/codex")
 	}
 
-	baseURL := profileBase(profile)
-	apiKey := profileKey(profile)
+	baseURL := profileUpstreamURL(profile)
 	quietCompatStderr := shouldQuietCompatStderr()
-	proxy, err := startResponsesCompatProxy(baseURL, apiKey, quietCompatStderr)
+	proxy, err := startResponsesCompatProxy(profile, quietCompatStderr)
 	if err != nil {
 		return err
 	}
@@ -4,33 +4,37 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
-	"strings"
 )
 
+// writeUpstreamErrorAsJSON relays an upstream error as an OpenAI error whose
+// code tells Codex whether to retry, compact or give up. Rate limit headers
+// are passed through so Codex can honour them.
 func writeUpstreamErrorAsJSON(w http.ResponseWriter, upResp *http.Response) {
 	data, _ := io.ReadAll(upResp.Body)
-	msg := strings.TrimSpace(string(data))
-
-	var decoded map[string]any
-	if err := json.Unmarshal(data, &decoded); err == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(upResp.StatusCode)
-		_ = json.NewEncoder(w).Encode(decoded)
-		return
-	}
-
+	errObj, msg := upstreamErrorBody(data)
 	if msg == "" {
 		msg = http.StatusText(upResp.StatusCode)
 	}
-	errBody := map[string]any{
-		"error": map[string]any{
-			"message": msg,
-			"type":    "invalid_request_error",
-		},
+	class := classifyUpstreamError(upResp.StatusCode, errObj)
+
+	out := make(map[string]any, len(errObj)+3)
+	for k, v := range errObj {
+		out[k] = v
 	}
+	out["message"] = msg
+	if typ := class.openAIType(); typ != "" && stringValue(out["type"]) == "" {
+		out["type"] = typ
+	}
+	// Keep a specific upstream code such as model_not_found unless the
+	// class has a code Codex acts on.
+	if code := stringValue(errObj["code"]); code == "" ||
+		(class != upstreamErrorInvalidRequest && class != upstreamErrorServer) {
+		out["code"] = class.responsesCode()
+	}
+	forwardRetryHeaders(w.Header(), upResp.Header)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(upResp.StatusCode)
-	_ = json.NewEncoder(w).Encode(errBody)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": out})
 }
 
 func writeJSONError(w http.ResponseWriter, status int, msg string) {
@@ -2,6 +2,8 @@ package integrations
 
 import "io"
 
+var codexCompatLog = proxyLogFile{envKey: "AGENT_LAUNCH_COMPAT_LOG", fileName: "codex-compat.log"}
+
 func openCompatLogFile() (io.WriteCloser, string, error) {
-	return openProxyLogFile("AGENT_LAUNCH_COMPAT_LOG", "codex-compat.log", "compat")
+	return openProxyLogFile(codexCompatLog)
 }
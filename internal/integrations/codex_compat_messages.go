@@ -0,0 +1,549 @@
+package integrations
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// responsesToAnthropicRequest maps a Responses request straight to Anthropic
+// Messages. Sampling, tool and reasoning options reuse the chat mapping so
+// both upstream kinds treat them alike; the transcript is converted directly
+// so reasoning items can be replayed as signed thinking blocks.
+func responsesToAnthropicRequest(req map[string]any) (map[string]any, error) {
+	chatReq := responsesToChatCompletions(req)
+	delete(chatReq, "messages")
+	out, err := chatToAnthropicRequest(chatReq)
+	if err != nil {
+		return nil, err
+	}
+	system, messages, err := responsesInputToAnthropicMessages(req["input"])
+	if err != nil {
+		return nil, err
+	}
+	if instructions := strings.TrimSpace(stringValue(req["instructions"])); instructions != "" {
+		system = append([]any{map[string]any{"type": "text", "text": instructions}}, system...)
+	}
+	delete(out, "system")
+	if len(system) > 0 {
+		out["system"] = system
+	}
+	out["messages"] = messages
+	dropUnreplayableThinking(out)
+	return out, nil
+}
+
+// responsesInputToAnthropicMessages converts Responses input items to an
+// Anthropic system prompt and alternating message turns.
+func responsesInputToAnthropicMessages(input any) ([]any, []map[string]any, error) {
+	system := make([]any, 0, 1)
+	var turns anthropicTurns
+	for i, raw := range responsesInputItems(input) {
+		item, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch itemType := stringValue(item["type"]); itemType {
+		case "function_call", "custom_tool_call", "local_shell_call":
+			callID := stringValue(item["call_id"])
+			if callID == "" {
+				callID = stringValue(item["id"])
+			}
+			name, arguments := responsesCallItemToFunction(item)
+			turns.append("assistant", []any{anthropicToolUseBlock(callID, name, arguments)})
+		case "function_call_output", "custom_tool_call_output", "local_shell_call_output":
+			turns.append("user", []any{map[string]any{
+				"type":        "tool_result",
+				"tool_use_id": stringValue(item["call_id"]),
+				"content":     normalizeMessageContent(item["output"]),
+			}})
+		case "reasoning":
+			// Only signed thinking can be sent back; summaries alone are dropped.
+			signature := stringValue(item["encrypted_content"])
+			if signature == "" {
+				continue
+			}
+			turns.append("assistant", []any{map[string]any{
+				"type":      "thinking",
+				"thinking":  responsesReasoningText(item),
+				"signature": signature,
+			}})
+		case "", "message":
+			blocks, err := responsesContentToAnthropicBlocks(item["content"])
+			if err != nil {
+				return nil, nil, fmt.Errorf("input.%d: %w", i, err)
+			}
+			switch stringValue(item["role"]) {
+			case "system", "developer":
+				system = append(system, blocks...)
+			case "assistant":
+				turns.append("assistant", blocks)
+			default:
+				turns.append("user", blocks)
+			}
+		}
+	}
+	return system, turns.messages(), nil
+}
+
+func responsesReasoningText(item map[string]any) string {
+	summary, _ := item["summary"].([]any)
+	parts := make([]string, 0, len(summary))
+	for _, raw := range summary {
+		if t := stringValue(mapValue(raw)["text"]); t != "" {
+			parts = append(parts, t)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func responsesContentToAnthropicBlocks(raw any) ([]any, error) {
+	parts, ok := raw.([]any)
+	if !ok {
+		if text := normalizeMessageContent(raw); text != "" {
+			return []any{map[string]any{"type": "text", "text": text}}, nil
+		}
+		return nil, nil
+	}
+	blocks := make([]any, 0, len(parts))
+	for _, item := range parts {
+		part := mapValue(item)
+		switch stringValue(part["type"]) {
+		case "input_text", "output_text", "text":
+			if text := stringValue(part["text"]); text != "" {
+				blocks = append(blocks, map[string]any{"type": "text", "text": text})
+			}
+		case "input_image":
+			url := stringValue(part["image_url"])
+			if url == "" {
+				url = stringValue(mapValue(part["image_url"])["url"])
+			}
+			source, err := anthropicImageSourceFromURL(url)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, map[string]any{"type": "image", "source": source})
+		}
+	}
+	return blocks, nil
+}
+
+// anthropicUsageToResponsesUsage reports cache reads and writes as part of
+// input_tokens, matching what chat upstreams report through the other path.
+func anthropicUsageToResponsesUsage(usage map[string]any) (map[string]any, bool) {
+	if len(usage) == 0 {
+		return nil, false
+	}
+	return chatUsageToResponsesUsage(map[string]any{"usage": chatUsageFromAnthropic(usage)})
+}
+
+// anthropicMessageToResponse converts a complete Anthropic message to a
+// Responses object. Thinking becomes a reasoning item whose encrypted_content
+// carries the signature, so the client can send it back on the next turn.
+func anthropicMessageToResponse(msg map[string]any, tools responsesToolSet) map[string]any {
+	outputItems := webSearchCallItems(msg[webSearchCallsKey])
+	var text strings.Builder
+	var msgText *strings.Builder
+	blocks, _ := msg["content"].([]any)
+	for i, raw := range blocks {
+		block := mapValue(raw)
+		switch stringValue(block["type"]) {
+		case "thinking":
+			msgText = nil
+			outputItems = append(outputItems, map[string]any{
+				"id":                fmt.Sprintf("rs_%d_%d", time.Now().UnixNano(), i),
+				"type":              "reasoning",
+				"summary":           []map[string]any{{"type": "summary_text", "text": stringValue(block["thinking"])}},
+				"encrypted_content": stringValue(block["signature"]),
+			})
+		case "text":
+			t := stringValue(block["text"])
+			text.WriteString(t)
+			if msgText == nil {
+				msgText = &strings.Builder{}
+				outputItems = append(outputItems, map[string]any{
+					"id":     fmt.Sprintf("msg_%d_%d", time.Now().UnixNano(), i),
+					"type":   "message",
+					"status": "completed",
+					"role":   "assistant",
+				})
+			}
+			msgText.WriteString(t)
+			outputItems[len(outputItems)-1]["content"] = []map[string]any{{"type": "output_text", "text": msgText.String()}}
+		case "tool_use":
+			msgText = nil
+			args, err := json.Marshal(block["input"])
+			if err != nil || string(args) == "null" {
+				args = []byte("{}")
+			}
+			id := stringValue(block["id"])
+			name := stringValue(block["name"])
+			outputItems = append(outputItems, responsesToolCallItem(tools.kind(name), "fc_"+id, id, name, string(args)))
+		}
+	}
+	model := stringValue(msg["model"])
+	if model == "" {
+		model = "unknown"
+	}
+	id := newResponseID()
+	out := map[string]any{
+		"id":          id,
+		"object":      "response",
+		"status":      "completed",
+		"model":       model,
+		"output_text": text.String(),
+		"output":      outputItems,
+	}
+	if usage, ok := anthropicUsageToResponsesUsage(mapValue(msg["usage"])); ok {
+		out["usage"] = usage
+	}
+	return out
+}
+
+func (p *responsesCompatProxy) forwardMessagesNonStream(w http.ResponseWriter, upResp *http.Response, tools responsesToolSet) map[string]any {
+	logf := requestLogOf(w).logfOr(p.logf)
+	if upResp.StatusCode >= 400 {
+		p.warnf(fmt.Sprintf("forward non-stream upstream status %d", upResp.StatusCode))
+		writeUpstreamErrorAsJSON(w, upResp)
+		return nil
+	}
+	var msg map[string]any
+	if err := json.NewDecoder(upResp.Body).Decode(&msg); err != nil {
+		p.warnf("invalid upstream non-stream JSON")
+		writeJSONError(w, http.StatusBadGateway, "invalid upstream response")
+		return nil
+	}
+	logf("upstream messages response=%s", mustJSONForLog(msg))
+	out := anthropicMessageToResponse(msg, tools)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+	return out
+}
+
+// messagesStreamBlock tracks one Anthropic content block while it streams.
+type messagesStreamBlock struct {
+	kind        string
+	outputIndex int
+	itemID      string
+	callID      string
+	name        string
+	toolKind    string
+	text        strings.Builder
+	signature   strings.Builder
+	arguments   strings.Builder
+}
+
+// forwardMessagesStream converts an Anthropic Messages SSE stream directly
+// into Responses events: text blocks become message items, thinking blocks
+// reasoning items and tool_use blocks tool call items.
+func (p *responsesCompatProxy) forwardMessagesStream(w http.ResponseWriter, upResp *http.Response, tools responsesToolSet) map[string]any {
+	logf := requestLogOf(w).logfOr(p.logf)
+	if upResp.StatusCode >= 400 {
+		p.warnf(fmt.Sprintf("forward stream upstream status %d", upResp.StatusCode))
+		writeUpstreamErrorAsJSON(w, upResp)
+		return nil
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "stream not supported")
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	respID := newResponseID()
+	model := "unknown"
+	for _, typ := range []string{"response.created", "response.in_progress"} {
+		writeSSE(w, map[string]any{
+			"type": typ,
+			"response": map[string]any{
+				"id":     respID,
+				"object": "response",
+				"status": "in_progress",
+				"model":  model,
+				"output": []any{},
+			},
+		})
+	}
+	flusher.Flush()
+
+	scanner := bufio.NewScanner(upResp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	blocks := map[int]*messagesStreamBlock{}
+	outputItems := make([]map[string]any, 0, 3)
+	var fullText strings.Builder
+	usage := map[string]any{}
+	nextOutputIndex := 0
+	failStream := func(failure *streamFailure) map[string]any {
+		p.warnf("upstream stream failed")
+		writeResponsesFailed(w, respID, model, failure.class.responsesCode(), failure.message)
+		_, _ = io.WriteString(w, "data: [DONE]\n\n")
+		flusher.Flush()
+		return nil
+	}
+
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "data:")
+		if !ok {
+			continue
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			continue
+		}
+		switch stringValue(event["type"]) {
+		case "message_start":
+			msg := mapValue(event["message"])
+			if v := stringValue(msg["model"]); v != "" {
+				model = v
+			}
+			for k, v := range mapValue(msg["usage"]) {
+				usage[k] = v
+			}
+		case "content_block_start":
+			cb := mapValue(event["content_block"])
+			b := &messagesStreamBlock{kind: stringValue(cb["type"]), outputIndex: nextOutputIndex}
+			switch b.kind {
+			case "text":
+				b.itemID = fmt.Sprintf("msg_%d", time.Now().UnixNano())
+				writeSSE(w, map[string]any{
+					"type":         "response.output_item.added",
+					"output_index": b.outputIndex,
+					"item": map[string]any{
+						"id":      b.itemID,
+						"type":    "message",
+						"status":  "in_progress",
+						"role":    "assistant",
+						"content": []any{},
+					},
+				})
+				writeSSE(w, map[string]any{
+					"type":          "response.content_part.added",
+					"item_id":       b.itemID,
+					"output_index":  b.outputIndex,
+					"content_index": 0,
+					"part":          map[string]any{"type": "output_text", "text": ""},
+				})
+			case "thinking":
+				b.itemID = fmt.Sprintf("rs_%d", time.Now().UnixNano())
+				writeSSE(w, map[string]any{
+					"type":         "response.output_item.added",
+					"output_index": b.outputIndex,
+					"item": map[string]any{
+						"id":      b.itemID,
+						"type":    "reasoning",
+						"summary": []any{},
+					},
+				})
+			case "tool_use":
+				b.callID = stringValue(cb["id"])
+				b.itemID = "fc_" + b.callID
+				b.name = stringValue(cb["name"])
+				b.toolKind = tools.kind(b.name)
+				if b.toolKind == responsesToolFunction {
+					writeSSE(w, map[string]any{
+						"type":         "response.output_item.added",
+						"output_index": b.outputIndex,
+						"item": map[string]any{
+							"id":        b.itemID,
+							"type":      "function_call",
+							"call_id":   b.callID,
+							"name":      b.name,
+							"arguments": "",
+							"status":    "in_progress",
+						},
+					})
+				}
+			default:
+				continue
+			}
+			nextOutputIndex++
+			blocks[intFromAny(event["index"])] = b
+			flusher.Flush()
+		case "content_block_delta":
+			b := blocks[intFromAny(event["index"])]
+			if b == nil {
+				continue
+			}
+			delta := mapValue(event["delta"])
+			switch stringValue(delta["type"]) {
+			case "text_delta":
+				t := stringValue(delta["text"])
+				b.text.WriteString(t)
+				fullText.WriteString(t)
+				writeSSE(w, map[string]any{
+					"type":          "response.output_text.delta",
+					"item_id":       b.itemID,
+					"delta":         t,
+					"output_index":  b.outputIndex,
+					"content_index": 0,
+					"logprobs":      []any{},
+				})
+			case "thinking_delta":
+				t := stringValue(delta["thinking"])
+				b.text.WriteString(t)
+				writeSSE(w, map[string]any{
+					"type":          "response.reasoning_summary_text.delta",
+					"item_id":       b.itemID,
+					"output_index":  b.outputIndex,
+					"summary_index": 0,
+					"delta":         t,
+				})
+			case "signature_delta":
+				b.signature.WriteString(stringValue(delta["signature"]))
+			case "input_json_delta":
+				t := stringValue(delta["partial_json"])
+				b.arguments.WriteString(t)
+				if b.toolKind == responsesToolFunction && t != "" {
+					writeSSE(w, map[string]any{
+						"type":         "response.function_call_arguments.delta",
+						"item_id":      b.itemID,
+						"output_index": b.outputIndex,
+						"delta":        t,
+					})
+				}
+			}
+			flusher.Flush()
+		case "content_block_stop":
+			index := intFromAny(event["index"])
+			b := blocks[index]
+			if b == nil {
+				continue
+			}
+			delete(blocks, index)
+			outputItems = append(outputItems, p.finishMessagesStreamBlock(w, b))
+			flusher.Flush()
+		case webSearchCallsKey:
+			for _, item := range webSearchCallItems(event[webSearchCallsKey]) {
+				writeWebSearchCallEvents(w, nextOutputIndex, item)
+				nextOutputIndex++
+				outputItems = append(outputItems, item)
+			}
+			flusher.Flush()
+		case "message_delta":
+			for k, v := range mapValue(event["usage"]) {
+				usage[k] = v
+			}
+		case "message_stop":
+			resp := map[string]any{
+				"id":          respID,
+				"object":      "response",
+				"status":      "completed",
+				"model":       model,
+				"output_text": fullText.String(),
+				"output":      outputItems,
+			}
+			if u, ok := anthropicUsageToResponsesUsage(usage); ok {
+				resp["usage"] = u
+				logf("stream usage present response_id=%s model=%s %s", respID, model, formatUsageForLog(u))
+			} else {
+				p.warnf("upstream stream completed without token usage")
+			}
+			writeSSE(w, map[string]any{
+				"type":     "response.completed",
+				"response": resp,
+			})
+			_, _ = io.WriteString(w, "data: [DONE]\n\n")
+			flusher.Flush()
+			return resp
+		case "error":
+			logf("upstream stream error event=%s", bodyForLog(data, 1024))
+			failure := streamErrorChunk(event)
+			if failure == nil {
+				failure = &streamFailure{class: upstreamErrorServer, message: "upstream stream error"}
+			}
+			return failStream(failure)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logf("upstream stream scan error: %v", err)
+		return failStream(streamReadFailure(err))
+	}
+	return failStream(prematureStreamEnd())
+}
+
+// finishMessagesStreamBlock writes the done events of a finished block and
+// returns its final output item.
+func (p *responsesCompatProxy) finishMessagesStreamBlock(w io.Writer, b *messagesStreamBlock) map[string]any {
+	switch b.kind {
+	case "text":
+		text := b.text.String()
+		item := map[string]any{
+			"id":      b.itemID,
+			"type":    "message",
+			"status":  "completed",
+			"role":    "assistant",
+			"content": []map[string]any{{"type": "output_text", "text": text}},
+		}
+		writeSSE(w, map[string]any{
+			"type":          "response.output_text.done",
+			"item_id":       b.itemID,
+			"text":          text,
+			"output_index":  b.outputIndex,
+			"content_index": 0,
+			"logprobs":      []any{},
+		})
+		writeSSE(w, map[string]any{
+			"type":          "response.content_part.done",
+			"item_id":       b.itemID,
+			"output_index":  b.outputIndex,
+			"content_index": 0,
+			"part":          map[string]any{"type": "output_text", "text": text},
+		})
+		writeSSE(w, map[string]any{
+			"type":         "response.output_item.done",
+			"output_index": b.outputIndex,
+			"item":         item,
+		})
+		return item
+	case "thinking":
+		text := b.text.String()
+		item := map[string]any{
+			"id":                b.itemID,
+			"type":              "reasoning",
+			"summary":           []map[string]any{{"type": "summary_text", "text": text}},
+			"encrypted_content": b.signature.String(),
+		}
+		writeSSE(w, map[string]any{
+			"type":          "response.reasoning_summary_text.done",
+			"item_id":       b.itemID,
+			"output_index":  b.outputIndex,
+			"summary_index": 0,
+			"text":          text,
+		})
+		writeSSE(w, map[string]any{
+			"type":         "response.output_item.done",
+			"output_index": b.outputIndex,
+			"item":         item,
+		})
+		return item
+	default:
+		args := b.arguments.String()
+		item := responsesToolCallItem(b.toolKind, b.itemID, b.callID, b.name, args)
+		if b.toolKind == responsesToolFunction {
+			writeSSE(w, map[string]any{
+				"type":         "response.function_call_arguments.done",
+				"item_id":      b.itemID,
+				"output_index": b.outputIndex,
+				"arguments":    item["arguments"],
+			})
+		} else {
+			writeSSE(w, map[string]any{
+				"type":         "response.output_item.added",
+				"output_index": b.outputIndex,
+				"item":         item,
+			})
+		}
+		writeSSE(w, map[string]any{
+			"type":         "response.output_item.done",
+			"output_index": b.outputIndex,
+			"item":         item,
+		})
+		return item
+	}
+}
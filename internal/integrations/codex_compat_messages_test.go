@@ -0,0 +1,188 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"spark/internal/config"
+)
+
+func TestResponsesToAnthropicRequest_ReplaysReasoningAndTools(t *testing.T) {
+	out, err := responsesToAnthropicRequest(map[string]any{
+		"model":        "claude-sonnet-4",
+		"instructions": "You are Codex.",
+		"reasoning":    map[string]any{"effort": "medium"},
+		"input": []any{
+			map[string]any{"role": "developer", "content": []any{map[string]any{"type": "input_text", "text": "Stay in the repo."}}},
+			map[string]any{"role": "user", "content": []any{map[string]any{"type": "input_text", "text": "list files"}}},
+			map[string]any{"type": "reasoning", "summary": []any{map[string]any{"type": "summary_text", "text": "Need ls."}}, "encrypted_content": "sig_1"},
+			map[string]any{"type": "local_shell_call", "call_id": "toolu_1", "action": map[string]any{"type": "exec", "command": []any{"ls"}}},
+			map[string]any{"type": "local_shell_call_output", "call_id": "toolu_1", "output": "main.go"},
+		},
+		"tools": []any{map[string]any{"type": "local_shell"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	system, _ := out["system"].([]any)
+	if len(system) != 2 || mapValue(system[0])["text"] != "You are Codex." || mapValue(system[1])["text"] != "Stay in the repo." {
+		t.Fatalf("unexpected system: %#v", out["system"])
+	}
+	msgs := out["messages"].([]map[string]any)
+	if len(msgs) != 3 {
+		t.Fatalf("expected user/assistant/user turns, got %#v", msgs)
+	}
+	assistant := msgs[1]["content"].([]any)
+	thinking, toolUse := mapValue(assistant[0]), mapValue(assistant[1])
+	if thinking["type"] != "thinking" || thinking["signature"] != "sig_1" || thinking["thinking"] != "Need ls." {
+		t.Fatalf("unexpected thinking replay: %#v", thinking)
+	}
+	if toolUse["name"] != "local_shell" || toolUse["id"] != "toolu_1" {
+		t.Fatalf("unexpected tool_use replay: %#v", toolUse)
+	}
+	result := mapValue(msgs[2]["content"].([]any)[0])
+	if result["type"] != "tool_result" || result["content"] != "main.go" {
+		t.Fatalf("unexpected tool_result: %#v", result)
+	}
+	if mapValue(out["thinking"])["budget_tokens"] != 16384 {
+		t.Fatalf("expected thinking kept with signed replay: %#v", out["thinking"])
+	}
+}
+
+func TestResponsesToAnthropicRequest_DropsThinkingWithoutSignedReplay(t *testing.T) {
+	out, err := responsesToAnthropicRequest(map[string]any{
+		"model":     "claude-sonnet-4",
+		"reasoning": map[string]any{"effort": "high"},
+		"input": []any{
+			map[string]any{"role": "user", "content": "read a"},
+			map[string]any{"type": "function_call", "call_id": "toolu_1", "name": "read", "arguments": `{"path":"a"}`},
+			map[string]any{"type": "function_call_output", "call_id": "toolu_1", "output": "A"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := out["thinking"]; ok {
+		t.Fatalf("expected thinking dropped for unsigned tool loop: %#v", out["thinking"])
+	}
+}
+
+func startTestMessagesResponsesProxy(t *testing.T, upstreamURL string) *responsesCompatProxy {
+	t.Helper()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	p, err := startResponsesCompatProxy(&config.Profile{AnthropicBaseURL: upstreamURL, AnthropicAuthToken: "sk-ant"}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestResponsesCompatProxy_AnthropicOnlyProfileNonStream(t *testing.T) {
+	var mu sync.Mutex
+	var upstreamReqs []map[string]any
+	upstream := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		mu.Lock()
+		upstreamReqs = append(upstreamReqs, req)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4","content":[` +
+			`{"type":"thinking","thinking":"Patch it.","signature":"sig_1"},` +
+			`{"type":"text","text":"Applying."},` +
+			`{"type":"tool_use","id":"toolu_1","name":"apply_patch","input":{"input":"*** Begin Patch"}}],` +
+			`"stop_reason":"tool_use","usage":{"input_tokens":10,"cache_read_input_tokens":90,"output_tokens":5}}`))
+	})
+	p := startTestMessagesResponsesProxy(t, upstream.URL)
+
+	body, _ := json.Marshal(codexEmulatedToolsRequest())
+	resp, err := http.Post(p.BaseURL()+"/responses", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	items, _ := out["output"].([]any)
+	if len(items) != 3 {
+		t.Fatalf("expected reasoning, message and tool call items, got %#v", out)
+	}
+	reasoning, message, call := mapValue(items[0]), mapValue(items[1]), mapValue(items[2])
+	if reasoning["type"] != "reasoning" || reasoning["encrypted_content"] != "sig_1" {
+		t.Fatalf("unexpected reasoning item: %#v", reasoning)
+	}
+	if message["type"] != "message" || out["output_text"] != "Applying." {
+		t.Fatalf("unexpected message item: %#v", message)
+	}
+	if call["type"] != "custom_tool_call" || call["input"] != "*** Begin Patch" || call["call_id"] != "toolu_1" {
+		t.Fatalf("unexpected tool call item: %#v", call)
+	}
+	usage := mapValue(out["usage"])
+	if intFromAny(usage["input_tokens"]) != 100 || intFromAny(mapValue(usage["input_tokens_details"])["cached_tokens"]) != 90 {
+		t.Fatalf("unexpected usage: %#v", usage)
+	}
+	if len(upstreamReqs) != 1 || upstreamReqs[0]["model"] != "GLM-4.7" {
+		t.Fatalf("unexpected upstream requests: %#v", upstreamReqs)
+	}
+}
+
+func TestResponsesCompatProxy_AnthropicOnlyProfileStream(t *testing.T) {
+	upstream := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, strings.Join([]string{
+			`event: message_start`,
+			`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4","usage":{"input_tokens":12,"output_tokens":1}}}`,
+			`data: {"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Read it."}}`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig_1"}}`,
+			`data: {"type":"content_block_stop","index":0}`,
+			`data: {"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hi"}}`,
+			`data: {"type":"content_block_stop","index":1}`,
+			`data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"read","input":{}}}`,
+			`data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"path\":\"a\"}"}}`,
+			`data: {"type":"content_block_stop","index":2}`,
+			`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":9}}`,
+			`data: {"type":"message_stop"}`,
+			``,
+		}, "\n\n"))
+	})
+	p := startTestMessagesResponsesProxy(t, upstream.URL)
+
+	resp, err := http.Post(p.BaseURL()+"/responses", "application/json",
+		strings.NewReader(`{"model":"claude-sonnet-4","stream":true,"input":"hi","tools":[{"type":"function","name":"read","parameters":{"type":"object"}}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	body := string(data)
+	for _, want := range []string{
+		`"type":"response.reasoning_summary_text.delta"`,
+		`"delta":"Hi"`,
+		`"type":"response.function_call_arguments.delta"`,
+		`"arguments":"{\"path\":\"a\"}"`,
+		"data: [DONE]",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %s in stream: %q", want, body)
+		}
+	}
+	var completed map[string]any
+	for _, line := range strings.Split(body, "\n") {
+		var ev map[string]any
+		if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev) == nil && ev["type"] == "response.completed" {
+			completed = mapValue(ev["response"])
+		}
+	}
+	items, _ := completed["output"].([]any)
+	if len(items) != 3 || mapValue(items[0])["encrypted_content"] != "sig_1" || mapValue(items[2])["call_id"] != "toolu_1" {
+		t.Fatalf("unexpected completed output: %#v", completed)
+	}
+	if intFromAny(mapValue(completed["usage"])["output_tokens"]) != 9 {
+		t.Fatalf("unexpected completed usage: %#v", completed["usage"])
+	}
+}
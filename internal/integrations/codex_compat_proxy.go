@@ -12,8 +12,9 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"sync"
 	"time"
+
+	"spark/internal/config"
 )
 
 type responsesCompatProxy struct {
@@ -22,14 +23,27 @@ type responsesCompatProxy struct {
 	baseURL      string
 	upstreamBase string
 	upstreamKey  string
+	keys         *keyPool
+	upstream     chatUpstream
+	messages     *anthropicMessagesUpstream
+	fallbacks    *upstreamChain
+	retry        *retryPolicy
+	limiter      *upstreamLimiter
+	timeouts     *streamTimeouts
+	recorder     *cassetteRecorder
+	toolParsers  *textToolCallParsers
+	webSearch    *webSearcher
+	replay       *cassetteReplayer
+	models       *modelCatalog
+	store        *responsesStore
 	client       *http.Client
 	quietStderr  bool
 	logFile      io.WriteCloser
-	logMu        sync.Mutex
+	log          *compatLogger
 	logPath      string
 }
 
-func startResponsesCompatProxy(upstreamBase, upstreamKey string, quietStderr bool) (*responsesCompatProxy, error) {
+func startResponsesCompatProxy(profile *config.Profile, quietStderr bool) (*responsesCompatProxy, error) {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, err
@@ -37,19 +51,81 @@ func startResponsesCompatProxy(upstreamBase, upstreamKey string, quietStderr boo
 	p := &responsesCompatProxy{
 		listener:     ln,
 		baseURL:      "http://" + ln.Addr().String() + "/v1",
-		upstreamBase: strings.TrimRight(upstreamBase, "/"),
-		upstreamKey:  upstreamKey,
+		upstreamBase: strings.TrimRight(profileBase(profile), "/"),
+		upstreamKey:  profileKey(profile),
+		keys:         newKeyPool(profile),
+		limiter:      newUpstreamLimiter(profile),
+		store:        newResponsesStore(responsesStoreMaxEntries, responsesStoreDir()),
 		client:       newStreamingHTTPClient(),
 		quietStderr:  quietStderr,
 	}
+	upstream, err := newChatUpstream(profile, p.client, p.logf)
+	if err == nil {
+		p.fallbacks, err = newUpstreamChain(profile, p.client, p.logf)
+	}
+	if err == nil {
+		p.retry, err = newRetryPolicy(profile)
+	}
+	if err == nil {
+		p.timeouts, err = newStreamTimeouts(profile)
+	}
+	if err == nil {
+		p.toolParsers, err = newTextToolCallParsers(profile)
+	}
+	if err == nil {
+		p.webSearch, err = newWebSearcher(profile)
+	}
+	if err == nil {
+		p.replay, err = newCassetteReplayer("codex", p.logf)
+	}
+	if err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	p.fallbacks.wrapFallbacks(p.timeouts.executor)
+	fetchModels := p.fetchUpstreamModels
+	if upstream != nil {
+		p.upstream = upstream
+		fetchModels = upstream.fetchModels
+	}
+	if anthropicOnlyProfile(profile) {
+		// No chat/completions endpoint to target: talk Messages directly.
+		messages := &anthropicMessagesUpstream{
+			client:  p.client,
+			baseURL: anthropicBaseURL(profile),
+			token:   profile.AnthropicAuthToken,
+			logf:    p.logf,
+		}
+		if p.fallbacks != nil {
+			// Fallbacks speak chat/completions, so failing over needs the
+			// chat path, with Messages bridged as its primary upstream.
+			p.upstream = messages
+		} else {
+			p.messages = messages
+		}
+		fetchModels = messages.fetchModels
+	}
+	if p.replay != nil {
+		fetchModels = p.replay.fetchModels
+	}
+	p.models = newModelCatalog(profileModelIDs(profile), fetchModels)
 	logFile, logPath, err := openCompatLogFile()
 	if err != nil {
 		return nil, err
 	}
 	p.logFile = logFile
 	p.logPath = logPath
+	if p.log, err = newCompatLogger(logFile, "codex", profile); err != nil {
+		_ = ln.Close()
+		_ = logFile.Close()
+		return nil, err
+	}
+	p.logf("logger initialized log_bodies=%s", p.log.bodies)
+	p.recorder = newCassetteRecorder("codex", p.log.bodies, p.logf)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/responses", p.handleResponses)
+	mux.HandleFunc("/v1/models", p.handleModels)
+	mux.HandleFunc("/v1/models/", p.handleModels)
 	p.server = &http.Server{Handler: mux}
 
 	go func() {
@@ -77,12 +153,7 @@ func (p *responsesCompatProxy) LogPath() string {
 }
 
 func (p *responsesCompatProxy) logf(format string, args ...any) {
-	line := fmt.Sprintf("[compat] "+format, args...)
-	p.logMu.Lock()
-	defer p.logMu.Unlock()
-	if p.logFile != nil {
-		_, _ = fmt.Fprintf(p.logFile, "%s %s\n", time.Now().Format(time.RFC3339), line)
-	}
+	p.log.logf(format, args...)
 }
 
 func (p *responsesCompatProxy) warnf(summary string) {
@@ -93,7 +164,11 @@ func (p *responsesCompatProxy) warnf(summary string) {
 }
 
 func (p *responsesCompatProxy) handleResponses(w http.ResponseWriter, r *http.Request) {
-	p.logf("request method=%s path=%s content_type=%q content_encoding=%q user_agent=%q",
+	lw, r := p.log.begin(w, r)
+	defer lw.finish()
+	w = lw
+	logf := lw.log.logf
+	logf("request method=%s path=%s content_type=%q content_encoding=%q user_agent=%q",
 		r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"), r.Header.Get("User-Agent"))
 
 	if r.Method != http.MethodPost {
@@ -103,54 +178,137 @@ func (p *responsesCompatProxy) handleResponses(w http.ResponseWriter, r *http.Re
 
 	req, rawBody, err := decodeResponsesRequest(r)
 	if err != nil {
-		p.logf("raw incoming body=%s", rawBody)
-		p.logf("decode request failed: %v", err)
+		logf("raw incoming body=%s", bodyForLog(rawBody, 16*1024))
+		logf("decode request failed: %v", err)
 		p.warnf("request decode failed")
 		writeJSONError(w, http.StatusBadRequest, "invalid json (adapter request decode failed: "+err.Error()+")")
 		return
 	}
-	p.logf("raw incoming body=%s", rawBody)
-	p.logf("decoded responses request=%s", mustJSONForLog(req))
+	logf("raw incoming body=%s", bodyForLog(rawBody, 16*1024))
+	logf("decoded responses request=%s", mustJSONForLog(req))
+	lw.log.setModel(stringValue(req["model"]))
+	if dropped := responsesDroppedParams(req); len(dropped) > 0 {
+		logf("warning: upstream chat/completions has no equivalent for %s; ignoring", strings.Join(dropped, ", "))
+	}
 
-	reqTranslator := newResponsesRequestTranslator()
-	executor := newCodexChatExecutor(p)
-	chatReq, upResp, err := executeTranslatedChat(r.Context(), req, reqTranslator, executor)
-	if err != nil {
-		var perr pipelineError
-		if errors.As(err, &perr) && perr.stage == pipelineStageTranslate {
-			p.logf("request translate failed: %v", perr.err)
-			writeJSONError(w, http.StatusBadRequest, "invalid request")
+	// Keep the input as the client sent it plus any chained history, so the
+	// stored transcript never includes adapter-side rewrites.
+	fullInput := responsesInputItems(req["input"])
+	if prevID := stringValue(req["previous_response_id"]); prevID != "" {
+		prev, ok := p.store.Get(prevID)
+		if !ok {
+			logf("previous_response_id not found id=%s", prevID)
+			writeJSONError(w, http.StatusBadRequest, "previous response not found: "+prevID)
 			return
 		}
-		p.logf("upstream request failed: %v", err)
-		p.warnf("upstream request failed")
-		writeJSONError(w, http.StatusBadGateway, "upstream request failed: "+err.Error())
+		fullInput = responsesTranscript(prev, req["input"])
+		req["input"] = fullInput
+		logf("rebuilt transcript from previous_response_id=%s items=%d", prevID, len(fullInput))
+	}
+
+	stream, _ := req["stream"].(bool)
+	var resp map[string]any
+	if p.messages != nil {
+		resp = p.respondViaMessages(w, r, req, stream)
+	} else {
+		resp = p.respondViaChat(w, r, req, stream)
+	}
+	if resp == nil {
 		return
 	}
-	p.logf("mapped chat request(initial)=%s", mustJSONForLog(chatReq))
+	if usage, ok := resp["usage"].(map[string]any); ok {
+		lw.log.setUsage(intFromAny(usage["input_tokens"]), intFromAny(usage["output_tokens"]),
+			intFromAny(mapValue(usage["input_tokens_details"])["cached_tokens"]))
+	}
+	if store, ok := req["store"].(bool); ok && !store {
+		return
+	}
+	entry := &storedResponse{
+		ID:     stringValue(resp["id"]),
+		Input:  responsesStoreItems(fullInput),
+		Output: responsesStoreItems(resp["output"]),
+	}
+	if err := p.store.Put(entry); err != nil {
+		logf("store response failed id=%s: %v", entry.ID, err)
+	}
+}
+
+// respondViaChat serves the request through the chat/completions upstream and
+// returns the Responses object sent to the client, or nil on error.
+func (p *responsesCompatProxy) respondViaChat(w http.ResponseWriter, r *http.Request, req map[string]any, stream bool) map[string]any {
+	logf := requestLogOf(w).logfOr(p.logf)
+	reqTranslator := newResponsesRequestTranslator()
+	executor := newCodexChatExecutor(p)
+	chatReq, upResp, err := executeTranslatedChat(p.requestContext(r, req), req, reqTranslator, executor)
+	if err != nil {
+		p.writePipelineError(w, err)
+		return nil
+	}
+	logf("mapped chat request(initial)=%s", mustJSONForLog(chatReq))
 	defer upResp.Body.Close()
 
-	stream, _ := req["stream"].(bool)
 	writer := newCodexResponseWriter(p)
-	writer.Write(w, upResp, stream)
+	return writer.Write(w, upResp, stream, responsesToolSetFromRequest(req))
 }
 
-func (p *responsesCompatProxy) postChatCompletions(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
-	body, err := json.Marshal(chatReq)
+// respondViaMessages is respondViaChat for an Anthropic Messages upstream.
+func (p *responsesCompatProxy) respondViaMessages(w http.ResponseWriter, r *http.Request, req map[string]any, stream bool) map[string]any {
+	logf := requestLogOf(w).logfOr(p.logf)
+	msgReq, upResp, err := executeTranslatedMessages(p.requestContext(r, req), req, newResponsesMessagesTranslator(), newCodexMessagesExecutor(p))
 	if err != nil {
-		return nil, err
+		p.writePipelineError(w, err)
+		return nil
 	}
-	upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.upstreamBase+"/chat/completions", bytes.NewReader(body))
+	logf("mapped messages request=%s", mustJSONForLog(msgReq))
+	defer upResp.Body.Close()
+
+	writer := newCodexResponseWriter(p)
+	return writer.WriteMessages(w, upResp, stream, responsesToolSetFromRequest(req))
+}
+
+// requestContext carries the client request to the executors: the cassette
+// recorder keeps it, and the web search executors run only when it declares
+// the built-in web_search tool.
+func (p *responsesCompatProxy) requestContext(r *http.Request, req map[string]any) context.Context {
+	ctx := withCassetteRequest(r.Context(), req)
+	if p.webSearch != nil && responsesToolSetFromRequest(req).kind(responsesToolWebSearch) == responsesToolWebSearch {
+		ctx = withWebSearchTool(ctx)
+	}
+	return ctx
+}
+
+func (p *responsesCompatProxy) writePipelineError(w http.ResponseWriter, err error) {
+	logf := requestLogOf(w).logfOr(p.logf)
+	var perr pipelineError
+	if errors.As(err, &perr) && perr.stage == pipelineStageTranslate {
+		logf("request translate failed: %v", perr.err)
+		writeJSONError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	logf("upstream request failed: %v", err)
+	p.warnf("upstream request failed")
+	writeJSONError(w, http.StatusBadGateway, "upstream request failed: "+err.Error())
+}
+
+func (p *responsesCompatProxy) postChatCompletions(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(p.logf)
+	body, err := json.Marshal(chatReq)
 	if err != nil {
 		return nil, err
 	}
-	upReq.Header.Set("Content-Type", "application/json")
-	upReq.Header.Set("Accept-Encoding", "identity")
-	if p.upstreamKey != "" {
-		upReq.Header.Set("Authorization", "Bearer "+p.upstreamKey)
-	}
-	p.logf("upstream POST %s payload=%s", p.upstreamBase+"/chat/completions", truncateForLog(string(body), 16*1024))
-	return p.client.Do(upReq)
+	logf("upstream POST %s payload=%s", p.upstreamBase+"/chat/completions", bodyForLog(string(body), 16*1024))
+	return p.keys.do(p.upstreamKey, logf, func(key string) (*http.Response, error) {
+		upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.upstreamBase+"/chat/completions", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		upReq.Header.Set("Content-Type", "application/json")
+		upReq.Header.Set("Accept-Encoding", "identity")
+		if key != "" {
+			upReq.Header.Set("Authorization", "Bearer "+key)
+		}
+		return p.client.Do(upReq)
+	})
 }
 
 func shouldRetryWithMinimalChatReq(status int, data []byte) bool {
@@ -208,38 +366,36 @@ func ultraMinimalChatCompletionsRequest(chatReq map[string]any) map[string]any {
 	return out
 }
 
-func (p *responsesCompatProxy) forwardNonStream(w http.ResponseWriter, upResp *http.Response) {
+func (p *responsesCompatProxy) forwardNonStream(w http.ResponseWriter, upResp *http.Response, tools responsesToolSet) map[string]any {
+	logf := requestLogOf(w).logfOr(p.logf)
 	if upResp.StatusCode >= 400 {
 		p.warnf(fmt.Sprintf("forward non-stream upstream status %d", upResp.StatusCode))
 		writeUpstreamErrorAsJSON(w, upResp)
-		return
+		return nil
 	}
 	rawBody, err := io.ReadAll(upResp.Body)
 	if err != nil {
 		p.warnf("failed to read upstream non-stream body")
 		writeJSONError(w, http.StatusBadGateway, "invalid upstream response")
-		return
+		return nil
 	}
-	p.logf("upstream non-stream raw body=%s", truncateForLog(string(rawBody), 16*1024))
+	logf("upstream non-stream raw body=%s", bodyForLog(string(rawBody), 16*1024))
 	var chatResp map[string]any
 	if err := json.NewDecoder(bytes.NewReader(rawBody)).Decode(&chatResp); err != nil {
 		p.warnf("invalid upstream non-stream JSON")
 		writeJSONError(w, http.StatusBadGateway, "invalid upstream response")
-		return
+		return nil
 	}
 
 	text := extractChatText(chatResp)
-	p.logf("non-stream extracted text length=%d", len(text))
+	logf("non-stream extracted text length=%d", len(text))
 	model := stringValue(chatResp["model"])
 	if model == "" {
 		model = "unknown"
 	}
-	id := stringValue(chatResp["id"])
-	if id == "" {
-		id = fmt.Sprintf("resp_%d", time.Now().UnixNano())
-	}
+	id := newResponseID()
 
-	outputItems := make([]map[string]any, 0, 2)
+	outputItems := webSearchCallItems(chatResp[webSearchCallsKey])
 	if text != "" {
 		outputItems = append(outputItems, map[string]any{
 			"type": "message",
@@ -253,14 +409,7 @@ func (p *responsesCompatProxy) forwardNonStream(w http.ResponseWriter, upResp *h
 		})
 	}
 	for _, tc := range extractChatToolCalls(chatResp) {
-		outputItems = append(outputItems, map[string]any{
-			"id":        tc.ID,
-			"type":      "function_call",
-			"call_id":   tc.CallID,
-			"name":      tc.Name,
-			"arguments": tc.Arguments,
-			"status":    "completed",
-		})
+		outputItems = append(outputItems, responsesToolCallItem(tools.kind(tc.Name), tc.ID, tc.CallID, tc.Name, tc.Arguments))
 	}
 	out := map[string]any{
 		"id":          id,
@@ -272,31 +421,33 @@ func (p *responsesCompatProxy) forwardNonStream(w http.ResponseWriter, upResp *h
 	}
 	if usage, ok := chatUsageToResponsesUsage(chatResp); ok {
 		out["usage"] = usage
-		p.logf("non-stream usage present response_id=%s model=%s %s", id, model, formatUsageForLog(usage))
+		logf("non-stream usage present response_id=%s model=%s %s", id, model, formatUsageForLog(usage))
 	} else {
-		p.logf("non-stream usage missing response_id=%s model=%s", id, model)
+		logf("non-stream usage missing response_id=%s model=%s", id, model)
 		p.warnf("upstream non-stream response missing token usage")
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(out)
+	return out
 }
 
-func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http.Response) {
+func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http.Response, tools responsesToolSet) map[string]any {
+	logf := requestLogOf(w).logfOr(p.logf)
 	if upResp.StatusCode >= 400 {
 		p.warnf(fmt.Sprintf("forward stream upstream status %d", upResp.StatusCode))
 		writeUpstreamErrorAsJSON(w, upResp)
-		return
+		return nil
 	}
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		writeJSONError(w, http.StatusInternalServerError, "stream not supported")
-		return
+		return nil
 	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	p.logf("forward stream headers status=%d content_type=%q content_encoding=%q transfer_encoding=%v",
+	logf("forward stream headers status=%d content_type=%q content_encoding=%q transfer_encoding=%v",
 		upResp.StatusCode, upResp.Header.Get("Content-Type"), upResp.Header.Get("Content-Encoding"), upResp.TransferEncoding)
 
 	scanner := bufio.NewScanner(upResp.Body)
@@ -305,7 +456,7 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 	var fullText strings.Builder
 	var fullReasoning strings.Builder
 	model := "unknown"
-	respID := fmt.Sprintf("resp_%d", time.Now().UnixNano())
+	respID := newResponseID()
 	msgItemID := fmt.Sprintf("msg_%d", time.Now().UnixNano())
 	reasoningItemID := fmt.Sprintf("rs_%d", time.Now().UnixNano())
 	type streamToolCallState struct {
@@ -321,6 +472,8 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 	var chunkSamples []string
 	chunkCount := 0
 	sawDone := false
+	sawFinish := false
+	var failure *streamFailure
 	firstValidChunk := ""
 	lastValidChunk := ""
 	messageStarted := false
@@ -330,6 +483,7 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 	messageOutputIndex := -1
 	nextOutputIndex := 0
 	lastUsage := map[string]any{}
+	var searchItems []map[string]any
 
 	startMessage := func() {
 		if messageStarted {
@@ -406,9 +560,9 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 			break
 		}
 		if firstValidChunk == "" {
-			firstValidChunk = truncateForLog(data, 512)
+			firstValidChunk = data
 		}
-		lastValidChunk = truncateForLog(data, 512)
+		lastValidChunk = data
 		chunkCount++
 		if len(chunkSamples) < 12 {
 			chunkSamples = append(chunkSamples, truncateForLog(data, 512))
@@ -420,14 +574,24 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 			}
 			continue
 		}
+		if failure = streamErrorChunk(chunk); failure != nil {
+			logf("upstream stream error chunk=%s", bodyForLog(data, 1024))
+			break
+		}
+		if extractChatFinishReason(chunk) != "" || chatChunkIsCompletion(chunk) {
+			sawFinish = true
+		}
 		if usage, ok := chatUsageToResponsesUsage(chunk); ok {
 			lastUsage = mergeResponsesUsage(lastUsage, usage)
 		}
 		if m := stringValue(chunk["model"]); m != "" {
 			model = m
 		}
-		if i := stringValue(chunk["id"]); i != "" {
-			respID = i
+		for _, item := range webSearchCallItems(chunk[webSearchCallsKey]) {
+			writeWebSearchCallEvents(w, nextOutputIndex, item)
+			nextOutputIndex++
+			searchItems = append(searchItems, item)
+			flusher.Flush()
 		}
 		reasoningDelta := extractChatReasoningDelta(chunk)
 		if reasoningDelta != "" {
@@ -496,6 +660,11 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 			if td.ArgumentsDelta != "" {
 				st.Arguments.WriteString(td.ArgumentsDelta)
 			}
+			if tools.kind(st.Name) != responsesToolFunction {
+				// Emulated tools are announced once their arguments are complete,
+				// since the native item carries the decoded input, not raw JSON.
+				continue
+			}
 			if startArgs || td.Name != "" || td.CallID != "" {
 				writeSSE(w, map[string]any{
 					"type":         "response.output_item.added",
@@ -550,9 +719,6 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 			if m := stringValue(full["model"]); m != "" {
 				model = m
 			}
-			if i := stringValue(full["id"]); i != "" {
-				respID = i
-			}
 			for _, tc := range extractChatToolCalls(full) {
 				if len(toolStates) > 0 {
 					break
@@ -573,24 +739,25 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 	}
 	scanErr := scanner.Err()
 	if scanErr != nil {
-		p.logf("upstream stream scan error: %v", scanErr)
-	}
-	p.logf("stream parse summary chunks=%d extracted_text_len=%d samples=%s",
-		chunkCount, len(text), truncateForLog(strings.Join(chunkSamples, " || "), 16*1024))
-	p.logf("stream parse flags saw_done=%t saw_content_delta=%t reasoning_len=%d first_chunk=%q last_chunk=%q",
-		sawDone, sawContentDelta, len(fullReasoning.String()), firstValidChunk, lastValidChunk)
-	if scanErr != nil && chunkCount == 0 {
-		p.warnf("upstream stream failed before first chunk")
-		writeSSE(w, map[string]any{
-			"type": "error",
-			"error": map[string]any{
-				"type":    "upstream_stream_error",
-				"message": "upstream stream parse failed before first chunk: " + scanErr.Error(),
-			},
-		})
+		logf("upstream stream scan error: %v", scanErr)
+	}
+	logf("stream parse summary chunks=%d extracted_text_len=%d samples=%s",
+		chunkCount, len(text), bodyForLog(strings.Join(chunkSamples, " || "), 16*1024))
+	logf("stream parse flags saw_done=%t saw_content_delta=%t reasoning_len=%d first_chunk=%q last_chunk=%q",
+		sawDone, sawContentDelta, len(fullReasoning.String()), bodyForLog(firstValidChunk, 512), bodyForLog(lastValidChunk, 512))
+	if failure == nil && scanErr != nil {
+		failure = streamReadFailure(scanErr)
+	}
+	if failure == nil && !sawDone && !sawFinish {
+		failure = prematureStreamEnd()
+	}
+	if failure != nil {
+		p.warnf("upstream stream failed")
+		logf("stream failed code=%s message=%q", failure.class.responsesCode(), bodyForLog(failure.message, 1024))
+		writeResponsesFailed(w, respID, model, failure.class.responsesCode(), failure.message)
 		_, _ = io.WriteString(w, "data: [DONE]\n\n")
 		flusher.Flush()
-		return
+		return nil
 	}
 	if text == "" {
 		p.warnf("stream response extracted empty text")
@@ -626,6 +793,25 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 		if args == "" {
 			args = "{}"
 		}
+		if kind := tools.kind(st.Name); kind != responsesToolFunction {
+			item := responsesToolCallItem(kind, st.ItemID, st.CallID, st.Name, args)
+			added := map[string]any{}
+			for k, v := range item {
+				added[k] = v
+			}
+			added["status"] = "in_progress"
+			writeSSE(w, map[string]any{
+				"type":         "response.output_item.added",
+				"output_index": st.OutputIndex,
+				"item":         added,
+			})
+			writeSSE(w, map[string]any{
+				"type":         "response.output_item.done",
+				"output_index": st.OutputIndex,
+				"item":         item,
+			})
+			continue
+		}
 		writeSSE(w, map[string]any{
 			"type":         "response.function_call_arguments.done",
 			"item_id":      st.ItemID,
@@ -681,7 +867,7 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 			},
 		})
 	}
-	outputItems := make([]map[string]any, 0, 2+len(toolOrder))
+	outputItems := make([]map[string]any, 0, 2+len(searchItems)+len(toolOrder))
 	if reasoningStarted {
 		outputItems = append(outputItems, map[string]any{
 			"id":      reasoningItemID,
@@ -689,27 +875,13 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 			"summary": []map[string]any{{"type": "summary_text", "text": fullReasoning.String()}},
 		})
 	}
+	outputItems = append(outputItems, searchItems...)
 	for _, idx := range toolOrder {
 		st := toolStates[idx]
 		if st == nil {
 			continue
 		}
-		args := st.Arguments.String()
-		if args == "" {
-			args = "{}"
-		}
-		callID := st.CallID
-		if callID == "" {
-			callID = st.ItemID
-		}
-		outputItems = append(outputItems, map[string]any{
-			"id":        st.ItemID,
-			"type":      "function_call",
-			"call_id":   callID,
-			"name":      st.Name,
-			"arguments": args,
-			"status":    "completed",
-		})
+		outputItems = append(outputItems, responsesToolCallItem(tools.kind(st.Name), st.ItemID, st.CallID, st.Name, st.Arguments.String()))
 	}
 	if messageStarted {
 		outputItems = append(outputItems, map[string]any{
@@ -735,9 +907,9 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 	}
 	if len(lastUsage) > 0 {
 		resp["usage"] = lastUsage
-		p.logf("stream usage present response_id=%s model=%s %s", respID, model, formatUsageForLog(lastUsage))
+		logf("stream usage present response_id=%s model=%s %s", respID, model, formatUsageForLog(lastUsage))
 	} else {
-		p.logf("stream usage missing response_id=%s model=%s chunks=%d saw_done=%t", respID, model, chunkCount, sawDone)
+		logf("stream usage missing response_id=%s model=%s chunks=%d saw_done=%t", respID, model, chunkCount, sawDone)
 		p.warnf("upstream stream completed without token usage")
 	}
 	writeSSE(w, map[string]any{
@@ -746,6 +918,7 @@ func (p *responsesCompatProxy) forwardStream(w http.ResponseWriter, upResp *http
 	})
 	_, _ = io.WriteString(w, "data: [DONE]\n\n")
 	flusher.Flush()
+	return resp
 }
 
 func chatUsageToResponsesUsage(payload map[string]any) (map[string]any, bool) {
@@ -840,6 +1013,10 @@ func mergeResponsesUsage(base map[string]any, incoming map[string]any) map[strin
 	}
 
 	out["cached_input_tokens"] = intFromAny(base["cached_input_tokens"])
+	if v := intFromAny(mapValue(base["input_tokens_details"])["cached_tokens"]); v > 0 {
+		out["cached_input_tokens"] = v
+		out["input_tokens_details"] = map[string]any{"cached_tokens": v}
+	}
 	if v := intFromAny(incoming["cached_input_tokens"]); v > 0 {
 		out["cached_input_tokens"] = v
 	}
@@ -849,6 +1026,10 @@ func mergeResponsesUsage(base map[string]any, incoming map[string]any) map[strin
 	}
 
 	out["reasoning_output_tokens"] = intFromAny(base["reasoning_output_tokens"])
+	if v := intFromAny(mapValue(base["output_tokens_details"])["reasoning_tokens"]); v > 0 {
+		out["reasoning_output_tokens"] = v
+		out["output_tokens_details"] = map[string]any{"reasoning_tokens": v}
+	}
 	if v := intFromAny(incoming["reasoning_output_tokens"]); v > 0 {
 		out["reasoning_output_tokens"] = v
 	}
@@ -859,6 +1040,7 @@ func mergeResponsesUsage(base map[string]any, incoming map[string]any) map[strin
 
 	return out
 }
+
 func formatUsageForLog(usage map[string]any) string {
 	input := intFromAny(usage["input_tokens"])
 	output := intFromAny(usage["output_tokens"])
@@ -874,6 +1056,24 @@ func formatUsageForLog(usage map[string]any) string {
 	return fmt.Sprintf("usage input=%d output=%d total=%d cached=%d reasoning=%d", input, output, total, cached, reasoning)
 }
 
+// writeResponsesFailed ends a Responses stream with response.failed.
+func writeResponsesFailed(w io.Writer, respID, model, code, message string) {
+	writeSSE(w, map[string]any{
+		"type": "response.failed",
+		"response": map[string]any{
+			"id":     respID,
+			"object": "response",
+			"status": "failed",
+			"model":  model,
+			"output": []any{},
+			"error": map[string]any{
+				"code":    code,
+				"message": message,
+			},
+		},
+	})
+}
+
 func writeSSE(w io.Writer, payload any) {
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -90,6 +90,75 @@ func TestResponsesToChatCompletions_ToolsMappedAndFiltered(t *testing.T) {
 	}
 }
 
+func TestResponsesToChatCompletions_InstructionsBecomeSystemMessage(t *testing.T) {
+	req := map[string]any{
+		"model":        "GLM-4.7",
+		"instructions": "You are Codex.",
+		"input":        "hello",
+	}
+	out := responsesToChatCompletions(req)
+	messages := out["messages"].([]map[string]any)
+	if len(messages) != 2 || messages[0]["role"] != "system" || messages[0]["content"] != "You are Codex." {
+		t.Fatalf("expected instructions as a leading system message: %#v", messages)
+	}
+	if dropped := responsesDroppedParams(req); len(dropped) != 0 {
+		t.Fatalf("expected instructions reported as mapped, got %v", dropped)
+	}
+}
+
+func TestResponsesToChatCompletions_ReasoningAndTextFormat(t *testing.T) {
+	req := map[string]any{
+		"model":     "GLM-4.7",
+		"input":     "hello",
+		"reasoning": map[string]any{"effort": "high", "summary": "auto"},
+		"text": map[string]any{
+			"format": map[string]any{
+				"type":   "json_schema",
+				"name":   "answer",
+				"strict": true,
+				"schema": map[string]any{"type": "object"},
+			},
+			"verbosity": "low",
+		},
+		"include": []any{"reasoning.encrypted_content"},
+	}
+	out := responsesToChatCompletions(req)
+
+	if out["reasoning_effort"] != "high" {
+		t.Fatalf("reasoning_effort mismatch: %#v", out["reasoning_effort"])
+	}
+	rf, ok := out["response_format"].(map[string]any)
+	if !ok || rf["type"] != "json_schema" {
+		t.Fatalf("response_format mismatch: %#v", out["response_format"])
+	}
+	js := mapValue(rf["json_schema"])
+	if js["name"] != "answer" || js["strict"] != true || mapValue(js["schema"])["type"] != "object" {
+		t.Fatalf("json_schema mismatch: %#v", js)
+	}
+
+	dropped := strings.Join(responsesDroppedParams(req), ",")
+	if dropped != "include,reasoning.summary,text.verbosity" {
+		t.Fatalf("dropped params mismatch: %q", dropped)
+	}
+}
+
+func TestResponsesToChatCompletions_TextFormatJSONObjectAndPlainText(t *testing.T) {
+	out := responsesToChatCompletions(map[string]any{
+		"input": "hello",
+		"text":  map[string]any{"format": map[string]any{"type": "json_object"}},
+	})
+	if rf := mapValue(out["response_format"]); rf["type"] != "json_object" {
+		t.Fatalf("response_format mismatch: %#v", out["response_format"])
+	}
+	out = responsesToChatCompletions(map[string]any{
+		"input": "hello",
+		"text":  map[string]any{"format": map[string]any{"type": "text"}},
+	})
+	if _, ok := out["response_format"]; ok {
+		t.Fatalf("expected no response_format for plain text: %#v", out["response_format"])
+	}
+}
+
 func TestResponsesInputToMessages_ArrayInput(t *testing.T) {
 	input := []any{
 		map[string]any{
@@ -237,7 +306,7 @@ func TestForwardNonStream_MapsToolCallsToFunctionCallOutputItems(t *testing.T) {
 	}
 	rec := &responseRecorder{header: make(http.Header)}
 	p := &responsesCompatProxy{}
-	p.forwardNonStream(rec, upResp)
+	p.forwardNonStream(rec, upResp, nil)
 
 	if rec.status != 0 && rec.status != 200 {
 		t.Fatalf("unexpected status: %d", rec.status)
@@ -260,7 +329,7 @@ func TestForwardNonStream_MapsUsageDetails(t *testing.T) {
 	}
 	rec := &responseRecorder{header: make(http.Header)}
 	p := &responsesCompatProxy{}
-	p.forwardNonStream(rec, upResp)
+	p.forwardNonStream(rec, upResp, nil)
 
 	body := rec.body.String()
 	if !strings.Contains(body, `"usage"`) {
@@ -400,7 +469,7 @@ func TestForwardStream_FallbackForSingleJSONLine(t *testing.T) {
 	}
 	rec := &flushResponseRecorder{responseRecorder: responseRecorder{header: make(http.Header)}}
 	p := &responsesCompatProxy{}
-	p.forwardStream(rec, upResp)
+	p.forwardStream(rec, upResp, nil)
 
 	body := rec.body.String()
 	if !strings.Contains(body, `"response.output_text.done"`) {
@@ -423,7 +492,7 @@ func TestForwardStream_EmitsFunctionCallEventsFromToolCallDeltas(t *testing.T) {
 	}
 	rec := &flushResponseRecorder{responseRecorder: responseRecorder{header: make(http.Header)}}
 	p := &responsesCompatProxy{}
-	p.forwardStream(rec, upResp)
+	p.forwardStream(rec, upResp, nil)
 
 	body := rec.body.String()
 	if !strings.Contains(body, `"response.function_call_arguments.delta"`) {
@@ -450,20 +519,31 @@ func TestForwardStream_ResponseCompletedIncludesUsageDetails(t *testing.T) {
 	}
 	rec := &flushResponseRecorder{responseRecorder: responseRecorder{header: make(http.Header)}}
 	p := &responsesCompatProxy{}
-	p.forwardStream(rec, upResp)
+	p.forwardStream(rec, upResp, nil)
 
 	body := rec.body.String()
-	if !strings.Contains(body, `"type":"response.completed"`) {
+	var usage map[string]any
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event map[string]any
+		if json.Unmarshal([]byte(data), &event) == nil && event["type"] == "response.completed" {
+			usage = mapValue(mapValue(event["response"])["usage"])
+		}
+	}
+	if usage == nil {
 		t.Fatalf("expected response.completed event, got %q", body)
 	}
-	if !strings.Contains(body, `"usage":{"input_tokens":12`) {
-		t.Fatalf("expected usage tokens in completed event, got %q", body)
+	if intFromAny(usage["input_tokens"]) != 12 || intFromAny(usage["output_tokens"]) != 5 {
+		t.Fatalf("expected usage tokens in completed event, got %#v", usage)
 	}
-	if !strings.Contains(body, `"input_tokens_details":{"cached_tokens":4}`) {
-		t.Fatalf("expected cached usage details in completed event, got %q", body)
+	if intFromAny(mapValue(usage["input_tokens_details"])["cached_tokens"]) != 4 {
+		t.Fatalf("expected cached usage details in completed event, got %#v", usage)
 	}
-	if !strings.Contains(body, `"output_tokens_details":{"reasoning_tokens":2}`) {
-		t.Fatalf("expected reasoning usage details in completed event, got %q", body)
+	if intFromAny(mapValue(usage["output_tokens_details"])["reasoning_tokens"]) != 2 {
+		t.Fatalf("expected reasoning usage details in completed event, got %#v", usage)
 	}
 }
 
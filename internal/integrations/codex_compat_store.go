@@ -0,0 +1,222 @@
+package integrations
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	responsesStoreMaxEntries = 256
+	responsesStoreKeepDays   = 7
+)
+
+// newResponseID mints the id of a proxied response. Upstream ids are not
+// reused: chat ids are not unique across providers or fallbacks, and the id is
+// the store key previous_response_id is resolved against.
+func newResponseID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "resp_" + hex.EncodeToString(b[:])
+}
+
+// storedResponse is everything needed to rebuild the transcript a response
+// was produced from: the full input (history included) and the output items.
+type storedResponse struct {
+	ID        string    `json:"id"`
+	Input     []any     `json:"input"`
+	Output    []any     `json:"output"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// responsesStore keeps recent responses for previous_response_id chaining.
+// Entries live in a bounded LRU; when dir is set they are also written to disk
+// so chains survive a restart of the adapter.
+type responsesStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	dir        string
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+func newResponsesStore(maxEntries int, dir string) *responsesStore {
+	if maxEntries <= 0 {
+		maxEntries = responsesStoreMaxEntries
+	}
+	s := &responsesStore{
+		maxEntries: maxEntries,
+		dir:        dir,
+		order:      list.New(),
+		entries:    map[string]*list.Element{},
+	}
+	if dir != "" {
+		s.cleanupDisk(time.Now())
+	}
+	return s
+}
+
+// responsesStoreDir returns the persistence directory configured through
+// AGENT_LAUNCH_COMPAT_STORE_DIR, or "" to keep the store in memory only.
+func responsesStoreDir() string {
+	return strings.TrimSpace(os.Getenv("AGENT_LAUNCH_COMPAT_STORE_DIR"))
+}
+
+func (s *responsesStore) Get(id string) (*storedResponse, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[id]; ok {
+		s.order.MoveToFront(el)
+		return el.Value.(*storedResponse), true
+	}
+	entry, err := s.readDisk(id)
+	if err != nil {
+		return nil, false
+	}
+	s.putLocked(entry)
+	return entry, true
+}
+
+func (s *responsesStore) Put(entry *storedResponse) error {
+	if s == nil || entry == nil || entry.ID == "" {
+		return nil
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putLocked(entry)
+	return s.writeDisk(entry)
+}
+
+func (s *responsesStore) putLocked(entry *storedResponse) {
+	if el, ok := s.entries[entry.ID]; ok {
+		el.Value = entry
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[entry.ID] = s.order.PushFront(entry)
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*storedResponse).ID)
+	}
+}
+
+var responsesStoreIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func (s *responsesStore) path(id string) (string, error) {
+	if !responsesStoreIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid response id %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *responsesStore) readDisk(id string) (*storedResponse, error) {
+	if s.dir == "" {
+		return nil, os.ErrNotExist
+	}
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry storedResponse
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	entry.ID = id
+	return &entry, nil
+}
+
+func (s *responsesStore) writeDisk(entry *storedResponse) error {
+	if s.dir == "" {
+		return nil
+	}
+	path, err := s.path(entry.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func (s *responsesStore) cleanupDisk(now time.Time) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	cutoff := now.AddDate(0, 0, -responsesStoreKeepDays)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			_ = os.Remove(filepath.Join(s.dir, entry.Name()))
+		}
+	}
+}
+
+// responsesInputItems normalizes a Responses "input" value to its item list.
+func responsesInputItems(input any) []any {
+	switch v := input.(type) {
+	case nil:
+		return nil
+	case string:
+		return []any{map[string]any{"role": "user", "content": v}}
+	case []any:
+		return v
+	default:
+		return []any{map[string]any{"role": "user", "content": fmt.Sprint(v)}}
+	}
+}
+
+// responsesTranscript returns the full input of a follow-up request: the
+// stored input and output of the previous response followed by the new items.
+func responsesTranscript(prev *storedResponse, input any) []any {
+	items := responsesInputItems(input)
+	out := make([]any, 0, len(prev.Input)+len(prev.Output)+len(items))
+	out = append(out, prev.Input...)
+	out = append(out, prev.Output...)
+	out = append(out, items...)
+	return out
+}
+
+// responsesStoreItems deep-copies items through JSON so stored entries hold
+// only plain []any/map[string]any values, the same shape decoded requests have.
+func responsesStoreItems(items any) []any {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil
+	}
+	var out []any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,203 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"spark/internal/config"
+)
+
+func TestResponsesStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	s := newResponsesStore(2, "")
+	_ = s.Put(&storedResponse{ID: "resp_1"})
+	_ = s.Put(&storedResponse{ID: "resp_2"})
+	if _, ok := s.Get("resp_1"); !ok {
+		t.Fatal("expected resp_1 to be stored")
+	}
+	_ = s.Put(&storedResponse{ID: "resp_3"})
+	if _, ok := s.Get("resp_2"); ok {
+		t.Fatal("expected resp_2 to be evicted")
+	}
+	if _, ok := s.Get("resp_1"); !ok {
+		t.Fatal("expected recently used resp_1 to survive eviction")
+	}
+}
+
+func TestResponsesStore_PersistsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	s := newResponsesStore(4, dir)
+	err := s.Put(&storedResponse{
+		ID:     "chatcmpl_1",
+		Input:  []any{map[string]any{"role": "user", "content": "hi"}},
+		Output: []any{map[string]any{"type": "message", "role": "assistant"}},
+	})
+	if err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	reloaded := newResponsesStore(4, dir)
+	got, ok := reloaded.Get("chatcmpl_1")
+	if !ok || len(got.Input) != 1 || len(got.Output) != 1 {
+		t.Fatalf("expected entry from disk, got %#v", got)
+	}
+	if _, ok := reloaded.Get("../etc/passwd"); ok {
+		t.Fatal("unexpected lookup success for path-like id")
+	}
+}
+
+func TestHandleResponses_PreviousResponseIDRebuildsTranscript(t *testing.T) {
+	var mu sync.Mutex
+	var upstreamReqs []map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var req map[string]any
+		_ = json.Unmarshal(data, &req)
+		mu.Lock()
+		upstreamReqs = append(upstreamReqs, req)
+		n := len(upstreamReqs)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = w.Write([]byte(`{"id":"chatcmpl_first","model":"m","choices":[{"message":{"content":"4"}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"chatcmpl_second","model":"m","choices":[{"message":{"content":"8"}}]}`))
+	}))
+	defer upstream.Close()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+
+	p, err := startResponsesCompatProxy(&config.Profile{OpenAIBaseURL: upstream.URL}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	post := func(body string) (int, map[string]any) {
+		resp, err := http.Post(p.BaseURL()+"/responses", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	status, first := post(`{"model":"m","input":"2+2?"}`)
+	firstID := stringValue(first["id"])
+	if status != http.StatusOK || !strings.HasPrefix(firstID, "resp_") {
+		t.Fatalf("first response mismatch: %d %#v", status, first)
+	}
+	status, second := post(`{"model":"m","previous_response_id":"` + firstID + `","store":false,"input":[{"role":"user","content":"double it"}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("chained request failed: %d", status)
+	}
+	msgs, _ := upstreamReqs[1]["messages"].([]any)
+	if len(msgs) != 3 {
+		t.Fatalf("expected full transcript upstream, got %#v", msgs)
+	}
+	if mapValue(msgs[0])["content"] != "2+2?" || mapValue(msgs[1])["role"] != "assistant" || mapValue(msgs[1])["content"] != "4" || mapValue(msgs[2])["content"] != "double it" {
+		t.Fatalf("unexpected transcript order: %#v", msgs)
+	}
+
+	// store:false must not make the second response chainable.
+	status, out := post(`{"model":"m","previous_response_id":"` + stringValue(second["id"]) + `","input":"again"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected unknown previous_response_id to fail, got %d %#v", status, out)
+	}
+}
+
+// streamedResponseIDs returns the response id of every response.* event in a
+// Responses SSE body.
+func streamedResponseIDs(body string) []string {
+	var ids []string
+	for _, line := range strings.Split(body, "\n") {
+		var ev map[string]any
+		if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev) != nil {
+			continue
+		}
+		if id := stringValue(mapValue(ev["response"])["id"]); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestHandleResponses_MintsResponseIDsWhenUpstreamReusesThem(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if boolValue(req["stream"]) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: {\"id\":\"chatcmpl_same\",\"model\":\"m\",\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"chatcmpl_same","model":"m","choices":[{"message":{"content":"hi"}}]}`)
+	}))
+	defer upstream.Close()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	p, err := startResponsesCompatProxy(&config.Profile{OpenAIBaseURL: upstream.URL}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	_, nonStream := postForBody(t, p.BaseURL()+"/responses", `{"model":"m","input":"one"}`)
+	var out map[string]any
+	_ = json.Unmarshal([]byte(nonStream), &out)
+	firstID := stringValue(out["id"])
+	_, stream := postForBody(t, p.BaseURL()+"/responses", `{"model":"m","stream":true,"input":"two"}`)
+	ids := streamedResponseIDs(stream)
+	if len(ids) < 3 {
+		t.Fatalf("expected created, in_progress and completed events: %q", stream)
+	}
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("expected one id across the stream, got %v", ids)
+		}
+	}
+	secondID := ids[0]
+	if !strings.HasPrefix(firstID, "resp_") || !strings.HasPrefix(secondID, "resp_") || firstID == secondID {
+		t.Fatalf("expected distinct proxy ids, got %q and %q", firstID, secondID)
+	}
+
+	first, ok := p.store.Get(firstID)
+	if !ok || mapValue(first.Input[0])["content"] != "one" {
+		t.Fatalf("second response must not overwrite the first: %#v", first)
+	}
+	if second, ok := p.store.Get(secondID); !ok || mapValue(second.Input[0])["content"] != "two" {
+		t.Fatalf("expected streamed response stored under its event id: %#v", second)
+	}
+}
+
+func TestHandleResponses_MessagesUpstreamUsesProxyResponseID(t *testing.T) {
+	upstream := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, strings.Join([]string{
+			`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4","usage":{"input_tokens":3}}}`,
+			`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+			`data: {"type":"content_block_stop","index":0}`,
+			`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}`,
+			`data: {"type":"message_stop"}`,
+			``,
+		}, "\n\n"))
+	})
+	p := startTestMessagesResponsesProxy(t, upstream.URL)
+
+	_, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"claude-sonnet-4","stream":true,"input":"hi"}`)
+	ids := streamedResponseIDs(body)
+	if len(ids) < 3 || ids[0] == "msg_1" || ids[len(ids)-1] != ids[0] {
+		t.Fatalf("expected the proxy id from response.created through response.completed, got %v", ids)
+	}
+	if _, ok := p.store.Get(ids[0]); !ok {
+		t.Fatalf("expected response stored under %s", ids[0])
+	}
+}
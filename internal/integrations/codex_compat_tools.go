@@ -0,0 +1,280 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Responses tool types the adapter can expose to a chat/completions upstream.
+// Everything except "function" is emulated with a synthetic function tool.
+const (
+	responsesToolFunction   = "function"
+	responsesToolCustom     = "custom"
+	responsesToolLocalShell = "local_shell"
+	responsesToolWebSearch  = "web_search"
+)
+
+const localShellToolName = "local_shell"
+
+// responsesToolSet maps the function name the upstream sees to the Responses
+// tool type it was declared as, so tool calls can be turned back into the item
+// type the client expects.
+type responsesToolSet map[string]string
+
+func responsesToolSetFromRequest(req map[string]any) responsesToolSet {
+	items, _ := req["tools"].([]any)
+	set := responsesToolSet{}
+	for _, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch t := stringValue(m["type"]); t {
+		case responsesToolFunction, responsesToolCustom:
+			if name := stringValue(m["name"]); name != "" {
+				set[name] = t
+			}
+		case responsesToolLocalShell:
+			set[localShellToolName] = t
+		case responsesToolWebSearch:
+			set[responsesToolWebSearch] = t
+		}
+	}
+	return set
+}
+
+// kind returns the declared tool type for name; unknown names are plain functions.
+func (s responsesToolSet) kind(name string) string {
+	if t, ok := s[name]; ok {
+		return t
+	}
+	return responsesToolFunction
+}
+
+// customToolToChatTool wraps a freeform custom tool (e.g. Codex apply_patch) in
+// a function taking the raw text as its single "input" argument. Grammar-based
+// formats are spelled out in the description since chat tools cannot carry them.
+func customToolToChatTool(m map[string]any) map[string]any {
+	desc := stringValue(m["description"])
+	format := mapValue(m["format"])
+	if def := stringValue(format["definition"]); def != "" {
+		syntax := stringValue(format["syntax"])
+		if syntax == "" {
+			syntax = "grammar"
+		}
+		desc = strings.TrimSpace(desc + "\n\nThe input must be valid under this " + syntax + " definition:\n" + def)
+	}
+	fn := map[string]any{
+		"name": stringValue(m["name"]),
+		"parameters": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"input": map[string]any{
+					"type":        "string",
+					"description": "The raw input passed to the tool verbatim.",
+				},
+			},
+			"required":             []string{"input"},
+			"additionalProperties": false,
+		},
+	}
+	if desc != "" {
+		fn["description"] = desc
+	}
+	return map[string]any{"type": "function", "function": fn}
+}
+
+func localShellChatTool() map[string]any {
+	return map[string]any{
+		"type": "function",
+		"function": map[string]any{
+			"name":        localShellToolName,
+			"description": "Runs a command on the user's machine and returns its output.",
+			"parameters": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"command": map[string]any{
+						"type":        "array",
+						"items":       map[string]any{"type": "string"},
+						"description": `The program and its arguments, e.g. ["bash", "-lc", "ls -la"].`,
+					},
+					"workdir": map[string]any{
+						"type":        "string",
+						"description": "Working directory to run the command in.",
+					},
+					"timeout_ms": map[string]any{
+						"type":        "integer",
+						"description": "Timeout for the command in milliseconds.",
+					},
+				},
+				"required": []string{"command"},
+			},
+		},
+	}
+}
+
+// webSearchChatTool exposes web search as a plain function. With a search
+// backend configured the proxy runs the calls itself (see webSearcher);
+// otherwise they stay function_call items for the client to answer.
+func webSearchChatTool() map[string]any {
+	return map[string]any{
+		"type": "function",
+		"function": map[string]any{
+			"name":        responsesToolWebSearch,
+			"description": "Searches the web and returns relevant results.",
+			"parameters": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{
+						"type":        "string",
+						"description": "The search query.",
+					},
+				},
+				"required": []string{"query"},
+			},
+		},
+	}
+}
+
+// responsesToolCallItem builds the Responses output item for an upstream
+// function call, converting emulated tools back to their native item type.
+func responsesToolCallItem(kind, id, callID, name, args string) map[string]any {
+	if callID == "" {
+		callID = id
+	}
+	if args == "" {
+		args = "{}"
+	}
+	switch kind {
+	case responsesToolCustom:
+		return map[string]any{
+			"id":      id,
+			"type":    "custom_tool_call",
+			"call_id": callID,
+			"name":    name,
+			"input":   customToolInputFromArguments(args),
+			"status":  "completed",
+		}
+	case responsesToolLocalShell:
+		return map[string]any{
+			"id":      id,
+			"type":    "local_shell_call",
+			"call_id": callID,
+			"status":  "completed",
+			"action":  localShellActionFromArguments(args),
+		}
+	default:
+		return map[string]any{
+			"id":        id,
+			"type":      "function_call",
+			"call_id":   callID,
+			"name":      name,
+			"arguments": args,
+			"status":    "completed",
+		}
+	}
+}
+
+// webSearchCallItems converts the searches the proxy ran, as carried under
+// webSearchCallsKey, to Responses web_search_call items.
+func webSearchCallItems(raw any) []map[string]any {
+	calls, _ := raw.([]any)
+	items := make([]map[string]any, 0, len(calls))
+	for _, c := range calls {
+		call := mapValue(c)
+		items = append(items, map[string]any{
+			"id":     stringValue(call["id"]),
+			"type":   "web_search_call",
+			"status": stringValue(call["status"]),
+			"action": map[string]any{"type": "search", "query": stringValue(call["query"])},
+		})
+	}
+	return items
+}
+
+// writeWebSearchCallEvents announces a finished web_search_call item.
+func writeWebSearchCallEvents(w io.Writer, outputIndex int, item map[string]any) {
+	added := map[string]any{}
+	for k, v := range item {
+		added[k] = v
+	}
+	added["status"] = "in_progress"
+	writeSSE(w, map[string]any{
+		"type":         "response.output_item.added",
+		"output_index": outputIndex,
+		"item":         added,
+	})
+	writeSSE(w, map[string]any{
+		"type":         "response.output_item.done",
+		"output_index": outputIndex,
+		"item":         item,
+	})
+}
+
+// customToolInputFromArguments unwraps {"input": "..."}; models that ignore the
+// schema and send the raw text instead get it passed through unchanged.
+func customToolInputFromArguments(args string) string {
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(args), &decoded); err != nil {
+		return args
+	}
+	if input, ok := decoded["input"].(string); ok {
+		return input
+	}
+	return args
+}
+
+func localShellActionFromArguments(args string) map[string]any {
+	var decoded map[string]any
+	_ = json.Unmarshal([]byte(args), &decoded)
+	var command []string
+	switch v := decoded["command"].(type) {
+	case []any:
+		for _, part := range v {
+			command = append(command, stringValue(part))
+		}
+	case string:
+		command = []string{"bash", "-lc", v}
+	}
+	if command == nil {
+		command = []string{}
+	}
+	action := map[string]any{
+		"type":    "exec",
+		"command": command,
+	}
+	if wd := stringValue(decoded["workdir"]); wd != "" {
+		action["working_directory"] = wd
+	}
+	if timeout, ok := intValue(decoded["timeout_ms"]); ok {
+		action["timeout_ms"] = timeout
+	}
+	if env := mapValue(decoded["env"]); len(env) > 0 {
+		action["env"] = env
+	}
+	return action
+}
+
+// responsesCallItemToFunction returns the chat function name and arguments for
+// a tool call item found in the input history.
+func responsesCallItemToFunction(item map[string]any) (string, string) {
+	switch stringValue(item["type"]) {
+	case "custom_tool_call":
+		data, _ := json.Marshal(map[string]any{"input": stringValue(item["input"])})
+		return stringValue(item["name"]), string(data)
+	case "local_shell_call":
+		action := mapValue(item["action"])
+		args := map[string]any{"command": action["command"]}
+		if wd := stringValue(action["working_directory"]); wd != "" {
+			args["workdir"] = wd
+		}
+		if timeout, ok := intValue(action["timeout_ms"]); ok {
+			args["timeout_ms"] = timeout
+		}
+		data, _ := json.Marshal(args)
+		return localShellToolName, string(data)
+	default:
+		return stringValue(item["name"]), stringValue(item["arguments"])
+	}
+}
@@ -0,0 +1,141 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func codexEmulatedToolsRequest() map[string]any {
+	return map[string]any{
+		"model": "GLM-4.7",
+		"input": "fix the bug",
+		"tools": []any{
+			map[string]any{
+				"type":        "custom",
+				"name":        "apply_patch",
+				"description": "Edit files.",
+				"format": map[string]any{
+					"type":       "grammar",
+					"syntax":     "lark",
+					"definition": `start: "*** Begin Patch" LF`,
+				},
+			},
+			map[string]any{"type": "local_shell"},
+			map[string]any{"type": "web_search"},
+		},
+	}
+}
+
+func TestResponsesToChatCompletions_EmulatesCodexTools(t *testing.T) {
+	out := responsesToChatCompletions(codexEmulatedToolsRequest())
+	tools, ok := out["tools"].([]map[string]any)
+	if !ok || len(tools) != 3 {
+		t.Fatalf("expected three emulated tools, got %#v", out["tools"])
+	}
+	byName := map[string]map[string]any{}
+	for _, tool := range tools {
+		if tool["type"] != "function" {
+			t.Fatalf("expected function tool, got %#v", tool)
+		}
+		fn := mapValue(tool["function"])
+		byName[stringValue(fn["name"])] = fn
+	}
+	patch := byName["apply_patch"]
+	if !strings.Contains(stringValue(patch["description"]), "*** Begin Patch") {
+		t.Fatalf("expected grammar in description: %#v", patch)
+	}
+	if _, ok := mapValue(mapValue(patch["parameters"])["properties"])["input"]; !ok {
+		t.Fatalf("expected input parameter: %#v", patch["parameters"])
+	}
+	if _, ok := mapValue(mapValue(byName["local_shell"]["parameters"])["properties"])["command"]; !ok {
+		t.Fatalf("expected command parameter: %#v", byName["local_shell"])
+	}
+	if _, ok := byName["web_search"]; !ok {
+		t.Fatalf("expected web_search function: %#v", byName)
+	}
+}
+
+func TestForwardNonStream_MapsEmulatedToolCallsToNativeItems(t *testing.T) {
+	upResp := &http.Response{
+		StatusCode: 200,
+		Body: io.NopCloser(strings.NewReader(`{"id":"chatcmpl_1","model":"GLM-4.7","choices":[{"message":{"tool_calls":[` +
+			`{"id":"call_1","type":"function","function":{"name":"apply_patch","arguments":"{\"input\":\"*** Begin Patch\\n*** End Patch\"}"}},` +
+			`{"id":"call_2","type":"function","function":{"name":"local_shell","arguments":"{\"command\":[\"ls\",\"-la\"],\"workdir\":\"/tmp\"}"}}` +
+			`]}}]}`)),
+	}
+	rec := &responseRecorder{header: make(http.Header)}
+	p := &responsesCompatProxy{}
+	resp := p.forwardNonStream(rec, upResp, responsesToolSetFromRequest(codexEmulatedToolsRequest()))
+
+	items, _ := resp["output"].([]map[string]any)
+	if len(items) != 2 {
+		t.Fatalf("expected two output items, got %#v", resp["output"])
+	}
+	if items[0]["type"] != "custom_tool_call" || items[0]["input"] != "*** Begin Patch\n*** End Patch" || items[0]["call_id"] != "call_1" {
+		t.Fatalf("unexpected custom tool call item: %#v", items[0])
+	}
+	action := mapValue(items[1]["action"])
+	if items[1]["type"] != "local_shell_call" || action["working_directory"] != "/tmp" {
+		t.Fatalf("unexpected local shell item: %#v", items[1])
+	}
+	if cmd, _ := action["command"].([]string); strings.Join(cmd, " ") != "ls -la" {
+		t.Fatalf("unexpected local shell command: %#v", action["command"])
+	}
+}
+
+func TestForwardStream_MapsEmulatedToolCallsToNativeItems(t *testing.T) {
+	upResp := &http.Response{
+		StatusCode: 200,
+		Body: io.NopCloser(strings.NewReader(strings.Join([]string{
+			`data: {"id":"chatcmpl_1","model":"GLM-4.7","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"apply_patch","arguments":"{\"input\":\"*** Begin"}}]}}]}`,
+			`data: {"id":"chatcmpl_1","model":"GLM-4.7","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":" Patch\"}"}}]}}]}`,
+			`data: [DONE]`,
+		}, "\n") + "\n")),
+	}
+	rec := &flushResponseRecorder{responseRecorder: responseRecorder{header: make(http.Header)}}
+	p := &responsesCompatProxy{}
+	p.forwardStream(rec, upResp, responsesToolSetFromRequest(codexEmulatedToolsRequest()))
+
+	body := rec.body.String()
+	if strings.Contains(body, "response.function_call_arguments.delta") || strings.Contains(body, `"type":"function_call"`) {
+		t.Fatalf("emulated tool leaked as function_call: %q", body)
+	}
+	var done map[string]any
+	for _, line := range strings.Split(body, "\n") {
+		data := strings.TrimPrefix(line, "data: ")
+		var ev map[string]any
+		if json.Unmarshal([]byte(data), &ev) == nil && ev["type"] == "response.output_item.done" {
+			done = mapValue(ev["item"])
+		}
+	}
+	if done["type"] != "custom_tool_call" || done["input"] != "*** Begin Patch" || done["name"] != "apply_patch" {
+		t.Fatalf("unexpected output_item.done: %#v", done)
+	}
+}
+
+func TestResponsesInputToMessages_EmulatedToolHistoryRoundTrip(t *testing.T) {
+	msgs := responsesInputToMessages([]any{
+		map[string]any{"role": "user", "content": "fix the bug"},
+		map[string]any{"type": "custom_tool_call", "call_id": "call_1", "name": "apply_patch", "input": "*** Begin Patch"},
+		map[string]any{"type": "custom_tool_call_output", "call_id": "call_1", "output": "Done!"},
+		map[string]any{"type": "local_shell_call", "call_id": "call_2", "action": map[string]any{"type": "exec", "command": []any{"ls"}}},
+		map[string]any{"type": "function_call_output", "call_id": "call_2", "output": "main.go"},
+	})
+	if len(msgs) != 5 {
+		t.Fatalf("expected user + two call/result pairs, got %#v", msgs)
+	}
+	call := mapValue(msgs[1]["tool_calls"].([]map[string]any)[0]["function"])
+	if call["name"] != "apply_patch" || call["arguments"] != `{"input":"*** Begin Patch"}` {
+		t.Fatalf("unexpected custom tool call replay: %#v", call)
+	}
+	if msgs[2]["role"] != "tool" || msgs[2]["content"] != "Done!" {
+		t.Fatalf("unexpected custom tool output replay: %#v", msgs[2])
+	}
+	shell := mapValue(msgs[3]["tool_calls"].([]map[string]any)[0]["function"])
+	if shell["name"] != "local_shell" || shell["arguments"] != `{"command":["ls"]}` {
+		t.Fatalf("unexpected local shell replay: %#v", shell)
+	}
+}
@@ -3,6 +3,7 @@ package integrations
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 )
@@ -16,6 +17,9 @@ func responsesToChatCompletions(req map[string]any) map[string]any {
 	if len(messages) == 0 {
 		messages = []map[string]any{{"role": "user", "content": ""}}
 	}
+	if instructions := strings.TrimSpace(stringValue(req["instructions"])); instructions != "" {
+		messages = append([]map[string]any{{"role": "system", "content": instructions}}, messages...)
+	}
 	out := map[string]any{
 		"model":    model,
 		"messages": messages,
@@ -50,6 +54,96 @@ func responsesToChatCompletions(req map[string]any) map[string]any {
 			out["tool_choice"] = tc
 		}
 	}
+	if effort := stringValue(mapValue(req["reasoning"])["effort"]); effort != "" {
+		out["reasoning_effort"] = effort
+	}
+	if rf, ok := responsesTextFormatToResponseFormat(mapValue(req["text"])["format"]); ok {
+		out["response_format"] = rf
+	}
+	return out
+}
+
+// responsesTextFormatToResponseFormat maps Responses text.format, which keeps
+// the schema fields inline, to chat response_format, which nests them under
+// json_schema. Plain "text" needs no response_format at all.
+func responsesTextFormatToResponseFormat(raw any) (map[string]any, bool) {
+	format := mapValue(raw)
+	switch stringValue(format["type"]) {
+	case "json_object":
+		return map[string]any{"type": "json_object"}, true
+	case "json_schema":
+		schema := map[string]any{
+			"name": stringValue(format["name"]),
+		}
+		if schema["name"] == "" {
+			schema["name"] = "response"
+		}
+		if desc := stringValue(format["description"]); desc != "" {
+			schema["description"] = desc
+		}
+		if v, ok := format["schema"]; ok {
+			schema["schema"] = v
+		}
+		if strict, ok := format["strict"].(bool); ok {
+			schema["strict"] = strict
+		}
+		return map[string]any{
+			"type":        "json_schema",
+			"json_schema": schema,
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// responsesMappedParams are the top-level Responses fields the adapter either
+// translates or consumes itself; anything else is dropped on the way upstream.
+var responsesMappedParams = map[string]struct{}{
+	"model":                {},
+	"instructions":         {},
+	"input":                {},
+	"stream":               {},
+	"max_output_tokens":    {},
+	"temperature":          {},
+	"top_p":                {},
+	"stop":                 {},
+	"tools":                {},
+	"tool_choice":          {},
+	"reasoning":            {},
+	"text":                 {},
+	"store":                {},
+	"previous_response_id": {},
+}
+
+// responsesDroppedParams lists request parameters that chat/completions has no
+// equivalent for, so the proxy can report them instead of losing them silently.
+func responsesDroppedParams(req map[string]any) []string {
+	var out []string
+	for key, v := range req {
+		if _, ok := responsesMappedParams[key]; ok || v == nil {
+			continue
+		}
+		out = append(out, key)
+	}
+	for key := range mapValue(req["reasoning"]) {
+		if key != "effort" {
+			out = append(out, "reasoning."+key)
+		}
+	}
+	text := mapValue(req["text"])
+	for key := range text {
+		if key != "format" {
+			out = append(out, "text."+key)
+		}
+	}
+	if format, ok := text["format"]; ok {
+		switch t := stringValue(mapValue(format)["type"]); t {
+		case "text", "json_object", "json_schema":
+		default:
+			out = append(out, fmt.Sprintf("text.format(type=%q)", t))
+		}
+	}
+	sort.Strings(out)
 	return out
 }
 
@@ -64,8 +158,21 @@ func responsesToolsToChatTools(raw any) []map[string]any {
 		if !ok {
 			continue
 		}
-		if stringValue(m["type"]) != "function" {
-			// chat/completions does not accept most Responses built-in tools
+		switch stringValue(m["type"]) {
+		case responsesToolFunction:
+		case responsesToolCustom:
+			if stringValue(m["name"]) != "" {
+				out = append(out, customToolToChatTool(m))
+			}
+			continue
+		case responsesToolLocalShell:
+			out = append(out, localShellChatTool())
+			continue
+		case responsesToolWebSearch:
+			out = append(out, webSearchChatTool())
+			continue
+		default:
+			// chat/completions has no equivalent for the remaining built-in tools
 			continue
 		}
 		fn := map[string]any{}
@@ -99,7 +206,11 @@ func responsesToolChoiceToChatToolChoice(raw any) (any, bool) {
 			return nil, false
 		}
 	case map[string]any:
-		if stringValue(v["type"]) != "function" {
+		switch stringValue(v["type"]) {
+		case responsesToolFunction, responsesToolCustom:
+		case responsesToolLocalShell:
+			v = map[string]any{"name": localShellToolName}
+		default:
 			return nil, false
 		}
 		name := stringValue(v["name"])
@@ -168,7 +279,11 @@ func responsesInputToMessages(input any) []map[string]any {
 			}
 			itemType := stringValue(msg["type"])
 			switch itemType {
-			case "function_call_output":
+			case "web_search_call":
+				// Searches the proxy ran have no chat message to replay as;
+				// the reply that used their results follows in the history.
+				continue
+			case "function_call_output", "custom_tool_call_output", "local_shell_call_output":
 				toolCallID := stringValue(msg["call_id"])
 				if toolCallID == "" {
 					toolCallID = stringValue(msg["tool_call_id"])
@@ -192,7 +307,7 @@ func responsesInputToMessages(input any) []map[string]any {
 				})
 				delete(pendingCalls, toolCallID)
 				continue
-			case "function_call":
+			case "function_call", "custom_tool_call", "local_shell_call":
 				toolCallID := stringValue(msg["call_id"])
 				if toolCallID == "" {
 					toolCallID = stringValue(msg["id"])
@@ -200,8 +315,7 @@ func responsesInputToMessages(input any) []map[string]any {
 				if toolCallID == "" {
 					continue
 				}
-				name := stringValue(msg["name"])
-				arguments := stringValue(msg["arguments"])
+				name, arguments := responsesCallItemToFunction(msg)
 				pendingCalls[toolCallID] = pendingToolCall{
 					Name:      name,
 					Arguments: arguments,
@@ -458,6 +572,27 @@ func extractChatToolCallDeltas(chunk map[string]any) []chatToolCallDelta {
 	return out
 }
 
+// extractChatFinishReason returns the first choice's finish_reason, from a
+// stream chunk or a full completion.
+func extractChatFinishReason(chunk map[string]any) string {
+	choices, ok := chunk["choices"].([]any)
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+	return stringValue(mapValue(choices[0])["finish_reason"])
+}
+
+// chatChunkIsCompletion reports whether an upstream that ignored stream:true
+// sent its whole completion as a single line.
+func chatChunkIsCompletion(chunk map[string]any) bool {
+	choices, ok := chunk["choices"].([]any)
+	if !ok || len(choices) == 0 {
+		return false
+	}
+	_, ok = mapValue(choices[0])["message"]
+	return ok
+}
+
 func extractChatText(resp map[string]any) string {
 	choices, ok := resp["choices"].([]any)
 	if !ok || len(choices) == 0 {
@@ -519,9 +654,33 @@ func extractChatReasoningDelta(chunk map[string]any) string {
 	if text := normalizeMessageContent(delta["reasoning"]); text != "" {
 		return text
 	}
+	// DeepSeek-R1, Qwen QwQ and most vLLM/SGLang reasoning parsers use
+	// reasoning_content instead.
+	if text := normalizeMessageContent(delta["reasoning_content"]); text != "" {
+		return text
+	}
 	return ""
 }
 
+func extractChatReasoning(resp map[string]any) string {
+	choices, ok := resp["choices"].([]any)
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+	c0, ok := choices[0].(map[string]any)
+	if !ok {
+		return ""
+	}
+	msg, ok := c0["message"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	if text := normalizeMessageContent(msg["reasoning_content"]); text != "" {
+		return text
+	}
+	return normalizeMessageContent(msg["reasoning"])
+}
+
 func stringValue(v any) string {
 	s, _ := v.(string)
 	return s
@@ -0,0 +1,744 @@
+package integrations
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	anthropicAPIVersion       = "2023-06-01"
+	anthropicDefaultMaxTokens = 8192
+)
+
+// anthropicMessagesUpstream is a ChatExecutor backed by an Anthropic Messages
+// endpoint: chat/completions requests are translated to /v1/messages and the
+// replies, streamed or not, are converted back to chat/completions so the
+// existing response writers can consume them unchanged.
+type anthropicMessagesUpstream struct {
+	client  *http.Client
+	baseURL string
+	token   string
+	logf    func(format string, args ...any)
+}
+
+func (u *anthropicMessagesUpstream) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(u.logf)
+	msgReq, err := chatToAnthropicRequest(chatReq)
+	if err != nil {
+		return nil, err
+	}
+	upResp, err := u.DoMessages(ctx, msgReq)
+	if err != nil {
+		return nil, err
+	}
+	if upResp.StatusCode >= 400 {
+		data, _ := io.ReadAll(upResp.Body)
+		_ = upResp.Body.Close()
+		return chatErrorResponse(upResp, anthropicErrorToChatError(upResp.StatusCode, data)), nil
+	}
+	model := stringValue(chatReq["model"])
+	if !boolValue(msgReq["stream"]) {
+		defer upResp.Body.Close()
+		var msg map[string]any
+		if err := json.NewDecoder(upResp.Body).Decode(&msg); err != nil {
+			return nil, fmt.Errorf("invalid upstream messages response: %w", err)
+		}
+		return chatJSONResponse(http.StatusOK, anthropicMessageToChatCompletion(msg, model)), nil
+	}
+	includeUsage := boolValue(mapValue(chatReq["stream_options"])["include_usage"])
+	pr, pw := io.Pipe()
+	go func() {
+		defer upResp.Body.Close()
+		err := anthropicStreamToChatStream(pw, upResp.Body, model, includeUsage)
+		if err != nil {
+			logf("upstream stream conversion failed: %v", err)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       pr,
+	}, nil
+}
+
+// DoMessages posts an Anthropic Messages request and returns the upstream
+// response as is; error bodies are logged and left readable for the caller.
+func (u *anthropicMessagesUpstream) DoMessages(ctx context.Context, msgReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(u.logf)
+	body, err := json.Marshal(msgReq)
+	if err != nil {
+		return nil, err
+	}
+	url := anthropicMessagesURL(u.baseURL)
+	upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	u.setHeaders(upReq)
+	upReq.Header.Set("Content-Type", "application/json")
+	logf("upstream POST %s payload=%s", url, bodyForLog(string(body), 16*1024))
+	upResp, err := u.client.Do(upReq)
+	if err != nil {
+		return nil, err
+	}
+	logf("upstream status=%d", upResp.StatusCode)
+	if upResp.StatusCode >= 400 {
+		data, _ := io.ReadAll(upResp.Body)
+		_ = upResp.Body.Close()
+		logf("upstream error body=%s", bodyForLog(string(data), 16*1024))
+		upResp.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	return upResp, nil
+}
+
+func (u *anthropicMessagesUpstream) setHeaders(r *http.Request) {
+	r.Header.Set("anthropic-version", anthropicAPIVersion)
+	r.Header.Set("Accept-Encoding", "identity")
+	if u.token != "" {
+		// Anthropic reads x-api-key; most Anthropic-compatible gateways, like
+		// Claude Code's ANTHROPIC_AUTH_TOKEN, use a bearer token instead.
+		r.Header.Set("x-api-key", u.token)
+		r.Header.Set("Authorization", "Bearer "+u.token)
+	}
+}
+
+// fetchModels reads the Anthropic /v1/models listing.
+func (u *anthropicMessagesUpstream) fetchModels(ctx context.Context) ([]compatModel, error) {
+	url := strings.TrimSuffix(anthropicMessagesURL(u.baseURL), "/messages") + "/models"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	u.setHeaders(req)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("upstream /v1/models status %d: %s", resp.StatusCode, truncateForLog(strings.TrimSpace(string(data)), 240))
+	}
+	var decoded struct {
+		Data []struct {
+			ID        string `json:"id"`
+			CreatedAt string `json:"created_at"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid upstream /v1/models response: %w", err)
+	}
+	out := make([]compatModel, 0, len(decoded.Data))
+	for _, m := range decoded.Data {
+		if strings.TrimSpace(m.ID) == "" {
+			continue
+		}
+		var created int64
+		if t, err := time.Parse(time.RFC3339, m.CreatedAt); err == nil {
+			created = t.Unix()
+		}
+		out = append(out, compatModel{ID: m.ID, Created: created})
+	}
+	return out, nil
+}
+
+// anthropicMessagesURL accepts both the Claude Code style base URL
+// (https://api.anthropic.com) and one that already ends in /v1.
+func anthropicMessagesURL(base string) string {
+	base = strings.TrimRight(base, "/")
+	if strings.HasSuffix(base, "/v1") {
+		return base + "/messages"
+	}
+	return base + "/v1/messages"
+}
+
+func chatJSONResponse(status int, body map[string]any) *http.Response {
+	data, _ := json.Marshal(body)
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}
+}
+
+func anthropicErrorToChatError(status int, data []byte) map[string]any {
+	errType := "invalid_request_error"
+	msg := strings.TrimSpace(string(data))
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err == nil {
+		errObj := mapValue(decoded["error"])
+		if t := stringValue(errObj["type"]); t != "" {
+			errType = t
+		}
+		if m := stringValue(errObj["message"]); m != "" {
+			msg = m
+		}
+	}
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	return map[string]any{
+		"error": map[string]any{
+			"message": msg,
+			"type":    errType,
+		},
+	}
+}
+
+// chatToAnthropicRequest maps a chat/completions request to Anthropic
+// Messages: system messages move to "system", tool messages become
+// tool_result blocks and consecutive same-role turns are merged, since
+// Anthropic requires user and assistant turns to alternate.
+func chatToAnthropicRequest(chatReq map[string]any) (map[string]any, error) {
+	system := make([]any, 0, 1)
+	var turns anthropicTurns
+	for i, msg := range chatMessagesList(chatReq["messages"]) {
+		switch role := stringValue(msg["role"]); role {
+		case "system", "developer":
+			blocks, err := chatContentToAnthropicBlocks(msg["content"])
+			if err != nil {
+				return nil, fmt.Errorf("messages.%d: %w", i, err)
+			}
+			system = append(system, blocks...)
+		case "assistant":
+			blocks, err := chatContentToAnthropicBlocks(msg["content"])
+			if err != nil {
+				return nil, fmt.Errorf("messages.%d: %w", i, err)
+			}
+			for _, tc := range chatMessageToolCalls(msg["tool_calls"]) {
+				fn := mapValue(tc["function"])
+				blocks = append(blocks, anthropicToolUseBlock(stringValue(tc["id"]), stringValue(fn["name"]), stringValue(fn["arguments"])))
+			}
+			turns.append("assistant", blocks)
+		case "tool":
+			block := map[string]any{
+				"type":        "tool_result",
+				"tool_use_id": stringValue(msg["tool_call_id"]),
+				"content":     normalizeMessageContent(msg["content"]),
+			}
+			if parts, ok := msg["content"].([]any); ok && len(parts) > 0 {
+				if cc := mapValue(mapValue(parts[len(parts)-1])["cache_control"]); len(cc) > 0 {
+					block["cache_control"] = cc
+				}
+			}
+			turns.append("user", []any{block})
+		default:
+			blocks, err := chatContentToAnthropicBlocks(msg["content"])
+			if err != nil {
+				return nil, fmt.Errorf("messages.%d: %w", i, err)
+			}
+			turns.append("user", blocks)
+		}
+	}
+	messages := turns.messages()
+
+	maxTokens, ok := intValue(chatReq["max_completion_tokens"])
+	if !ok {
+		maxTokens, ok = intValue(chatReq["max_tokens"])
+	}
+	if !ok || maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+	out := map[string]any{
+		"model":      stringValue(chatReq["model"]),
+		"messages":   messages,
+		"max_tokens": maxTokens,
+		"stream":     boolValue(chatReq["stream"]),
+	}
+	if len(system) > 0 {
+		out["system"] = system
+	}
+	if v, ok := chatReq["temperature"]; ok && v != nil {
+		out["temperature"] = v
+	}
+	if v, ok := chatReq["top_p"]; ok && v != nil {
+		out["top_p"] = v
+	}
+	switch v := chatReq["stop"].(type) {
+	case string:
+		if v != "" {
+			out["stop_sequences"] = []string{v}
+		}
+	case []any:
+		if len(v) > 0 {
+			out["stop_sequences"] = v
+		}
+	}
+	if tools := chatToolsToAnthropicTools(chatReq["tools"]); len(tools) > 0 {
+		out["tools"] = tools
+		if tc, ok := chatToolChoiceToAnthropicToolChoice(chatReq["tool_choice"]); ok {
+			out["tool_choice"] = tc
+		}
+		if parallel, ok := chatReq["parallel_tool_calls"].(bool); ok && !parallel {
+			tc := mapValue(out["tool_choice"])
+			if len(tc) == 0 {
+				tc = map[string]any{"type": "auto"}
+			}
+			tc["disable_parallel_tool_use"] = true
+			out["tool_choice"] = tc
+		}
+	}
+	if budget := anthropicBudgetForReasoningEffort(stringValue(chatReq["reasoning_effort"])); budget > 0 {
+		out["thinking"] = map[string]any{"type": "enabled", "budget_tokens": budget}
+		if maxTokens <= budget {
+			out["max_tokens"] = budget + anthropicDefaultMaxTokens
+		}
+		// Extended thinking rejects sampling overrides.
+		delete(out, "temperature")
+		delete(out, "top_p")
+	}
+	if user := stringValue(chatReq["user"]); user != "" {
+		out["metadata"] = map[string]any{"user_id": user}
+	}
+	dropUnreplayableThinking(out)
+	return out, nil
+}
+
+// dropUnreplayableThinking disables extended thinking when a request
+// continues a tool loop whose assistant turn lost its thinking block:
+// Anthropic rejects such requests, and the signature needed to rebuild the
+// block is gone.
+func dropUnreplayableThinking(msgReq map[string]any) {
+	if _, ok := msgReq["thinking"]; !ok {
+		return
+	}
+	messages, _ := msgReq["messages"].([]map[string]any)
+	n := len(messages)
+	if n < 2 || messages[n-1]["role"] != "user" || messages[n-2]["role"] != "assistant" {
+		return
+	}
+	results, _ := messages[n-1]["content"].([]any)
+	for _, b := range results {
+		if stringValue(mapValue(b)["type"]) != "tool_result" {
+			return
+		}
+	}
+	blocks, _ := messages[n-2]["content"].([]any)
+	if len(blocks) == 0 {
+		return
+	}
+	switch stringValue(mapValue(blocks[0])["type"]) {
+	case "thinking", "redacted_thinking":
+	default:
+		delete(msgReq, "thinking")
+	}
+}
+
+func anthropicToolUseBlock(id, name, arguments string) map[string]any {
+	input := map[string]any{}
+	if args := strings.TrimSpace(arguments); args != "" {
+		_ = json.Unmarshal([]byte(args), &input)
+	}
+	return map[string]any{
+		"type":  "tool_use",
+		"id":    id,
+		"name":  name,
+		"input": input,
+	}
+}
+
+// anthropicTurns accumulates Anthropic messages, merging consecutive blocks of
+// the same role into one turn.
+type anthropicTurns []map[string]any
+
+func (t *anthropicTurns) append(role string, blocks []any) {
+	if len(blocks) == 0 {
+		return
+	}
+	if n := len(*t); n > 0 && (*t)[n-1]["role"] == role {
+		(*t)[n-1]["content"] = append((*t)[n-1]["content"].([]any), blocks...)
+		return
+	}
+	*t = append(*t, map[string]any{"role": role, "content": blocks})
+}
+
+// messages returns the turns, with a placeholder user turn when there are
+// none since Anthropic rejects an empty message list.
+func (t anthropicTurns) messages() []map[string]any {
+	if len(t) == 0 {
+		return []map[string]any{{
+			"role":    "user",
+			"content": []any{map[string]any{"type": "text", "text": "..."}},
+		}}
+	}
+	return t
+}
+
+// anthropicBudgetForReasoningEffort is the inverse of reasoningEffortForBudget.
+func anthropicBudgetForReasoningEffort(effort string) int {
+	switch strings.ToLower(strings.TrimSpace(effort)) {
+	case "low":
+		return 4096
+	case "medium":
+		return 16384
+	case "high", "xhigh":
+		return 32768
+	default:
+		return 0
+	}
+}
+
+// chatMessagesList accepts messages as decoded JSON ([]any) or as built by
+// the other translators ([]map[string]any).
+func chatMessagesList(raw any) []map[string]any {
+	switch v := raw.(type) {
+	case []map[string]any:
+		return v
+	case []any:
+		out := make([]map[string]any, 0, len(v))
+		for _, item := range v {
+			if m, ok := item.(map[string]any); ok {
+				out = append(out, m)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func chatMessageToolCalls(raw any) []map[string]any {
+	switch v := raw.(type) {
+	case []map[string]any:
+		return v
+	default:
+		return chatMessagesList(v)
+	}
+}
+
+func chatContentToAnthropicBlocks(raw any) ([]any, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []any{map[string]any{"type": "text", "text": v}}, nil
+	}
+	var parts []map[string]any
+	switch v := raw.(type) {
+	case []map[string]any:
+		parts = v
+	case []any:
+		parts = chatMessagesList(v)
+	default:
+		if text := normalizeMessageContent(v); text != "" {
+			return []any{map[string]any{"type": "text", "text": text}}, nil
+		}
+		return nil, nil
+	}
+	blocks := make([]any, 0, len(parts))
+	for _, part := range parts {
+		var block map[string]any
+		switch stringValue(part["type"]) {
+		case "text", "input_text", "output_text":
+			text := stringValue(part["text"])
+			if text == "" {
+				continue
+			}
+			block = map[string]any{"type": "text", "text": text}
+		case "image_url":
+			url := stringValue(mapValue(part["image_url"])["url"])
+			if url == "" {
+				url = stringValue(part["image_url"])
+			}
+			source, err := anthropicImageSourceFromURL(url)
+			if err != nil {
+				return nil, err
+			}
+			block = map[string]any{"type": "image", "source": source}
+		default:
+			continue
+		}
+		if cc := mapValue(part["cache_control"]); len(cc) > 0 {
+			block["cache_control"] = cc
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// anthropicImageSourceFromURL is the inverse of anthropicImageToChatPart.
+func anthropicImageSourceFromURL(url string) (map[string]any, error) {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		mediaType, data, ok := strings.Cut(rest, ";base64,")
+		if !ok || mediaType == "" || data == "" {
+			return nil, fmt.Errorf("image data URL must be base64 encoded")
+		}
+		return map[string]any{"type": "base64", "media_type": mediaType, "data": data}, nil
+	}
+	if url == "" {
+		return nil, fmt.Errorf("image_url requires url")
+	}
+	return map[string]any{"type": "url", "url": url}, nil
+}
+
+func chatToolsToAnthropicTools(raw any) []map[string]any {
+	items := chatMessagesList(raw)
+	out := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if t := stringValue(item["type"]); t != "" && t != "function" {
+			continue
+		}
+		fn := mapValue(item["function"])
+		name := stringValue(fn["name"])
+		if name == "" {
+			continue
+		}
+		schema := mapValue(fn["parameters"])
+		if len(schema) == 0 {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		tool := map[string]any{
+			"name":         name,
+			"input_schema": schema,
+		}
+		if desc := stringValue(fn["description"]); desc != "" {
+			tool["description"] = desc
+		}
+		out = append(out, tool)
+	}
+	return out
+}
+
+func chatToolChoiceToAnthropicToolChoice(raw any) (map[string]any, bool) {
+	switch v := raw.(type) {
+	case string:
+		switch v {
+		case "auto":
+			return map[string]any{"type": "auto"}, true
+		case "required":
+			return map[string]any{"type": "any"}, true
+		case "none":
+			return map[string]any{"type": "none"}, true
+		}
+	case map[string]any:
+		if name := stringValue(mapValue(v["function"])["name"]); name != "" {
+			return map[string]any{"type": "tool", "name": name}, true
+		}
+	}
+	return nil, false
+}
+
+// anthropicMessageToChatCompletion is the inverse of chatToAnthropicMessage.
+func anthropicMessageToChatCompletion(msg map[string]any, requestedModel string) map[string]any {
+	var text, reasoning strings.Builder
+	toolCalls := make([]map[string]any, 0, 2)
+	blocks, _ := msg["content"].([]any)
+	for _, raw := range blocks {
+		block := mapValue(raw)
+		switch stringValue(block["type"]) {
+		case "text":
+			text.WriteString(stringValue(block["text"]))
+		case "thinking":
+			reasoning.WriteString(stringValue(block["thinking"]))
+		case "tool_use":
+			args, err := json.Marshal(block["input"])
+			if err != nil || string(args) == "null" {
+				args = []byte("{}")
+			}
+			toolCalls = append(toolCalls, map[string]any{
+				"id":   stringValue(block["id"]),
+				"type": "function",
+				"function": map[string]any{
+					"name":      stringValue(block["name"]),
+					"arguments": string(args),
+				},
+			})
+		}
+	}
+	message := map[string]any{
+		"role":    "assistant",
+		"content": nil,
+	}
+	if text.Len() > 0 {
+		message["content"] = text.String()
+	}
+	if reasoning.Len() > 0 {
+		message["reasoning_content"] = reasoning.String()
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+	model := stringValue(msg["model"])
+	if model == "" {
+		model = requestedModel
+	}
+	return map[string]any{
+		"id":      stringValue(msg["id"]),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []any{
+			map[string]any{
+				"index":         0,
+				"message":       message,
+				"finish_reason": anthropicStopReasonToFinishReason(stringValue(msg["stop_reason"])),
+			},
+		},
+		"usage": chatUsageFromAnthropic(mapValue(msg["usage"])),
+	}
+}
+
+func anthropicStopReasonToFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// chatUsageFromAnthropic is the inverse of anthropicUsage: cache reads and
+// writes count towards prompt_tokens, and reads are also reported as cached.
+func chatUsageFromAnthropic(usage map[string]any) map[string]any {
+	cacheRead := intFromAny(usage["cache_read_input_tokens"])
+	prompt := intFromAny(usage["input_tokens"]) + cacheRead + intFromAny(usage["cache_creation_input_tokens"])
+	completion := intFromAny(usage["output_tokens"])
+	return map[string]any{
+		"prompt_tokens":         prompt,
+		"completion_tokens":     completion,
+		"total_tokens":          prompt + completion,
+		"prompt_tokens_details": map[string]any{"cached_tokens": cacheRead},
+	}
+}
+
+// anthropicStreamToChatStream converts an Anthropic Messages SSE stream into
+// chat/completions chunks. It returns an error when the upstream stream ends
+// before message_stop, in which case no [DONE] marker is written.
+func anthropicStreamToChatStream(w io.Writer, upBody io.Reader, requestedModel string, includeUsage bool) error {
+	scanner := bufio.NewScanner(upBody)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+
+	id := fmt.Sprintf("chatcmpl_%d", time.Now().UnixNano())
+	model := requestedModel
+	created := time.Now().Unix()
+	usage := map[string]any{}
+	toolIndexByBlock := map[int]int{}
+
+	emit := func(delta map[string]any, finishReason any) {
+		writeSSE(w, map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []any{
+				map[string]any{
+					"index":         0,
+					"delta":         delta,
+					"finish_reason": finishReason,
+				},
+			},
+		})
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			continue
+		}
+		switch stringValue(event["type"]) {
+		case "message_start":
+			msg := mapValue(event["message"])
+			if v := stringValue(msg["id"]); v != "" {
+				id = v
+			}
+			if v := stringValue(msg["model"]); v != "" {
+				model = v
+			}
+			for k, v := range mapValue(msg["usage"]) {
+				usage[k] = v
+			}
+			emit(map[string]any{"role": "assistant", "content": ""}, nil)
+		case "content_block_start":
+			index := intFromAny(event["index"])
+			block := mapValue(event["content_block"])
+			switch stringValue(block["type"]) {
+			case "text":
+				if text := stringValue(block["text"]); text != "" {
+					emit(map[string]any{"content": text}, nil)
+				}
+			case "tool_use":
+				toolIndex := len(toolIndexByBlock)
+				toolIndexByBlock[index] = toolIndex
+				emit(map[string]any{
+					"tool_calls": []any{map[string]any{
+						"index": toolIndex,
+						"id":    stringValue(block["id"]),
+						"type":  "function",
+						"function": map[string]any{
+							"name":      stringValue(block["name"]),
+							"arguments": "",
+						},
+					}},
+				}, nil)
+			}
+		case "content_block_delta":
+			delta := mapValue(event["delta"])
+			switch stringValue(delta["type"]) {
+			case "text_delta":
+				emit(map[string]any{"content": stringValue(delta["text"])}, nil)
+			case "thinking_delta":
+				emit(map[string]any{"reasoning_content": stringValue(delta["thinking"])}, nil)
+			case "input_json_delta":
+				toolIndex, ok := toolIndexByBlock[intFromAny(event["index"])]
+				if !ok {
+					continue
+				}
+				emit(map[string]any{
+					"tool_calls": []any{map[string]any{
+						"index":    toolIndex,
+						"function": map[string]any{"arguments": stringValue(delta["partial_json"])},
+					}},
+				}, nil)
+			}
+		case "message_delta":
+			for k, v := range mapValue(event["usage"]) {
+				usage[k] = v
+			}
+			stopReason := stringValue(mapValue(event["delta"])["stop_reason"])
+			emit(map[string]any{}, anthropicStopReasonToFinishReason(stopReason))
+		case "message_stop":
+			if includeUsage {
+				writeSSE(w, map[string]any{
+					"id":      id,
+					"object":  "chat.completion.chunk",
+					"created": created,
+					"model":   model,
+					"choices": []any{},
+					"usage":   chatUsageFromAnthropic(usage),
+				})
+			}
+			_, err := io.WriteString(w, "data: [DONE]\n\n")
+			return err
+		case "error":
+			writeSSE(w, map[string]any{"error": anthropicErrorToChatError(0, []byte(data))["error"]})
+			return fmt.Errorf("upstream stream error: %s", truncateForLog(strings.TrimSpace(data), 240))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
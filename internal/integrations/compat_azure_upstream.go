@@ -0,0 +1,70 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+
+	"spark/internal/config"
+)
+
+// azureChatUpstream is a chatUpstream for Azure OpenAI. The wire format is
+// chat/completions already; only the URL, which names a deployment rather
+// than the model, and the api-key header differ.
+type azureChatUpstream struct {
+	client  *http.Client
+	profile config.Profile
+	keys    *keyPool
+	logf    func(format string, args ...any)
+}
+
+func (u *azureChatUpstream) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(u.logf)
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, err
+	}
+	url := u.profile.AzureChatCompletionsURL(stringValue(chatReq["model"]))
+	logf("upstream POST %s payload=%s", url, bodyForLog(string(body), 16*1024))
+	upResp, err := u.keys.do(profileKey(&u.profile), logf, func(key string) (*http.Response, error) {
+		upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		upReq.Header.Set("Content-Type", "application/json")
+		upReq.Header.Set("Accept-Encoding", "identity")
+		if key != "" {
+			upReq.Header.Set("api-key", key)
+		}
+		return u.client.Do(upReq)
+	})
+	if err != nil {
+		return nil, err
+	}
+	logf("upstream status=%d", upResp.StatusCode)
+	if upResp.StatusCode >= 400 {
+		data, _ := io.ReadAll(upResp.Body)
+		_ = upResp.Body.Close()
+		logf("upstream error body=%s", bodyForLog(string(data), 16*1024))
+		upResp.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	return upResp, nil
+}
+
+// fetchModels lists the models mapped in azure_deployments. Azure's data
+// plane has no model listing for current API versions.
+func (u *azureChatUpstream) fetchModels(context.Context) ([]compatModel, error) {
+	ids := make([]string, 0, len(u.profile.AzureDeployments))
+	for model := range u.profile.AzureDeployments {
+		ids = append(ids, model)
+	}
+	sort.Strings(ids)
+	out := make([]compatModel, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, compatModel{ID: id, OwnedBy: "azure"})
+	}
+	return out, nil
+}
@@ -0,0 +1,93 @@
+package integrations
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"spark/internal/config"
+)
+
+func newFakeAzureServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("api-key") != "az-key" || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":"401","message":"Access denied due to invalid subscription key."}}`))
+			return
+		}
+		if r.URL.Path != "/openai/deployments/prod-4o/chat/completions" || r.URL.Query().Get("api-version") != "2024-10-21" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":"DeploymentNotFound","message":"The API deployment for this resource does not exist."}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"chatcmpl_az","model":"gpt-4o","choices":[{"message":{"role":"assistant","content":"hi from azure"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":3,"completion_tokens":4,"total_tokens":7}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func azureTestProfile(url string) *config.Profile {
+	return &config.Profile{
+		Provider:         config.ProviderAzure,
+		OpenAIBaseURL:    url,
+		OpenAIAPIKey:     "az-key",
+		AzureDeployments: map[string]string{"gpt-4o": "prod-4o"},
+	}
+}
+
+func TestResponsesCompatProxy_AzureUpstreamRoutesDeployment(t *testing.T) {
+	upstream := newFakeAzureServer(t)
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	p, err := startResponsesCompatProxy(azureTestProfile(upstream.URL), true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	resp, err := http.Post(p.BaseURL()+"/responses", "application/json", strings.NewReader(`{"model":"gpt-4o","input":"hi"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != http.StatusOK || out["output_text"] != "hi from azure" {
+		t.Fatalf("unexpected response: %d %#v", resp.StatusCode, out)
+	}
+	var models map[string]any
+	getJSON(t, p.BaseURL()+"/models", &models)
+	if data, _ := models["data"].([]any); len(data) != 1 || mapValue(data[0])["id"] != "gpt-4o" {
+		t.Fatalf("expected deployment map as model listing: %#v", models)
+	}
+}
+
+func TestEditorChatProfile_ProxiesAzureProfiles(t *testing.T) {
+	upstream := newFakeAzureServer(t)
+	t.Setenv("AGENT_LAUNCH_CHAT_COMPAT_LOG", t.TempDir()+"/chat.log")
+	got, stop, err := editorChatProfile(azureTestProfile(upstream.URL))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stop()
+	if got.Provider != "" || !strings.HasPrefix(got.OpenAIBaseURL, "http://127.0.0.1:") {
+		t.Fatalf("expected plain OpenAI proxy profile, got %#v", got)
+	}
+
+	resp, err := http.Post(got.OpenAIBaseURL+"/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	msg := mapValue(mapValue(out["choices"].([]any)[0])["message"])
+	if msg["content"] != "hi from azure" {
+		t.Fatalf("unexpected proxied response: %#v", out)
+	}
+}
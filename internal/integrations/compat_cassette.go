@@ -0,0 +1,377 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"spark/internal/config"
+)
+
+const cassetteVersion = 1
+
+// cassette is one recorded compat proxy exchange: the client's request, the
+// request the proxy mapped it to, and the raw upstream response as it
+// arrived, chunk by chunk.
+type cassette struct {
+	Version    int              `json:"version"`
+	Proxy      string           `json:"proxy"`
+	RecordedAt time.Time        `json:"recorded_at"`
+	Request    map[string]any   `json:"request,omitempty"`
+	Mapped     map[string]any   `json:"mapped_request"`
+	Response   cassetteResponse `json:"response"`
+}
+
+type cassetteResponse struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	Chunks      []cassetteChunk `json:"chunks"`
+}
+
+// cassetteChunk is one upstream read, AtMS after the request was sent.
+type cassetteChunk struct {
+	AtMS int64  `json:"at_ms"`
+	Data string `json:"data"`
+}
+
+func (r cassetteResponse) body() []byte {
+	var buf bytes.Buffer
+	for _, c := range r.Chunks {
+		buf.WriteString(c.Data)
+	}
+	return buf.Bytes()
+}
+
+// cassetteRecordDir is where proxies write cassettes; recording is off when
+// AGENT_LAUNCH_COMPAT_RECORD is unset.
+func cassetteRecordDir() string {
+	return strings.TrimSpace(os.Getenv("AGENT_LAUNCH_COMPAT_RECORD"))
+}
+
+// cassetteReplayPath is a cassette file or directory the proxies serve
+// instead of the real upstream.
+func cassetteReplayPath() string {
+	return strings.TrimSpace(os.Getenv("AGENT_LAUNCH_COMPAT_REPLAY"))
+}
+
+type cassetteRequestKey struct{}
+
+// withCassetteRequest attaches the client's request to ctx so a recorder
+// further down the executor chain can store it next to the mapped request.
+func withCassetteRequest(ctx context.Context, req map[string]any) context.Context {
+	return context.WithValue(ctx, cassetteRequestKey{}, req)
+}
+
+// cassetteRecorder writes one cassette per upstream exchange.
+type cassetteRecorder struct {
+	dir   string
+	proxy string
+	seq   atomic.Int64
+	logf  func(format string, args ...any)
+}
+
+// newCassetteRecorder returns a recorder for proxy, or nil when recording is
+// off. Cassettes hold whole prompts and completions, so a proxy whose
+// log_bodies level is metadata does not record.
+func newCassetteRecorder(proxy, logBodies string, logf func(format string, args ...any)) *cassetteRecorder {
+	dir := cassetteRecordDir()
+	if dir == "" {
+		return nil
+	}
+	if logBodies == config.LogBodiesMetadata {
+		logf("cassette recording disabled: log_bodies=%s", logBodies)
+		return nil
+	}
+	return &cassetteRecorder{dir: dir, proxy: proxy, logf: logf}
+}
+
+// executor wraps next so its exchanges are recorded. A nil recorder returns
+// next unchanged.
+func (r *cassetteRecorder) executor(next ChatExecutor) ChatExecutor {
+	if r == nil {
+		return next
+	}
+	return recordingExecutor{recorder: r, next: next.Do}
+}
+
+// messagesExecutor is executor for Anthropic Messages upstreams.
+func (r *cassetteRecorder) messagesExecutor(next MessagesExecutor) MessagesExecutor {
+	if r == nil {
+		return next
+	}
+	return recordingExecutor{recorder: r, next: next.DoMessages}
+}
+
+type recordingExecutor struct {
+	recorder *cassetteRecorder
+	next     func(ctx context.Context, req map[string]any) (*http.Response, error)
+}
+
+func (e recordingExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	return e.record(ctx, chatReq)
+}
+
+func (e recordingExecutor) DoMessages(ctx context.Context, msgReq map[string]any) (*http.Response, error) {
+	return e.record(ctx, msgReq)
+}
+
+// record sends req and tees the response body into a cassette that is
+// written when the caller closes the body.
+func (e recordingExecutor) record(ctx context.Context, req map[string]any) (*http.Response, error) {
+	start := time.Now()
+	// Copy before sending: executors such as the retry paths may rewrite it.
+	mapped := cloneJSONMap(req)
+	resp, err := e.next(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	incoming, _ := ctx.Value(cassetteRequestKey{}).(map[string]any)
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		recorder:   e.recorder,
+		start:      start,
+		cassette: cassette{
+			Version:    cassetteVersion,
+			Proxy:      e.recorder.proxy,
+			RecordedAt: start.UTC(),
+			Request:    cloneJSONMap(incoming),
+			Mapped:     mapped,
+			Response: cassetteResponse{
+				Status:      resp.StatusCode,
+				ContentType: resp.Header.Get("Content-Type"),
+			},
+		},
+	}
+	return resp, nil
+}
+
+type recordingBody struct {
+	io.ReadCloser
+	recorder *cassetteRecorder
+	start    time.Time
+	cassette cassette
+	once     sync.Once
+	// partial holds the bytes of a character a read split, so chunk data
+	// stays valid UTF-8 for its JSON string.
+	partial []byte
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		data := append(b.partial, p[:n]...)
+		cut := completeUTF8Prefix(data)
+		b.partial = append([]byte(nil), data[cut:]...)
+		b.appendChunk(data[:cut])
+	}
+	return n, err
+}
+
+func (b *recordingBody) appendChunk(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	b.cassette.Response.Chunks = append(b.cassette.Response.Chunks, cassetteChunk{
+		AtMS: time.Since(b.start).Milliseconds(),
+		Data: string(data),
+	})
+}
+
+// completeUTF8Prefix is the length of data without a trailing character
+// that is still missing bytes.
+func completeUTF8Prefix(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if utf8.FullRune(data[i:]) {
+				return len(data)
+			}
+			return i
+		}
+	}
+	return len(data)
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.appendChunk(b.partial)
+		path, werr := b.recorder.write(&b.cassette)
+		if werr != nil {
+			b.recorder.logf("cassette write failed: %v", werr)
+			return
+		}
+		b.recorder.logf("cassette recorded path=%s", path)
+	})
+	return err
+}
+
+func (r *cassetteRecorder) write(c *cassette) (string, error) {
+	if err := os.MkdirAll(r.dir, 0o700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s-%04d.json", r.proxy, c.RecordedAt.Format("20060102-150405.000"), r.seq.Add(1))
+	path := filepath.Join(r.dir, name)
+	return path, os.WriteFile(path, data, 0o600)
+}
+
+// cassetteReplayer serves recorded cassettes in place of the upstream. Each
+// cassette is served once; a request gets the first unused cassette whose
+// mapped request matches it, or else the next unused one in file order.
+type cassetteReplayer struct {
+	mu        sync.Mutex
+	cassettes []*cassette
+	names     []string
+	used      []bool
+	realtime  bool
+	logf      func(format string, args ...any)
+}
+
+// newCassetteReplayer loads the cassettes recorded by proxy from
+// AGENT_LAUNCH_COMPAT_REPLAY, or returns nil when replay is off.
+// AGENT_LAUNCH_COMPAT_REPLAY_REALTIME=1 keeps the recorded chunk timing.
+func newCassetteReplayer(proxy string, logf func(format string, args ...any)) (*cassetteReplayer, error) {
+	path := cassetteReplayPath()
+	if path == "" {
+		return nil, nil
+	}
+	files := []string{path}
+	if info, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("cassette replay: %w", err)
+	} else if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+	r := &cassetteReplayer{logf: logf}
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("AGENT_LAUNCH_COMPAT_REPLAY_REALTIME"))) {
+	case "1", "true", "on", "yes":
+		r.realtime = true
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var c cassette
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("cassette %s: %w", file, err)
+		}
+		if c.Proxy != "" && c.Proxy != proxy {
+			continue
+		}
+		r.cassettes = append(r.cassettes, &c)
+		r.names = append(r.names, filepath.Base(file))
+	}
+	if len(r.cassettes) == 0 {
+		return nil, fmt.Errorf("cassette replay: no %s cassettes in %s", proxy, path)
+	}
+	r.used = make([]bool, len(r.cassettes))
+	return r, nil
+}
+
+func (r *cassetteReplayer) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	return r.replay(ctx, chatReq)
+}
+
+func (r *cassetteReplayer) DoMessages(ctx context.Context, msgReq map[string]any) (*http.Response, error) {
+	return r.replay(ctx, msgReq)
+}
+
+func (r *cassetteReplayer) fetchModels(context.Context) ([]compatModel, error) {
+	return nil, errors.New("model listing is not recorded in cassettes")
+}
+
+func (r *cassetteReplayer) next(req map[string]any) (*cassette, string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	want := cloneJSONMap(req)
+	fallback := -1
+	for i, c := range r.cassettes {
+		if r.used[i] {
+			continue
+		}
+		if reflect.DeepEqual(c.Mapped, want) {
+			r.used[i] = true
+			return c, r.names[i], true
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+	}
+	if fallback < 0 {
+		return nil, "", false
+	}
+	r.used[fallback] = true
+	return r.cassettes[fallback], r.names[fallback], false
+}
+
+func (r *cassetteReplayer) replay(ctx context.Context, req map[string]any) (*http.Response, error) {
+	c, name, matched := r.next(req)
+	if c == nil {
+		return nil, errors.New("cassette replay: no cassettes left")
+	}
+	r.logf("cassette replay name=%s matched=%t status=%d chunks=%d", name, matched, c.Response.Status, len(c.Response.Chunks))
+	resp := &http.Response{
+		StatusCode: c.Response.Status,
+		Header:     http.Header{},
+	}
+	if c.Response.ContentType != "" {
+		resp.Header.Set("Content-Type", c.Response.ContentType)
+	}
+	if !r.realtime {
+		resp.Body = io.NopCloser(bytes.NewReader(c.Response.body()))
+		return resp, nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		start := time.Now()
+		for _, chunk := range c.Response.Chunks {
+			if err := sleepContext(ctx, time.Duration(chunk.AtMS)*time.Millisecond-time.Since(start)); err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.WriteString(pw, chunk.Data); err != nil {
+				return
+			}
+		}
+		_ = pw.Close()
+	}()
+	resp.Body = pr
+	return resp, nil
+}
+
+// cloneJSONMap deep-copies m through JSON, which also normalizes numbers and
+// typed slices so recorded and live requests compare equal.
+func cloneJSONMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	var out map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
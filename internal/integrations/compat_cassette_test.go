@@ -0,0 +1,202 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"spark/internal/config"
+	"spark/internal/mockupstream"
+)
+
+func postAnthropicStream(t *testing.T, baseURL string) string {
+	t.Helper()
+	resp, err := http.Post(baseURL+"/v1/messages", "application/json", strings.NewReader(
+		`{"model":"claude-sonnet-4","max_tokens":256,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", resp.StatusCode, data)
+	}
+	return string(data)
+}
+
+func TestAnthropicCompatProxy_RecordsAndReplaysCassettes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher, _ := w.(http.Flusher)
+		for _, line := range []string{
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}`,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"content":" there"},"finish_reason":"stop"}],"usage":{"prompt_tokens":4,"completion_tokens":2,"total_tokens":6}}`,
+			`data: [DONE]`,
+		} {
+			_, _ = io.WriteString(w, line+"\n\n")
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}))
+	dir := t.TempDir()
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", t.TempDir()+"/claude.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_RECORD", dir)
+	profile := &config.Profile{OpenAIBaseURL: upstream.URL, OpenAIAPIKey: "secret-key"}
+	p, err := startAnthropicCompatProxy(profile, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	recorded := postAnthropicStream(t, p.BaseURL())
+	_ = p.Close()
+	upstream.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "anthropic-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected one cassette, got %v", files)
+	}
+	raw, _ := os.ReadFile(files[0])
+	if strings.Contains(string(raw), "secret-key") {
+		t.Fatalf("cassette must not contain the API key")
+	}
+	var c cassette
+	if err := json.Unmarshal(raw, &c); err != nil {
+		t.Fatalf("decode cassette: %v", err)
+	}
+	if intFromAny(c.Request["max_tokens"]) != 256 || c.Mapped["model"] != "gpt-4.1" || c.Response.Status != http.StatusOK {
+		t.Fatalf("unexpected cassette: %+v", c)
+	}
+	if body := string(c.Response.body()); !strings.Contains(body, `"content":" there"`) || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("expected the raw upstream stream, got %q", body)
+	}
+
+	t.Setenv("AGENT_LAUNCH_COMPAT_RECORD", "")
+	t.Setenv("AGENT_LAUNCH_COMPAT_REPLAY", dir)
+	p, err = startAnthropicCompatProxy(profile, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start replay proxy: %v", err)
+	}
+	defer p.Close()
+	replayed := postAnthropicStream(t, p.BaseURL())
+	for _, want := range []string{`"text":"Hello"`, `"text":" there"`, `"output_tokens":2`, "event: message_stop"} {
+		if !strings.Contains(recorded, want) || !strings.Contains(replayed, want) {
+			t.Fatalf("missing %s in recorded or replayed stream:\n%s\n---\n%s", want, recorded, replayed)
+		}
+	}
+	resp, err := http.Post(p.BaseURL()+"/v1/messages", "application/json", strings.NewReader(
+		`{"model":"claude-sonnet-4","max_tokens":256,"messages":[{"role":"user","content":"again"}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected an error once cassettes run out, got %d", resp.StatusCode)
+	}
+}
+
+func TestAnthropicCompatProxy_DoesNotRecordMetadataOnlyProfiles(t *testing.T) {
+	_, baseURL := startMockUpstream(t, mockupstream.TextStream("gpt-4.1", "secret completion"))
+	dir := t.TempDir()
+	t.Setenv("AGENT_LAUNCH_COMPAT_RECORD", dir)
+	p, err := startAnthropicCompatProxy(&config.Profile{OpenAIBaseURL: baseURL, LogBodies: config.LogBodiesMetadata}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	postAnthropicStream(t, p.BaseURL())
+	_ = p.Close()
+
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected no cassettes for a metadata-only profile, got %v", files)
+	}
+	data, _ := os.ReadFile(p.LogPath())
+	assertContainsAll(t, string(data), "cassette recording disabled: log_bodies=metadata")
+}
+
+func TestCassette_RoundTripsCharactersSplitAcrossReads(t *testing.T) {
+	const stream = "data: {\"content\":\"héllo 你好 🚀\"}\n\n"
+	dir := t.TempDir()
+	t.Setenv("AGENT_LAUNCH_COMPAT_RECORD", dir)
+	recorder := newCassetteRecorder("chat", config.LogBodiesFull, t.Logf)
+	upstream := &scriptedExecutor{steps: []func() (*http.Response, error){
+		func() (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       io.NopCloser(iotest.OneByteReader(strings.NewReader(stream))),
+			}, nil
+		},
+	}}
+	resp, err := recorder.executor(upstream).Do(t.Context(), map[string]any{"model": "gpt-4.1"})
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if data, _ := io.ReadAll(resp.Body); string(data) != stream {
+		t.Fatalf("recording changed the live stream: %q", data)
+	}
+	_ = resp.Body.Close()
+
+	t.Setenv("AGENT_LAUNCH_COMPAT_RECORD", "")
+	t.Setenv("AGENT_LAUNCH_COMPAT_REPLAY", dir)
+	t.Setenv("AGENT_LAUNCH_COMPAT_REPLAY_REALTIME", "1")
+	replayer, err := newCassetteReplayer("chat", t.Logf)
+	if err != nil {
+		t.Fatalf("load cassettes: %v", err)
+	}
+	resp, err = replayer.Do(t.Context(), map[string]any{"model": "gpt-4.1"})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	defer resp.Body.Close()
+	if data, _ := io.ReadAll(resp.Body); string(data) != stream {
+		t.Fatalf("replayed %q, want %q", data, stream)
+	}
+}
+
+// Cassettes under testdata/cassettes are replayed through the Codex proxy as
+// regression tests for the chat stream translation.
+func TestResponsesCompatProxy_ReplaysSplitToolCallCassette(t *testing.T) {
+	path := filepath.Join("testdata", "cassettes", "codex-stream-split-tool-call.json")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read cassette: %v", err)
+	}
+	var c cassette
+	if err := json.Unmarshal(raw, &c); err != nil {
+		t.Fatalf("decode cassette: %v", err)
+	}
+	request, _ := json.Marshal(c.Request)
+
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	t.Setenv("AGENT_LAUNCH_COMPAT_REPLAY", path)
+	p, err := startResponsesCompatProxy(&config.Profile{OpenAIBaseURL: "http://127.0.0.1:1"}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	resp, err := http.Post(p.BaseURL()+"/responses", "application/json", strings.NewReader(string(request)))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	body := string(data)
+	for _, want := range []string{
+		`"delta":"Let me check."`,
+		`"type":"function_call"`,
+		`"name":"shell"`,
+		`"arguments":"{\"command\":[\"ls\"]}"`,
+		`"type":"response.completed"`,
+		`"input_tokens":57`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %s in stream: %q", want, body)
+		}
+	}
+}
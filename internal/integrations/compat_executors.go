@@ -13,15 +13,44 @@ type codexChatExecutor struct {
 }
 
 func newCodexChatExecutor(proxy *responsesCompatProxy) ChatExecutor {
-	return codexChatExecutor{proxy: proxy}
+	if proxy.replay != nil {
+		return proxy.toolParsers.executor(proxy.replay, proxy.logf)
+	}
+	var executor ChatExecutor = codexChatExecutor{proxy: proxy}
+	executor = proxy.timeouts.executor(executor)
+	executor = proxy.limiter.executor(executor, proxy.logf)
+	executor = proxy.fallbacks.executor(executor)
+	executor = proxy.retry.executor(executor, proxy.logf)
+	executor = proxy.recorder.executor(executor)
+	executor = proxy.toolParsers.executor(executor, proxy.logf)
+	return proxy.webSearch.executor(executor, proxy.logf)
+}
+
+// newCodexMessagesExecutor is the Messages counterpart of
+// newCodexChatExecutor. It has no failover layer: profiles with fallbacks are
+// served through the chat path.
+func newCodexMessagesExecutor(proxy *responsesCompatProxy) MessagesExecutor {
+	if proxy.replay != nil {
+		return proxy.replay
+	}
+	var executor MessagesExecutor = proxy.messages
+	executor = proxy.timeouts.messagesExecutor(executor)
+	executor = proxy.limiter.messagesExecutor(executor, proxy.logf)
+	executor = proxy.retry.messagesExecutor(executor, proxy.logf)
+	executor = proxy.recorder.messagesExecutor(executor)
+	return proxy.webSearch.messagesExecutor(executor, proxy.logf)
 }
 
 func (e codexChatExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(e.proxy.logf)
+	if e.proxy.upstream != nil {
+		return e.proxy.upstream.Do(ctx, chatReq)
+	}
 	upResp, err := e.proxy.postChatCompletions(ctx, chatReq)
 	if err != nil {
 		return nil, err
 	}
-	e.proxy.logf("upstream status=%d on initial mapped request", upResp.StatusCode)
+	logf("upstream status=%d on initial mapped request", upResp.StatusCode)
 	if upResp.StatusCode < 400 {
 		return upResp, nil
 	}
@@ -29,12 +58,12 @@ func (e codexChatExecutor) Do(ctx context.Context, chatReq map[string]any) (*htt
 	e.proxy.warnf(fmt.Sprintf("upstream returned status %d", upResp.StatusCode))
 	data, _ := io.ReadAll(upResp.Body)
 	_ = upResp.Body.Close()
-	e.proxy.logf(
+	logf(
 		"upstream error on initial mapped request status=%d content_type=%q content_encoding=%q body=%s",
 		upResp.StatusCode,
 		upResp.Header.Get("Content-Type"),
 		upResp.Header.Get("Content-Encoding"),
-		truncateForLog(string(data), 16*1024),
+		bodyForLog(string(data), 16*1024),
 	)
 	if !shouldRetryWithMinimalChatReq(upResp.StatusCode, data) {
 		return &http.Response{
@@ -44,27 +73,27 @@ func (e codexChatExecutor) Do(ctx context.Context, chatReq map[string]any) (*htt
 		}, nil
 	}
 
-	e.proxy.logf("retrying with minimal chat request due to status=%d body=%q", upResp.StatusCode, truncateForLog(string(data), 240))
+	logf("retrying with minimal chat request due to status=%d body=%q", upResp.StatusCode, bodyForLog(string(data), 240))
 	minReq := minimalChatCompletionsRequest(chatReq)
-	e.proxy.logf("mapped chat request(minimal)=%s", mustJSONForLog(minReq))
+	logf("mapped chat request(minimal)=%s", mustJSONForLog(minReq))
 	upResp, err = e.proxy.postChatCompletions(ctx, minReq)
 	if err != nil {
-		e.proxy.logf("upstream minimal retry failed: %v", err)
+		logf("upstream minimal retry failed: %v", err)
 		return nil, err
 	}
-	e.proxy.logf("upstream status=%d on minimal retry", upResp.StatusCode)
+	logf("upstream status=%d on minimal retry", upResp.StatusCode)
 	if upResp.StatusCode < 400 {
 		return upResp, nil
 	}
 
 	data, _ = io.ReadAll(upResp.Body)
 	_ = upResp.Body.Close()
-	e.proxy.logf(
+	logf(
 		"upstream error on minimal retry status=%d content_type=%q content_encoding=%q body=%s",
 		upResp.StatusCode,
 		upResp.Header.Get("Content-Type"),
 		upResp.Header.Get("Content-Encoding"),
-		truncateForLog(string(data), 16*1024),
+		bodyForLog(string(data), 16*1024),
 	)
 	if !shouldRetryWithMinimalChatReq(upResp.StatusCode, data) {
 		return &http.Response{
@@ -74,24 +103,24 @@ func (e codexChatExecutor) Do(ctx context.Context, chatReq map[string]any) (*htt
 		}, nil
 	}
 
-	e.proxy.logf("retrying with ultra-minimal chat request due to status=%d body=%q", upResp.StatusCode, truncateForLog(string(data), 240))
+	logf("retrying with ultra-minimal chat request due to status=%d body=%q", upResp.StatusCode, bodyForLog(string(data), 240))
 	ultraReq := ultraMinimalChatCompletionsRequest(chatReq)
-	e.proxy.logf("mapped chat request(ultra-minimal)=%s", mustJSONForLog(ultraReq))
+	logf("mapped chat request(ultra-minimal)=%s", mustJSONForLog(ultraReq))
 	upResp, err = e.proxy.postChatCompletions(ctx, ultraReq)
 	if err != nil {
-		e.proxy.logf("upstream ultra-minimal retry failed: %v", err)
+		logf("upstream ultra-minimal retry failed: %v", err)
 		return nil, err
 	}
-	e.proxy.logf("upstream status=%d on ultra-minimal retry", upResp.StatusCode)
+	logf("upstream status=%d on ultra-minimal retry", upResp.StatusCode)
 	if upResp.StatusCode >= 400 {
 		data, _ := io.ReadAll(upResp.Body)
 		_ = upResp.Body.Close()
-		e.proxy.logf(
+		logf(
 			"upstream error on ultra-minimal retry status=%d content_type=%q content_encoding=%q body=%s",
 			upResp.StatusCode,
 			upResp.Header.Get("Content-Type"),
 			upResp.Header.Get("Content-Encoding"),
-			truncateForLog(string(data), 16*1024),
+			bodyForLog(string(data), 16*1024),
 		)
 		return &http.Response{
 			StatusCode: upResp.StatusCode,
@@ -107,9 +136,21 @@ type anthropicChatExecutor struct {
 }
 
 func newAnthropicChatExecutor(proxy *anthropicCompatProxy) ChatExecutor {
-	return anthropicChatExecutor{proxy: proxy}
+	if proxy.replay != nil {
+		return proxy.toolParsers.executor(proxy.replay, proxy.logf)
+	}
+	var executor ChatExecutor = anthropicChatExecutor{proxy: proxy}
+	executor = proxy.timeouts.executor(executor)
+	executor = proxy.limiter.executor(executor, proxy.logf)
+	executor = proxy.fallbacks.executor(executor)
+	executor = proxy.retry.executor(executor, proxy.logf)
+	executor = proxy.recorder.executor(executor)
+	return proxy.toolParsers.executor(executor, proxy.logf)
 }
 
 func (e anthropicChatExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	if e.proxy.upstream != nil {
+		return e.proxy.upstream.Do(ctx, chatReq)
+	}
 	return e.proxy.postChatCompletions(ctx, chatReq)
 }
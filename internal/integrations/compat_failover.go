@@ -0,0 +1,239 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"spark/internal/config"
+)
+
+const (
+	// breakerFailureThreshold consecutive failures open an upstream's breaker.
+	breakerFailureThreshold = 3
+	// breakerCooldown is how long an open breaker skips its upstream before
+	// letting a single trial request through.
+	breakerCooldown = 30 * time.Second
+)
+
+// circuitBreaker tracks consecutive failures of one upstream. After
+// breakerFailureThreshold failures it opens and the upstream is skipped until
+// breakerCooldown has passed; then one request is let through and its result
+// closes or re-opens the breaker.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+	now       func() time.Time
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{now: time.Now}
+}
+
+// allow reports whether a request may be sent to the upstream.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < breakerFailureThreshold {
+		return true
+	}
+	if b.trial || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+// abandon ends a trial request that was cancelled before it showed whether
+// the upstream recovered, so the next request can try again.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures >= breakerFailureThreshold {
+		b.openUntil = b.now().Add(breakerCooldown)
+	}
+}
+
+// failoverTarget is one fallback upstream of a profile.
+type failoverTarget struct {
+	label    string
+	model    string
+	executor ChatExecutor
+	breaker  *circuitBreaker
+}
+
+// upstreamChain holds a profile's fallback upstreams and the circuit breakers
+// of every upstream in the chain. It outlives requests so breaker state
+// carries over; executor binds it to a request's primary ChatExecutor.
+type upstreamChain struct {
+	primaryLabel   string
+	primaryBreaker *circuitBreaker
+	fallbacks      []failoverTarget
+	logf           func(format string, args ...any)
+}
+
+// newUpstreamChain returns the failover chain for profile, or nil when the
+// profile lists no fallbacks.
+func newUpstreamChain(profile *config.Profile, client *http.Client, logf func(format string, args ...any)) (*upstreamChain, error) {
+	if profile == nil || len(profile.Fallbacks) == 0 {
+		return nil, nil
+	}
+	chain := &upstreamChain{
+		primaryLabel:   profileUpstreamURL(profile),
+		primaryBreaker: newCircuitBreaker(),
+		logf:           logf,
+	}
+	for i, fb := range profile.Fallbacks {
+		fbProfile := fallbackProfile(profile, fb)
+		var executor ChatExecutor
+		upstream, err := newChatUpstream(fbProfile, client, logf)
+		if err != nil {
+			return nil, fmt.Errorf("fallback %d: %w", i+1, err)
+		}
+		if upstream != nil {
+			executor = upstream
+		} else {
+			executor = &openAIChatUpstream{
+				client:  client,
+				baseURL: strings.TrimRight(profileBase(fbProfile), "/"),
+				key:     profileKey(fbProfile),
+				logf:    logf,
+			}
+		}
+		chain.fallbacks = append(chain.fallbacks, failoverTarget{
+			label:    strings.TrimRight(profileBase(fbProfile), "/"),
+			model:    strings.TrimSpace(fb.Model),
+			executor: executor,
+			breaker:  newCircuitBreaker(),
+		})
+	}
+	return chain, nil
+}
+
+// fallbackProfile is profile with its endpoint replaced by fb. Provider
+// options such as Azure deployments and Ollama settings carry over.
+func fallbackProfile(profile *config.Profile, fb config.Fallback) *config.Profile {
+	out := *profile
+	out.OpenAIBaseURL = strings.TrimSpace(fb.BaseURL)
+	out.OpenAIAPIKey = fb.APIKey
+	out.OpenAIAPIKeys = nil
+	if p := strings.TrimSpace(fb.Provider); p != "" {
+		out.Provider = p
+	}
+	out.Fallbacks = nil
+	return &out
+}
+
+// wrapFallbacks applies wrap to every fallback upstream's executor, so
+// per-upstream layers such as stream timeouts cover fallbacks too.
+func (c *upstreamChain) wrapFallbacks(wrap func(ChatExecutor) ChatExecutor) {
+	if c == nil {
+		return
+	}
+	for i := range c.fallbacks {
+		c.fallbacks[i].executor = wrap(c.fallbacks[i].executor)
+	}
+}
+
+// executor returns a ChatExecutor that sends requests to primary and fails
+// over along the chain. A nil chain returns primary unchanged.
+func (c *upstreamChain) executor(primary ChatExecutor) ChatExecutor {
+	if c == nil {
+		return primary
+	}
+	return failoverExecutor{chain: c, primary: primary}
+}
+
+type failoverExecutor struct {
+	chain   *upstreamChain
+	primary ChatExecutor
+}
+
+// Do tries each upstream whose breaker allows it, in order. Connection errors
+// and 5xx responses move on to the next upstream; any other response is
+// returned as is. When every upstream fails, the last 5xx response or error is
+// returned.
+func (e failoverExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(e.chain.logf)
+	targets := make([]failoverTarget, 0, len(e.chain.fallbacks)+1)
+	targets = append(targets, failoverTarget{
+		label:    e.chain.primaryLabel,
+		executor: e.primary,
+		breaker:  e.chain.primaryBreaker,
+	})
+	targets = append(targets, e.chain.fallbacks...)
+
+	var lastResp *http.Response
+	var lastErr error
+	for i, target := range targets {
+		if !target.breaker.allow() {
+			logf("upstream %s skipped: circuit open", target.label)
+			continue
+		}
+		req := chatReq
+		if target.model != "" {
+			req = make(map[string]any, len(chatReq))
+			for k, v := range chatReq {
+				req[k] = v
+			}
+			req["model"] = target.model
+		}
+		resp, err := target.executor.Do(ctx, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				target.breaker.abandon()
+				return nil, err
+			}
+			target.breaker.failure()
+			logf("upstream %s failed: %v", target.label, err)
+			lastResp, lastErr = nil, err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			data, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(data))
+			target.breaker.failure()
+			logf("upstream %s failed: status=%d body=%s", target.label, resp.StatusCode, bodyForLog(string(data), 1024))
+			lastResp, lastErr = resp, nil
+			continue
+		}
+		target.breaker.success()
+		if i == 0 {
+			logf("upstream served_by=%s", target.label)
+		} else {
+			logf("upstream served_by=%s fallback=%d", target.label, i)
+		}
+		return resp, nil
+	}
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("all upstreams unavailable: circuit open")
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,201 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"spark/internal/config"
+)
+
+func TestCircuitBreaker_OpensAndAllowsTrialAfterCooldown(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newCircuitBreaker()
+	b.now = func() time.Time { return now }
+	for i := 0; i < breakerFailureThreshold; i++ {
+		if !b.allow() {
+			t.Fatalf("breaker opened early after %d failures", i)
+		}
+		b.failure()
+	}
+	if b.allow() {
+		t.Fatalf("expected breaker open after %d failures", breakerFailureThreshold)
+	}
+	now = now.Add(breakerCooldown)
+	if !b.allow() {
+		t.Fatalf("expected a trial request after cooldown")
+	}
+	if b.allow() {
+		t.Fatalf("expected only one trial request while half-open")
+	}
+	b.failure()
+	if b.allow() {
+		t.Fatalf("expected failed trial to re-open the breaker")
+	}
+	now = now.Add(breakerCooldown)
+	if !b.allow() {
+		t.Fatalf("expected a second trial after cooldown")
+	}
+	b.success()
+	if !b.allow() || !b.allow() {
+		t.Fatalf("expected successful trial to close the breaker")
+	}
+}
+
+func TestFailoverExecutor_CancelledTrialReleasesHalfOpenBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	breaker := newCircuitBreaker()
+	breaker.now = func() time.Time { return now }
+	for i := 0; i < breakerFailureThreshold; i++ {
+		breaker.failure()
+	}
+	now = now.Add(breakerCooldown)
+	chain := &upstreamChain{primaryLabel: "primary", primaryBreaker: breaker, logf: func(string, ...any) {}}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	primary := &scriptedExecutor{steps: []func() (*http.Response, error){
+		func() (*http.Response, error) { return nil, ctx.Err() },
+	}}
+	if _, err := chain.executor(primary).Do(ctx, map[string]any{"model": "m"}); err == nil {
+		t.Fatalf("expected the cancelled trial to fail")
+	}
+	if !breaker.allow() {
+		t.Fatalf("expected a new trial request after the cancelled one")
+	}
+	if breaker.failures != breakerFailureThreshold {
+		t.Fatalf("expected a cancelled trial not counted as a failure, got %d failures", breaker.failures)
+	}
+}
+
+func TestAnthropicCompatProxy_FailsOverOn5xxAndConnectionErrors(t *testing.T) {
+	primaryHits := 0
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits++
+		http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+	var fallbackReq map[string]any
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer fb-key" {
+			t.Errorf("unexpected fallback auth %q", got)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&fallbackReq)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"c1","model":"backup-model","choices":[{"index":0,"message":{"role":"assistant","content":"from fallback"},"finish_reason":"stop"}],`+
+			`"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`)
+	}))
+	defer fallback.Close()
+
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", t.TempDir()+"/claude.log")
+	p, err := startAnthropicCompatProxy(&config.Profile{
+		OpenAIBaseURL: primary.URL,
+		OpenAIAPIKey:  "primary-key",
+		Fallbacks: []config.Fallback{
+			{BaseURL: downURL},
+			{BaseURL: fallback.URL, APIKey: "fb-key", Model: "backup-model"},
+		},
+	}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	for i := 0; i < breakerFailureThreshold+1; i++ {
+		resp, err := http.Post(p.BaseURL()+"/v1/messages", "application/json",
+			strings.NewReader(`{"model":"claude-sonnet-4","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(data), "from fallback") {
+			t.Fatalf("request %d: expected fallback answer, got %d %s", i, resp.StatusCode, data)
+		}
+	}
+	if fallbackReq["model"] != "backup-model" {
+		t.Fatalf("expected fallback model rename, got %#v", fallbackReq["model"])
+	}
+	if primaryHits != breakerFailureThreshold {
+		t.Fatalf("expected primary skipped once its breaker opened, got %d hits", primaryHits)
+	}
+
+	p.Close()
+	logData, _ := os.ReadFile(p.logPath)
+	logText := string(logData)
+	for _, want := range []string{
+		"upstream " + primary.URL + " failed: status=502",
+		"upstream " + downURL + " failed:",
+		"upstream " + primary.URL + " skipped: circuit open",
+		"upstream served_by=" + fallback.URL + " fallback=2",
+	} {
+		if !strings.Contains(logText, want) {
+			t.Fatalf("missing %q in compat log:\n%s", want, logText)
+		}
+	}
+}
+
+func TestResponsesCompatProxy_AnthropicOnlyProfileFailsOverToChatFallback(t *testing.T) {
+	primary := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, `{"type":"error","error":{"type":"api_error","message":"boom"}}`)
+	})
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":"from fallback"},"finish_reason":"stop"}],`+
+			`"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`)
+	}))
+	defer fallback.Close()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	p, err := startResponsesCompatProxy(&config.Profile{
+		AnthropicBaseURL:   primary.URL,
+		AnthropicAuthToken: "sk-ant",
+		Retry:              &config.RetryPolicy{MaxAttempts: 1},
+		Fallbacks:          []config.Fallback{{BaseURL: fallback.URL, Model: "backup-model"}},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	status, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"claude-sonnet-4","input":"hi"}`)
+	if status != http.StatusOK || !strings.Contains(body, "from fallback") {
+		t.Fatalf("expected fallback answer, got %d %s", status, body)
+	}
+}
+
+func TestFailoverExecutor_ReturnsLast5xxWhenAllUpstreamsFail(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+	profile := &config.Profile{
+		OpenAIBaseURL: upstream.URL,
+		Fallbacks:     []config.Fallback{{BaseURL: upstream.URL + "/backup"}},
+	}
+	logf := func(string, ...any) {}
+	chain, err := newUpstreamChain(profile, http.DefaultClient, logf)
+	if err != nil {
+		t.Fatalf("chain: %v", err)
+	}
+	primary := &openAIChatUpstream{client: http.DefaultClient, baseURL: upstream.URL, logf: logf}
+	resp, err := chain.executor(primary).Do(t.Context(), map[string]any{"model": "m"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(data), "unavailable") {
+		t.Fatalf("expected the last upstream error, got %d %s", resp.StatusCode, data)
+	}
+}
@@ -0,0 +1,700 @@
+package integrations
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const geminiDefaultBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// geminiSkipThoughtSignature is Google's documented placeholder for replayed
+// function calls whose thought signature is unknown, e.g. history produced
+// before the proxy started.
+const geminiSkipThoughtSignature = "skip_thought_signature_validator"
+
+// geminiMaxSignatures bounds the remembered thought signatures; a Codex or
+// Claude Code session only ever replays its recent tool calls, so the least
+// recently used ones are evicted first.
+const geminiMaxSignatures = 1024
+
+// geminiChatUpstream is a chatUpstream backed by Gemini's generateContent
+// API. Gemini attaches a thought signature to each function call that must
+// be sent back with it; chat/completions has nowhere to carry it, so the
+// upstream remembers signatures by tool call ID for the proxy's lifetime.
+type geminiChatUpstream struct {
+	client  *http.Client
+	baseURL string
+	key     string
+	keys    *keyPool
+	logf    func(format string, args ...any)
+
+	sigMu      sync.Mutex
+	sigOrder   *list.List
+	signatures map[string]*list.Element
+}
+
+type geminiSignature struct {
+	callID    string
+	signature string
+}
+
+func (u *geminiChatUpstream) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(u.logf)
+	model := strings.TrimPrefix(stringValue(chatReq["model"]), "models/")
+	stream := boolValue(chatReq["stream"])
+	gemReq, err := chatToGeminiRequest(chatReq, u.signature)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(gemReq)
+	if err != nil {
+		return nil, err
+	}
+	url := geminiGenerateURL(u.baseURL, model, stream)
+	logf("upstream POST %s payload=%s", url, bodyForLog(string(body), 16*1024))
+	upResp, err := u.keys.do(u.key, logf, func(key string) (*http.Response, error) {
+		upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		setGeminiHeaders(upReq, key)
+		upReq.Header.Set("Content-Type", "application/json")
+		return u.client.Do(upReq)
+	})
+	if err != nil {
+		return nil, err
+	}
+	logf("upstream status=%d", upResp.StatusCode)
+	if upResp.StatusCode >= 400 {
+		data, _ := io.ReadAll(upResp.Body)
+		_ = upResp.Body.Close()
+		logf("upstream error body=%s", bodyForLog(string(data), 16*1024))
+		return chatErrorResponse(upResp, geminiErrorToChatError(upResp.StatusCode, data)), nil
+	}
+	if !stream {
+		defer upResp.Body.Close()
+		var gemResp map[string]any
+		if err := json.NewDecoder(upResp.Body).Decode(&gemResp); err != nil {
+			return nil, fmt.Errorf("invalid upstream generateContent response: %w", err)
+		}
+		return chatJSONResponse(http.StatusOK, geminiResponseToChatCompletion(gemResp, model, u.rememberSignature)), nil
+	}
+	includeUsage := boolValue(mapValue(chatReq["stream_options"])["include_usage"])
+	pr, pw := io.Pipe()
+	go func() {
+		defer upResp.Body.Close()
+		err := geminiStreamToChatStream(pw, upResp.Body, model, includeUsage, u.rememberSignature)
+		if err != nil {
+			logf("upstream stream conversion failed: %v", err)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       pr,
+	}, nil
+}
+
+func setGeminiHeaders(r *http.Request, key string) {
+	r.Header.Set("Accept-Encoding", "identity")
+	if key != "" {
+		r.Header.Set("x-goog-api-key", key)
+	}
+}
+
+func (u *geminiChatUpstream) signature(callID string) string {
+	u.sigMu.Lock()
+	defer u.sigMu.Unlock()
+	el, ok := u.signatures[callID]
+	if !ok {
+		return ""
+	}
+	u.sigOrder.MoveToFront(el)
+	return el.Value.(*geminiSignature).signature
+}
+
+func (u *geminiChatUpstream) rememberSignature(callID, signature string) {
+	if callID == "" || signature == "" {
+		return
+	}
+	u.sigMu.Lock()
+	defer u.sigMu.Unlock()
+	if u.signatures == nil {
+		u.sigOrder = list.New()
+		u.signatures = map[string]*list.Element{}
+	}
+	if el, ok := u.signatures[callID]; ok {
+		el.Value.(*geminiSignature).signature = signature
+		u.sigOrder.MoveToFront(el)
+		return
+	}
+	u.signatures[callID] = u.sigOrder.PushFront(&geminiSignature{callID: callID, signature: signature})
+	for u.sigOrder.Len() > geminiMaxSignatures {
+		oldest := u.sigOrder.Back()
+		u.sigOrder.Remove(oldest)
+		delete(u.signatures, oldest.Value.(*geminiSignature).callID)
+	}
+}
+
+// fetchModels reads the Gemini model listing, keeping models that support
+// generateContent.
+func (u *geminiChatUpstream) fetchModels(ctx context.Context) ([]compatModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(u.baseURL, "/")+"/models?pageSize=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+	setGeminiHeaders(req, u.key)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("upstream /models status %d: %s", resp.StatusCode, truncateForLog(strings.TrimSpace(string(data)), 240))
+	}
+	var decoded struct {
+		Models []struct {
+			Name    string   `json:"name"`
+			Methods []string `json:"supportedGenerationMethods"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid upstream /models response: %w", err)
+	}
+	out := make([]compatModel, 0, len(decoded.Models))
+	for _, m := range decoded.Models {
+		id := strings.TrimPrefix(strings.TrimSpace(m.Name), "models/")
+		if id == "" {
+			continue
+		}
+		generates := len(m.Methods) == 0
+		for _, method := range m.Methods {
+			if method == "generateContent" {
+				generates = true
+			}
+		}
+		if generates {
+			out = append(out, compatModel{ID: id, OwnedBy: "google"})
+		}
+	}
+	return out, nil
+}
+
+func geminiGenerateURL(base, model string, stream bool) string {
+	base = strings.TrimRight(base, "/") + "/models/" + model
+	if stream {
+		return base + ":streamGenerateContent?alt=sse"
+	}
+	return base + ":generateContent"
+}
+
+func geminiErrorToChatError(status int, data []byte) map[string]any {
+	errType := "api_error"
+	switch status {
+	case http.StatusBadRequest, http.StatusNotFound:
+		errType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errType = "authentication_error"
+	case http.StatusForbidden:
+		errType = "permission_error"
+	case http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	}
+	msg := strings.TrimSpace(string(data))
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err == nil {
+		if m := stringValue(mapValue(decoded["error"])["message"]); m != "" {
+			msg = m
+		}
+	}
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	return map[string]any{
+		"error": map[string]any{
+			"message": msg,
+			"type":    errType,
+		},
+	}
+}
+
+// chatToGeminiRequest maps a chat/completions request to Gemini
+// generateContent: system messages become systemInstruction, assistant turns
+// use the "model" role, tool results become functionResponse parts and
+// consecutive same-role turns are merged. signature looks up the thought
+// signature of a replayed tool call.
+func chatToGeminiRequest(chatReq map[string]any, signature func(callID string) string) (map[string]any, error) {
+	system := make([]any, 0, 1)
+	var turns geminiTurns
+	toolNames := map[string]string{}
+	for i, msg := range chatMessagesList(chatReq["messages"]) {
+		switch role := stringValue(msg["role"]); role {
+		case "system", "developer":
+			parts, err := chatContentToGeminiParts(msg["content"])
+			if err != nil {
+				return nil, fmt.Errorf("messages.%d: %w", i, err)
+			}
+			system = append(system, parts...)
+		case "assistant":
+			parts, err := chatContentToGeminiParts(msg["content"])
+			if err != nil {
+				return nil, fmt.Errorf("messages.%d: %w", i, err)
+			}
+			for j, call := range chatMessageToolCalls(msg["tool_calls"]) {
+				id := stringValue(call["id"])
+				fn := mapValue(call["function"])
+				name := stringValue(fn["name"])
+				toolNames[id] = name
+				args := map[string]any{}
+				if raw := strings.TrimSpace(stringValue(fn["arguments"])); raw != "" {
+					_ = json.Unmarshal([]byte(raw), &args)
+				}
+				part := map[string]any{"functionCall": map[string]any{"name": name, "args": args}}
+				if sig := signature(id); sig != "" {
+					part["thoughtSignature"] = sig
+				} else if j == 0 {
+					part["thoughtSignature"] = geminiSkipThoughtSignature
+				}
+				parts = append(parts, part)
+			}
+			turns.append("model", parts)
+		case "tool":
+			id := stringValue(msg["tool_call_id"])
+			name := toolNames[id]
+			if name == "" {
+				name = stringValue(msg["name"])
+			}
+			turns.append("user", []any{map[string]any{
+				"functionResponse": map[string]any{
+					"name":     name,
+					"response": map[string]any{"content": normalizeMessageContent(msg["content"])},
+				},
+			}})
+		default:
+			parts, err := chatContentToGeminiParts(msg["content"])
+			if err != nil {
+				return nil, fmt.Errorf("messages.%d: %w", i, err)
+			}
+			turns.append("user", parts)
+		}
+	}
+	out := map[string]any{"contents": turns.contents()}
+	if len(system) > 0 {
+		out["systemInstruction"] = map[string]any{"parts": system}
+	}
+
+	config := map[string]any{}
+	if v, ok := chatReq["temperature"]; ok && v != nil {
+		config["temperature"] = v
+	}
+	if v, ok := chatReq["top_p"]; ok && v != nil {
+		config["topP"] = v
+	}
+	maxTokens, ok := intValue(chatReq["max_completion_tokens"])
+	if !ok {
+		maxTokens, ok = intValue(chatReq["max_tokens"])
+	}
+	if ok && maxTokens > 0 {
+		config["maxOutputTokens"] = maxTokens
+	}
+	switch v := chatReq["stop"].(type) {
+	case string:
+		if v != "" {
+			config["stopSequences"] = []string{v}
+		}
+	case []any:
+		if len(v) > 0 {
+			config["stopSequences"] = v
+		}
+	}
+	format := mapValue(chatReq["response_format"])
+	switch stringValue(format["type"]) {
+	case "json_object":
+		config["responseMimeType"] = "application/json"
+	case "json_schema":
+		config["responseMimeType"] = "application/json"
+		if schema, ok := mapValue(format["json_schema"])["schema"]; ok {
+			config["responseJsonSchema"] = schema
+		}
+	}
+	if budget := geminiBudgetForReasoningEffort(stringValue(chatReq["reasoning_effort"])); budget > 0 {
+		config["thinkingConfig"] = map[string]any{"thinkingBudget": budget, "includeThoughts": true}
+	}
+	if len(config) > 0 {
+		out["generationConfig"] = config
+	}
+
+	if decls := chatToolsToGeminiDeclarations(chatReq["tools"]); len(decls) > 0 {
+		out["tools"] = []any{map[string]any{"functionDeclarations": decls}}
+		if tc, ok := chatToolChoiceToGeminiToolConfig(chatReq["tool_choice"]); ok {
+			out["toolConfig"] = tc
+		}
+	}
+	return out, nil
+}
+
+// geminiTurns is anthropicTurns for Gemini contents.
+type geminiTurns []map[string]any
+
+func (t *geminiTurns) append(role string, parts []any) {
+	if len(parts) == 0 {
+		return
+	}
+	if n := len(*t); n > 0 && (*t)[n-1]["role"] == role {
+		(*t)[n-1]["parts"] = append((*t)[n-1]["parts"].([]any), parts...)
+		return
+	}
+	*t = append(*t, map[string]any{"role": role, "parts": parts})
+}
+
+func (t geminiTurns) contents() []map[string]any {
+	if len(t) == 0 {
+		return []map[string]any{{"role": "user", "parts": []any{map[string]any{"text": "..."}}}}
+	}
+	return t
+}
+
+func geminiBudgetForReasoningEffort(effort string) int {
+	switch strings.ToLower(strings.TrimSpace(effort)) {
+	case "low":
+		return 1024
+	case "medium":
+		return 8192
+	case "high", "xhigh":
+		return 24576
+	default:
+		return 0
+	}
+}
+
+func chatContentToGeminiParts(raw any) ([]any, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []any{map[string]any{"text": v}}, nil
+	}
+	out := make([]any, 0, 2)
+	for _, part := range chatMessagesList(raw) {
+		switch stringValue(part["type"]) {
+		case "text":
+			if text := stringValue(part["text"]); text != "" {
+				out = append(out, map[string]any{"text": text})
+			}
+		case "image_url":
+			url := stringValue(part["image_url"])
+			if url == "" {
+				url = stringValue(mapValue(part["image_url"])["url"])
+			}
+			imagePart, err := geminiImagePartFromURL(url)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, imagePart)
+		}
+	}
+	return out, nil
+}
+
+// geminiImagePartFromURL sends data URLs inline and other URLs by reference.
+func geminiImagePartFromURL(url string) (map[string]any, error) {
+	source, err := anthropicImageSourceFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if source["type"] == "base64" {
+		return map[string]any{"inlineData": map[string]any{"mimeType": source["media_type"], "data": source["data"]}}, nil
+	}
+	fileData := map[string]any{"fileUri": url}
+	if mimeType := mime.TypeByExtension(path.Ext(strings.SplitN(url, "?", 2)[0])); mimeType != "" {
+		fileData["mimeType"] = mimeType
+	}
+	return map[string]any{"fileData": fileData}, nil
+}
+
+// chatToolsToGeminiDeclarations passes tool schemas through
+// parametersJsonSchema, which accepts full JSON Schema, rather than the
+// OpenAPI subset behind "parameters".
+func chatToolsToGeminiDeclarations(raw any) []map[string]any {
+	out := make([]map[string]any, 0)
+	for _, tool := range chatToolsToAnthropicTools(raw) {
+		decl := map[string]any{
+			"name":                 tool["name"],
+			"parametersJsonSchema": tool["input_schema"],
+		}
+		if desc, ok := tool["description"]; ok {
+			decl["description"] = desc
+		}
+		out = append(out, decl)
+	}
+	return out
+}
+
+func chatToolChoiceToGeminiToolConfig(raw any) (map[string]any, bool) {
+	mode := ""
+	var allowed []string
+	switch v := raw.(type) {
+	case string:
+		switch v {
+		case "auto":
+			mode = "AUTO"
+		case "none":
+			mode = "NONE"
+		case "required":
+			mode = "ANY"
+		}
+	case map[string]any:
+		if name := stringValue(mapValue(v["function"])["name"]); name != "" {
+			mode = "ANY"
+			allowed = []string{name}
+		}
+	}
+	if mode == "" {
+		return nil, false
+	}
+	cfg := map[string]any{"mode": mode}
+	if len(allowed) > 0 {
+		cfg["allowedFunctionNames"] = allowed
+	}
+	return map[string]any{"functionCallingConfig": cfg}, true
+}
+
+// geminiCandidate returns the parts and finish reason of the first
+// candidate. A prompt blocked before generation reports as SAFETY.
+func geminiCandidate(resp map[string]any) ([]map[string]any, string) {
+	candidates, _ := resp["candidates"].([]any)
+	if len(candidates) == 0 {
+		if stringValue(mapValue(resp["promptFeedback"])["blockReason"]) != "" {
+			return nil, "SAFETY"
+		}
+		return nil, ""
+	}
+	candidate := mapValue(candidates[0])
+	return chatMessagesList(mapValue(candidate["content"])["parts"]), stringValue(candidate["finishReason"])
+}
+
+// geminiToolCall converts a functionCall part to a chat tool call, assigning
+// an ID when Gemini did not and remembering the part's thought signature.
+func geminiToolCall(part map[string]any, index int, remember func(callID, signature string)) map[string]any {
+	call := mapValue(part["functionCall"])
+	id := stringValue(call["id"])
+	if id == "" {
+		id = fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), index)
+	}
+	remember(id, stringValue(part["thoughtSignature"]))
+	args, err := json.Marshal(call["args"])
+	if err != nil || string(args) == "null" {
+		args = []byte("{}")
+	}
+	return map[string]any{
+		"id":   id,
+		"type": "function",
+		"function": map[string]any{
+			"name":      stringValue(call["name"]),
+			"arguments": string(args),
+		},
+	}
+}
+
+func geminiResponseToChatCompletion(resp map[string]any, requestedModel string, remember func(callID, signature string)) map[string]any {
+	parts, finishReason := geminiCandidate(resp)
+	var text, reasoning strings.Builder
+	toolCalls := make([]map[string]any, 0, 2)
+	for _, part := range parts {
+		switch {
+		case part["functionCall"] != nil:
+			toolCalls = append(toolCalls, geminiToolCall(part, len(toolCalls), remember))
+		case boolValue(part["thought"]):
+			reasoning.WriteString(stringValue(part["text"]))
+		default:
+			text.WriteString(stringValue(part["text"]))
+		}
+	}
+	message := map[string]any{
+		"role":    "assistant",
+		"content": nil,
+	}
+	if text.Len() > 0 {
+		message["content"] = text.String()
+	}
+	if reasoning.Len() > 0 {
+		message["reasoning_content"] = reasoning.String()
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+	model := stringValue(resp["modelVersion"])
+	if model == "" {
+		model = requestedModel
+	}
+	id := stringValue(resp["responseId"])
+	if id == "" {
+		id = fmt.Sprintf("chatcmpl_%d", time.Now().UnixNano())
+	}
+	return map[string]any{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []any{
+			map[string]any{
+				"index":         0,
+				"message":       message,
+				"finish_reason": geminiFinishReasonToFinishReason(finishReason, len(toolCalls) > 0),
+			},
+		},
+		"usage": chatUsageFromGemini(mapValue(resp["usageMetadata"])),
+	}
+}
+
+func geminiFinishReasonToFinishReason(reason string, toolCalls bool) string {
+	switch reason {
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	}
+	if toolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// chatUsageFromGemini counts thinking tokens as completion tokens, as OpenAI
+// does for reasoning models, and reports context cache hits as cached.
+func chatUsageFromGemini(meta map[string]any) map[string]any {
+	prompt := intFromAny(meta["promptTokenCount"])
+	reasoning := intFromAny(meta["thoughtsTokenCount"])
+	completion := intFromAny(meta["candidatesTokenCount"]) + reasoning
+	total := intFromAny(meta["totalTokenCount"])
+	if total == 0 {
+		total = prompt + completion
+	}
+	return map[string]any{
+		"prompt_tokens":             prompt,
+		"completion_tokens":         completion,
+		"total_tokens":              total,
+		"prompt_tokens_details":     map[string]any{"cached_tokens": intFromAny(meta["cachedContentTokenCount"])},
+		"completion_tokens_details": map[string]any{"reasoning_tokens": reasoning},
+	}
+}
+
+// geminiStreamToChatStream converts a streamGenerateContent SSE stream into
+// chat/completions chunks. Gemini has no end-of-stream event, so a stream
+// that closes before any candidate reports a finishReason is an error and
+// no [DONE] marker is written.
+func geminiStreamToChatStream(w io.Writer, upBody io.Reader, requestedModel string, includeUsage bool, remember func(callID, signature string)) error {
+	scanner := bufio.NewScanner(upBody)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+
+	id := fmt.Sprintf("chatcmpl_%d", time.Now().UnixNano())
+	model := requestedModel
+	created := time.Now().Unix()
+	usage := map[string]any{}
+	started := false
+	toolCalls := 0
+	finishReason := ""
+
+	emit := func(delta map[string]any, finishReason any) {
+		writeSSE(w, map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []any{
+				map[string]any{
+					"index":         0,
+					"delta":         delta,
+					"finish_reason": finishReason,
+				},
+			},
+		})
+	}
+
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "data:")
+		if !ok {
+			continue
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+			continue
+		}
+		if errObj, ok := chunk["error"]; ok {
+			writeSSE(w, map[string]any{"error": geminiErrorToChatError(intFromAny(mapValue(errObj)["code"]), []byte(data))["error"]})
+			return fmt.Errorf("upstream stream error: %s", truncateForLog(strings.TrimSpace(data), 240))
+		}
+		if !started {
+			if v := stringValue(chunk["responseId"]); v != "" {
+				id = v
+			}
+			if v := stringValue(chunk["modelVersion"]); v != "" {
+				model = v
+			}
+			emit(map[string]any{"role": "assistant", "content": ""}, nil)
+			started = true
+		}
+		if meta := mapValue(chunk["usageMetadata"]); len(meta) > 0 {
+			usage = meta
+		}
+		parts, reason := geminiCandidate(chunk)
+		for _, part := range parts {
+			switch {
+			case part["functionCall"] != nil:
+				call := geminiToolCall(part, toolCalls, remember)
+				call["index"] = toolCalls
+				toolCalls++
+				emit(map[string]any{"tool_calls": []any{call}}, nil)
+			case boolValue(part["thought"]):
+				if t := stringValue(part["text"]); t != "" {
+					emit(map[string]any{"reasoning_content": t}, nil)
+				}
+			default:
+				if t := stringValue(part["text"]); t != "" {
+					emit(map[string]any{"content": t}, nil)
+				}
+			}
+		}
+		if reason != "" {
+			finishReason = reason
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if finishReason == "" {
+		return io.ErrUnexpectedEOF
+	}
+	emit(map[string]any{}, geminiFinishReasonToFinishReason(finishReason, toolCalls > 0))
+	if includeUsage {
+		writeSSE(w, map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []any{},
+			"usage":   chatUsageFromGemini(usage),
+		})
+	}
+	_, err := io.WriteString(w, "data: [DONE]\n\n")
+	return err
+}
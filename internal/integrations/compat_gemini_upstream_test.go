@@ -0,0 +1,228 @@
+package integrations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"spark/internal/config"
+)
+
+func TestChatToGeminiRequest_MapsMessagesToolsAndImages(t *testing.T) {
+	signatures := map[string]string{"call_1": "sig_1"}
+	out, err := chatToGeminiRequest(map[string]any{
+		"model":            "gemini-2.5-pro",
+		"max_tokens":       512,
+		"reasoning_effort": "medium",
+		"tool_choice":      "required",
+		"messages": []any{
+			map[string]any{"role": "system", "content": "Be brief."},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "text", "text": "what is this?"},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,AAAA"}},
+			}},
+			map[string]any{"role": "assistant", "tool_calls": []any{
+				map[string]any{"id": "call_1", "type": "function", "function": map[string]any{"name": "read", "arguments": `{"path":"a"}`}},
+				map[string]any{"id": "call_2", "type": "function", "function": map[string]any{"name": "read", "arguments": `{"path":"b"}`}},
+			}},
+			map[string]any{"role": "tool", "tool_call_id": "call_1", "content": "A"},
+			map[string]any{"role": "tool", "tool_call_id": "call_2", "content": "B"},
+		},
+		"tools": []any{
+			map[string]any{"type": "function", "function": map[string]any{
+				"name":       "read",
+				"parameters": map[string]any{"type": "object", "additionalProperties": false},
+			}},
+		},
+	}, func(id string) string { return signatures[id] })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	system := mapValue(out["systemInstruction"])["parts"].([]any)
+	if mapValue(system[0])["text"] != "Be brief." {
+		t.Fatalf("unexpected systemInstruction: %#v", out["systemInstruction"])
+	}
+	contents := out["contents"].([]map[string]any)
+	if len(contents) != 3 || contents[1]["role"] != "model" {
+		t.Fatalf("expected user/model/user contents, got %#v", contents)
+	}
+	image := mapValue(mapValue(contents[0]["parts"].([]any)[1])["inlineData"])
+	if image["mimeType"] != "image/png" || image["data"] != "AAAA" {
+		t.Fatalf("unexpected inline image: %#v", contents[0]["parts"])
+	}
+	calls := contents[1]["parts"].([]any)
+	if mapValue(calls[0])["thoughtSignature"] != "sig_1" || mapValue(calls[1])["thoughtSignature"] != nil {
+		t.Fatalf("unexpected thought signatures: %#v", calls)
+	}
+	responses := contents[2]["parts"].([]any)
+	if len(responses) != 2 || mapValue(mapValue(responses[1])["functionResponse"])["name"] != "read" {
+		t.Fatalf("expected both function responses in one turn: %#v", responses)
+	}
+	decl := mapValue(mapValue(out["tools"].([]any)[0])["functionDeclarations"].([]map[string]any)[0])
+	if decl["name"] != "read" || mapValue(decl["parametersJsonSchema"])["additionalProperties"] != false {
+		t.Fatalf("unexpected function declaration: %#v", decl)
+	}
+	if mapValue(mapValue(out["toolConfig"])["functionCallingConfig"])["mode"] != "ANY" {
+		t.Fatalf("unexpected toolConfig: %#v", out["toolConfig"])
+	}
+	config := mapValue(out["generationConfig"])
+	if config["maxOutputTokens"] != 512 || mapValue(config["thinkingConfig"])["thinkingBudget"] != 8192 {
+		t.Fatalf("unexpected generationConfig: %#v", config)
+	}
+}
+
+func newFakeGeminiServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, req map[string]any)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-goog-api-key") != "g-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":401,"message":"API key not valid","status":"UNAUTHENTICATED"}}`))
+			return
+		}
+		if r.URL.Path == "/models" {
+			_, _ = w.Write([]byte(`{"models":[{"name":"models/gemini-2.5-pro","supportedGenerationMethods":["generateContent"]},` +
+				`{"name":"models/text-embedding-004","supportedGenerationMethods":["embedContent"]}]}`))
+			return
+		}
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		handler(w, r, req)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGeminiSignatures_EvictLeastRecentlyUsed(t *testing.T) {
+	u := &geminiChatUpstream{}
+	u.rememberSignature("call_live", "sig_live")
+	for i := 0; i < geminiMaxSignatures; i++ {
+		if i == geminiMaxSignatures/2 {
+			// A replayed call stays remembered while newer ones arrive.
+			u.signature("call_live")
+		}
+		u.rememberSignature(fmt.Sprintf("call_%d", i), "sig")
+	}
+	if got := u.signature("call_live"); got != "sig_live" {
+		t.Fatalf("expected a recently replayed signature kept, got %q", got)
+	}
+	if got := u.signature("call_0"); got != "" {
+		t.Fatalf("expected the least recently used signature evicted, got %q", got)
+	}
+	if got := u.signature(fmt.Sprintf("call_%d", geminiMaxSignatures-1)); got != "sig" {
+		t.Fatalf("expected the newest signature kept, got %q", got)
+	}
+}
+
+func TestResponsesCompatProxy_GeminiUpstreamReplaysThoughtSignatures(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var upstreamReqs []map[string]any
+	upstream := newFakeGeminiServer(t, func(w http.ResponseWriter, r *http.Request, req map[string]any) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		upstreamReqs = append(upstreamReqs, req)
+		n := len(upstreamReqs)
+		mu.Unlock()
+		if n == 1 {
+			_, _ = w.Write([]byte(`{"responseId":"resp_g1","modelVersion":"gemini-2.5-pro","candidates":[{"content":{"role":"model","parts":[` +
+				`{"functionCall":{"name":"read","args":{"path":"a"}},"thoughtSignature":"sig_1"}]},"finishReason":"STOP"}],` +
+				`"usageMetadata":{"promptTokenCount":20,"candidatesTokenCount":5,"thoughtsTokenCount":7,"totalTokenCount":32}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"responseId":"resp_g2","candidates":[{"content":{"role":"model","parts":[{"text":"A it is."}]},"finishReason":"STOP"}]}`))
+	})
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	p, err := startResponsesCompatProxy(&config.Profile{Provider: "gemini", OpenAIBaseURL: upstream.URL, OpenAIAPIKey: "g-key"}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	post := func(body string) map[string]any {
+		resp, err := http.Post(p.BaseURL()+"/responses", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return out
+	}
+	tools := `"tools":[{"type":"function","name":"read","parameters":{"type":"object"}}]`
+	first := post(`{"model":"gemini-2.5-pro","input":"read a",` + tools + `}`)
+	items, _ := first["output"].([]any)
+	if len(items) != 1 || mapValue(items[0])["type"] != "function_call" || mapValue(items[0])["arguments"] != `{"path":"a"}` {
+		t.Fatalf("unexpected first output: %#v", first)
+	}
+	if usage := mapValue(first["usage"]); intFromAny(usage["output_tokens"]) != 12 {
+		t.Fatalf("expected thinking tokens in output_tokens: %#v", usage)
+	}
+	callID := stringValue(mapValue(items[0])["call_id"])
+	post(`{"model":"gemini-2.5-pro","previous_response_id":"` + stringValue(first["id"]) + `","input":[{"type":"function_call_output","call_id":"` + callID + `","output":"A"}],` + tools + `}`)
+
+	if paths[0] != "/models/gemini-2.5-pro:generateContent" {
+		t.Fatalf("unexpected upstream path: %s", paths[0])
+	}
+	contents, _ := upstreamReqs[1]["contents"].([]any)
+	if len(contents) != 3 {
+		t.Fatalf("expected user/model/user contents, got %#v", contents)
+	}
+	call := mapValue(mapValue(contents[1])["parts"].([]any)[0])
+	if call["thoughtSignature"] != "sig_1" {
+		t.Fatalf("expected remembered thought signature, got %#v", call)
+	}
+	var models map[string]any
+	getJSON(t, p.BaseURL()+"/models", &models)
+	if data, _ := models["data"].([]any); len(data) != 1 || mapValue(data[0])["id"] != "gemini-2.5-pro" {
+		t.Fatalf("unexpected models listing: %#v", models)
+	}
+}
+
+func TestAnthropicCompatProxy_GeminiUpstreamStream(t *testing.T) {
+	upstream := newFakeGeminiServer(t, func(w http.ResponseWriter, r *http.Request, req map[string]any) {
+		if r.URL.Path != "/models/gemini-2.5-pro:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("unexpected upstream URL: %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, strings.Join([]string{
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Let me look.","thought":true}]}}]}`,
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Reading "}]}}]}`,
+			`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read","args":{"path":"a"}}}]},"finishReason":"STOP"}],` +
+				`"usageMetadata":{"promptTokenCount":12,"candidatesTokenCount":4,"totalTokenCount":16}}`,
+			``,
+		}, "\n\n"))
+	})
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", t.TempDir()+"/claude.log")
+	p, err := startAnthropicCompatProxy(&config.Profile{Provider: "gemini", OpenAIBaseURL: upstream.URL, OpenAIAPIKey: "g-key"}, "gemini-2.5-pro")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	resp, err := http.Post(p.BaseURL()+"/v1/messages", "application/json", strings.NewReader(`{"model":"claude-sonnet-4","max_tokens":1024,"stream":true,`+
+		`"messages":[{"role":"user","content":"read a"}],"tools":[{"name":"read","input_schema":{"type":"object"}}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	body := string(data)
+	for _, want := range []string{
+		`"text":"Reading "`,
+		`"type":"tool_use"`,
+		`"name":"read"`,
+		`"partial_json":"{\"path\":\"a\"}"`,
+		`"stop_reason":"tool_use"`,
+		"event: message_stop",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %s in stream: %q", want, body)
+		}
+	}
+}
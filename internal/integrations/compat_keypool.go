@@ -0,0 +1,165 @@
+package integrations
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"spark/internal/config"
+)
+
+const (
+	// keyRateLimitCooldown rests a key after a 429 without Retry-After.
+	keyRateLimitCooldown = 30 * time.Second
+	// keyUnauthorizedCooldown rests a key the gateway rejected with 401.
+	keyUnauthorizedCooldown = 10 * time.Minute
+)
+
+// keyPool hands out a profile's API keys and rotates away from keys the
+// gateway rate limits (429) or rejects (401). Each such key cools down for
+// the Retry-After the gateway sent, or a default.
+type keyPool struct {
+	mu        sync.Mutex
+	keys      []string
+	strategy  string
+	next      int
+	until     []time.Time
+	limitedAt []time.Time
+	now       func() time.Time
+}
+
+// profileKeys returns openai_api_key followed by openai_api_keys, without
+// blanks or duplicates.
+func profileKeys(profile *config.Profile) []string {
+	if profile == nil {
+		return nil
+	}
+	var keys []string
+	seen := map[string]bool{}
+	for _, k := range append([]string{profile.OpenAIAPIKey}, profile.OpenAIAPIKeys...) {
+		k = strings.TrimSpace(k)
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// newKeyPool returns the key pool for profile, or nil when it has fewer than
+// two keys.
+func newKeyPool(profile *config.Profile) *keyPool {
+	keys := profileKeys(profile)
+	if len(keys) < 2 {
+		return nil
+	}
+	return &keyPool{
+		keys:      keys,
+		strategy:  strings.ToLower(strings.TrimSpace(profile.KeyStrategy)),
+		until:     make([]time.Time, len(keys)),
+		limitedAt: make([]time.Time, len(keys)),
+		now:       time.Now,
+	}
+}
+
+// pick returns the index of the key to use next. Keys cooling down are
+// skipped; when all are, the one available soonest is returned.
+func (k *keyPool) pick() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := k.now()
+	best := -1
+	for n := 0; n < len(k.keys); n++ {
+		i := (k.next + n) % len(k.keys)
+		if now.Before(k.until[i]) {
+			continue
+		}
+		if k.strategy != config.KeyStrategyLeastRecentlyLimited {
+			best = i
+			break
+		}
+		if best < 0 || k.limitedAt[i].Before(k.limitedAt[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		best = 0
+		for i := range k.keys {
+			if k.until[i].Before(k.until[best]) {
+				best = i
+			}
+		}
+	}
+	if k.strategy != config.KeyStrategyLeastRecentlyLimited {
+		k.next = (best + 1) % len(k.keys)
+	}
+	return best
+}
+
+// limit puts key i on cooldown after the gateway answered status.
+func (k *keyPool) limit(i int, status int, header http.Header) time.Duration {
+	cooldown, ok := parseRetryAfter(header.Get("Retry-After"), k.now())
+	if !ok {
+		cooldown = keyRateLimitCooldown
+		if status == http.StatusUnauthorized {
+			cooldown = keyUnauthorizedCooldown
+		}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.limitedAt[i] = k.now()
+	k.until[i] = k.limitedAt[i].Add(cooldown)
+	return cooldown
+}
+
+// do calls send with a key from the pool, moving to the next key after a 401
+// or 429 until every key has been tried once. A nil pool sends fallbackKey.
+func (k *keyPool) do(fallbackKey string, logf func(format string, args ...any), send func(key string) (*http.Response, error)) (*http.Response, error) {
+	if k == nil {
+		return send(fallbackKey)
+	}
+	for attempt := 1; ; attempt++ {
+		i := k.pick()
+		resp, err := send(k.keys[i])
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusUnauthorized {
+			return resp, nil
+		}
+		cooldown := k.limit(i, resp.StatusCode, resp.Header)
+		if attempt >= len(k.keys) {
+			logf("upstream key #%d status=%d; all %d keys tried", i+1, resp.StatusCode, len(k.keys))
+			return resp, nil
+		}
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		logf("upstream key #%d status=%d cooldown=%s body=%s; rotating key", i+1, resp.StatusCode, cooldown, bodyForLog(string(bytes.TrimSpace(data)), 240))
+	}
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or HTTP-date form.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
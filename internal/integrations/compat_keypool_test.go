@@ -0,0 +1,153 @@
+package integrations
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"spark/internal/config"
+)
+
+func TestKeyPool_Strategies(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rr := newKeyPool(&config.Profile{OpenAIAPIKey: "a", OpenAIAPIKeys: []string{"b", "a", " ", "c"}})
+	rr.now = func() time.Time { return now }
+	if len(rr.keys) != 3 {
+		t.Fatalf("expected deduplicated keys, got %#v", rr.keys)
+	}
+	if got := []int{rr.pick(), rr.pick(), rr.pick(), rr.pick()}; got[0] != 0 || got[1] != 1 || got[2] != 2 || got[3] != 0 {
+		t.Fatalf("unexpected round-robin order %v", got)
+	}
+	rr.limit(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"10"}})
+	if got := []int{rr.pick(), rr.pick()}; got[0] != 2 || got[1] != 0 {
+		t.Fatalf("expected limited key skipped, got %v", got)
+	}
+	now = now.Add(10 * time.Second)
+	if got := rr.pick(); got != 1 {
+		t.Fatalf("expected key back after Retry-After, got %d", got)
+	}
+
+	lrl := newKeyPool(&config.Profile{OpenAIAPIKeys: []string{"a", "b", "c"}, KeyStrategy: "least-recently-limited"})
+	lrl.now = func() time.Time { return now }
+	if lrl.pick() != 0 || lrl.pick() != 0 {
+		t.Fatalf("expected least-recently-limited to stay on the first key")
+	}
+	lrl.limit(0, http.StatusTooManyRequests, http.Header{})
+	now = now.Add(time.Second)
+	lrl.limit(1, http.StatusTooManyRequests, http.Header{})
+	if got := lrl.pick(); got != 2 {
+		t.Fatalf("expected the never-limited key, got %d", got)
+	}
+	lrl.limit(2, http.StatusUnauthorized, http.Header{})
+	now = now.Add(keyRateLimitCooldown)
+	if got := lrl.pick(); got != 0 {
+		t.Fatalf("expected the key limited longest ago, got %d", got)
+	}
+
+	if newKeyPool(&config.Profile{OpenAIAPIKey: "a", OpenAIAPIKeys: []string{"a"}}) != nil {
+		t.Fatalf("expected no pool for a single key")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d, ok := parseRetryAfter("7", now); !ok || d != 7*time.Second {
+		t.Fatalf("unexpected seconds parse %v %v", d, ok)
+	}
+	if d, ok := parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now); !ok || d != time.Minute {
+		t.Fatalf("unexpected date parse %v %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("soon", now); ok {
+		t.Fatalf("expected invalid Retry-After rejected")
+	}
+}
+
+func TestEditorChatProfile_RotatesKeysOn429(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		seen = append(seen, auth)
+		mu.Unlock()
+		if auth == "Bearer k1" {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, `{"error":{"message":"rate limited"}}`, http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+	t.Setenv("AGENT_LAUNCH_CHAT_COMPAT_LOG", t.TempDir()+"/chat.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STDERR", "0")
+
+	proxied, stop, err := editorChatProfile(&config.Profile{OpenAIBaseURL: upstream.URL, OpenAIAPIKeys: []string{"k1", "k2"}})
+	if err != nil {
+		t.Fatalf("editorChatProfile: %v", err)
+	}
+	defer stop()
+	if proxied.OpenAIBaseURL == upstream.URL || len(proxied.OpenAIAPIKeys) != 0 {
+		t.Fatalf("expected editor routed through the local proxy: %#v", proxied)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(proxied.OpenAIBaseURL+"/chat/completions", "application/json", strings.NewReader(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(data), `"ok"`) {
+			t.Fatalf("request %d: unexpected response %d %s", i, resp.StatusCode, data)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(seen, ",") != "Bearer k1,Bearer k2,Bearer k2" {
+		t.Fatalf("expected rotation away from the limited key, got %v", seen)
+	}
+}
+
+func TestChatUpstreams_RotateKeyPoolForAzureAndGemini(t *testing.T) {
+	for _, tc := range []struct {
+		provider string
+		header   string
+		body     string
+	}{
+		{config.ProviderAzure, "api-key", `{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`},
+		{config.ProviderGemini, "x-goog-api-key", `{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`},
+	} {
+		var seen []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(tc.header)
+			seen = append(seen, key)
+			if key != "k2" {
+				http.Error(w, `{"error":{"message":"rate limited"}}`, http.StatusTooManyRequests)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, tc.body)
+		}))
+		defer srv.Close()
+		upstream, err := newChatUpstream(&config.Profile{
+			Provider:      tc.provider,
+			OpenAIBaseURL: srv.URL,
+			OpenAIAPIKeys: []string{"k1", "k2"},
+		}, http.DefaultClient, func(string, ...any) {})
+		if err != nil {
+			t.Fatalf("%s: newChatUpstream: %v", tc.provider, err)
+		}
+		resp, err := upstream.Do(t.Context(), map[string]any{"model": "m", "messages": []any{map[string]any{"role": "user", "content": "hi"}}})
+		if err != nil {
+			t.Fatalf("%s: Do: %v", tc.provider, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || strings.Join(seen, ",") != "k1,k2" {
+			t.Fatalf("%s: expected rotation to the second pooled key, got %d %v", tc.provider, resp.StatusCode, seen)
+		}
+	}
+}
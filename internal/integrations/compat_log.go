@@ -0,0 +1,322 @@
+package integrations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"spark/internal/config"
+)
+
+// compatLogger writes a proxy's log as JSON lines. Every record names the
+// integration and profile; records logged while serving a request also
+// carry its request and session IDs so concurrent requests can be told
+// apart.
+type compatLogger struct {
+	logger *slog.Logger
+	// bodies is the profile's log_bodies level, applied to logBody args.
+	bodies string
+}
+
+func newCompatLogger(w io.Writer, integration string, profile *config.Profile) (*compatLogger, error) {
+	bodies, err := profile.LogBodiesLevel()
+	if err != nil {
+		return nil, err
+	}
+	registerLogSecrets(profile)
+	name := ""
+	if profile != nil {
+		name = profile.Name
+	}
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	return &compatLogger{
+		logger: slog.New(handler).With("integration", integration, "profile", name),
+		bodies: bodies,
+	}, nil
+}
+
+// logf logs a message outside any request. A nil l discards it.
+func (l *compatLogger) logf(format string, args ...any) {
+	if l == nil {
+		return
+	}
+	l.logger.Info(formatForLog(l.bodies, format, args))
+}
+
+// logBody is prompt or completion content passed to logf. How much of it
+// reaches the log depends on the log_bodies level.
+type logBody struct {
+	text  string
+	limit int
+}
+
+// bodyForLog wraps s for logf, cut to limit bytes at the truncated level.
+func bodyForLog(s string, limit int) logBody {
+	return logBody{text: s, limit: limit}
+}
+
+// String is the truncated rendering.
+func (b logBody) String() string {
+	return truncateForLog(b.text, b.limit)
+}
+
+func (b logBody) render(level string) string {
+	switch level {
+	case config.LogBodiesMetadata:
+		return fmt.Sprintf("[%d bytes omitted]", len(b.text))
+	case config.LogBodiesFull:
+		return redactForLog(b.text)
+	default:
+		return b.String()
+	}
+}
+
+func formatForLog(level, format string, args []any) string {
+	for i, arg := range args {
+		if b, ok := arg.(logBody); ok {
+			args[i] = b.render(level)
+		}
+	}
+	return redactForLog(fmt.Sprintf(format, args...))
+}
+
+// begin starts the request log for r. The returned writer records the
+// response status and carries the log to the stream adapters; the returned
+// request carries it to the executors.
+func (l *compatLogger) begin(w http.ResponseWriter, r *http.Request) (*loggingResponseWriter, *http.Request) {
+	rl := &requestLog{start: time.Now(), session: requestSessionID(r)}
+	if l != nil {
+		rl.bodies = l.bodies
+		rl.logger = l.logger.With("request_id", newRequestID())
+		if rl.session != "" {
+			rl.logger = rl.logger.With("session_id", rl.session)
+		}
+	}
+	return &loggingResponseWriter{ResponseWriter: w, log: rl},
+		r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl))
+}
+
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return "req_" + hex.EncodeToString(b[:])
+}
+
+// requestSessionID is the agent's conversation ID: Codex sends session_id,
+// Claude Code x-claude-code-session-id.
+func requestSessionID(r *http.Request) string {
+	for _, h := range []string{"session_id", "x-claude-code-session-id", "x-session-id"} {
+		if v := strings.TrimSpace(r.Header.Get(h)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// anthropicSessionID reads the session from a Messages request's
+// metadata.user_id, which Claude Code ends with "_session_<id>".
+func anthropicSessionID(req map[string]any) string {
+	userID := stringValue(mapValue(req["metadata"])["user_id"])
+	if i := strings.LastIndex(userID, "_session_"); i >= 0 {
+		return userID[i+len("_session_"):]
+	}
+	return ""
+}
+
+// requestLog collects one request's records and its closing summary. Its
+// methods are safe on a nil receiver, as used by code that runs outside a
+// proxy handler.
+type requestLog struct {
+	mu           sync.Mutex
+	logger       *slog.Logger
+	bodies       string
+	start        time.Time
+	session      string
+	model        string
+	inputTokens  int
+	outputTokens int
+	cachedTokens int
+}
+
+type requestLogKey struct{}
+
+// requestLogFrom returns the request log attached to ctx, or nil.
+func requestLogFrom(ctx context.Context) *requestLog {
+	rl, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	return rl
+}
+
+// requestLogOf returns the request log a handler attached to w, or nil.
+func requestLogOf(w http.ResponseWriter) *requestLog {
+	if lw, ok := w.(*loggingResponseWriter); ok {
+		return lw.log
+	}
+	return nil
+}
+
+func (rl *requestLog) logf(format string, args ...any) {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	logger := rl.logger
+	rl.mu.Unlock()
+	if logger != nil {
+		logger.Info(formatForLog(rl.bodies, format, args))
+	}
+}
+
+// logfOr returns rl.logf, or fallback when there is no request log.
+func (rl *requestLog) logfOr(fallback func(format string, args ...any)) func(format string, args ...any) {
+	if rl == nil || rl.logger == nil {
+		return fallback
+	}
+	return rl.logf
+}
+
+// setSession records a session ID found in the request body when the
+// headers had none.
+func (rl *requestLog) setSession(session string) {
+	if rl == nil || session == "" {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.session != "" || rl.logger == nil {
+		return
+	}
+	rl.session = session
+	rl.logger = rl.logger.With("session_id", session)
+}
+
+func (rl *requestLog) setModel(model string) {
+	if rl == nil || model == "" {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.model = model
+}
+
+func (rl *requestLog) setUsage(input, output, cached int) {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.inputTokens, rl.outputTokens, rl.cachedTokens = input, output, cached
+}
+
+// loggingResponseWriter is the http.ResponseWriter proxy handlers hand
+// down; it remembers the status for the request summary.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	log    *requestLog
+	status int
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *loggingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish logs the request summary: status, latency, model and usage.
+func (w *loggingResponseWriter) finish() {
+	rl := w.log
+	if rl == nil || rl.logger == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.logger.Info("request finished",
+		"status", w.status,
+		"latency_ms", time.Since(rl.start).Milliseconds(),
+		"model", rl.model,
+		"input_tokens", rl.inputTokens,
+		"output_tokens", rl.outputTokens,
+		"cached_tokens", rl.cachedTokens,
+	)
+}
+
+// logSecrets holds the API keys of every profile a proxy was started with,
+// so log output never repeats them.
+var logSecrets struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// minLogSecretLen keeps placeholder keys such as "ollama" from redacting
+// ordinary words.
+const minLogSecretLen = 8
+
+func registerLogSecrets(profile *config.Profile) {
+	if profile == nil {
+		return
+	}
+	candidates := append([]string{profile.OpenAIAPIKey, profile.AnthropicAuthToken}, profile.OpenAIAPIKeys...)
+	for _, fb := range profile.Fallbacks {
+		candidates = append(candidates, fb.APIKey)
+	}
+	if profile.WebSearch != nil {
+		candidates = append(candidates, profile.WebSearch.APIKey)
+	}
+	logSecrets.mu.Lock()
+	defer logSecrets.mu.Unlock()
+	for _, c := range candidates {
+		c = strings.TrimSpace(c)
+		if len(c) < minLogSecretLen || slices.Contains(logSecrets.values, c) {
+			continue
+		}
+		logSecrets.values = append(logSecrets.values, c)
+	}
+	// Longest first, so a key that contains another is replaced whole.
+	sort.Slice(logSecrets.values, func(i, j int) bool { return len(logSecrets.values[i]) > len(logSecrets.values[j]) })
+}
+
+const redactedForLog = "[REDACTED]"
+
+var (
+	credentialHeaderPattern = regexp.MustCompile(`(?i)((?:authorization|x-api-key|api-key|x-goog-api-key)["']?\s*[:=]\s*\[?\s*["']?)(?:bearer\s+)?[^\s"',\]}]+`)
+	bearerTokenPattern      = regexp.MustCompile(`(?i)\bbearer\s+[a-z0-9._~+/=-]{8,}`)
+)
+
+// redactForLog masks credential headers, bearer tokens and registered API
+// keys in s.
+func redactForLog(s string) string {
+	logSecrets.mu.RLock()
+	for _, secret := range logSecrets.values {
+		s = strings.ReplaceAll(s, secret, redactedForLog)
+	}
+	logSecrets.mu.RUnlock()
+	s = credentialHeaderPattern.ReplaceAllString(s, "${1}"+redactedForLog)
+	return bearerTokenPattern.ReplaceAllString(s, "Bearer "+redactedForLog)
+}
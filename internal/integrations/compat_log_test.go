@@ -0,0 +1,221 @@
+package integrations
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"spark/internal/config"
+	"spark/internal/mockupstream"
+)
+
+func TestRedactForLog(t *testing.T) {
+	registerLogSecrets(&config.Profile{OpenAIAPIKey: "sk-redact-test-0123456789", OpenAIAPIKeys: []string{"short"}})
+
+	got := mustJSONForLog(map[string]any{
+		"headers": map[string]any{"Authorization": "Bearer gw-token-abcdefgh"},
+		"note":    "key sk-redact-test-0123456789 leaked",
+	}).String()
+	for _, leak := range []string{"gw-token-abcdefgh", "sk-redact-test-0123456789"} {
+		if strings.Contains(got, leak) {
+			t.Fatalf("expected %q redacted, got %s", leak, got)
+		}
+	}
+	if !strings.Contains(got, redactedForLog) {
+		t.Fatalf("expected redaction marker, got %s", got)
+	}
+	if got := redactForLog("Authorization: Bearer abc.def-123456 and x-api-key=k-1234567890"); strings.Contains(got, "abc.def") ||
+		strings.Contains(got, "k-1234567890") {
+		t.Fatalf("expected header values redacted, got %s", got)
+	}
+	if got := truncateForLog("prefix sk-redact-test-0123456789", 12); strings.Contains(got, "sk-red") {
+		t.Fatalf("expected a key cut by truncation still redacted, got %s", got)
+	}
+	if got := redactForLog("a short key stays"); got != "a short key stays" {
+		t.Fatalf("expected short keys not registered, got %s", got)
+	}
+}
+
+func readLogRecords(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	defer f.Close()
+	var records []map[string]any
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("log line is not JSON: %q", scanner.Text())
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestCompatLog_CorrelatesRequestRecords(t *testing.T) {
+	_, baseURL := startMockUpstream(t, mockupstream.TextStream("gpt-4.1", "Hello"))
+	const secret = "sk-correlate-0123456789"
+	p, err := startResponsesCompatProxy(&config.Profile{Name: "work", OpenAIBaseURL: baseURL, OpenAIAPIKey: secret}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, p.BaseURL()+"/responses",
+		strings.NewReader(`{"model":"gpt-4.1","stream":true,"input":"hi"}`))
+	req.Header.Set("session_id", "sess-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	p.Close()
+
+	data, _ := os.ReadFile(p.LogPath())
+	if strings.Contains(string(data), secret) {
+		t.Fatalf("expected the API key redacted from the log:\n%s", data)
+	}
+	requestIDs := map[string]bool{}
+	var summary map[string]any
+	for _, rec := range readLogRecords(t, p.LogPath()) {
+		if rec["integration"] != "codex" || rec["profile"] != "work" {
+			t.Fatalf("expected integration and profile on every record, got %v", rec)
+		}
+		if id, ok := rec["request_id"].(string); ok {
+			requestIDs[id] = true
+			if rec["session_id"] != "sess-1" {
+				t.Fatalf("expected the session on request records, got %v", rec)
+			}
+		}
+		if rec["msg"] == "request finished" {
+			summary = rec
+		}
+	}
+	if len(requestIDs) != 1 {
+		t.Fatalf("expected one request ID across the request's records, got %v", requestIDs)
+	}
+	if summary == nil || summary["status"] != float64(200) || summary["model"] != "gpt-4.1" ||
+		summary["input_tokens"] != float64(12) || summary["output_tokens"] != float64(1) {
+		t.Fatalf("unexpected request summary %v", summary)
+	}
+	if _, ok := summary["latency_ms"]; !ok {
+		t.Fatalf("expected latency in the summary %v", summary)
+	}
+}
+
+func TestCompatLog_AnthropicSessionFromMetadata(t *testing.T) {
+	if got := anthropicSessionID(map[string]any{"metadata": map[string]any{"user_id": "user_ab_account_cd_session_ef-12"}}); got != "ef-12" {
+		t.Fatalf("unexpected session %q", got)
+	}
+	if got := anthropicSessionID(map[string]any{}); got != "" {
+		t.Fatalf("expected no session, got %q", got)
+	}
+}
+
+func TestCompatLog_MetadataLevelOmitsBodies(t *testing.T) {
+	_, baseURL := startMockUpstream(t, mockupstream.TextStream("gpt-4.1", "secret completion"))
+	const prompt = "confidential prompt text"
+	p, err := startResponsesCompatProxy(&config.Profile{OpenAIBaseURL: baseURL, LogBodies: config.LogBodiesMetadata}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	_, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"gpt-4.1","stream":true,"input":"`+prompt+`"}`)
+	p.Close()
+	assertContainsAll(t, body, "secret completion")
+
+	data, _ := os.ReadFile(p.LogPath())
+	for _, leak := range []string{prompt, "secret completion"} {
+		if strings.Contains(string(data), leak) {
+			t.Fatalf("expected %q kept out of a metadata-only log:\n%s", leak, data)
+		}
+	}
+	assertContainsAll(t, string(data), "log_bodies=metadata", "bytes omitted", `"msg":"request finished"`)
+}
+
+func TestCompatLog_MetadataLevelOmitsUpstreamErrorBodies(t *testing.T) {
+	const detail = "upstream private detail"
+	errorBody := `{"error":{"message":"` + detail + `","type":"invalid_request_error"}}`
+	_, baseURL := startMockUpstream(t,
+		mockupstream.Scenario{Status: http.StatusServiceUnavailable, Body: errorBody},
+		mockupstream.Scenario{Status: http.StatusBadRequest, Body: errorBody},
+		mockupstream.Scenario{Chunks: []string{"data: " + errorBody + "\n\n"}},
+	)
+	profile := &config.Profile{
+		OpenAIBaseURL: baseURL,
+		LogBodies:     config.LogBodiesMetadata,
+		Retry:         &config.RetryPolicy{MaxAttempts: 2, InitialBackoff: "1ms"},
+	}
+	responses, err := startResponsesCompatProxy(profile, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	postForBody(t, responses.BaseURL()+"/responses", `{"model":"gpt-4.1","stream":true,"input":"hi"}`)
+	responses.Close()
+	claude, err := startAnthropicCompatProxy(profile, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	_, body := postForBody(t, claude.BaseURL()+"/v1/messages", `{"model":"claude-sonnet-4","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	claude.Close()
+	assertContainsAll(t, body, "event: error")
+
+	for _, path := range []string{responses.LogPath(), claude.LogPath()} {
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), detail) {
+			t.Fatalf("expected upstream error bodies kept out of a metadata-only log:\n%s", data)
+		}
+		assertContainsAll(t, string(data), "bytes omitted")
+	}
+}
+
+func TestCompatLog_PolicyCapsLogBodies(t *testing.T) {
+	_, baseURL := startMockUpstream(t)
+	policyPath := t.TempDir() + "/policy.json"
+	t.Setenv("AGENT_LAUNCH_POLICY_FILE", policyPath)
+	if err := os.WriteFile(policyPath, []byte(`{"log_bodies":"metadata"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := startAnthropicCompatProxy(&config.Profile{OpenAIBaseURL: baseURL, LogBodies: config.LogBodiesFull}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	p.Close()
+	data, _ := os.ReadFile(p.LogPath())
+	assertContainsAll(t, string(data), "log_bodies=metadata")
+
+	if err := os.WriteFile(policyPath, []byte(`{`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := startAnthropicCompatProxy(&config.Profile{OpenAIBaseURL: baseURL}, "gpt-4.1"); err == nil {
+		t.Fatalf("expected an unreadable policy to stop the proxy from starting")
+	}
+}
+
+func TestPurgeLogs(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", dir+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", dir+"/claude.log")
+	t.Setenv("AGENT_LAUNCH_CHAT_COMPAT_LOG", dir+"/missing/chat.log")
+	for _, name := range []string{"codex-2026-01-02.log", "claude-2026-01-03.log", "codex-notes.log", "other.txt"} {
+		if err := os.WriteFile(dir+"/"+name, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := PurgeLogs()
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("expected the two daily logs removed, got %v", removed)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("expected unrelated files kept, got %v", entries)
+	}
+}
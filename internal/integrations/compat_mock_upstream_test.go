@@ -0,0 +1,137 @@
+package integrations
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"spark/internal/config"
+	"spark/internal/mockupstream"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the proxies from downloading tokenizer rank files during tests.
+	os.Setenv("AGENT_LAUNCH_TOKENIZER_URL", "off")
+	os.Exit(m.Run())
+}
+
+// startMockUpstream serves scenarios from an in-process fake upstream,
+// routes the compat proxies' logs to the test's temp dir, and returns the
+// server with its base URL.
+func startMockUpstream(t *testing.T, scenarios ...mockupstream.Scenario) (*mockupstream.Server, string) {
+	t.Helper()
+	srv := mockupstream.NewServer(scenarios...)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", t.TempDir()+"/claude.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	t.Setenv("AGENT_LAUNCH_POLICY_FILE", "")
+	return srv, ts.URL + "/v1"
+}
+
+func postForBody(t *testing.T, url, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func assertContainsAll(t *testing.T, body string, wants ...string) {
+	t.Helper()
+	for _, want := range wants {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %s in:\n%s", want, body)
+		}
+	}
+}
+
+func TestMockUpstream_ResponsesProxyRoundTrips(t *testing.T) {
+	srv, baseURL := startMockUpstream(t,
+		mockupstream.TextStream("gpt-4.1", "Hello", " world"),
+		mockupstream.ToolCallStream("gpt-4.1", "shell", `{"command":`, `["ls",`, `"-la"]}`),
+		mockupstream.ReasoningStream("gpt-4.1", "Weighing", " options"),
+		mockupstream.RateLimited(0),
+		mockupstream.Completion("gpt-4.1", "after the limit"),
+	)
+	p, err := startResponsesCompatProxy(&config.Profile{
+		OpenAIBaseURL: baseURL,
+		Retry:         &config.RetryPolicy{InitialBackoff: "1ms"},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	url := p.BaseURL() + "/responses"
+
+	_, body := postForBody(t, url, `{"model":"gpt-4.1","stream":true,"input":"hi"}`)
+	assertContainsAll(t, body, `"delta":"Hello"`, `"delta":" world"`, `"output_text":"Hello world"`, `"type":"response.completed"`)
+
+	_, body = postForBody(t, url, `{"model":"gpt-4.1","stream":true,"input":"list",`+
+		`"tools":[{"type":"function","name":"shell","parameters":{"type":"object"}}]}`)
+	assertContainsAll(t, body, `"type":"function_call"`, `"call_id":"call_mock"`, `"arguments":"{\"command\":[\"ls\",\"-la\"]}"`)
+
+	_, body = postForBody(t, url, `{"model":"gpt-4.1","stream":true,"input":"think"}`)
+	assertContainsAll(t, body, `"type":"reasoning"`, `"text":"Weighing options"`, `"type":"response.completed"`)
+
+	status, body := postForBody(t, url, `{"model":"gpt-4.1","input":"again"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected the 429 retried away, got %d %s", status, body)
+	}
+	assertContainsAll(t, body, `"output_text":"after the limit"`)
+
+	reqs := srv.Requests()
+	if len(reqs) != 5 || reqs[1].Body["tools"] == nil {
+		t.Fatalf("unexpected upstream requests: %d", len(reqs))
+	}
+}
+
+func TestMockUpstream_AnthropicProxyRoundTrips(t *testing.T) {
+	_, baseURL := startMockUpstream(t,
+		mockupstream.TextStream("gpt-4.1", "Hi", " there"),
+		mockupstream.ToolCallStream("gpt-4.1", "Bash", `{"command":`, `"ls`, ` -la"}`),
+	)
+	p, err := startAnthropicCompatProxy(&config.Profile{OpenAIBaseURL: baseURL}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	url := p.BaseURL() + "/v1/messages"
+
+	_, body := postForBody(t, url, `{"model":"claude-sonnet-4","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	assertContainsAll(t, body, `"text":"Hi"`, `"text":" there"`, `"stop_reason":"end_turn"`, "event: message_stop")
+
+	_, body = postForBody(t, url, `{"model":"claude-sonnet-4","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"list"}],`+
+		`"tools":[{"name":"Bash","input_schema":{"type":"object"}}]}`)
+	assertContainsAll(t, body, `"type":"tool_use"`, `"name":"Bash"`, `"stop_reason":"tool_use"`)
+	var args strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		if i := strings.Index(line, `"partial_json":`); i >= 0 {
+			args.WriteString(line[i:])
+		}
+	}
+	if !strings.Contains(args.String(), `\"ls`) || !strings.Contains(args.String(), ` -la\"}`) {
+		t.Fatalf("expected split tool arguments streamed through, got %s", args.String())
+	}
+}
+
+func TestMockUpstream_OllamaNativeNDJSON(t *testing.T) {
+	srv, baseURL := startMockUpstream(t, mockupstream.NDJSONTextStream("qwen3:8b", "Hello", " over NDJSON."))
+	p, err := startResponsesCompatProxy(&config.Profile{Provider: config.ProviderOllama, OpenAIBaseURL: baseURL}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	_, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"qwen3:8b","stream":true,"input":"hi"}`)
+	assertContainsAll(t, body, `"output_text":"Hello over NDJSON."`, `"input_tokens":10`)
+	if reqs := srv.Requests(); len(reqs) != 1 || reqs[0].Path != "/api/chat" {
+		t.Fatalf("expected one native /api/chat request, got %+v", reqs)
+	}
+}
@@ -0,0 +1,270 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"spark/internal/config"
+)
+
+// compatModel is one entry of the model listing served by the compat proxies.
+type compatModel struct {
+	ID      string
+	Created int64
+	OwnedBy string
+}
+
+// modelCatalog merges the profile's configured models with the upstream
+// /models listing. The upstream is queried once, on first use, and the result
+// (including a failure) is kept for the lifetime of the proxy.
+type modelCatalog struct {
+	once    sync.Once
+	local   []string
+	fetch   func(ctx context.Context) ([]compatModel, error)
+	created int64
+	models  []compatModel
+	err     error
+}
+
+func newModelCatalog(local []string, fetch func(ctx context.Context) ([]compatModel, error)) *modelCatalog {
+	return &modelCatalog{
+		local:   dedupeModelIDs(local),
+		fetch:   fetch,
+		created: time.Now().Unix(),
+	}
+}
+
+// List returns profile models first, then upstream models not already listed.
+// The error reports why the upstream part is missing, if it is.
+func (c *modelCatalog) List() ([]compatModel, error) {
+	c.once.Do(func() {
+		var upstream []compatModel
+		if c.fetch != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			upstream, c.err = c.fetch(ctx)
+			cancel()
+		}
+		seen := map[string]struct{}{}
+		out := make([]compatModel, 0, len(c.local)+len(upstream))
+		upstreamByID := map[string]compatModel{}
+		for _, m := range upstream {
+			upstreamByID[m.ID] = m
+		}
+		for _, id := range c.local {
+			m, ok := upstreamByID[id]
+			if !ok {
+				m = compatModel{ID: id, Created: c.created, OwnedBy: "spark"}
+			}
+			seen[id] = struct{}{}
+			out = append(out, m)
+		}
+		for _, m := range upstream {
+			if _, ok := seen[m.ID]; ok {
+				continue
+			}
+			if m.Created == 0 {
+				m.Created = c.created
+			}
+			seen[m.ID] = struct{}{}
+			out = append(out, m)
+		}
+		c.models = out
+	})
+	return c.models, c.err
+}
+
+func (c *modelCatalog) Find(id string) (compatModel, bool) {
+	models, _ := c.List()
+	for _, m := range models {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return compatModel{}, false
+}
+
+// profileModelIDs lists the models a profile advertises, default model first.
+func profileModelIDs(profile *config.Profile) []string {
+	if profile == nil {
+		return nil
+	}
+	return dedupeModelIDs(append([]string{profile.DefaultModel}, profile.Models...))
+}
+
+func dedupeModelIDs(in []string) []string {
+	out := make([]string, 0, len(in))
+	seen := map[string]struct{}{}
+	for _, id := range in {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		out = append(out, id)
+	}
+	return out
+}
+
+// fetchOpenAIModels reads an OpenAI-style {"data":[{"id":...}]} listing.
+func fetchOpenAIModels(ctx context.Context, client *http.Client, upstreamBase, upstreamKey string) ([]compatModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamBase+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	if upstreamKey != "" {
+		req.Header.Set("Authorization", "Bearer "+upstreamKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("upstream /models status %d: %s", resp.StatusCode, truncateForLog(strings.TrimSpace(string(data)), 240))
+	}
+	var decoded struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+			OwnedBy string `json:"owned_by"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid upstream /models response: %w", err)
+	}
+	out := make([]compatModel, 0, len(decoded.Data))
+	for _, m := range decoded.Data {
+		if strings.TrimSpace(m.ID) == "" {
+			continue
+		}
+		out = append(out, compatModel{ID: m.ID, Created: m.Created, OwnedBy: m.OwnedBy})
+	}
+	return out, nil
+}
+
+// modelIDFromPath returns the {id} of /v1/models/{id}, or "" for the listing.
+func modelIDFromPath(path string) string {
+	_, id, ok := strings.Cut(strings.TrimPrefix(path, "/v1"), "/models/")
+	if !ok {
+		return ""
+	}
+	return strings.Trim(id, "/")
+}
+
+func (p *responsesCompatProxy) fetchUpstreamModels(ctx context.Context) ([]compatModel, error) {
+	return fetchOpenAIModels(ctx, p.client, p.upstreamBase, p.upstreamKey)
+}
+
+func (p *anthropicCompatProxy) fetchUpstreamModels(ctx context.Context) ([]compatModel, error) {
+	return fetchOpenAIModels(ctx, p.client, p.upstreamBase, p.upstreamKey)
+}
+
+func (p *responsesCompatProxy) handleModels(w http.ResponseWriter, r *http.Request) {
+	serveOpenAIModels(w, r, p.models, p.logf)
+}
+
+func (p *chatCompatProxy) handleModels(w http.ResponseWriter, r *http.Request) {
+	serveOpenAIModels(w, r, p.models, p.logf)
+}
+
+// serveOpenAIModels answers GET /v1/models and /v1/models/{id} in OpenAI format.
+func serveOpenAIModels(w http.ResponseWriter, r *http.Request, catalog *modelCatalog, logf func(format string, args ...any)) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	models, err := catalog.List()
+	if err != nil {
+		logf("upstream model listing unavailable, serving profile models only: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if id := modelIDFromPath(r.URL.Path); id != "" {
+		m, ok := catalog.Find(id)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "model not found: "+id)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(openAIModelObject(m))
+		return
+	}
+	data := make([]map[string]any, 0, len(models))
+	for _, m := range models {
+		data = append(data, openAIModelObject(m))
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func (p *anthropicCompatProxy) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAnthropicError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	models, err := p.models.List()
+	if err != nil {
+		p.logf("upstream model listing unavailable, serving profile models only: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if id := modelIDFromPath(r.URL.Path); id != "" {
+		m, ok := p.models.Find(id)
+		if !ok {
+			writeAnthropicError(w, http.StatusNotFound, "model not found: "+id)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(anthropicModelObject(m))
+		return
+	}
+	data := make([]map[string]any, 0, len(models))
+	for _, m := range models {
+		data = append(data, anthropicModelObject(m))
+	}
+	var firstID, lastID any
+	if len(models) > 0 {
+		firstID = models[0].ID
+		lastID = models[len(models)-1].ID
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"data":     data,
+		"has_more": false,
+		"first_id": firstID,
+		"last_id":  lastID,
+	})
+}
+
+func openAIModelObject(m compatModel) map[string]any {
+	ownedBy := m.OwnedBy
+	if ownedBy == "" {
+		ownedBy = "spark"
+	}
+	return map[string]any{
+		"id":       m.ID,
+		"object":   "model",
+		"created":  m.Created,
+		"owned_by": ownedBy,
+	}
+}
+
+func anthropicModelObject(m compatModel) map[string]any {
+	return map[string]any{
+		"type":         "model",
+		"id":           m.ID,
+		"display_name": m.ID,
+		"created_at":   time.Unix(m.Created, 0).UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,129 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"spark/internal/config"
+)
+
+func TestModelCatalog_MergesProfileAndUpstreamOnce(t *testing.T) {
+	var calls int32
+	c := newModelCatalog([]string{"glm-5", "", "glm-4.7", "glm-5"}, func(ctx context.Context) ([]compatModel, error) {
+		atomic.AddInt32(&calls, 1)
+		return []compatModel{{ID: "glm-4.7", Created: 42, OwnedBy: "zhipu"}, {ID: "glm-4.5-air"}}, nil
+	})
+	for i := 0; i < 3; i++ {
+		models, err := c.List()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids := make([]string, 0, len(models))
+		for _, m := range models {
+			ids = append(ids, m.ID)
+		}
+		if strings.Join(ids, ",") != "glm-5,glm-4.7,glm-4.5-air" {
+			t.Fatalf("unexpected model order: %v", ids)
+		}
+		if models[1].OwnedBy != "zhipu" || models[1].Created != 42 {
+			t.Fatalf("expected upstream metadata for profile model, got %#v", models[1])
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected upstream listing to be fetched once, got %d", calls)
+	}
+}
+
+func TestModelCatalog_UpstreamFailureKeepsProfileModels(t *testing.T) {
+	c := newModelCatalog([]string{"qwen3-coder"}, func(ctx context.Context) ([]compatModel, error) {
+		return nil, errors.New("connection refused")
+	})
+	models, err := c.List()
+	if err == nil {
+		t.Fatal("expected upstream error to be reported")
+	}
+	if len(models) != 1 || models[0].ID != "qwen3-coder" {
+		t.Fatalf("expected profile models, got %#v", models)
+	}
+}
+
+func TestCompatProxies_ServeModelListings(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer sk-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"gpt-4.1","created":1,"owned_by":"openai"}]}`))
+	}))
+	defer upstream.Close()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", t.TempDir()+"/anthropic.log")
+	profile := &config.Profile{
+		OpenAIBaseURL: upstream.URL,
+		OpenAIAPIKey:  "sk-test",
+		Models:        []string{"gpt-4.1-mini"},
+		DefaultModel:  "gpt-4.1",
+	}
+
+	rp, err := startResponsesCompatProxy(profile, true)
+	if err != nil {
+		t.Fatalf("start responses proxy: %v", err)
+	}
+	defer rp.Close()
+	var openAIList struct {
+		Object string           `json:"object"`
+		Data   []map[string]any `json:"data"`
+	}
+	getJSON(t, rp.BaseURL()+"/models", &openAIList)
+	if openAIList.Object != "list" || len(openAIList.Data) != 2 {
+		t.Fatalf("unexpected responses proxy listing: %#v", openAIList)
+	}
+	if openAIList.Data[0]["id"] != "gpt-4.1" || openAIList.Data[0]["owned_by"] != "openai" {
+		t.Fatalf("expected default model first with upstream metadata: %#v", openAIList.Data[0])
+	}
+
+	ap, err := startAnthropicCompatProxy(profile, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start anthropic proxy: %v", err)
+	}
+	defer ap.Close()
+	var anthropicList map[string]any
+	getJSON(t, ap.BaseURL()+"/v1/models", &anthropicList)
+	data, _ := anthropicList["data"].([]any)
+	if len(data) != 2 || anthropicList["first_id"] != "gpt-4.1" || anthropicList["has_more"] != false {
+		t.Fatalf("unexpected anthropic proxy listing: %#v", anthropicList)
+	}
+	if first := mapValue(data[0]); first["type"] != "model" || first["display_name"] != "gpt-4.1" {
+		t.Fatalf("unexpected anthropic model object: %#v", first)
+	}
+	var single map[string]any
+	getJSON(t, ap.BaseURL()+"/v1/models/gpt-4.1-mini", &single)
+	if single["id"] != "gpt-4.1-mini" {
+		t.Fatalf("unexpected single model: %#v", single)
+	}
+}
+
+func getJSON(t *testing.T, url string, out any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("GET %s: decode: %v", url, err)
+	}
+}
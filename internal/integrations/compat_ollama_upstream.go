@@ -0,0 +1,466 @@
+package integrations
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const ollamaDefaultBaseURL = "http://localhost:11434"
+
+// ollamaChatUpstream is a chatUpstream backed by Ollama's native /api/chat.
+// Unlike Ollama's OpenAI shim it honours num_ctx, keep_alive and think, and
+// it streams newline-delimited JSON rather than SSE.
+type ollamaChatUpstream struct {
+	client    *http.Client
+	baseURL   string
+	numCtx    int
+	keepAlive string
+	logf      func(format string, args ...any)
+}
+
+func (u *ollamaChatUpstream) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(u.logf)
+	ollamaReq, err := chatToOllamaRequest(chatReq, u.numCtx, u.keepAlive)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(ollamaReq)
+	if err != nil {
+		return nil, err
+	}
+	url := u.baseURL + "/api/chat"
+	upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	upReq.Header.Set("Content-Type", "application/json")
+	logf("upstream POST %s payload=%s", url, bodyForLog(string(body), 16*1024))
+	upResp, err := u.client.Do(upReq)
+	if err != nil {
+		return nil, err
+	}
+	logf("upstream status=%d", upResp.StatusCode)
+	if upResp.StatusCode >= 400 {
+		data, _ := io.ReadAll(upResp.Body)
+		_ = upResp.Body.Close()
+		logf("upstream error body=%s", bodyForLog(string(data), 16*1024))
+		return chatErrorResponse(upResp, ollamaErrorToChatError(upResp.StatusCode, data)), nil
+	}
+	model := stringValue(chatReq["model"])
+	if !boolValue(ollamaReq["stream"]) {
+		defer upResp.Body.Close()
+		var msg map[string]any
+		if err := json.NewDecoder(upResp.Body).Decode(&msg); err != nil {
+			return nil, fmt.Errorf("invalid upstream /api/chat response: %w", err)
+		}
+		return chatJSONResponse(http.StatusOK, ollamaResponseToChatCompletion(msg, model)), nil
+	}
+	includeUsage := boolValue(mapValue(chatReq["stream_options"])["include_usage"])
+	pr, pw := io.Pipe()
+	go func() {
+		defer upResp.Body.Close()
+		err := ollamaStreamToChatStream(pw, upResp.Body, model, includeUsage)
+		if err != nil {
+			logf("upstream stream conversion failed: %v", err)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       pr,
+	}, nil
+}
+
+// fetchModels reads the locally pulled models from /api/tags.
+func (u *ollamaChatUpstream) fetchModels(ctx context.Context) ([]compatModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("upstream /api/tags status %d: %s", resp.StatusCode, truncateForLog(strings.TrimSpace(string(data)), 240))
+	}
+	var decoded struct {
+		Models []struct {
+			Name       string `json:"name"`
+			ModifiedAt string `json:"modified_at"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid upstream /api/tags response: %w", err)
+	}
+	out := make([]compatModel, 0, len(decoded.Models))
+	for _, m := range decoded.Models {
+		if strings.TrimSpace(m.Name) == "" {
+			continue
+		}
+		var created int64
+		if t, err := time.Parse(time.RFC3339, m.ModifiedAt); err == nil {
+			created = t.Unix()
+		}
+		out = append(out, compatModel{ID: m.Name, Created: created, OwnedBy: "ollama"})
+	}
+	return out, nil
+}
+
+// ollamaNativeBaseURL strips the OpenAI shim's /v1 suffix so profiles created
+// for the shim (http://localhost:11434/v1) also work in native mode.
+func ollamaNativeBaseURL(base string) string {
+	base = strings.TrimRight(base, "/")
+	base = strings.TrimSuffix(base, "/v1")
+	return strings.TrimSuffix(base, "/api")
+}
+
+func ollamaErrorToChatError(status int, data []byte) map[string]any {
+	msg := strings.TrimSpace(string(data))
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err == nil {
+		if m := stringValue(decoded["error"]); m != "" {
+			msg = m
+		}
+	}
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	errType := "api_error"
+	if status < 500 {
+		errType = "invalid_request_error"
+	}
+	return map[string]any{
+		"error": map[string]any{
+			"message": msg,
+			"type":    errType,
+		},
+	}
+}
+
+// chatToOllamaRequest maps a chat/completions request to /api/chat. Ollama
+// takes tool call arguments as objects, images as bare base64 strings and
+// names the tool on each tool result instead of referencing the call ID.
+// numCtx and keepAlive are the profile's model-load options, if set.
+func chatToOllamaRequest(chatReq map[string]any, numCtx int, keepAlive string) (map[string]any, error) {
+	toolNames := map[string]string{}
+	messages := make([]map[string]any, 0)
+	for i, msg := range chatMessagesList(chatReq["messages"]) {
+		role := stringValue(msg["role"])
+		if role == "developer" {
+			role = "system"
+		}
+		text, images, err := chatContentToOllama(msg["content"])
+		if err != nil {
+			return nil, fmt.Errorf("messages.%d: %w", i, err)
+		}
+		out := map[string]any{"role": role, "content": text}
+		if len(images) > 0 {
+			out["images"] = images
+		}
+		switch role {
+		case "assistant":
+			if thinking := stringValue(msg["reasoning_content"]); thinking != "" {
+				out["thinking"] = thinking
+			}
+			calls := chatMessageToolCalls(msg["tool_calls"])
+			if len(calls) > 0 {
+				ollamaCalls := make([]any, 0, len(calls))
+				for _, call := range calls {
+					fn := mapValue(call["function"])
+					name := stringValue(fn["name"])
+					toolNames[stringValue(call["id"])] = name
+					args := map[string]any{}
+					if raw := strings.TrimSpace(stringValue(fn["arguments"])); raw != "" {
+						_ = json.Unmarshal([]byte(raw), &args)
+					}
+					ollamaCalls = append(ollamaCalls, map[string]any{"function": map[string]any{"name": name, "arguments": args}})
+				}
+				out["tool_calls"] = ollamaCalls
+			}
+		case "tool":
+			out["content"] = normalizeMessageContent(msg["content"])
+			if name := toolNames[stringValue(msg["tool_call_id"])]; name != "" {
+				out["tool_name"] = name
+			}
+		}
+		messages = append(messages, out)
+	}
+
+	out := map[string]any{
+		"model":    stringValue(chatReq["model"]),
+		"messages": messages,
+		"stream":   boolValue(chatReq["stream"]),
+	}
+	if keepAlive != "" {
+		out["keep_alive"] = keepAlive
+	}
+	options := map[string]any{}
+	if numCtx > 0 {
+		options["num_ctx"] = numCtx
+	}
+	if v, ok := chatReq["temperature"]; ok && v != nil {
+		options["temperature"] = v
+	}
+	if v, ok := chatReq["top_p"]; ok && v != nil {
+		options["top_p"] = v
+	}
+	if v, ok := chatReq["seed"]; ok && v != nil {
+		options["seed"] = v
+	}
+	maxTokens, ok := intValue(chatReq["max_completion_tokens"])
+	if !ok {
+		maxTokens, ok = intValue(chatReq["max_tokens"])
+	}
+	if ok && maxTokens > 0 {
+		options["num_predict"] = maxTokens
+	}
+	switch v := chatReq["stop"].(type) {
+	case string:
+		if v != "" {
+			options["stop"] = []string{v}
+		}
+	case []any:
+		if len(v) > 0 {
+			options["stop"] = v
+		}
+	}
+	if len(options) > 0 {
+		out["options"] = options
+	}
+	if tools := chatMessagesList(chatReq["tools"]); len(tools) > 0 {
+		out["tools"] = tools
+	}
+	format := mapValue(chatReq["response_format"])
+	switch stringValue(format["type"]) {
+	case "json_object":
+		out["format"] = "json"
+	case "json_schema":
+		if schema, ok := mapValue(format["json_schema"])["schema"]; ok {
+			out["format"] = schema
+		}
+	}
+	if think, ok := ollamaThinkForReasoningEffort(stringValue(chatReq["reasoning_effort"]), stringValue(chatReq["model"])); ok {
+		out["think"] = think
+	}
+	return out, nil
+}
+
+// ollamaThinkForReasoningEffort maps reasoning_effort to Ollama's think
+// control. Only gpt-oss accepts effort levels; other thinking models take a
+// boolean.
+func ollamaThinkForReasoningEffort(effort, model string) (any, bool) {
+	switch effort = strings.ToLower(strings.TrimSpace(effort)); effort {
+	case "":
+		return nil, false
+	case "none", "minimal":
+		return false, true
+	case "xhigh":
+		effort = "high"
+	}
+	if strings.Contains(strings.ToLower(model), "gpt-oss") {
+		return effort, true
+	}
+	return true, true
+}
+
+func chatContentToOllama(raw any) (string, []string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil, nil
+	case string:
+		return v, nil, nil
+	}
+	var text strings.Builder
+	var images []string
+	for _, part := range chatMessagesList(raw) {
+		switch stringValue(part["type"]) {
+		case "text":
+			text.WriteString(stringValue(part["text"]))
+		case "image_url":
+			url := stringValue(part["image_url"])
+			if url == "" {
+				url = stringValue(mapValue(part["image_url"])["url"])
+			}
+			source, err := anthropicImageSourceFromURL(url)
+			if err != nil {
+				return "", nil, err
+			}
+			if source["type"] != "base64" {
+				return "", nil, fmt.Errorf("ollama only accepts inline base64 images")
+			}
+			images = append(images, stringValue(source["data"]))
+		}
+	}
+	return text.String(), images, nil
+}
+
+func ollamaToolCalls(msg map[string]any, offset int) []map[string]any {
+	calls, _ := msg["tool_calls"].([]any)
+	out := make([]map[string]any, 0, len(calls))
+	for i, raw := range calls {
+		fn := mapValue(mapValue(raw)["function"])
+		args, err := json.Marshal(fn["arguments"])
+		if err != nil || string(args) == "null" {
+			args = []byte("{}")
+		}
+		out = append(out, map[string]any{
+			"id":   fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), offset+i),
+			"type": "function",
+			"function": map[string]any{
+				"name":      stringValue(fn["name"]),
+				"arguments": string(args),
+			},
+		})
+	}
+	return out
+}
+
+func ollamaDoneReasonToFinishReason(reason string, toolCalls bool) string {
+	if reason == "length" {
+		return "length"
+	}
+	if toolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+func chatUsageFromOllama(resp map[string]any) map[string]any {
+	prompt := intFromAny(resp["prompt_eval_count"])
+	completion := intFromAny(resp["eval_count"])
+	return map[string]any{
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+	}
+}
+
+func ollamaResponseToChatCompletion(resp map[string]any, requestedModel string) map[string]any {
+	msg := mapValue(resp["message"])
+	toolCalls := ollamaToolCalls(msg, 0)
+	message := map[string]any{
+		"role":    "assistant",
+		"content": nil,
+	}
+	if text := stringValue(msg["content"]); text != "" {
+		message["content"] = text
+	}
+	if thinking := stringValue(msg["thinking"]); thinking != "" {
+		message["reasoning_content"] = thinking
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+	model := stringValue(resp["model"])
+	if model == "" {
+		model = requestedModel
+	}
+	return map[string]any{
+		"id":      fmt.Sprintf("chatcmpl_%d", time.Now().UnixNano()),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []any{
+			map[string]any{
+				"index":         0,
+				"message":       message,
+				"finish_reason": ollamaDoneReasonToFinishReason(stringValue(resp["done_reason"]), len(toolCalls) > 0),
+			},
+		},
+		"usage": chatUsageFromOllama(resp),
+	}
+}
+
+// ollamaStreamToChatStream converts an /api/chat NDJSON stream into
+// chat/completions chunks. It returns an error when the stream ends before
+// the final "done" object, in which case no [DONE] marker is written.
+func ollamaStreamToChatStream(w io.Writer, upBody io.Reader, requestedModel string, includeUsage bool) error {
+	scanner := bufio.NewScanner(upBody)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+
+	id := fmt.Sprintf("chatcmpl_%d", time.Now().UnixNano())
+	model := requestedModel
+	created := time.Now().Unix()
+	toolCalls := 0
+
+	emit := func(delta map[string]any, finishReason any) {
+		writeSSE(w, map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []any{
+				map[string]any{
+					"index":         0,
+					"delta":         delta,
+					"finish_reason": finishReason,
+				},
+			},
+		})
+	}
+	emit(map[string]any{"role": "assistant", "content": ""}, nil)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			continue
+		}
+		if msg := stringValue(chunk["error"]); msg != "" {
+			writeSSE(w, map[string]any{"error": map[string]any{"message": msg, "type": "api_error"}})
+			return fmt.Errorf("upstream stream error: %s", truncateForLog(msg, 240))
+		}
+		if v := stringValue(chunk["model"]); v != "" {
+			model = v
+		}
+		msg := mapValue(chunk["message"])
+		if t := stringValue(msg["thinking"]); t != "" {
+			emit(map[string]any{"reasoning_content": t}, nil)
+		}
+		if t := stringValue(msg["content"]); t != "" {
+			emit(map[string]any{"content": t}, nil)
+		}
+		for _, call := range ollamaToolCalls(msg, toolCalls) {
+			call["index"] = toolCalls
+			toolCalls++
+			emit(map[string]any{"tool_calls": []any{call}}, nil)
+		}
+		if !boolValue(chunk["done"]) {
+			continue
+		}
+		emit(map[string]any{}, ollamaDoneReasonToFinishReason(stringValue(chunk["done_reason"]), toolCalls > 0))
+		if includeUsage {
+			writeSSE(w, map[string]any{
+				"id":      id,
+				"object":  "chat.completion.chunk",
+				"created": created,
+				"model":   model,
+				"choices": []any{},
+				"usage":   chatUsageFromOllama(chunk),
+			})
+		}
+		_, err := io.WriteString(w, "data: [DONE]\n\n")
+		return err
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
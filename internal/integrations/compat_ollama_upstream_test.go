@@ -0,0 +1,130 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"spark/internal/config"
+)
+
+func TestChatToOllamaRequest_MapsOptionsToolsAndImages(t *testing.T) {
+	out, err := chatToOllamaRequest(map[string]any{
+		"model":            "gpt-oss:20b",
+		"max_tokens":       256,
+		"reasoning_effort": "high",
+		"messages": []any{
+			map[string]any{"role": "developer", "content": "Be brief."},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "text", "text": "what is this?"},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,AAAA"}},
+			}},
+			map[string]any{"role": "assistant", "tool_calls": []any{
+				map[string]any{"id": "call_1", "type": "function", "function": map[string]any{"name": "read", "arguments": `{"path":"a"}`}},
+			}},
+			map[string]any{"role": "tool", "tool_call_id": "call_1", "content": "A"},
+		},
+	}, 32768, "30m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msgs := out["messages"].([]map[string]any)
+	if msgs[0]["role"] != "system" {
+		t.Fatalf("expected developer mapped to system: %#v", msgs[0])
+	}
+	if images, _ := msgs[1]["images"].([]string); len(images) != 1 || images[0] != "AAAA" {
+		t.Fatalf("unexpected images: %#v", msgs[1])
+	}
+	call := mapValue(msgs[2]["tool_calls"].([]any)[0])
+	if mapValue(mapValue(call["function"])["arguments"])["path"] != "a" {
+		t.Fatalf("expected object arguments: %#v", call)
+	}
+	if msgs[3]["tool_name"] != "read" || msgs[3]["content"] != "A" {
+		t.Fatalf("unexpected tool result: %#v", msgs[3])
+	}
+	options := mapValue(out["options"])
+	if options["num_ctx"] != 32768 || options["num_predict"] != 256 || out["keep_alive"] != "30m" {
+		t.Fatalf("unexpected options: %#v %#v", options, out["keep_alive"])
+	}
+	if out["think"] != "high" {
+		t.Fatalf("expected gpt-oss effort level, got %#v", out["think"])
+	}
+}
+
+func TestOllamaThinkForReasoningEffort(t *testing.T) {
+	if think, ok := ollamaThinkForReasoningEffort("medium", "qwen3:8b"); !ok || think != true {
+		t.Fatalf("expected boolean think for non gpt-oss models, got %#v", think)
+	}
+	if think, ok := ollamaThinkForReasoningEffort("minimal", "qwen3:8b"); !ok || think != false {
+		t.Fatalf("expected thinking disabled, got %#v", think)
+	}
+	if _, ok := ollamaThinkForReasoningEffort("", "qwen3:8b"); ok {
+		t.Fatalf("expected no think control without reasoning_effort")
+	}
+}
+
+func TestResponsesCompatProxy_OllamaNativeStream(t *testing.T) {
+	var upstreamReq map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = w.Write([]byte(`{"models":[{"name":"qwen3:8b","modified_at":"2025-05-01T10:00:00Z"}]}`))
+		case "/api/chat":
+			_ = json.NewDecoder(r.Body).Decode(&upstreamReq)
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			_, _ = io.WriteString(w, strings.Join([]string{
+				`{"model":"qwen3:8b","message":{"role":"assistant","content":"","thinking":"Need to read."},"done":false}`,
+				`{"model":"qwen3:8b","message":{"role":"assistant","content":"Reading."},"done":false}`,
+				`{"model":"qwen3:8b","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"read","arguments":{"path":"a"}}}]},"done":false}`,
+				`{"model":"qwen3:8b","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":30,"eval_count":12}`,
+				``,
+			}, "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	p, err := startResponsesCompatProxy(&config.Profile{
+		Provider:        config.ProviderOllama,
+		OpenAIBaseURL:   upstream.URL + "/v1",
+		OllamaNumCtx:    65536,
+		OllamaKeepAlive: "1h",
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	resp, err := http.Post(p.BaseURL()+"/responses", "application/json", strings.NewReader(`{"model":"qwen3:8b","stream":true,`+
+		`"reasoning":{"effort":"low"},"input":"read a","tools":[{"type":"function","name":"read","parameters":{"type":"object"}}]}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	body := string(data)
+	for _, want := range []string{
+		`"delta":"Need to read."`,
+		`"delta":"Reading."`,
+		`"name":"read"`,
+		`"arguments":"{\"path\":\"a\"}"`,
+		`"type":"response.completed"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %s in stream: %q", want, body)
+		}
+	}
+	if upstreamReq["think"] != true || upstreamReq["keep_alive"] != "1h" || intFromAny(mapValue(upstreamReq["options"])["num_ctx"]) != 65536 {
+		t.Fatalf("unexpected upstream request: %#v", upstreamReq)
+	}
+	var models map[string]any
+	getJSON(t, p.BaseURL()+"/models", &models)
+	if data, _ := models["data"].([]any); len(data) != 1 || mapValue(data[0])["id"] != "qwen3:8b" {
+		t.Fatalf("unexpected models listing: %#v", models)
+	}
+}
@@ -40,3 +40,20 @@ func executeTranslatedChat(
 	}
 	return chatReq, resp, nil
 }
+
+func executeTranslatedMessages(
+	ctx context.Context,
+	req map[string]any,
+	translator MessagesRequestTranslator,
+	executor MessagesExecutor,
+) (map[string]any, *http.Response, error) {
+	msgReq, err := translator.ToMessages(req)
+	if err != nil {
+		return nil, nil, pipelineError{stage: pipelineStageTranslate, err: err}
+	}
+	resp, err := executor.DoMessages(ctx, msgReq)
+	if err != nil {
+		return nil, nil, pipelineError{stage: pipelineStageExecute, err: err}
+	}
+	return msgReq, resp, nil
+}
@@ -0,0 +1,278 @@
+package integrations
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"spark/internal/config"
+	"spark/internal/tokenizer"
+)
+
+// tokenBucket refills perMinute units evenly over a minute and holds at
+// most a minute's worth, so an idle upstream allows one minute of burst.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	perSec   float64
+	last     time.Time
+}
+
+// newTokenBucket returns a full bucket, or nil when perMinute is unlimited.
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		perSec:   float64(perMinute) / 60,
+		last:     now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.capacity, b.tokens+elapsed*b.perSec)
+	}
+	b.last = now
+}
+
+// wait returns how long until n units are available. Requests larger than
+// the bucket only wait for a full one.
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	n = min(n, b.capacity)
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.perSec * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.refill(now)
+	b.tokens -= min(n, b.capacity)
+}
+
+// upstreamLimiter enforces a profile's rate limits. Requests wait in a FIFO
+// queue; the head is admitted once a concurrency slot is free and both
+// buckets cover it, so a large prompt is not starved by a stream of small
+// ones.
+type upstreamLimiter struct {
+	mu            sync.Mutex
+	requests      *tokenBucket
+	tokens        *tokenBucket
+	maxConcurrent int
+	inFlight      int
+	queue         []chan struct{}
+	now           func() time.Time
+}
+
+// newUpstreamLimiter returns the limiter for profile, or nil when it sets no
+// limits.
+func newUpstreamLimiter(profile *config.Profile) *upstreamLimiter {
+	if profile == nil || profile.Limits == nil {
+		return nil
+	}
+	limits := profile.Limits
+	if limits.RequestsPerMinute <= 0 && limits.TokensPerMinute <= 0 && limits.MaxConcurrent <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &upstreamLimiter{
+		requests:      newTokenBucket(limits.RequestsPerMinute, now),
+		tokens:        newTokenBucket(limits.TokensPerMinute, now),
+		maxConcurrent: limits.MaxConcurrent,
+		now:           time.Now,
+	}
+}
+
+// acquire waits for the request's turn. It returns the number of requests
+// that were queued ahead of it and a release function that frees the
+// concurrency slot.
+func (l *upstreamLimiter) acquire(ctx context.Context, cost int) (func(), int, error) {
+	wake := make(chan struct{}, 1)
+	l.mu.Lock()
+	depth := len(l.queue)
+	l.queue = append(l.queue, wake)
+	l.mu.Unlock()
+
+	for {
+		wait := time.Duration(-1)
+		l.mu.Lock()
+		if l.queue[0] == wake && (l.maxConcurrent <= 0 || l.inFlight < l.maxConcurrent) {
+			now := l.now()
+			wait = max(l.requests.wait(1, now), l.tokens.wait(float64(cost), now))
+			if wait == 0 {
+				l.requests.take(1, now)
+				l.tokens.take(float64(cost), now)
+				l.inFlight++
+				l.queue = l.queue[1:]
+				l.wakeHead()
+				l.mu.Unlock()
+				var once sync.Once
+				return func() { once.Do(l.release) }, depth, nil
+			}
+		}
+		l.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			l.leave(wake)
+			return nil, depth, ctx.Err()
+		case <-wake:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (l *upstreamLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.wakeHead()
+}
+
+// leave drops an abandoned waiter from the queue.
+func (l *upstreamLimiter) leave(wake chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.queue {
+		if w == wake {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			break
+		}
+	}
+	l.wakeHead()
+}
+
+// wakeHead nudges the first waiter to re-check admission. Callers hold mu.
+func (l *upstreamLimiter) wakeHead() {
+	if len(l.queue) == 0 {
+		return
+	}
+	select {
+	case l.queue[0] <- struct{}{}:
+	default:
+	}
+}
+
+// executor wraps next with the limiter. A nil limiter returns next
+// unchanged.
+func (l *upstreamLimiter) executor(next ChatExecutor, logf func(format string, args ...any)) ChatExecutor {
+	if l == nil {
+		return next
+	}
+	return limitedExecutor{limiter: l, next: next.Do, estimate: estimateChatPromptTokens, logf: logf}
+}
+
+// messagesExecutor is executor for Anthropic Messages upstreams.
+func (l *upstreamLimiter) messagesExecutor(next MessagesExecutor, logf func(format string, args ...any)) MessagesExecutor {
+	if l == nil {
+		return next
+	}
+	return limitedExecutor{limiter: l, next: next.DoMessages, estimate: estimateMessagesPromptTokens, logf: logf}
+}
+
+type limitedExecutor struct {
+	limiter  *upstreamLimiter
+	next     func(ctx context.Context, req map[string]any) (*http.Response, error)
+	estimate func(req map[string]any) int
+	logf     func(format string, args ...any)
+}
+
+func (e limitedExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	return e.send(ctx, chatReq)
+}
+
+func (e limitedExecutor) DoMessages(ctx context.Context, msgReq map[string]any) (*http.Response, error) {
+	return e.send(ctx, msgReq)
+}
+
+// send holds a concurrency slot until the response body is closed, so a
+// streaming answer counts as in flight for as long as it streams.
+func (e limitedExecutor) send(ctx context.Context, req map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(e.logf)
+	cost := e.estimate(req)
+	start := time.Now()
+	release, depth, err := e.limiter.acquire(ctx, cost)
+	if err != nil {
+		logf("rate limit wait abandoned est_tokens=%d queue_depth=%d waited=%s: %v", cost, depth, time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	logf("rate limit admitted est_tokens=%d queue_depth=%d waited=%s", cost, depth, time.Since(start).Round(time.Millisecond))
+	resp, err := e.next(ctx, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// estimateChatPromptTokens sizes a chat/completions request for the
+// tokens-per-minute bucket with the same counters as count_tokens.
+func estimateChatPromptTokens(chatReq map[string]any) int {
+	counter := tokenizer.ForModel(stringValue(chatReq["model"]))
+	total := anthropicTokensPerRequest
+	for _, msg := range chatMessagesList(chatReq["messages"]) {
+		total += anthropicTokensPerMessage + counter.Count(stringValue(msg["role"]))
+		// Parts arrive as decoded JSON or as the translators build them, so
+		// normalise both slice shapes like the messages themselves.
+		if parts := chatMessagesList(msg["content"]); parts != nil {
+			for _, part := range parts {
+				if stringValue(part["type"]) == "image_url" {
+					total += anthropicMaxImageTokens
+					continue
+				}
+				total += countAnthropicContentTokens(part, counter)
+			}
+		} else {
+			total += countAnthropicContentTokens(msg["content"], counter)
+		}
+		if calls, ok := msg["tool_calls"]; ok {
+			total += countJSONTokens(calls, counter)
+		}
+	}
+	if tools := chatMessagesList(chatReq["tools"]); len(tools) > 0 {
+		total += len(tools)*anthropicTokensPerTool + countJSONTokens(tools, counter)
+	}
+	return total
+}
+
+// estimateMessagesPromptTokens is estimateChatPromptTokens for an Anthropic
+// Messages request.
+func estimateMessagesPromptTokens(msgReq map[string]any) int {
+	return countAnthropicRequestTokens(msgReq, tokenizer.ForModel(stringValue(msgReq["model"])))
+}
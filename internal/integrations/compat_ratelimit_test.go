@@ -0,0 +1,168 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"spark/internal/config"
+)
+
+func TestTokenBucket_WaitAndRefill(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newTokenBucket(60, now)
+	if d := b.wait(60, now); d != 0 {
+		t.Fatalf("expected a full bucket, got wait %s", d)
+	}
+	b.take(60, now)
+	if d := b.wait(1, now); d != time.Second {
+		t.Fatalf("expected 1s for one unit at 60/min, got %s", d)
+	}
+	now = now.Add(30 * time.Second)
+	if d := b.wait(30, now); d != 0 {
+		t.Fatalf("expected 30 units after 30s, got wait %s", d)
+	}
+	if d := b.wait(500, now); d != 30*time.Second {
+		t.Fatalf("expected oversized requests to wait for a full bucket, got %s", d)
+	}
+	if newTokenBucket(0, now) != nil {
+		t.Fatalf("expected no bucket when unlimited")
+	}
+}
+
+func TestUpstreamLimiter_AdmitsInArrivalOrderWithinConcurrency(t *testing.T) {
+	l := newUpstreamLimiter(&config.Profile{Limits: &config.RateLimits{MaxConcurrent: 1}})
+	release, depth, err := l.acquire(t.Context(), 10)
+	if err != nil || depth != 0 {
+		t.Fatalf("first acquire: depth=%d err=%v", depth, err)
+	}
+
+	order := make(chan int, 2)
+	releases := make(chan func(), 2)
+	for i := 1; i <= 2; i++ {
+		go func() {
+			rel, _, err := l.acquire(context.Background(), 10)
+			if err != nil {
+				t.Errorf("acquire %d: %v", i, err)
+				return
+			}
+			order <- i
+			releases <- rel
+		}()
+		// Let each goroutine enqueue before the next one.
+		for {
+			l.mu.Lock()
+			n := len(l.queue)
+			l.mu.Unlock()
+			if n == i {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, _, err := l.acquire(cancelled, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled waiter to give up, got %v", err)
+	}
+
+	release()
+	release() // releasing twice must not free a second slot
+	if got := <-order; got != 1 {
+		t.Fatalf("expected the first waiter admitted first, got %d", got)
+	}
+	select {
+	case got := <-order:
+		t.Fatalf("waiter %d admitted beyond max_concurrent", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+	(<-releases)()
+	if got := <-order; got != 2 {
+		t.Fatalf("expected the second waiter next, got %d", got)
+	}
+	(<-releases)()
+}
+
+func TestLimitedExecutor_HoldsSlotUntilBodyClosed(t *testing.T) {
+	l := newUpstreamLimiter(&config.Profile{Limits: &config.RateLimits{MaxConcurrent: 1, TokensPerMinute: 100000}})
+	next := &scriptedExecutor{steps: []func() (*http.Response, error){statusStep(http.StatusOK, nil)}}
+	var logs []string
+	exec := l.executor(next, func(format string, args ...any) { logs = append(logs, format) })
+	resp, err := exec.Do(t.Context(), map[string]any{"model": "gpt-4o", "messages": []any{
+		map[string]any{"role": "user", "content": "hello there"},
+	}})
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if l.inFlight != 1 {
+		t.Fatalf("expected the slot held while the body is open, in flight=%d", l.inFlight)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if l.inFlight != 0 {
+		t.Fatalf("expected the slot released on close, in flight=%d", l.inFlight)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "queue_depth=") || !strings.Contains(logs[0], "waited=") {
+		t.Fatalf("expected queue depth and wait logged, got %v", logs)
+	}
+}
+
+func TestEstimateChatPromptTokens_CountsMessagesToolsAndImages(t *testing.T) {
+	base := estimateChatPromptTokens(map[string]any{"model": "gpt-4o", "messages": []any{
+		map[string]any{"role": "user", "content": "hello"},
+	}})
+	withImage := estimateChatPromptTokens(map[string]any{"model": "gpt-4o", "messages": []any{
+		map[string]any{"role": "user", "content": []any{
+			map[string]any{"type": "text", "text": "hello"},
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64," + strings.Repeat("A", 4096)}},
+		}},
+	}})
+	if withImage != base+anthropicMaxImageTokens {
+		t.Fatalf("expected image counted at the flat maximum, got %d vs base %d", withImage, base)
+	}
+	withTools := estimateChatPromptTokens(map[string]any{"model": "gpt-4o", "messages": []any{
+		map[string]any{"role": "user", "content": "hello"},
+	}, "tools": []any{map[string]any{"type": "function", "function": map[string]any{"name": "read"}}}})
+	if withTools <= base {
+		t.Fatalf("expected tools to add tokens, got %d vs %d", withTools, base)
+	}
+}
+
+func TestEstimateChatPromptTokens_CountsTranslatedClaudeRequest(t *testing.T) {
+	properties := map[string]any{}
+	for i := 0; i < 400; i++ {
+		properties[fmt.Sprintf("field_%d", i)] = map[string]any{"type": "string", "description": "A parameter the tool accepts."}
+	}
+	chatReq, err := anthropicToChatCompletions(map[string]any{
+		"model":      "claude-sonnet-4",
+		"max_tokens": 64,
+		"tools": []any{map[string]any{
+			"name":         "big_tool",
+			"description":  "A tool with a large schema.",
+			"input_schema": map[string]any{"type": "object", "properties": properties},
+		}},
+		"messages": []any{map[string]any{"role": "user", "content": []any{
+			map[string]any{"type": "text", "text": "what is in this screenshot?"},
+			map[string]any{"type": "image", "source": map[string]any{
+				"type": "base64", "media_type": "image/png", "data": strings.Repeat("A", 400*1024),
+			}},
+		}}},
+	})
+	if err != nil {
+		t.Fatalf("translate: %v", err)
+	}
+	withoutTools := map[string]any{"model": chatReq["model"], "messages": chatReq["messages"]}
+	toolTokens := estimateChatPromptTokens(chatReq) - estimateChatPromptTokens(withoutTools)
+	if toolTokens < 4000 {
+		t.Fatalf("expected the tool schema charged, got %d tokens", toolTokens)
+	}
+	if got := estimateChatPromptTokens(withoutTools); got > anthropicMaxImageTokens+100 {
+		t.Fatalf("expected the image charged at the flat maximum, got %d tokens", got)
+	}
+}
@@ -0,0 +1,207 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"spark/internal/config"
+)
+
+// Defaults for profiles without a retry block or with zero fields in it.
+var (
+	defaultRetryStatusCodes = []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 8 * time.Second
+	defaultRetryBudget         = 30 * time.Second
+)
+
+// retryPolicy retries an upstream request on connection errors and
+// retryable statuses, with jittered exponential backoff, honoring
+// Retry-After. Attempts stop when the next wait would overrun the budget.
+type retryPolicy struct {
+	maxAttempts    int
+	statuses       map[int]bool
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	budget         time.Duration
+	now            func() time.Time
+	sleep          func(ctx context.Context, d time.Duration) error
+	jitter         func(d time.Duration) time.Duration
+}
+
+// newRetryPolicy builds the retry policy for profile.
+func newRetryPolicy(profile *config.Profile) (*retryPolicy, error) {
+	p := &retryPolicy{
+		maxAttempts:    defaultRetryMaxAttempts,
+		initialBackoff: defaultRetryInitialBackoff,
+		maxBackoff:     defaultRetryMaxBackoff,
+		budget:         defaultRetryBudget,
+		now:            time.Now,
+		sleep:          sleepContext,
+		jitter:         equalJitter,
+	}
+	codes := defaultRetryStatusCodes
+	if profile != nil && profile.Retry != nil {
+		cfg := profile.Retry
+		if cfg.MaxAttempts > 0 {
+			p.maxAttempts = cfg.MaxAttempts
+		}
+		if len(cfg.StatusCodes) > 0 {
+			codes = cfg.StatusCodes
+		}
+		for _, d := range []struct {
+			name  string
+			value string
+			dst   *time.Duration
+		}{
+			{"initial_backoff", cfg.InitialBackoff, &p.initialBackoff},
+			{"max_backoff", cfg.MaxBackoff, &p.maxBackoff},
+			{"budget", cfg.Budget, &p.budget},
+		} {
+			if strings.TrimSpace(d.value) == "" {
+				continue
+			}
+			parsed, err := time.ParseDuration(strings.TrimSpace(d.value))
+			if err != nil || parsed < 0 {
+				return nil, fmt.Errorf("retry.%s: invalid duration %q", d.name, d.value)
+			}
+			*d.dst = parsed
+		}
+	}
+	p.statuses = make(map[int]bool, len(codes))
+	for _, code := range codes {
+		p.statuses[code] = true
+	}
+	return p, nil
+}
+
+// backoff returns the wait before attempt+1, after attempt failed.
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	d := p.initialBackoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return p.jitter(d)
+}
+
+// executor wraps next with the policy. A nil policy or a single attempt
+// returns next unchanged.
+func (p *retryPolicy) executor(next ChatExecutor, logf func(format string, args ...any)) ChatExecutor {
+	if p == nil || p.maxAttempts <= 1 {
+		return next
+	}
+	return retryExecutor{policy: p, next: next.Do, logf: logf}
+}
+
+// messagesExecutor is executor for Anthropic Messages upstreams.
+func (p *retryPolicy) messagesExecutor(next MessagesExecutor, logf func(format string, args ...any)) MessagesExecutor {
+	if p == nil || p.maxAttempts <= 1 {
+		return next
+	}
+	return retryExecutor{policy: p, next: next.DoMessages, logf: logf}
+}
+
+type retryExecutor struct {
+	policy *retryPolicy
+	next   func(ctx context.Context, req map[string]any) (*http.Response, error)
+	logf   func(format string, args ...any)
+}
+
+func (e retryExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	return e.send(ctx, chatReq)
+}
+
+func (e retryExecutor) DoMessages(ctx context.Context, msgReq map[string]any) (*http.Response, error) {
+	return e.send(ctx, msgReq)
+}
+
+// send sends req until it gets a non-retryable answer, runs out of attempts
+// or budget, or ctx is done. The last answer is returned as is, so callers
+// see the same error they would have without retries.
+func (e retryExecutor) send(ctx context.Context, req map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(e.logf)
+	p := e.policy
+	start := p.now()
+	for attempt := 1; ; attempt++ {
+		resp, err := e.next(ctx, req)
+		if err != nil && ctx.Err() != nil {
+			return nil, err
+		}
+		if err == nil && !p.statuses[resp.StatusCode] {
+			if attempt > 1 {
+				logf("upstream attempt %d/%d status=%d", attempt, p.maxAttempts, resp.StatusCode)
+			}
+			return resp, nil
+		}
+
+		wait := p.backoff(attempt)
+		outcome := ""
+		if err != nil {
+			outcome = "error=" + err.Error()
+		} else {
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), p.now()); ok {
+				wait = d
+			}
+			outcome = fmt.Sprintf("status=%d", resp.StatusCode)
+		}
+		if attempt >= p.maxAttempts {
+			logf("upstream attempt %d/%d %s; giving up", attempt, p.maxAttempts, outcome)
+			return resp, err
+		}
+		if p.now().Sub(start)+wait > p.budget {
+			logf("upstream attempt %d/%d %s; retry in %s exceeds budget %s, giving up", attempt, p.maxAttempts, outcome, wait, p.budget)
+			return resp, err
+		}
+		if resp != nil {
+			data, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			logf("upstream attempt %d/%d %s body=%s; retrying in %s", attempt, p.maxAttempts, outcome, bodyForLog(string(bytes.TrimSpace(data)), 240), wait)
+		} else {
+			logf("upstream attempt %d/%d %s; retrying in %s", attempt, p.maxAttempts, outcome, wait)
+		}
+		if err := p.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// equalJitter picks a wait uniformly between half of d and d, so concurrent
+// clients spread out without collapsing the backoff to zero.
+func equalJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
@@ -0,0 +1,179 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"spark/internal/config"
+)
+
+type scriptedExecutor struct {
+	steps []func() (*http.Response, error)
+	calls int
+}
+
+func (e *scriptedExecutor) Do(context.Context, map[string]any) (*http.Response, error) {
+	step := e.steps[e.calls]
+	e.calls++
+	return step()
+}
+
+func statusStep(status int, header http.Header) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader("body"))}, nil
+	}
+}
+
+func testRetryPolicy(t *testing.T, cfg *config.RetryPolicy) (*retryPolicy, *[]time.Duration) {
+	t.Helper()
+	p, err := newRetryPolicy(&config.Profile{Retry: cfg})
+	if err != nil {
+		t.Fatalf("newRetryPolicy: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	var slept []time.Duration
+	p.now = func() time.Time { return now }
+	p.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+	p.jitter = func(d time.Duration) time.Duration { return d }
+	return p, &slept
+}
+
+func TestRetryExecutor_BacksOffAndHonorsRetryAfter(t *testing.T) {
+	p, slept := testRetryPolicy(t, &config.RetryPolicy{MaxAttempts: 4, InitialBackoff: "100ms"})
+	next := &scriptedExecutor{steps: []func() (*http.Response, error){
+		statusStep(http.StatusBadGateway, nil),
+		func() (*http.Response, error) { return nil, errors.New("connection reset by peer") },
+		statusStep(http.StatusTooManyRequests, http.Header{"Retry-After": {"2"}}),
+		statusStep(http.StatusOK, nil),
+	}}
+	var logs []string
+	resp, err := p.executor(next, func(format string, args ...any) { logs = append(logs, format) }).Do(t.Context(), map[string]any{})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected success after retries, got %v %v", resp, err)
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 2 * time.Second}
+	if len(*slept) != len(want) {
+		t.Fatalf("unexpected waits %v", *slept)
+	}
+	for i := range want {
+		if (*slept)[i] != want[i] {
+			t.Fatalf("unexpected waits %v, want %v", *slept, want)
+		}
+	}
+	if len(logs) != 4 {
+		t.Fatalf("expected every attempt logged, got %d lines", len(logs))
+	}
+}
+
+func TestRetryExecutor_StopsOnNonRetryableAttemptsAndBudget(t *testing.T) {
+	p, _ := testRetryPolicy(t, nil)
+	next := &scriptedExecutor{steps: []func() (*http.Response, error){statusStep(http.StatusBadRequest, nil)}}
+	if resp, _ := p.executor(next, func(string, ...any) {}).Do(t.Context(), nil); resp.StatusCode != http.StatusBadRequest || next.calls != 1 {
+		t.Fatalf("expected 400 returned without retry, got %d after %d calls", resp.StatusCode, next.calls)
+	}
+
+	next = &scriptedExecutor{steps: []func() (*http.Response, error){
+		statusStep(http.StatusServiceUnavailable, nil),
+		statusStep(http.StatusServiceUnavailable, nil),
+		statusStep(http.StatusServiceUnavailable, nil),
+	}}
+	resp, err := p.executor(next, func(string, ...any) {}).Do(t.Context(), nil)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || next.calls != defaultRetryMaxAttempts {
+		t.Fatalf("expected last 503 after %d attempts, got %v %v after %d calls", defaultRetryMaxAttempts, resp, err, next.calls)
+	}
+	if data, _ := io.ReadAll(resp.Body); string(data) != "body" {
+		t.Fatalf("expected the final body intact, got %q", data)
+	}
+
+	p, _ = testRetryPolicy(t, &config.RetryPolicy{Budget: "5s"})
+	next = &scriptedExecutor{steps: []func() (*http.Response, error){
+		statusStep(http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}}),
+	}}
+	if resp, _ := p.executor(next, func(string, ...any) {}).Do(t.Context(), nil); resp.StatusCode != http.StatusTooManyRequests || next.calls != 1 {
+		t.Fatalf("expected Retry-After beyond the budget to stop retries, got %d after %d calls", resp.StatusCode, next.calls)
+	}
+}
+
+func TestNewRetryPolicy_RejectsBadDurations(t *testing.T) {
+	if _, err := newRetryPolicy(&config.Profile{Retry: &config.RetryPolicy{MaxBackoff: "soon"}}); err == nil || !strings.Contains(err.Error(), "retry.max_backoff") {
+		t.Fatalf("expected max_backoff error, got %v", err)
+	}
+}
+
+func TestResponsesCompatProxy_RetriesTransientUpstreamErrors(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":"recovered"},"finish_reason":"stop"}],`+
+			`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`)
+	}))
+	defer upstream.Close()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	p, err := startResponsesCompatProxy(&config.Profile{
+		OpenAIBaseURL: upstream.URL,
+		Retry:         &config.RetryPolicy{InitialBackoff: "1ms"},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	resp, err := http.Post(p.BaseURL()+"/responses", "application/json", strings.NewReader(`{"model":"m","input":"hi"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(data), "recovered") || hits.Load() != 2 {
+		t.Fatalf("expected retried success, got %d %s after %d hits", resp.StatusCode, data, hits.Load())
+	}
+}
+
+func TestResponsesCompatProxy_RetriesTransientMessagesUpstreamErrors(t *testing.T) {
+	var hits atomic.Int32
+	upstream := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		if hits.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4",`+
+			`"content":[{"type":"text","text":"recovered"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+	})
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	p, err := startResponsesCompatProxy(&config.Profile{
+		AnthropicBaseURL:   upstream.URL,
+		AnthropicAuthToken: "sk-ant",
+		Retry:              &config.RetryPolicy{InitialBackoff: "1ms"},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	status, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"claude-sonnet-4","input":"hi"}`)
+	if status != http.StatusOK || !strings.Contains(body, "recovered") || hits.Load() != 2 {
+		t.Fatalf("expected retried success, got %d %s after %d hits", status, body, hits.Load())
+	}
+}
@@ -0,0 +1,175 @@
+package integrations
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// upstreamErrorClass buckets an upstream failure so each adapter can report
+// it with the error type its agent acts on: retrying transient failures and
+// giving up on the rest.
+type upstreamErrorClass int
+
+const (
+	upstreamErrorServer upstreamErrorClass = iota
+	upstreamErrorRateLimit
+	upstreamErrorOverloaded
+	upstreamErrorQuota
+	upstreamErrorContextLength
+	upstreamErrorAuthentication
+	upstreamErrorPermission
+	upstreamErrorInvalidRequest
+)
+
+// classifyUpstreamError buckets an upstream error from its HTTP status (0
+// when there is none, as for an error sent mid-stream) and its error object
+// in any of the OpenAI, Anthropic, Gemini or vLLM shapes.
+func classifyUpstreamError(status int, errObj map[string]any) upstreamErrorClass {
+	if status == 0 {
+		status = intFromAny(errObj["code"])
+	}
+	if status == 0 {
+		status, _ = strconv.Atoi(stringValue(errObj["code"]))
+	}
+	code := strings.ToLower(strings.Join([]string{
+		stringValue(errObj["code"]),
+		stringValue(errObj["type"]),
+		stringValue(errObj["status"]),
+	}, " "))
+	switch {
+	case strings.Contains(code, "context_length") || isContextLengthMessage(stringValue(errObj["message"])):
+		return upstreamErrorContextLength
+	case strings.Contains(code, "insufficient_quota"):
+		return upstreamErrorQuota
+	case status == 429 || strings.Contains(code, "rate_limit") || strings.Contains(code, "resource_exhausted"):
+		return upstreamErrorRateLimit
+	case status == 503 || status == 529 || strings.Contains(code, "overloaded") || strings.Contains(code, "unavailable"):
+		return upstreamErrorOverloaded
+	case status == 401 || strings.Contains(code, "authentication") || strings.Contains(code, "unauthenticated") ||
+		strings.Contains(code, "invalid_api_key"):
+		return upstreamErrorAuthentication
+	case status == 403 || strings.Contains(code, "permission"):
+		return upstreamErrorPermission
+	case (status >= 400 && status < 500) || strings.Contains(code, "invalid_request") || strings.Contains(code, "badrequest"):
+		return upstreamErrorInvalidRequest
+	default:
+		return upstreamErrorServer
+	}
+}
+
+// isContextLengthMessage reports whether an upstream error message says the
+// prompt does not fit the model's context window.
+func isContextLengthMessage(message string) bool {
+	message = strings.ToLower(message)
+	for _, needle := range []string{
+		"context length", "context_length", "maximum context", "context window",
+		"prompt is too long", "too many tokens", "reduce the length",
+	} {
+		if strings.Contains(message, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// anthropicType is the Anthropic error.type for c.
+func (c upstreamErrorClass) anthropicType() string {
+	switch c {
+	case upstreamErrorRateLimit:
+		return "rate_limit_error"
+	case upstreamErrorOverloaded:
+		return "overloaded_error"
+	case upstreamErrorAuthentication:
+		return "authentication_error"
+	case upstreamErrorPermission:
+		return "permission_error"
+	case upstreamErrorQuota, upstreamErrorContextLength, upstreamErrorInvalidRequest:
+		return "invalid_request_error"
+	default:
+		return "api_error"
+	}
+}
+
+// openAIType is the OpenAI error.type for c. Rate limits have none: OpenAI
+// names the limit that was hit (requests or tokens), which c does not know.
+func (c upstreamErrorClass) openAIType() string {
+	switch c {
+	case upstreamErrorRateLimit:
+		return ""
+	case upstreamErrorQuota:
+		return "insufficient_quota"
+	case upstreamErrorContextLength, upstreamErrorAuthentication, upstreamErrorPermission, upstreamErrorInvalidRequest:
+		return "invalid_request_error"
+	default:
+		return "server_error"
+	}
+}
+
+// responsesCode is the Responses error.code for c. Codex gives up on
+// context_length_exceeded and insufficient_quota and retries the others.
+func (c upstreamErrorClass) responsesCode() string {
+	switch c {
+	case upstreamErrorRateLimit:
+		return "rate_limit_exceeded"
+	case upstreamErrorOverloaded:
+		return "server_is_overloaded"
+	case upstreamErrorQuota:
+		return "insufficient_quota"
+	case upstreamErrorContextLength:
+		return "context_length_exceeded"
+	case upstreamErrorAuthentication:
+		return "invalid_api_key"
+	case upstreamErrorPermission:
+		return "permission_denied"
+	case upstreamErrorInvalidRequest:
+		return "invalid_request_error"
+	default:
+		return "server_error"
+	}
+}
+
+// streamFailure is why an upstream stream could not be finished.
+type streamFailure struct {
+	class   upstreamErrorClass
+	message string
+}
+
+// streamErrorChunk returns the failure an upstream chunk reports, or nil
+// for an ordinary chunk. Gateways send {"error": {...}}; vLLM sends an
+// object of type "error".
+func streamErrorChunk(chunk map[string]any) *streamFailure {
+	errObj := mapValue(chunk["error"])
+	if len(errObj) == 0 {
+		if stringValue(chunk["object"]) != "error" {
+			return nil
+		}
+		errObj = chunk
+	}
+	message := stringValue(errObj["message"])
+	if message == "" {
+		message = "upstream stream error"
+	}
+	return &streamFailure{class: classifyUpstreamError(0, errObj), message: message}
+}
+
+// streamReadFailure describes an error reading the upstream stream.
+func streamReadFailure(err error) *streamFailure {
+	var timeout *streamTimeoutError
+	switch {
+	case errors.As(err, &timeout):
+		return &streamFailure{class: upstreamErrorServer, message: timeout.Error()}
+	case errors.Is(err, bufio.ErrTooLong):
+		return &streamFailure{class: upstreamErrorServer, message: "upstream stream line exceeds 2 MiB"}
+	default:
+		return &streamFailure{class: upstreamErrorServer, message: fmt.Sprintf("upstream stream broke: %v", err)}
+	}
+}
+
+// prematureStreamEnd is the failure of a stream that ended cleanly but
+// without [DONE] or a finish reason.
+func prematureStreamEnd() *streamFailure {
+	return &streamFailure{class: upstreamErrorServer, message: "upstream stream ended before the response finished"}
+}
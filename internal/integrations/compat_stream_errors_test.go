@@ -0,0 +1,117 @@
+package integrations
+
+import (
+	"strings"
+	"testing"
+
+	"spark/internal/config"
+	"spark/internal/mockupstream"
+)
+
+func TestClassifyUpstreamError(t *testing.T) {
+	cases := []struct {
+		status    int
+		errObj    map[string]any
+		anthropic string
+		responses string
+	}{
+		{429, map[string]any{"message": "slow down"}, "rate_limit_error", "rate_limit_exceeded"},
+		{0, map[string]any{"code": float64(429)}, "rate_limit_error", "rate_limit_exceeded"},
+		{0, map[string]any{"code": "503"}, "overloaded_error", "server_is_overloaded"},
+		{529, nil, "overloaded_error", "server_is_overloaded"},
+		{0, map[string]any{"type": "overloaded_error"}, "overloaded_error", "server_is_overloaded"},
+		{0, map[string]any{"status": "RESOURCE_EXHAUSTED"}, "rate_limit_error", "rate_limit_exceeded"},
+		{429, map[string]any{"code": "insufficient_quota"}, "invalid_request_error", "insufficient_quota"},
+		{400, map[string]any{"message": "This model's maximum context length is 8192 tokens"}, "invalid_request_error", "context_length_exceeded"},
+		{401, nil, "authentication_error", "invalid_api_key"},
+		{403, nil, "permission_error", "permission_denied"},
+		{422, nil, "invalid_request_error", "invalid_request_error"},
+		{0, map[string]any{"message": "boom"}, "api_error", "server_error"},
+		{500, nil, "api_error", "server_error"},
+	}
+	for _, tc := range cases {
+		class := classifyUpstreamError(tc.status, tc.errObj)
+		if class.anthropicType() != tc.anthropic || class.responsesCode() != tc.responses {
+			t.Errorf("classify(%d, %v) = %s/%s, want %s/%s", tc.status, tc.errObj,
+				class.anthropicType(), class.responsesCode(), tc.anthropic, tc.responses)
+		}
+	}
+}
+
+func TestStreamErrorChunk(t *testing.T) {
+	if f := streamErrorChunk(map[string]any{"choices": []any{}}); f != nil {
+		t.Fatalf("expected an ordinary chunk ignored, got %+v", f)
+	}
+	f := streamErrorChunk(map[string]any{"object": "error", "message": "engine died", "code": float64(500)})
+	if f == nil || f.message != "engine died" || f.class != upstreamErrorServer {
+		t.Fatalf("unexpected vLLM error chunk failure %+v", f)
+	}
+}
+
+func TestStreamErrors_ResponsesFailsOnBrokenStreams(t *testing.T) {
+	errorChunk := mockupstream.TextStream("gpt-4.1", "partial")
+	errorChunk.Chunks = []string{
+		errorChunk.Chunks[0],
+		`data: {"error":{"message":"prompt is too long for this model","type":"invalid_request_error"}}` + "\n\n",
+	}
+	longLine := mockupstream.TextStream("gpt-4.1", "partial")
+	longLine.Chunks = []string{longLine.Chunks[0], "data: " + strings.Repeat("x", 3<<20) + "\n\n"}
+	_, baseURL := startMockUpstream(t,
+		mockupstream.DisconnectStream("gpt-4.1", "partial"),
+		errorChunk,
+		longLine,
+		mockupstream.MalformedJSON("gpt-4.1"),
+	)
+	p, err := startResponsesCompatProxy(&config.Profile{OpenAIBaseURL: baseURL}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	url := p.BaseURL() + "/responses"
+	req := `{"model":"gpt-4.1","stream":true,"input":"hi"}`
+
+	for _, want := range [][]string{
+		{`"code":"server_error"`, "upstream stream"},
+		{`"code":"context_length_exceeded"`, "prompt is too long for this model"},
+		{`"code":"server_error"`, "upstream stream line exceeds 2 MiB"},
+	} {
+		_, body := postForBody(t, url, req)
+		assertContainsAll(t, body, append([]string{`"delta":"partial"`, `"type":"response.failed"`, `"status":"failed"`}, want...)...)
+		if strings.Contains(body, "response.completed") {
+			t.Fatalf("expected no completed event after a failure:\n%s", body)
+		}
+	}
+
+	_, body := postForBody(t, url, req)
+	assertContainsAll(t, body, `"type":"response.completed"`, `"output_text":"Hello"`)
+	if strings.Contains(body, "response.failed") {
+		t.Fatalf("expected a skipped malformed chunk not to fail the response:\n%s", body)
+	}
+}
+
+func TestStreamErrors_AnthropicSendsTypedErrorEvents(t *testing.T) {
+	rateLimited := mockupstream.TextStream("gpt-4.1", "partial")
+	rateLimited.Chunks = []string{
+		rateLimited.Chunks[0],
+		`data: {"error":{"message":"Rate limit reached","code":"rate_limit_exceeded"}}` + "\n\n",
+	}
+	_, baseURL := startMockUpstream(t, rateLimited, mockupstream.DisconnectStream("gpt-4.1", "partial"))
+	p, err := startAnthropicCompatProxy(&config.Profile{OpenAIBaseURL: baseURL}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	url := p.BaseURL() + "/v1/messages"
+	req := `{"model":"claude-sonnet-4","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+
+	for _, want := range [][]string{
+		{`"type":"rate_limit_error"`, "Rate limit reached"},
+		{`"type":"api_error"`, "upstream stream"},
+	} {
+		_, body := postForBody(t, url, req)
+		assertContainsAll(t, body, append([]string{`"text":"partial"`, "event: error"}, want...)...)
+		if strings.Contains(body, "message_stop") {
+			t.Fatalf("expected no message_stop after a failure:\n%s", body)
+		}
+	}
+}
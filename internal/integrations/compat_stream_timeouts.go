@@ -0,0 +1,194 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"spark/internal/config"
+)
+
+// Defaults for profiles without a timeouts block. Local models can take
+// minutes to load and prefill a long prompt, so the first byte gets longer.
+var (
+	defaultStreamFirstByteTimeout = 5 * time.Minute
+	defaultStreamIdleTimeout      = 2 * time.Minute
+)
+
+// streamTimeouts aborts an upstream request that sends nothing for too long,
+// instead of leaving the agent waiting on a stalled stream or answer.
+type streamTimeouts struct {
+	firstByte time.Duration
+	idle      time.Duration
+}
+
+// newStreamTimeouts builds the stream timeouts for profile.
+func newStreamTimeouts(profile *config.Profile) (*streamTimeouts, error) {
+	t := &streamTimeouts{firstByte: defaultStreamFirstByteTimeout, idle: defaultStreamIdleTimeout}
+	if profile == nil || profile.Timeouts == nil {
+		return t, nil
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"first_byte", profile.Timeouts.FirstByte, &t.firstByte},
+		{"idle", profile.Timeouts.Idle, &t.idle},
+	} {
+		if strings.TrimSpace(d.value) == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(strings.TrimSpace(d.value))
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("timeouts.%s: invalid duration %q", d.name, d.value)
+		}
+		*d.dst = parsed
+	}
+	return t, nil
+}
+
+// streamTimeoutError reports which wait ran out. Stream adapters turn it
+// into a protocol error event for the agent.
+type streamTimeoutError struct {
+	firstByte bool
+	after     time.Duration
+}
+
+func (e *streamTimeoutError) Error() string {
+	if e.firstByte {
+		return fmt.Sprintf("upstream sent no data within %s", e.after)
+	}
+	return fmt.Sprintf("upstream stream idle for %s", e.after)
+}
+
+// executor wraps one upstream so each attempt gets its own deadlines. It
+// returns next unchanged when t is nil.
+func (t *streamTimeouts) executor(next ChatExecutor) ChatExecutor {
+	if t == nil {
+		return next
+	}
+	return timeoutExecutor{next: next.Do, timeouts: t}
+}
+
+// messagesExecutor is executor for Anthropic Messages upstreams.
+func (t *streamTimeouts) messagesExecutor(next MessagesExecutor) MessagesExecutor {
+	if t == nil {
+		return next
+	}
+	return timeoutExecutor{next: next.DoMessages, timeouts: t}
+}
+
+type timeoutExecutor struct {
+	next     func(ctx context.Context, req map[string]any) (*http.Response, error)
+	timeouts *streamTimeouts
+}
+
+func (e timeoutExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	return e.send(ctx, chatReq)
+}
+
+func (e timeoutExecutor) DoMessages(ctx context.Context, msgReq map[string]any) (*http.Response, error) {
+	return e.send(ctx, msgReq)
+}
+
+// send runs req under a watchdog. Non-streaming requests get one too: their
+// answer arrives in one piece once complete, so for them the first-byte
+// timeout bounds the whole generation.
+func (e timeoutExecutor) send(ctx context.Context, req map[string]any) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	wd := newStreamWatchdog(e.timeouts, cancel)
+	resp, err := e.next(ctx, req)
+	if err != nil {
+		wd.stop()
+		if expired := wd.expired(); expired != nil {
+			return nil, expired
+		}
+		return nil, err
+	}
+	resp.Body = &watchdogBody{ReadCloser: resp.Body, wd: wd}
+	return resp, nil
+}
+
+// streamWatchdog cancels a request when its timer runs out. The timer
+// starts at the first-byte timeout and switches to the idle timeout once
+// data flows.
+type streamWatchdog struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	idle    time.Duration
+	window  time.Duration
+	gotData bool
+	err     *streamTimeoutError
+	cancel  context.CancelFunc
+}
+
+func newStreamWatchdog(t *streamTimeouts, cancel context.CancelFunc) *streamWatchdog {
+	wd := &streamWatchdog{idle: t.idle, window: t.firstByte, cancel: cancel}
+	wd.timer = time.AfterFunc(t.firstByte, wd.fire)
+	return wd
+}
+
+func (wd *streamWatchdog) fire() {
+	wd.mu.Lock()
+	if wd.err == nil {
+		wd.err = &streamTimeoutError{firstByte: !wd.gotData, after: wd.window}
+	}
+	wd.mu.Unlock()
+	wd.cancel()
+}
+
+// touch restarts the idle timer after data arrived.
+func (wd *streamWatchdog) touch() {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if wd.err != nil {
+		return
+	}
+	wd.gotData = true
+	wd.window = wd.idle
+	wd.timer.Reset(wd.idle)
+}
+
+func (wd *streamWatchdog) expired() error {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if wd.err == nil {
+		return nil
+	}
+	return wd.err
+}
+
+func (wd *streamWatchdog) stop() {
+	wd.timer.Stop()
+	wd.cancel()
+}
+
+// watchdogBody feeds reads to the watchdog and reports its timeout in place
+// of the cancellation error the aborted read returns.
+type watchdogBody struct {
+	io.ReadCloser
+	wd *streamWatchdog
+}
+
+func (b *watchdogBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.wd.touch()
+	}
+	if err != nil && err != io.EOF {
+		if expired := b.wd.expired(); expired != nil {
+			return n, expired
+		}
+	}
+	return n, err
+}
+
+func (b *watchdogBody) Close() error {
+	b.wd.stop()
+	return b.ReadCloser.Close()
+}
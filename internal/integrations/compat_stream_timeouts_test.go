@@ -0,0 +1,243 @@
+package integrations
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"spark/internal/config"
+	"spark/internal/mockupstream"
+)
+
+// stallingUpstream answers every chat or Messages request with prefix,
+// flushed, and then hangs until the request is canceled, which it reports on
+// the returned channel. With beforeHeaders it hangs without sending headers.
+func stallingUpstream(t *testing.T, prefix string, beforeHeaders bool) (string, <-chan struct{}) {
+	t.Helper()
+	canceled := make(chan struct{}, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/chat/completions") && !strings.HasSuffix(r.URL.Path, "/messages") {
+			http.NotFound(w, r)
+			return
+		}
+		// The server only notices a closed connection once the body is read.
+		_, _ = io.Copy(io.Discard, r.Body)
+		if !beforeHeaders {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(prefix))
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+		canceled <- struct{}{}
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_ANTHROPIC_COMPAT_LOG", t.TempDir()+"/claude.log")
+	t.Setenv("AGENT_LAUNCH_CHAT_COMPAT_LOG", t.TempDir()+"/chat.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	return ts.URL + "/v1", canceled
+}
+
+func waitCanceled(t *testing.T, canceled <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("upstream request was not canceled")
+	}
+}
+
+func TestNewStreamTimeouts(t *testing.T) {
+	got, err := newStreamTimeouts(&config.Profile{})
+	if err != nil || got.firstByte != defaultStreamFirstByteTimeout || got.idle != defaultStreamIdleTimeout {
+		t.Fatalf("unexpected defaults %+v %v", got, err)
+	}
+	got, err = newStreamTimeouts(&config.Profile{Timeouts: &config.StreamTimeouts{FirstByte: "90s", Idle: " 15s "}})
+	if err != nil || got.firstByte != 90*time.Second || got.idle != 15*time.Second {
+		t.Fatalf("unexpected timeouts %+v %v", got, err)
+	}
+	if _, err := newStreamTimeouts(&config.Profile{Timeouts: &config.StreamTimeouts{Idle: "0s"}}); err == nil ||
+		!strings.Contains(err.Error(), "timeouts.idle") {
+		t.Fatalf("expected zero idle timeout rejected, got %v", err)
+	}
+}
+
+func TestStreamTimeouts_ResponsesIdleTimeoutFailsResponse(t *testing.T) {
+	baseURL, canceled := stallingUpstream(t, mockupstream.TextStream("gpt-4.1", "partial").Chunks[0], false)
+	p, err := startResponsesCompatProxy(&config.Profile{
+		OpenAIBaseURL: baseURL,
+		Timeouts:      &config.StreamTimeouts{Idle: "50ms"},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	_, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"gpt-4.1","stream":true,"input":"hi"}`)
+	assertContainsAll(t, body, `"delta":"partial"`, `"type":"response.failed"`, `"status":"failed"`, `"code":"server_error"`, "upstream stream idle for 50ms")
+	if strings.Contains(body, "response.completed") {
+		t.Fatalf("expected no completed event after a timeout:\n%s", body)
+	}
+	waitCanceled(t, canceled)
+}
+
+func TestStreamTimeouts_FirstByteTimeoutBeforeHeaders(t *testing.T) {
+	baseURL, canceled := stallingUpstream(t, "", true)
+	p, err := startResponsesCompatProxy(&config.Profile{
+		OpenAIBaseURL: baseURL,
+		Timeouts:      &config.StreamTimeouts{FirstByte: "50ms"},
+		Retry:         &config.RetryPolicy{MaxAttempts: 1},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	status, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"gpt-4.1","stream":true,"input":"hi"}`)
+	if status < 500 || !strings.Contains(body, "upstream sent no data within 50ms") {
+		t.Fatalf("expected a first-byte timeout error, got %d %s", status, body)
+	}
+	waitCanceled(t, canceled)
+}
+
+func TestStreamTimeouts_ResponsesMessagesUpstreamFirstByteTimeout(t *testing.T) {
+	baseURL, canceled := stallingUpstream(t, "", true)
+	p, err := startResponsesCompatProxy(&config.Profile{
+		AnthropicBaseURL:   baseURL,
+		AnthropicAuthToken: "sk-ant",
+		Timeouts:           &config.StreamTimeouts{FirstByte: "50ms"},
+		Retry:              &config.RetryPolicy{MaxAttempts: 1},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	status, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"claude-sonnet-4","stream":true,"input":"hi"}`)
+	if status < 500 || !strings.Contains(body, "upstream sent no data within 50ms") {
+		t.Fatalf("expected a first-byte timeout error, got %d %s", status, body)
+	}
+	waitCanceled(t, canceled)
+}
+
+func TestStreamTimeouts_ChatProxyFirstByteTimeout(t *testing.T) {
+	baseURL, canceled := stallingUpstream(t, "", true)
+	p, err := startChatCompatProxy(&config.Profile{
+		AnthropicBaseURL:   baseURL,
+		AnthropicAuthToken: "sk-ant",
+		Timeouts:           &config.StreamTimeouts{FirstByte: "50ms"},
+		Retry:              &config.RetryPolicy{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	status, body := postForBody(t, p.BaseURL()+"/chat/completions", `{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if status < 500 || !strings.Contains(body, "upstream sent no data within 50ms") {
+		t.Fatalf("expected a first-byte timeout error, got %d %s", status, body)
+	}
+	waitCanceled(t, canceled)
+}
+
+func TestStreamTimeouts_NonStreamRequestsTimeOut(t *testing.T) {
+	baseURL, canceled := stallingUpstream(t, "", true)
+	p, err := startResponsesCompatProxy(&config.Profile{
+		OpenAIBaseURL: baseURL,
+		Timeouts:      &config.StreamTimeouts{FirstByte: "50ms"},
+		Retry:         &config.RetryPolicy{MaxAttempts: 1},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	status, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"gpt-4.1","stream":false,"input":"hi"}`)
+	if status < 500 || !strings.Contains(body, "upstream sent no data within 50ms") {
+		t.Fatalf("expected a first-byte timeout error, got %d %s", status, body)
+	}
+	waitCanceled(t, canceled)
+
+	chat, err := startChatCompatProxy(&config.Profile{
+		AnthropicBaseURL:   baseURL,
+		AnthropicAuthToken: "sk-ant",
+		Timeouts:           &config.StreamTimeouts{FirstByte: "50ms"},
+		Retry:              &config.RetryPolicy{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer chat.Close()
+	status, body = postForBody(t, chat.BaseURL()+"/chat/completions", `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hi"}]}`)
+	if status < 500 || !strings.Contains(body, "upstream sent no data within 50ms") {
+		t.Fatalf("expected a first-byte timeout error from the Messages upstream, got %d %s", status, body)
+	}
+	waitCanceled(t, canceled)
+}
+
+func TestStreamTimeouts_AnthropicFirstByteTimeoutSendsErrorEvent(t *testing.T) {
+	baseURL, canceled := stallingUpstream(t, "", false)
+	p, err := startAnthropicCompatProxy(&config.Profile{
+		OpenAIBaseURL: baseURL,
+		Timeouts:      &config.StreamTimeouts{FirstByte: "50ms"},
+	}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	_, body := postForBody(t, p.BaseURL()+"/v1/messages", `{"model":"claude-sonnet-4","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	assertContainsAll(t, body, "event: error", `"type":"api_error"`, "upstream sent no data within 50ms")
+	if strings.Contains(body, "message_stop") {
+		t.Fatalf("expected no message_stop after a timeout:\n%s", body)
+	}
+	waitCanceled(t, canceled)
+}
+
+func TestStreamTimeouts_AnthropicPingsSlowStreams(t *testing.T) {
+	old := anthropicPingInterval
+	anthropicPingInterval = 10 * time.Millisecond
+	defer func() { anthropicPingInterval = old }()
+
+	slow := mockupstream.TextStream("gpt-4.1", "Slow", " answer")
+	slow.Chunks = append([]string{": waiting\n\n"}, slow.Chunks...)
+	slow.DelayMS = 80
+	_, baseURL := startMockUpstream(t, slow)
+	p, err := startAnthropicCompatProxy(&config.Profile{OpenAIBaseURL: baseURL}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	_, body := postForBody(t, p.BaseURL()+"/v1/messages", `{"model":"claude-sonnet-4","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	assertContainsAll(t, body, "event: ping", `"text":" answer"`, "event: message_stop")
+	if !strings.HasPrefix(body, "event: message_start") || strings.Index(body, "event: ping") < strings.Index(body, `"text":"Slow"`) {
+		t.Fatalf("expected no pings before the upstream sent content:\n%s", body)
+	}
+}
+
+func TestStreamTimeouts_ClientDisconnectCancelsUpstream(t *testing.T) {
+	baseURL, canceled := stallingUpstream(t, mockupstream.TextStream("gpt-4.1", "hello").Chunks[0], false)
+	p, err := startResponsesCompatProxy(&config.Profile{OpenAIBaseURL: baseURL}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL()+"/responses",
+		strings.NewReader(`{"model":"gpt-4.1","stream":true,"input":"hi"}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), `"delta":"hello"`) {
+			break
+		}
+	}
+	cancel()
+	waitCanceled(t, canceled)
+}
@@ -0,0 +1,702 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"spark/internal/config"
+)
+
+// textToolCallParsers picks, per model, the format a model without native
+// tool calling writes its calls in, so they can be lifted out of the content
+// into structured tool_calls before the stream adapters see them.
+type textToolCallParsers struct {
+	formats map[string]string
+}
+
+// newTextToolCallParsers returns nil when the profile configures none.
+func newTextToolCallParsers(profile *config.Profile) (*textToolCallParsers, error) {
+	if profile == nil || len(profile.ToolCallParsers) == 0 {
+		return nil, nil
+	}
+	formats := map[string]string{}
+	for model, format := range profile.ToolCallParsers {
+		format = strings.ToLower(strings.TrimSpace(format))
+		switch format {
+		case config.ToolCallParserAuto, config.ToolCallParserHermes, config.ToolCallParserQwen,
+			config.ToolCallParserLlama, config.ToolCallParserMistral:
+		default:
+			return nil, fmt.Errorf("tool_call_parsers[%s]: unknown format %q", model, format)
+		}
+		formats[strings.TrimSpace(model)] = format
+	}
+	return &textToolCallParsers{formats: formats}, nil
+}
+
+// format returns the parser format for model, falling back to "*".
+func (t *textToolCallParsers) format(model string) string {
+	if t == nil {
+		return ""
+	}
+	if format, ok := t.formats[model]; ok {
+		return format
+	}
+	return t.formats["*"]
+}
+
+// executor rewrites responses to requests that declare tools and target a
+// model with a configured parser. It returns next unchanged when t is nil.
+func (t *textToolCallParsers) executor(next ChatExecutor, logf func(string, ...any)) ChatExecutor {
+	if t == nil {
+		return next
+	}
+	return textToolCallExecutor{next: next, parsers: t, logf: logf}
+}
+
+type textToolCallExecutor struct {
+	next    ChatExecutor
+	parsers *textToolCallParsers
+	logf    func(string, ...any)
+}
+
+func (e textToolCallExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(e.logf)
+	format := e.parsers.format(stringValue(chatReq["model"]))
+	tools := chatToolNames(chatReq)
+	if format == "" || len(tools) == 0 {
+		return e.next.Do(ctx, chatReq)
+	}
+	resp, err := e.next.Do(ctx, chatReq)
+	if err != nil || resp.StatusCode >= 400 {
+		return resp, err
+	}
+	parser := newTextToolCallParser(format, tools)
+	if !boolValue(chatReq["stream"]) {
+		defer resp.Body.Close()
+		var chatResp map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+			return nil, fmt.Errorf("invalid upstream chat response: %w", err)
+		}
+		if n := rewriteTextToolCallCompletion(chatResp, parser); n > 0 {
+			logf("text tool calls parsed format=%s count=%d", format, n)
+		}
+		return chatJSONResponse(resp.StatusCode, chatResp), nil
+	}
+	upBody := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		defer upBody.Close()
+		n, err := rewriteTextToolCallStream(pw, upBody, parser)
+		if n > 0 {
+			logf("text tool calls parsed format=%s count=%d", format, n)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	header := resp.Header.Clone()
+	header.Del("Content-Length")
+	return &http.Response{StatusCode: resp.StatusCode, Header: header, Body: pr}, nil
+}
+
+// chatToolNames returns the function names a chat request declares.
+func chatToolNames(chatReq map[string]any) map[string]bool {
+	names := map[string]bool{}
+	for _, tool := range chatToolsToAnthropicTools(chatReq["tools"]) {
+		names[stringValue(tool["name"])] = true
+	}
+	return names
+}
+
+// rewriteTextToolCallCompletion moves tool calls found in a non-streaming
+// completion's content into its tool_calls and returns how many it moved.
+func rewriteTextToolCallCompletion(chatResp map[string]any, parser *textToolCallParser) int {
+	choices, _ := chatResp["choices"].([]any)
+	if len(choices) == 0 {
+		return 0
+	}
+	choice := mapValue(choices[0])
+	msg := mapValue(choice["message"])
+	content, ok := msg["content"].(string)
+	if !ok || content == "" {
+		return 0
+	}
+	text, calls := parser.feed(content)
+	rest, more := parser.flush()
+	calls = append(calls, more...)
+	if len(calls) == 0 {
+		return 0
+	}
+	existing, _ := msg["tool_calls"].([]any)
+	for _, call := range calls {
+		existing = append(existing, call.chatToolCall(len(existing)))
+	}
+	msg["content"] = strings.TrimSpace(text + rest)
+	msg["tool_calls"] = existing
+	if stringValue(choice["finish_reason"]) == "stop" || choice["finish_reason"] == nil {
+		choice["finish_reason"] = "tool_calls"
+	}
+	return len(calls)
+}
+
+// rewriteTextToolCallStream copies a chat/completions SSE stream to w,
+// holding back content while a possible tool call is being parsed and
+// replacing parsed calls with tool_calls deltas. It returns how many calls
+// it parsed.
+//
+// Native and parsed calls share one index space, numbered in the order they
+// first appear, since a native call can start after a parsed one.
+func rewriteTextToolCallStream(w io.Writer, upBody io.Reader, parser *textToolCallParser) (int, error) {
+	lines := newStreamLineReader(upBody)
+	defer lines.stop()
+
+	parsed := 0
+	nextIndex := 0
+	nativeIndexes := map[int]int{}
+	var template map[string]any
+	emit := func(chunk map[string]any, choice, delta map[string]any, text string, calls []textToolCall) {
+		if text != "" {
+			delta["content"] = text
+		}
+		if len(calls) > 0 {
+			toolCalls, _ := delta["tool_calls"].([]any)
+			for _, call := range calls {
+				toolCalls = append(toolCalls, call.chatToolCall(nextIndex))
+				nextIndex++
+				parsed++
+			}
+			delta["tool_calls"] = toolCalls
+		}
+		choice["delta"] = delta
+		chunk["choices"] = []any{choice}
+		writeSSE(w, chunk)
+	}
+	// flushChunk emits whatever the parser still holds as a chunk of its own.
+	flushChunk := func() {
+		text, calls := parser.flush()
+		if text == "" && len(calls) == 0 {
+			return
+		}
+		chunk := map[string]any{"object": "chat.completion.chunk"}
+		for _, k := range []string{"id", "object", "created", "model"} {
+			if v, ok := template[k]; ok {
+				chunk[k] = v
+			}
+		}
+		emit(chunk, map[string]any{"index": 0}, map[string]any{}, text, calls)
+	}
+
+	// afterData is set once a data line has been written, since it is
+	// written with its blank terminator already.
+	afterData := false
+	for raw := range lines.lines {
+		line := strings.TrimSpace(raw)
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			if line != "" || !afterData {
+				_, _ = io.WriteString(w, line+"\n")
+			}
+			afterData = false
+			continue
+		}
+		afterData = true
+		data = strings.TrimSpace(data)
+		var chunk map[string]any
+		if data == "[DONE]" || json.Unmarshal([]byte(data), &chunk) != nil {
+			if data == "[DONE]" {
+				flushChunk()
+			}
+			_, _ = io.WriteString(w, "data: "+data+"\n\n")
+			continue
+		}
+		template = chunk
+		choices, _ := chunk["choices"].([]any)
+		if len(choices) == 0 {
+			writeSSE(w, chunk)
+			continue
+		}
+		choice := mapValue(choices[0])
+		delta := mapValue(choice["delta"])
+		toolCalls, _ := delta["tool_calls"].([]any)
+		for _, item := range toolCalls {
+			tc, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			native := intFromAny(tc["index"])
+			index, ok := nativeIndexes[native]
+			if !ok {
+				index = nextIndex
+				nativeIndexes[native] = index
+				nextIndex++
+			}
+			tc["index"] = index
+		}
+
+		var text string
+		var calls []textToolCall
+		content, _ := delta["content"].(string)
+		if content != "" {
+			text, calls = parser.feed(content)
+			delete(delta, "content")
+		}
+		finish := stringValue(choice["finish_reason"])
+		if finish != "" {
+			rest, more := parser.flush()
+			text += rest
+			calls = append(calls, more...)
+			if finish == "stop" && parsed+len(calls) > 0 {
+				choice["finish_reason"] = "tool_calls"
+			}
+		}
+		if content != "" && text == "" && len(calls) == 0 && len(delta) == 0 && finish == "" && chunk["usage"] == nil {
+			// Everything in this chunk is held back.
+			continue
+		}
+		emit(chunk, choice, delta, text, calls)
+	}
+	if err := lines.err(); err != nil {
+		return parsed, err
+	}
+	flushChunk()
+	return parsed, nil
+}
+
+// textToolCall is a call parsed out of model text.
+type textToolCall struct {
+	Name      string
+	Arguments string
+}
+
+func (c textToolCall) chatToolCall(index int) map[string]any {
+	return map[string]any{
+		"index": index,
+		"id":    fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), index),
+		"type":  "function",
+		"function": map[string]any{
+			"name":      c.Name,
+			"arguments": c.Arguments,
+		},
+	}
+}
+
+// textToolCallOpener is a marker that may start a tool call in model text.
+type textToolCallOpener struct {
+	start string
+	scan  func(p *textToolCallParser, s string, final bool) (int, []textToolCall, textToolCallScan)
+}
+
+type textToolCallScan int
+
+const (
+	textToolCallNeedMore textToolCallScan = iota
+	textToolCallMatched
+	textToolCallNotACall
+)
+
+const (
+	hermesToolCallStart  = "<tool_call>"
+	llamaPythonTag       = "<|python_tag|>"
+	mistralToolCallStart = "[TOOL_CALLS]"
+	codeFence            = "```"
+)
+
+var (
+	hermesToolCallOpener  = &textToolCallOpener{start: hermesToolCallStart, scan: scanTaggedToolCall}
+	llamaPythonTagOpener  = &textToolCallOpener{start: llamaPythonTag, scan: scanLlamaToolCall}
+	mistralToolCallOpener = &textToolCallOpener{start: mistralToolCallStart, scan: scanMistralToolCall}
+	fencedToolCallOpener  = &textToolCallOpener{start: codeFence, scan: scanFencedToolCall}
+	// Llama's end-of-message tokens carry no text of their own.
+	llamaEOMOpener = &textToolCallOpener{start: "<|eom_id|>", scan: scanDroppedToken}
+	llamaEOTOpener = &textToolCallOpener{start: "<|eot_id|>", scan: scanDroppedToken}
+	// bareJSONToolCallOpener matches Llama's plain {"name": ...} answers; it
+	// only applies before any other text.
+	bareJSONToolCallOpener = &textToolCallOpener{start: "{", scan: scanLlamaToolCall}
+)
+
+// textToolCallParser incrementally separates text from tool calls in
+// streamed content. Text that might begin a call is held back until the call
+// either parses or turns out to be plain text, which is then released as-is.
+type textToolCallParser struct {
+	tools    map[string]bool
+	openers  []*textToolCallOpener
+	bareJSON bool
+
+	pending string
+	open    *textToolCallOpener
+	sawText bool
+	// afterCall trims the whitespace separating a call from what follows.
+	afterCall bool
+}
+
+func newTextToolCallParser(format string, tools map[string]bool) *textToolCallParser {
+	p := &textToolCallParser{tools: tools}
+	switch format {
+	case config.ToolCallParserHermes, config.ToolCallParserQwen:
+		p.openers = []*textToolCallOpener{hermesToolCallOpener}
+	case config.ToolCallParserLlama:
+		p.openers = []*textToolCallOpener{llamaPythonTagOpener, llamaEOMOpener, llamaEOTOpener}
+		p.bareJSON = true
+	case config.ToolCallParserMistral:
+		p.openers = []*textToolCallOpener{mistralToolCallOpener}
+	default:
+		p.openers = []*textToolCallOpener{hermesToolCallOpener, llamaPythonTagOpener, llamaEOMOpener, llamaEOTOpener, mistralToolCallOpener}
+		p.bareJSON = true
+	}
+	p.openers = append(p.openers, fencedToolCallOpener)
+	return p
+}
+
+// feed adds a content delta and returns the text that is safe to emit and
+// any calls completed by it.
+func (p *textToolCallParser) feed(s string) (string, []textToolCall) {
+	p.pending += s
+	return p.drain(false)
+}
+
+// flush ends the content, releasing anything still held back.
+func (p *textToolCallParser) flush() (string, []textToolCall) {
+	return p.drain(true)
+}
+
+func (p *textToolCallParser) drain(final bool) (string, []textToolCall) {
+	var text strings.Builder
+	var calls []textToolCall
+	release := func(s string) {
+		if p.afterCall {
+			s = strings.TrimLeft(s, " \t\r\n;")
+			p.afterCall = s == ""
+		}
+		text.WriteString(s)
+		if strings.TrimSpace(s) != "" {
+			p.sawText = true
+		}
+	}
+	for p.pending != "" {
+		if p.open == nil {
+			idx, opener := p.nextOpener()
+			if opener == nil {
+				keep := 0
+				if !final {
+					keep = p.heldSuffix()
+				}
+				release(p.pending[:len(p.pending)-keep])
+				p.pending = p.pending[len(p.pending)-keep:]
+				break
+			}
+			if opener != bareJSONToolCallOpener {
+				release(p.pending[:idx])
+			}
+			p.pending = p.pending[idx:]
+			p.open = opener
+		}
+		n, found, result := p.open.scan(p, p.pending, final)
+		if result == textToolCallNeedMore && final {
+			result = textToolCallNotACall
+			n = len(p.pending)
+		}
+		switch result {
+		case textToolCallNeedMore:
+			return text.String(), calls
+		case textToolCallMatched:
+			calls = append(calls, found...)
+			p.pending = p.pending[n:]
+			p.afterCall = true
+		case textToolCallNotACall:
+			// Release the marker and keep scanning after it.
+			n = max(n, len(p.open.start))
+			release(p.pending[:n])
+			p.pending = p.pending[n:]
+		}
+		p.open = nil
+	}
+	return text.String(), calls
+}
+
+// nextOpener returns the earliest complete opener in pending.
+func (p *textToolCallParser) nextOpener() (int, *textToolCallOpener) {
+	best, found := -1, (*textToolCallOpener)(nil)
+	for _, opener := range p.openers {
+		if i := strings.Index(p.pending, opener.start); i >= 0 && (best < 0 || i < best) {
+			best, found = i, opener
+		}
+	}
+	if p.bareJSON && !p.sawText {
+		if trimmed := strings.TrimLeft(p.pending, " \t\r\n"); strings.HasPrefix(trimmed, "{") {
+			if i := len(p.pending) - len(trimmed); best < 0 || i < best {
+				best, found = i, bareJSONToolCallOpener
+			}
+		}
+	}
+	return best, found
+}
+
+// heldSuffix is how much of pending's tail could still grow into an opener.
+func (p *textToolCallParser) heldSuffix() int {
+	if p.bareJSON && !p.sawText && strings.TrimSpace(p.pending) == "" {
+		return len(p.pending)
+	}
+	keep := 0
+	for _, opener := range p.openers {
+		for n := min(len(opener.start)-1, len(p.pending)); n > keep; n-- {
+			if strings.HasSuffix(p.pending, opener.start[:n]) {
+				keep = n
+				break
+			}
+		}
+	}
+	return keep
+}
+
+// scanTaggedToolCall parses <tool_call>...</tool_call> as written by Hermes
+// and Qwen models. A missing closing tag is tolerated at the end of content.
+func scanTaggedToolCall(p *textToolCallParser, s string, final bool) (int, []textToolCall, textToolCallScan) {
+	body := s[len(hermesToolCallStart):]
+	end := strings.Index(body, "</tool_call>")
+	n := len(s)
+	if end >= 0 {
+		n = len(hermesToolCallStart) + end + len("</tool_call>")
+		body = body[:end]
+	} else if !final {
+		return 0, nil, textToolCallNeedMore
+	}
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "<function=") {
+		call, ok := parseQwenXMLToolCall(body)
+		if !ok || !p.tools[call.Name] {
+			return n, nil, textToolCallNotACall
+		}
+		return n, []textToolCall{call}, textToolCallMatched
+	}
+	v, _, result := decodeJSONPrefix(body)
+	if result != textToolCallMatched {
+		return n, nil, textToolCallNotACall
+	}
+	if calls, ok := p.toolCallsFromJSON(v); ok {
+		return n, calls, textToolCallMatched
+	}
+	return n, nil, textToolCallNotACall
+}
+
+// scanLlamaToolCall parses a Llama call: an optional <|python_tag|> followed
+// by one JSON object.
+func scanLlamaToolCall(p *textToolCallParser, s string, final bool) (int, []textToolCall, textToolCallScan) {
+	offset := 0
+	if strings.HasPrefix(s, llamaPythonTag) {
+		offset = len(llamaPythonTag)
+	}
+	body := strings.TrimLeft(s[offset:], " \t\r\n")
+	offset += len(s[offset:]) - len(body)
+	v, n, result := decodeJSONPrefix(body)
+	if result != textToolCallMatched {
+		return offset + n, nil, result
+	}
+	calls, ok := p.toolCallsFromJSON(v)
+	if !ok {
+		return offset + n, nil, textToolCallNotACall
+	}
+	return offset + n, calls, textToolCallMatched
+}
+
+func scanDroppedToken(p *textToolCallParser, s string, final bool) (int, []textToolCall, textToolCallScan) {
+	return len(p.open.start), nil, textToolCallMatched
+}
+
+// scanMistralToolCall parses [TOOL_CALLS] followed by a JSON array of calls,
+// or by name[ARGS]{...} as newer Mistral templates write it.
+func scanMistralToolCall(p *textToolCallParser, s string, final bool) (int, []textToolCall, textToolCallScan) {
+	offset := len(mistralToolCallStart)
+	body := strings.TrimLeft(s[offset:], " \t\r\n")
+	offset += len(s[offset:]) - len(body)
+	if body == "" {
+		return 0, nil, textToolCallNeedMore
+	}
+	if body[0] == '[' || body[0] == '{' {
+		v, n, result := decodeJSONPrefix(body)
+		if result != textToolCallMatched {
+			return offset + n, nil, result
+		}
+		if calls, ok := p.toolCallsFromJSON(v); ok {
+			return offset + n, calls, textToolCallMatched
+		}
+		return offset + n, nil, textToolCallNotACall
+	}
+	name, args, ok := strings.Cut(body, "[ARGS]")
+	if !ok {
+		if strings.ContainsAny(body, " \t\r\n{}") {
+			return 0, nil, textToolCallNotACall
+		}
+		return 0, nil, textToolCallNeedMore
+	}
+	offset += len(name) + len("[ARGS]")
+	v, n, result := decodeJSONPrefix(args)
+	if result != textToolCallMatched {
+		return offset + n, nil, result
+	}
+	calls, ok := p.toolCallsFromJSON(map[string]any{"name": name, "arguments": v})
+	if !ok {
+		return offset + n, nil, textToolCallNotACall
+	}
+	return offset + n, calls, textToolCallMatched
+}
+
+// scanFencedToolCall parses a ```json fence holding a call object. Fences
+// that do not open with JSON are released as soon as that is clear.
+func scanFencedToolCall(p *textToolCallParser, s string, final bool) (int, []textToolCall, textToolCallScan) {
+	start := len(codeFence)
+	lang, rest, ok := strings.Cut(s[start:], "\n")
+	if !ok {
+		if len(lang) > 16 {
+			return 0, nil, textToolCallNotACall
+		}
+		return 0, nil, textToolCallNeedMore
+	}
+	switch strings.ToLower(strings.TrimSpace(lang)) {
+	case "", "json", "tool_call":
+	default:
+		return start + len(lang), nil, textToolCallNotACall
+	}
+	body := strings.TrimLeft(rest, " \t\r\n")
+	if body == "" {
+		return 0, nil, textToolCallNeedMore
+	}
+	if body[0] != '{' && body[0] != '[' {
+		return start + len(lang), nil, textToolCallNotACall
+	}
+	end := strings.Index(body, codeFence)
+	if end < 0 {
+		return 0, nil, textToolCallNeedMore
+	}
+	n := len(s) - len(body) + end + len(codeFence)
+	v, _, result := decodeJSONPrefix(strings.TrimSpace(body[:end]))
+	if result != textToolCallMatched {
+		return n, nil, textToolCallNotACall
+	}
+	if calls, ok := p.toolCallsFromJSON(v); ok {
+		return n, calls, textToolCallMatched
+	}
+	return n, nil, textToolCallNotACall
+}
+
+// decodeJSONPrefix decodes the JSON value at the start of s and reports how
+// many bytes it used, or whether more input is needed.
+func decodeJSONPrefix(s string) (any, int, textToolCallScan) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return nil, 0, textToolCallNeedMore
+		}
+		return nil, 0, textToolCallNotACall
+	}
+	return v, int(dec.InputOffset()), textToolCallMatched
+}
+
+// toolCallsFromJSON reads one call object or an array of them. Every call
+// must name a declared tool, so JSON that merely looks like a call stays
+// text.
+func (p *textToolCallParser) toolCallsFromJSON(v any) ([]textToolCall, bool) {
+	items, isList := v.([]any)
+	if !isList {
+		items = []any{v}
+	}
+	if len(items) == 0 {
+		return nil, false
+	}
+	calls := make([]textToolCall, 0, len(items))
+	for _, item := range items {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if fn := mapValue(obj["function"]); len(fn) > 0 {
+			obj = fn
+		}
+		name := strings.TrimSpace(stringValue(obj["name"]))
+		if !p.tools[name] {
+			return nil, false
+		}
+		args, ok := obj["arguments"]
+		if !ok {
+			args = obj["parameters"]
+		}
+		encoded, ok := toolCallArgumentsJSON(args)
+		if !ok {
+			return nil, false
+		}
+		calls = append(calls, textToolCall{Name: name, Arguments: encoded})
+	}
+	return calls, true
+}
+
+func toolCallArgumentsJSON(args any) (string, bool) {
+	switch v := args.(type) {
+	case nil:
+		return "{}", true
+	case string:
+		if !json.Valid([]byte(v)) {
+			return "", false
+		}
+		return v, true
+	case map[string]any:
+		data, err := json.Marshal(v)
+		return string(data), err == nil
+	default:
+		return "", false
+	}
+}
+
+// parseQwenXMLToolCall parses the <function=name><parameter=key>value
+// </parameter></function> body Qwen coder models put in <tool_call> tags.
+// Values that look like JSON objects or arrays are decoded.
+func parseQwenXMLToolCall(body string) (textToolCall, bool) {
+	rest, ok := strings.CutPrefix(body, "<function=")
+	if !ok {
+		return textToolCall{}, false
+	}
+	name, rest, ok := strings.Cut(rest, ">")
+	if !ok {
+		return textToolCall{}, false
+	}
+	rest, _, ok = strings.Cut(rest, "</function>")
+	if !ok {
+		return textToolCall{}, false
+	}
+	args := map[string]any{}
+	for {
+		_, after, found := strings.Cut(rest, "<parameter=")
+		if !found {
+			break
+		}
+		key, after, ok := strings.Cut(after, ">")
+		if !ok {
+			return textToolCall{}, false
+		}
+		value, after, ok := strings.Cut(after, "</parameter>")
+		if !ok {
+			return textToolCall{}, false
+		}
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "\n"), "\n")
+		args[strings.TrimSpace(key)] = value
+		if trimmed := strings.TrimSpace(value); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var decoded any
+			if json.Unmarshal([]byte(trimmed), &decoded) == nil {
+				args[strings.TrimSpace(key)] = decoded
+			}
+		}
+		rest = after
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(args); err != nil {
+		return textToolCall{}, false
+	}
+	return textToolCall{Name: strings.TrimSpace(name), Arguments: strings.TrimSpace(buf.String())}, true
+}
@@ -0,0 +1,278 @@
+package integrations
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"spark/internal/config"
+	"spark/internal/mockupstream"
+)
+
+// feedByRune feeds content one rune at a time, as a worst-case stream.
+func feedByRune(p *textToolCallParser, content string) (string, []textToolCall) {
+	var text strings.Builder
+	var calls []textToolCall
+	for _, r := range content {
+		t, c := p.feed(string(r))
+		text.WriteString(t)
+		calls = append(calls, c...)
+	}
+	t, c := p.flush()
+	text.WriteString(t)
+	return text.String(), append(calls, c...)
+}
+
+func TestTextToolCallParser_Formats(t *testing.T) {
+	tools := map[string]bool{"read_file": true, "shell": true}
+	cases := []struct {
+		name, format, content, text string
+		calls                       []textToolCall
+	}{
+		{
+			name:    "hermes",
+			format:  config.ToolCallParserHermes,
+			content: "Let me look.\n<tool_call>\n{\"name\": \"read_file\", \"arguments\": {\"path\": \"a.go\"}}\n</tool_call>",
+			text:    "Let me look.\n",
+			calls:   []textToolCall{{Name: "read_file", Arguments: `{"path":"a.go"}`}},
+		},
+		{
+			name:    "hermes without closing tag",
+			format:  config.ToolCallParserHermes,
+			content: `<tool_call>{"name": "shell", "arguments": {"command": ["ls"]}}`,
+			calls:   []textToolCall{{Name: "shell", Arguments: `{"command":["ls"]}`}},
+		},
+		{
+			name:    "qwen xml",
+			format:  config.ToolCallParserQwen,
+			content: "<tool_call>\n<function=read_file>\n<parameter=path>\nsrc/main.go\n</parameter>\n</function>\n</tool_call>",
+			calls:   []textToolCall{{Name: "read_file", Arguments: `{"path":"src/main.go"}`}},
+		},
+		{
+			name:    "llama python tag",
+			format:  config.ToolCallParserLlama,
+			content: `<|python_tag|>{"name": "shell", "parameters": {"command": ["pwd"]}}<|eom_id|>`,
+			calls:   []textToolCall{{Name: "shell", Arguments: `{"command":["pwd"]}`}},
+		},
+		{
+			name:    "llama bare json",
+			format:  config.ToolCallParserLlama,
+			content: ` {"name": "read_file", "parameters": {"path": "go.mod"}}`,
+			calls:   []textToolCall{{Name: "read_file", Arguments: `{"path":"go.mod"}`}},
+		},
+		{
+			name:    "mistral array",
+			format:  config.ToolCallParserMistral,
+			content: `[TOOL_CALLS] [{"name": "shell", "arguments": {"command": ["ls"]}}, {"name": "read_file", "arguments": "{\"path\":\"x\"}"}]`,
+			calls: []textToolCall{
+				{Name: "shell", Arguments: `{"command":["ls"]}`},
+				{Name: "read_file", Arguments: `{"path":"x"}`},
+			},
+		},
+		{
+			name:    "mistral args",
+			format:  config.ToolCallParserMistral,
+			content: `[TOOL_CALLS]read_file[ARGS]{"path": "README.md"}`,
+			calls:   []textToolCall{{Name: "read_file", Arguments: `{"path":"README.md"}`}},
+		},
+		{
+			name:    "fenced json",
+			format:  config.ToolCallParserAuto,
+			content: "Running it.\n```json\n{\"name\": \"shell\", \"arguments\": {\"command\": [\"make\"]}}\n```\nDone.",
+			text:    "Running it.\nDone.",
+			calls:   []textToolCall{{Name: "shell", Arguments: `{"command":["make"]}`}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, calls := feedByRune(newTextToolCallParser(tc.format, tools), tc.content)
+			if text != tc.text {
+				t.Fatalf("text = %q, want %q", text, tc.text)
+			}
+			if len(calls) != len(tc.calls) {
+				t.Fatalf("calls = %+v, want %+v", calls, tc.calls)
+			}
+			for i := range calls {
+				if calls[i] != tc.calls[i] {
+					t.Fatalf("call %d = %+v, want %+v", i, calls[i], tc.calls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTextToolCallParser_ReleasesLookalikes(t *testing.T) {
+	tools := map[string]bool{"shell": true}
+	for _, content := range []string{
+		"Use ```go\nfmt.Println(1)\n``` here.",
+		"```json\n{\"name\": \"unknown\", \"arguments\": {}}\n```",
+		"<tool_call>not json</tool_call> and on",
+		"{\"a\": 1} is just JSON",
+		"Ends with a partial <tool_",
+		"a < b and [TOOL and ``",
+	} {
+		text, calls := feedByRune(newTextToolCallParser(config.ToolCallParserAuto, tools), content)
+		if text != content || len(calls) != 0 {
+			t.Fatalf("expected %q released as text, got %q %+v", content, text, calls)
+		}
+	}
+}
+
+func TestTextToolCallParser_HoldsBackOnlyWhatMightBeACall(t *testing.T) {
+	p := newTextToolCallParser(config.ToolCallParserHermes, map[string]bool{"shell": true})
+	if text, _ := p.feed("Checking <tool"); text != "Checking " {
+		t.Fatalf("expected the possible tag held back, got %q", text)
+	}
+	if text, _ := p.feed("_call>{\"name\":\"shell\""); text != "" {
+		t.Fatalf("expected nothing released inside a call, got %q", text)
+	}
+	text, calls := p.feed(",\"arguments\":{}}</tool_call> ok")
+	if text != "ok" || len(calls) != 1 || calls[0].Arguments != "{}" {
+		t.Fatalf("unexpected completion %q %+v", text, calls)
+	}
+}
+
+func TestNewTextToolCallParsers(t *testing.T) {
+	if p, err := newTextToolCallParsers(&config.Profile{}); p != nil || err != nil {
+		t.Fatalf("expected nil parsers without config, got %+v %v", p, err)
+	}
+	p, err := newTextToolCallParsers(&config.Profile{ToolCallParsers: map[string]string{"*": "auto", "qwen3:8b": " Hermes "}})
+	if err != nil || p.format("qwen3:8b") != "hermes" || p.format("other") != "auto" {
+		t.Fatalf("unexpected parsers %+v %v", p, err)
+	}
+	if _, err := newTextToolCallParsers(&config.Profile{ToolCallParsers: map[string]string{"m": "xml"}}); err == nil ||
+		!strings.Contains(err.Error(), "tool_call_parsers[m]") {
+		t.Fatalf("expected unknown format rejected, got %v", err)
+	}
+}
+
+func TestRewriteTextToolCallCompletion_NumbersCallsAfterNativeOnes(t *testing.T) {
+	chatResp := map[string]any{"choices": []any{map[string]any{
+		"finish_reason": "stop",
+		"message": map[string]any{
+			"content":    `<tool_call>{"name": "read", "arguments": {}}</tool_call><tool_call>{"name": "shell", "arguments": {}}</tool_call>`,
+			"tool_calls": []any{map[string]any{"index": 0, "function": map[string]any{"name": "ls"}}},
+		},
+	}}}
+	parser := newTextToolCallParser(config.ToolCallParserHermes, map[string]bool{"read": true, "shell": true})
+	if n := rewriteTextToolCallCompletion(chatResp, parser); n != 2 {
+		t.Fatalf("expected two parsed calls, got %d", n)
+	}
+	toolCalls := mapValue(mapValue(chatResp["choices"].([]any)[0])["message"])["tool_calls"].([]any)
+	for i, want := range []string{"ls", "read", "shell"} {
+		call := mapValue(toolCalls[i])
+		if intFromAny(call["index"]) != i || mapValue(call["function"])["name"] != want {
+			t.Fatalf("tool call %d: got %v", i, call)
+		}
+	}
+}
+
+func TestRewriteTextToolCallStream_NativeCallAfterParsedCallGetsItsOwnIndex(t *testing.T) {
+	upstream := strings.Join([]string{
+		`data: {"choices":[{"index":0,"delta":{"content":"<tool_call>{\"name\": \"read\", \"arguments\": {}}</tool_call>"}}]}`,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_native","function":{"name":"shell","arguments":""}}]}}]}`,
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]}}]}`,
+		`data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	}, "\n\n") + "\n\n"
+	parser := newTextToolCallParser(config.ToolCallParserHermes, map[string]bool{"read": true, "shell": true})
+	var out bytes.Buffer
+	if n, err := rewriteTextToolCallStream(&out, strings.NewReader(upstream), parser); err != nil || n != 1 {
+		t.Fatalf("expected one parsed call, got %d %v", n, err)
+	}
+	names := map[int]string{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("bad chunk %q: %v", data, err)
+		}
+		for _, td := range extractChatToolCallDeltas(chunk) {
+			if td.Name != "" {
+				names[td.Index] = td.Name
+			}
+		}
+	}
+	if len(names) != 2 || names[0] != "read" || names[1] != "shell" {
+		t.Fatalf("expected read at 0 and shell at 1, got %v", names)
+	}
+}
+
+func TestTextToolCalls_ResponsesProxyEmitsFunctionCall(t *testing.T) {
+	srv, baseURL := startMockUpstream(t,
+		mockupstream.TextStream("qwen3:8b", "I'll list it.", "\n<tool_", "call>\n{\"name\": \"shell\", ", `"arguments": {"command": ["ls"]}}`, "\n</tool_call>"),
+		mockupstream.TextStream("qwen3:8b", "No <tools> needed."),
+		mockupstream.Completion("qwen3:8b", "<tool_call>{\"name\": \"shell\", \"arguments\": {\"command\": [\"pwd\"]}}</tool_call>"),
+	)
+	p, err := startResponsesCompatProxy(&config.Profile{
+		OpenAIBaseURL:   baseURL,
+		ToolCallParsers: map[string]string{"qwen3:8b": config.ToolCallParserQwen},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	url := p.BaseURL() + "/responses"
+	tools := `"tools":[{"type":"function","name":"shell","parameters":{"type":"object"}}]`
+
+	_, body := postForBody(t, url, `{"model":"qwen3:8b","stream":true,"input":"list",`+tools+`}`)
+	assertContainsAll(t, body, `"type":"function_call"`, `"name":"shell"`, `"arguments":"{\"command\":[\"ls\"]}"`, `"output_text":"I'll list it.\n"`)
+	if strings.Contains(body, "tool_call>") {
+		t.Fatalf("expected the tool call markup stripped from text:\n%s", body)
+	}
+
+	_, body = postForBody(t, url, `{"model":"qwen3:8b","stream":true,"input":"hi",`+tools+`}`)
+	assertContainsAll(t, body, `"output_text":"No \u003ctools\u003e needed."`)
+	if strings.Contains(body, `"function_call"`) {
+		t.Fatalf("expected no function call:\n%s", body)
+	}
+
+	_, body = postForBody(t, url, `{"model":"qwen3:8b","input":"where",`+tools+`}`)
+	assertContainsAll(t, body, `"type":"function_call"`, `"arguments":"{\"command\":[\"pwd\"]}"`)
+	if len(srv.Requests()) != 3 {
+		t.Fatalf("unexpected upstream requests: %d", len(srv.Requests()))
+	}
+}
+
+func TestTextToolCalls_AnthropicProxyEmitsToolUse(t *testing.T) {
+	_, baseURL := startMockUpstream(t,
+		mockupstream.TextStream("llama3.1", `<|python_tag|>{"name": "Bash", `, `"parameters": {"command": "ls"}}`, "<|eom_id|>"),
+	)
+	p, err := startAnthropicCompatProxy(&config.Profile{
+		OpenAIBaseURL:   baseURL,
+		ToolCallParsers: map[string]string{"*": config.ToolCallParserAuto},
+	}, "llama3.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+	_, body := postForBody(t, p.BaseURL()+"/v1/messages", `{"model":"claude-sonnet-4","max_tokens":64,"stream":true,`+
+		`"messages":[{"role":"user","content":"list"}],"tools":[{"name":"Bash","input_schema":{"type":"object"}}]}`)
+	assertContainsAll(t, body, `"type":"tool_use"`, `"name":"Bash"`, `"partial_json":"{\"command\":\"ls\"}"`, `"stop_reason":"tool_use"`)
+	if strings.Contains(body, "python_tag") {
+		t.Fatalf("expected the python tag stripped from text:\n%s", body)
+	}
+}
+
+func TestRewriteTextToolCallStream_KeepsLongLinesAndEventBoundaries(t *testing.T) {
+	long := strings.Repeat("x", 3*1024*1024)
+	upstream := ": keep-alive\n\n" +
+		`data: {"choices":[{"index":0,"delta":{"content":"` + long + `"}}]}` + "\n\n" +
+		"data: [DONE]\n\n"
+	parser := newTextToolCallParser(config.ToolCallParserHermes, map[string]bool{"read": true})
+	var out bytes.Buffer
+	if _, err := rewriteTextToolCallStream(&out, strings.NewReader(upstream), parser); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	if !strings.HasPrefix(got, ": keep-alive\n\n") {
+		t.Fatalf("expected the comment event to keep its blank terminator, got %q", got[:min(len(got), 40)])
+	}
+	if !strings.Contains(got, long) || !strings.HasSuffix(got, "data: [DONE]\n\n") || strings.Contains(got, "\n\n\n") {
+		t.Fatalf("unexpected stream: %d bytes", len(got))
+	}
+}
@@ -10,24 +10,45 @@ func (responsesRequestTranslator) ToChat(req map[string]any) (map[string]any, er
 	return responsesToChatCompletions(req), nil
 }
 
-type anthropicRequestTranslator struct{}
+type responsesMessagesTranslator struct{}
 
-func newAnthropicRequestTranslator() RequestTranslator {
-	return anthropicRequestTranslator{}
+func newResponsesMessagesTranslator() MessagesRequestTranslator {
+	return responsesMessagesTranslator{}
 }
 
-func (anthropicRequestTranslator) ToChat(req map[string]any) (map[string]any, error) {
-	return anthropicToChatCompletions(req), nil
+func (responsesMessagesTranslator) ToMessages(req map[string]any) (map[string]any, error) {
+	return responsesToAnthropicRequest(req)
 }
 
-type anthropicResponseTranslator struct{}
+type anthropicRequestTranslator struct {
+	cacheControl bool
+}
+
+func newAnthropicRequestTranslator(cacheControl bool) RequestTranslator {
+	return anthropicRequestTranslator{cacheControl: cacheControl}
+}
+
+func (t anthropicRequestTranslator) ToChat(req map[string]any) (map[string]any, error) {
+	out, err := anthropicToChatCompletions(req)
+	if err != nil {
+		return nil, err
+	}
+	if !t.cacheControl {
+		stripChatCacheControl(out)
+	}
+	return out, nil
+}
+
+type anthropicResponseTranslator struct {
+	thinking bool
+}
 
-func newAnthropicResponseTranslator() NonStreamResponseTranslator {
-	return anthropicResponseTranslator{}
+func newAnthropicResponseTranslator(thinking bool) NonStreamResponseTranslator {
+	return anthropicResponseTranslator{thinking: thinking}
 }
 
-func (anthropicResponseTranslator) FromChat(chatResp map[string]any, requestedModel string) (map[string]any, error) {
-	return chatToAnthropicMessage(chatResp, requestedModel), nil
+func (t anthropicResponseTranslator) FromChat(chatResp map[string]any, requestedModel string) (map[string]any, error) {
+	return chatToAnthropicMessage(chatResp, requestedModel, t.thinking), nil
 }
 
 // chatChunkTranslator wraps existing chunk extractors used by compat streams.
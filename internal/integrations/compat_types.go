@@ -31,3 +31,15 @@ type StreamTranslator interface {
 type ChatExecutor interface {
 	Do(ctx context.Context, chatReq map[string]any) (*http.Response, error)
 }
+
+// MessagesRequestTranslator maps an external API request directly to
+// Anthropic Messages, for upstreams that do not speak chat/completions.
+type MessagesRequestTranslator interface {
+	ToMessages(req map[string]any) (map[string]any, error)
+}
+
+// MessagesExecutor sends an Anthropic Messages request to upstream. Error
+// responses are returned as-is for the caller to map.
+type MessagesExecutor interface {
+	DoMessages(ctx context.Context, msgReq map[string]any) (*http.Response, error)
+}
@@ -0,0 +1,87 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"spark/internal/config"
+)
+
+// chatUpstream is a ChatExecutor for an upstream that does not speak OpenAI
+// chat/completions. It translates each request to the provider's API and
+// hands back a chat/completions response, so the compat proxies' response
+// writers stay provider-agnostic.
+type chatUpstream interface {
+	ChatExecutor
+	fetchModels(ctx context.Context) ([]compatModel, error)
+}
+
+// newChatUpstream returns the upstream for the profile's provider, or nil
+// when the proxy should post to OpenAI chat/completions itself.
+func newChatUpstream(profile *config.Profile, client *http.Client, logf func(format string, args ...any)) (chatUpstream, error) {
+	switch provider := profileProvider(profile); provider {
+	case config.ProviderOpenAI:
+		return nil, nil
+	case config.ProviderGemini:
+		return &geminiChatUpstream{
+			client:  client,
+			baseURL: profileBase(profile),
+			key:     profileKey(profile),
+			keys:    newKeyPool(profile),
+			logf:    logf,
+		}, nil
+	case config.ProviderAzure:
+		return &azureChatUpstream{client: client, profile: *profile, keys: newKeyPool(profile), logf: logf}, nil
+	case config.ProviderOllama:
+		return &ollamaChatUpstream{
+			client:    client,
+			baseURL:   ollamaNativeBaseURL(profileBase(profile)),
+			numCtx:    profile.OllamaNumCtx,
+			keepAlive: profile.OllamaKeepAlive,
+			logf:      logf,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported provider %q", provider)
+	}
+}
+
+// openAIChatUpstream posts to an OpenAI-compatible chat/completions endpoint,
+// rotating through keys when the profile has a key pool. It serves fallbacks
+// and the editor chat proxy; Codex and Claude Code go through their proxies'
+// postChatCompletions and its request-shrinking retries.
+type openAIChatUpstream struct {
+	client  *http.Client
+	baseURL string
+	key     string
+	keys    *keyPool
+	logf    func(format string, args ...any)
+}
+
+func (u *openAIChatUpstream) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(u.logf)
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, err
+	}
+	url := u.baseURL + "/chat/completions"
+	logf("upstream POST %s payload=%s", url, bodyForLog(string(body), 16*1024))
+	return u.keys.do(u.key, logf, func(key string) (*http.Response, error) {
+		upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		upReq.Header.Set("Content-Type", "application/json")
+		upReq.Header.Set("Accept-Encoding", "identity")
+		if key != "" {
+			upReq.Header.Set("Authorization", "Bearer "+key)
+		}
+		return u.client.Do(upReq)
+	})
+}
+
+func (u *openAIChatUpstream) fetchModels(ctx context.Context) ([]compatModel, error) {
+	return fetchOpenAIModels(ctx, u.client, u.baseURL, u.key)
+}
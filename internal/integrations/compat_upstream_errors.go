@@ -0,0 +1,54 @@
+package integrations
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// upstreamErrorBody pulls the error object and message out of an upstream
+// error body. It accepts {"error": {...}}, {"error": "..."}, Gemini's
+// one-element array and plain text, in which case the object is empty.
+func upstreamErrorBody(data []byte) (map[string]any, string) {
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return map[string]any{}, strings.TrimSpace(string(data))
+	}
+	if list, ok := decoded.([]any); ok && len(list) > 0 {
+		decoded = list[0]
+	}
+	body := mapValue(decoded)
+	errObj := mapValue(body["error"])
+	message := stringValue(errObj["message"])
+	if message == "" {
+		message = stringValue(body["error"])
+	}
+	for _, key := range []string{"message", "detail"} {
+		if message == "" {
+			message = stringValue(body[key])
+		}
+	}
+	if message == "" {
+		message = strings.TrimSpace(string(data))
+	}
+	return errObj, message
+}
+
+// forwardRetryHeaders copies the headers agents read to pace their retries.
+func forwardRetryHeaders(dst, src http.Header) {
+	for key, values := range src {
+		lower := strings.ToLower(key)
+		if lower == "retry-after" || lower == "retry-after-ms" ||
+			strings.HasPrefix(lower, "x-ratelimit-") || strings.HasPrefix(lower, "anthropic-ratelimit-") {
+			dst[key] = append([]string(nil), values...)
+		}
+	}
+}
+
+// chatErrorResponse is chatJSONResponse for an upstream error converted to
+// the chat shape. It keeps the upstream status and retry headers.
+func chatErrorResponse(upResp *http.Response, body map[string]any) *http.Response {
+	resp := chatJSONResponse(upResp.StatusCode, body)
+	forwardRetryHeaders(resp.Header, upResp.Header)
+	return resp
+}
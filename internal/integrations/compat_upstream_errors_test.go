@@ -0,0 +1,111 @@
+package integrations
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"spark/internal/config"
+	"spark/internal/mockupstream"
+)
+
+func TestUpstreamErrorBody(t *testing.T) {
+	cases := []struct {
+		body, message, code string
+	}{
+		{`{"error":{"message":"bad","code":"model_not_found"}}`, "bad", "model_not_found"},
+		{`{"error":"plain gateway error"}`, "plain gateway error", ""},
+		{`[{"error":{"code":429,"message":"quota","status":"RESOURCE_EXHAUSTED"}}]`, "quota", ""},
+		{`{"detail":"Not Found"}`, "Not Found", ""},
+		{" upstream exploded \n", "upstream exploded", ""},
+	}
+	for _, tc := range cases {
+		errObj, message := upstreamErrorBody([]byte(tc.body))
+		if message != tc.message || stringValue(errObj["code"]) != tc.code {
+			t.Errorf("upstreamErrorBody(%q) = %v %q", tc.body, errObj, message)
+		}
+	}
+}
+
+func TestUpstreamErrors_AnthropicProxyMapsStatus(t *testing.T) {
+	overloaded := mockupstream.Scenario{Status: 529, Body: `{"error":{"message":"busy"}}`}
+	tooLong := mockupstream.Scenario{
+		Status: http.StatusBadRequest,
+		Body:   `{"error":{"message":"This model's maximum context length is 8192 tokens","code":"context_length_exceeded"}}`,
+	}
+	denied := mockupstream.Scenario{Status: http.StatusForbidden, Body: "forbidden"}
+	_, baseURL := startMockUpstream(t, mockupstream.RateLimited(7), overloaded, tooLong, denied)
+	p, err := startAnthropicCompatProxy(&config.Profile{
+		OpenAIBaseURL: baseURL,
+		Retry:         &config.RetryPolicy{MaxAttempts: 1},
+	}, "gpt-4.1")
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	for _, want := range []struct {
+		status     int
+		retryAfter string
+		body       []string
+	}{
+		{http.StatusTooManyRequests, "7", []string{`"type":"rate_limit_error"`, "Rate limit reached"}},
+		{529, "", []string{`"type":"overloaded_error"`, "busy"}},
+		{http.StatusBadRequest, "", []string{`"type":"invalid_request_error"`, "prompt is too long: This model's maximum context length"}},
+		{http.StatusForbidden, "", []string{`"type":"permission_error"`, "forbidden"}},
+	} {
+		resp, err := http.Post(p.BaseURL()+"/v1/messages", "application/json",
+			strings.NewReader(`{"model":"claude-sonnet-4","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want.status || resp.Header.Get("Retry-After") != want.retryAfter {
+			t.Fatalf("got %d retry-after=%q, want %d %q", resp.StatusCode, resp.Header.Get("Retry-After"), want.status, want.retryAfter)
+		}
+		assertContainsAll(t, string(data), want.body...)
+	}
+}
+
+func TestUpstreamErrors_ResponsesProxyMapsCodes(t *testing.T) {
+	tooLong := mockupstream.Scenario{
+		Status: http.StatusBadRequest,
+		Body:   `{"error":{"message":"prompt is too long","type":"invalid_request_error","param":"messages"}}`,
+	}
+	notFound := mockupstream.Scenario{Status: http.StatusNotFound, Body: `{"error":{"message":"no such model","code":"model_not_found"}}`}
+	overloaded := mockupstream.Scenario{Status: 529, Body: `{"error":{"message":"busy"}}`}
+	unauthorized := mockupstream.Scenario{Status: http.StatusUnauthorized, Body: `{"error":{"message":"bad key"}}`}
+	limited := mockupstream.RateLimited(3)
+	limited.Headers["x-ratelimit-remaining-requests"] = "0"
+	_, baseURL := startMockUpstream(t, limited, tooLong, notFound, overloaded, unauthorized)
+	p, err := startResponsesCompatProxy(&config.Profile{
+		OpenAIBaseURL: baseURL,
+		Retry:         &config.RetryPolicy{MaxAttempts: 1},
+	}, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer p.Close()
+
+	for i, want := range [][]string{
+		{`"code":"rate_limit_exceeded"`, `"type":"rate_limit_error"`},
+		{`"code":"context_length_exceeded"`, `"param":"messages"`},
+		{`"code":"model_not_found"`, "no such model", `"type":"invalid_request_error"`},
+		{`"code":"server_is_overloaded"`, `"type":"server_error"`},
+		{`"code":"invalid_api_key"`, `"type":"invalid_request_error"`},
+	} {
+		resp, err := http.Post(p.BaseURL()+"/responses", "application/json",
+			strings.NewReader(`{"model":"gpt-4.1","stream":true,"input":"hi"}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if i == 0 && (resp.Header.Get("Retry-After") != "3" || resp.Header.Get("X-Ratelimit-Remaining-Requests") != "0") {
+			t.Fatalf("expected rate limit headers forwarded, got %v", resp.Header)
+		}
+		assertContainsAll(t, string(data), want...)
+	}
+}
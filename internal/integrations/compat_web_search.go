@@ -0,0 +1,832 @@
+package integrations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"spark/internal/config"
+)
+
+// webSearchCallsKey carries the searches the proxy ran, on a chat response or
+// chunk and on a Messages response or event, so the Responses writers can
+// report them as web_search_call items.
+const webSearchCallsKey = "x_web_search_calls"
+
+const (
+	defaultWebSearchResults = 5
+	// maxWebSearchRounds bounds how often one request goes back upstream with
+	// search results before the model has to answer.
+	maxWebSearchRounds = 4
+)
+
+// webSearcher answers the model's web_search calls from the profile's search
+// backend and sends the results back upstream, so Codex gets hosted
+// web_search_call items instead of calls it has no implementation for.
+type webSearcher struct {
+	url        *url.URL
+	apiKey     string
+	maxResults int
+	client     *http.Client
+}
+
+// newWebSearcher returns nil when the profile configures no search backend.
+func newWebSearcher(profile *config.Profile) (*webSearcher, error) {
+	if profile == nil || profile.WebSearch == nil {
+		return nil, nil
+	}
+	raw := strings.TrimSpace(profile.WebSearch.URL)
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("web_search.url: invalid URL %q", raw)
+	}
+	maxResults := profile.WebSearch.MaxResults
+	if maxResults <= 0 {
+		maxResults = defaultWebSearchResults
+	}
+	return &webSearcher{
+		url:        u,
+		apiKey:     strings.TrimSpace(profile.WebSearch.APIKey),
+		maxResults: maxResults,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+type webSearchToolKey struct{}
+
+// withWebSearchTool marks a request that declared the built-in web_search
+// tool, as opposed to a client function that happens to share its name.
+func withWebSearchTool(ctx context.Context) context.Context {
+	return context.WithValue(ctx, webSearchToolKey{}, true)
+}
+
+func webSearchToolRequested(ctx context.Context) bool {
+	v, _ := ctx.Value(webSearchToolKey{}).(bool)
+	return v
+}
+
+type webSearchResult struct {
+	Title   string `json:"title,omitempty"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// webSearchCall is one search the proxy ran for the model.
+type webSearchCall struct {
+	ID     string `json:"id"`
+	Query  string `json:"query"`
+	Status string `json:"status"`
+	// output is the tool result sent back upstream.
+	output string
+}
+
+// pendingWebSearch is a web_search call taken out of an upstream response.
+type pendingWebSearch struct {
+	id        string
+	arguments string
+}
+
+func newWebSearchCallID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "ws_" + hex.EncodeToString(b[:])
+}
+
+// runAll runs searches in order. Failed searches are reported to the model
+// as tool output rather than failing the request.
+func (s *webSearcher) runAll(ctx context.Context, logf func(string, ...any), searches []pendingWebSearch) []webSearchCall {
+	calls := make([]webSearchCall, 0, len(searches))
+	for _, pending := range searches {
+		var args map[string]any
+		_ = json.Unmarshal([]byte(pending.arguments), &args)
+		call := webSearchCall{ID: newWebSearchCallID(), Query: strings.TrimSpace(stringValue(args["query"])), Status: "completed"}
+		results, err := s.search(ctx, call.Query)
+		if err != nil {
+			logf("web search failed id=%s: %v", call.ID, err)
+			call.Status = "failed"
+			call.output = "web search failed: " + err.Error()
+		} else {
+			logf("web search done id=%s results=%d", call.ID, len(results))
+			data, _ := json.Marshal(map[string]any{"query": call.Query, "results": results})
+			call.output = string(data)
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+func (s *webSearcher) search(ctx context.Context, query string) ([]webSearchResult, error) {
+	if query == "" {
+		return nil, errors.New("missing query")
+	}
+	u := *s.url
+	q := u.Query()
+	q.Set("q", query)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The URL carries the query; keep it out of logs and tool output.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("search backend returned status %d", resp.StatusCode)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid search backend response: %w", err)
+	}
+	return webSearchResults(body, s.maxResults), nil
+}
+
+// webSearchResults reads "results" (SearXNG, Tavily) or "web.results" (Brave).
+func webSearchResults(body map[string]any, limit int) []webSearchResult {
+	items, ok := body["results"].([]any)
+	if !ok {
+		items, _ = mapValue(body["web"])["results"].([]any)
+	}
+	out := make([]webSearchResult, 0, limit)
+	for _, raw := range items {
+		if len(out) == limit {
+			break
+		}
+		m := mapValue(raw)
+		r := webSearchResult{Title: stringValue(m["title"]), URL: stringValue(m["url"])}
+		if r.URL == "" {
+			continue
+		}
+		for _, key := range []string{"content", "description", "snippet"} {
+			if v := stringValue(m[key]); v != "" {
+				r.Snippet = v
+				break
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// addUsage sums the token counts of two usage objects, nested details
+// included, so a response served in several rounds reports all of them.
+func addUsage(base, more map[string]any) map[string]any {
+	if len(more) == 0 {
+		return base
+	}
+	out := make(map[string]any, len(base)+len(more))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range more {
+		switch mv := v.(type) {
+		case float64:
+			out[k] = intFromAny(out[k]) + int(mv)
+		case int:
+			out[k] = intFromAny(out[k]) + mv
+		case map[string]any:
+			out[k] = addUsage(mapValue(out[k]), mv)
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// errWriter remembers the first write error, so a relay can stop once the
+// client side of its pipe has gone away.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
+
+// relayResponse runs relay on its own goroutine and returns a response that
+// streams what it writes.
+func relayResponse(resp *http.Response, relay func(w io.Writer) error) *http.Response {
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(relay(pw))
+	}()
+	header := resp.Header.Clone()
+	header.Del("Content-Length")
+	return &http.Response{StatusCode: resp.StatusCode, Header: header, Body: pr}
+}
+
+// executor runs web_search calls for requests marked by withWebSearchTool.
+// It returns next unchanged when s is nil.
+func (s *webSearcher) executor(next ChatExecutor, logf func(string, ...any)) ChatExecutor {
+	if s == nil {
+		return next
+	}
+	return webSearchExecutor{next: next, searcher: s, logf: logf}
+}
+
+// messagesExecutor is executor for a Messages upstream.
+func (s *webSearcher) messagesExecutor(next MessagesExecutor, logf func(string, ...any)) MessagesExecutor {
+	if s == nil {
+		return next
+	}
+	return webSearchMessagesExecutor{next: next, searcher: s, logf: logf}
+}
+
+type webSearchExecutor struct {
+	next     ChatExecutor
+	searcher *webSearcher
+	logf     func(string, ...any)
+}
+
+func (e webSearchExecutor) Do(ctx context.Context, chatReq map[string]any) (*http.Response, error) {
+	if !webSearchToolRequested(ctx) {
+		return e.next.Do(ctx, chatReq)
+	}
+	resp, err := e.next.Do(ctx, chatReq)
+	if err != nil || resp.StatusCode >= 400 {
+		return resp, err
+	}
+	if !boolValue(chatReq["stream"]) {
+		return e.complete(ctx, chatReq, resp)
+	}
+	return relayResponse(resp, func(w io.Writer) error {
+		return e.relay(ctx, w, chatReq, resp)
+	}), nil
+}
+
+func (e webSearchExecutor) complete(ctx context.Context, chatReq map[string]any, resp *http.Response) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(e.logf)
+	var calls []webSearchCall
+	var usage map[string]any
+	for round := 1; ; round++ {
+		var chatResp map[string]any
+		err := json.NewDecoder(resp.Body).Decode(&chatResp)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream chat response: %w", err)
+		}
+		usage = addUsage(usage, mapValue(chatResp["usage"]))
+		choices, _ := chatResp["choices"].([]any)
+		var choice, msg map[string]any
+		if len(choices) > 0 {
+			choice = mapValue(choices[0])
+			msg = mapValue(choice["message"])
+		}
+		var searches []pendingWebSearch
+		var others []any
+		toolCalls, _ := msg["tool_calls"].([]any)
+		for _, raw := range toolCalls {
+			call := mapValue(raw)
+			fn := mapValue(call["function"])
+			if stringValue(fn["name"]) != responsesToolWebSearch {
+				others = append(others, raw)
+				continue
+			}
+			searches = append(searches, pendingWebSearch{id: stringValue(call["id"]), arguments: stringValue(fn["arguments"])})
+		}
+		if len(searches) > 0 && len(others) == 0 && round <= maxWebSearchRounds {
+			ran := e.searcher.runAll(ctx, logf, searches)
+			calls = append(calls, ran...)
+			chatReq = chatRequestWithSearchResults(chatReq, stringValue(msg["content"]), stringValue(msg["reasoning_content"]), searches, ran)
+			resp, err = e.next.Do(ctx, chatReq)
+			if err != nil || resp.StatusCode >= 400 {
+				return resp, err
+			}
+			continue
+		}
+		if len(searches) > 0 {
+			logf("web_search calls dropped count=%d other_tool_calls=%d round=%d", len(searches), len(others), round)
+			if len(others) == 0 {
+				delete(msg, "tool_calls")
+				if stringValue(choice["finish_reason"]) == "tool_calls" {
+					choice["finish_reason"] = "stop"
+				}
+			} else {
+				msg["tool_calls"] = others
+			}
+		}
+		if len(calls) > 0 {
+			chatResp[webSearchCallsKey] = calls
+		}
+		if len(usage) > 0 {
+			chatResp["usage"] = usage
+		}
+		return chatJSONResponse(resp.StatusCode, chatResp), nil
+	}
+}
+
+// relay copies the upstream stream to w while holding back web_search calls,
+// the finish reason and usage. A round that ends in searches alone has them
+// run, reported in a webSearchCallsKey chunk and answered upstream, and the
+// next round continues the same client stream.
+func (e webSearchExecutor) relay(ctx context.Context, w io.Writer, chatReq map[string]any, resp *http.Response) error {
+	logf := requestLogFrom(ctx).logfOr(e.logf)
+	defer func() { _ = resp.Body.Close() }()
+	ew := &errWriter{w: w}
+	var calls int
+	var usage map[string]any
+	for round := 1; ; round++ {
+		r, err := relayChatRound(ew, resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		usage = addUsage(usage, r.usage)
+		if r.finish == "" && !r.done {
+			// Truncated upstream: let the stream adapter report it.
+			return nil
+		}
+		if len(r.searches) > 0 && !r.otherCalls && round <= maxWebSearchRounds {
+			ran := e.searcher.runAll(ctx, logf, r.searches)
+			calls += len(ran)
+			writeSSE(ew, map[string]any{"choices": []any{}, webSearchCallsKey: ran})
+			if ew.err != nil {
+				return ew.err
+			}
+			chatReq = chatRequestWithSearchResults(chatReq, r.content.String(), r.reasoning.String(), r.searches, ran)
+			if resp, err = e.next.Do(ctx, chatReq); err != nil {
+				return err
+			}
+			if resp.StatusCode >= 400 {
+				return fmt.Errorf("upstream returned status %d after web search", resp.StatusCode)
+			}
+			continue
+		}
+		if len(r.searches) > 0 {
+			logf("web_search calls dropped count=%d other_tool_calls=%t round=%d", len(r.searches), r.otherCalls, round)
+		}
+		finish := r.finish
+		if finish == "tool_calls" && !r.otherCalls {
+			finish = "stop"
+		}
+		if finish != "" || len(usage) > 0 {
+			final := map[string]any{"id": r.id, "model": r.model, "choices": []any{}}
+			if finish != "" {
+				final["choices"] = []any{map[string]any{"index": 0, "delta": map[string]any{}, "finish_reason": finish}}
+			}
+			if len(usage) > 0 {
+				final["usage"] = usage
+			}
+			writeSSE(ew, final)
+		}
+		_, _ = io.WriteString(ew, "data: [DONE]\n\n")
+		if calls > 0 {
+			logf("web search stream finished searches=%d rounds=%d", calls, round)
+		}
+		return ew.err
+	}
+}
+
+// chatRound is what relayChatRound held back from one upstream stream.
+type chatRound struct {
+	id, model  string
+	finish     string
+	done       bool
+	usage      map[string]any
+	content    strings.Builder
+	reasoning  strings.Builder
+	searches   []pendingWebSearch
+	otherCalls bool
+}
+
+// relayChatRound forwards one upstream chat stream to w, minus web_search
+// tool call deltas, finish reasons, usage and [DONE].
+func relayChatRound(w *errWriter, body io.Reader) (*chatRound, error) {
+	r := &chatRound{}
+	searchSlots := map[int]int{} // tool call index -> r.searches position
+	callKinds := map[int]bool{}  // tool call index -> is web_search
+	lines := newStreamLineReader(body)
+	defer lines.stop()
+	for line := range lines.lines {
+		trimmed := strings.TrimSpace(line)
+		data, ok := strings.CutPrefix(trimmed, "data:")
+		if !ok {
+			if strings.HasPrefix(trimmed, "{") {
+				data = trimmed
+			} else {
+				if trimmed != "" {
+					_, _ = io.WriteString(w, line+"\n")
+				}
+				continue
+			}
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			r.done = true
+			break
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			_, _ = io.WriteString(w, "data: "+data+"\n\n")
+			continue
+		}
+		if r.filter(chunk, searchSlots, callKinds) {
+			writeSSE(w, chunk)
+		}
+		if w.err != nil {
+			return nil, w.err
+		}
+	}
+	return r, lines.err()
+}
+
+// filter strips what the relay holds back from chunk and reports whether
+// anything is left to forward.
+func (r *chatRound) filter(chunk map[string]any, searchSlots map[int]int, callKinds map[int]bool) bool {
+	if v := stringValue(chunk["id"]); v != "" {
+		r.id = v
+	}
+	if v := stringValue(chunk["model"]); v != "" {
+		r.model = v
+	}
+	if u := mapValue(chunk["usage"]); len(u) > 0 {
+		r.usage = u
+	}
+	delete(chunk, "usage")
+	if _, ok := chunk["error"]; ok {
+		return true
+	}
+	keep := false
+	choices, _ := chunk["choices"].([]any)
+	for _, raw := range choices {
+		choice := mapValue(raw)
+		if fr := stringValue(choice["finish_reason"]); fr != "" {
+			r.finish = fr
+		}
+		delete(choice, "finish_reason")
+		delta := mapValue(choice["delta"])
+		if toolCalls, ok := delta["tool_calls"].([]any); ok {
+			var rest []any
+			for _, rawCall := range toolCalls {
+				call := mapValue(rawCall)
+				index := intFromAny(call["index"])
+				fn := mapValue(call["function"])
+				if name := stringValue(fn["name"]); name != "" {
+					callKinds[index] = name == responsesToolWebSearch
+				}
+				if !callKinds[index] {
+					r.otherCalls = true
+					rest = append(rest, rawCall)
+					continue
+				}
+				slot, ok := searchSlots[index]
+				if !ok {
+					slot = len(r.searches)
+					searchSlots[index] = slot
+					r.searches = append(r.searches, pendingWebSearch{})
+				}
+				if id := stringValue(call["id"]); id != "" {
+					r.searches[slot].id = id
+				}
+				r.searches[slot].arguments += stringValue(fn["arguments"])
+			}
+			if len(rest) == 0 {
+				delete(delta, "tool_calls")
+			} else {
+				delta["tool_calls"] = rest
+			}
+		}
+		r.content.WriteString(stringValue(delta["content"]))
+		r.reasoning.WriteString(stringValue(delta["reasoning_content"]))
+		if len(delta) > 0 || choice["message"] != nil {
+			keep = true
+		}
+	}
+	return keep
+}
+
+// chatRequestWithSearchResults returns chatReq continued with the assistant
+// turn that asked for searches and one tool message per search.
+func chatRequestWithSearchResults(chatReq map[string]any, content, reasoning string, searches []pendingWebSearch, ran []webSearchCall) map[string]any {
+	out := make(map[string]any, len(chatReq))
+	for k, v := range chatReq {
+		out[k] = v
+	}
+	messages := append([]map[string]any(nil), chatMessagesList(chatReq["messages"])...)
+	toolCalls := make([]any, 0, len(searches))
+	results := make([]map[string]any, 0, len(searches))
+	for i, search := range searches {
+		id := search.id
+		if id == "" {
+			id = "call_" + ran[i].ID
+		}
+		toolCalls = append(toolCalls, map[string]any{
+			"id":   id,
+			"type": "function",
+			"function": map[string]any{
+				"name":      responsesToolWebSearch,
+				"arguments": search.arguments,
+			},
+		})
+		results = append(results, map[string]any{"role": "tool", "tool_call_id": id, "content": ran[i].output})
+	}
+	assistant := map[string]any{"role": "assistant", "content": content, "tool_calls": toolCalls}
+	if reasoning != "" {
+		assistant["reasoning_content"] = reasoning
+	}
+	out["messages"] = append(append(messages, assistant), results...)
+	return out
+}
+
+type webSearchMessagesExecutor struct {
+	next     MessagesExecutor
+	searcher *webSearcher
+	logf     func(string, ...any)
+}
+
+func (e webSearchMessagesExecutor) DoMessages(ctx context.Context, msgReq map[string]any) (*http.Response, error) {
+	if !webSearchToolRequested(ctx) {
+		return e.next.DoMessages(ctx, msgReq)
+	}
+	resp, err := e.next.DoMessages(ctx, msgReq)
+	if err != nil || resp.StatusCode >= 400 {
+		return resp, err
+	}
+	if !boolValue(msgReq["stream"]) {
+		return e.complete(ctx, msgReq, resp)
+	}
+	return relayResponse(resp, func(w io.Writer) error {
+		return e.relay(ctx, w, msgReq, resp)
+	}), nil
+}
+
+func (e webSearchMessagesExecutor) complete(ctx context.Context, msgReq map[string]any, resp *http.Response) (*http.Response, error) {
+	logf := requestLogFrom(ctx).logfOr(e.logf)
+	var calls []webSearchCall
+	var usage map[string]any
+	for round := 1; ; round++ {
+		var msg map[string]any
+		err := json.NewDecoder(resp.Body).Decode(&msg)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream messages response: %w", err)
+		}
+		usage = addUsage(usage, mapValue(msg["usage"]))
+		blocks, _ := msg["content"].([]any)
+		var searches []pendingWebSearch
+		var kept []any
+		otherCalls := false
+		for _, raw := range blocks {
+			block := mapValue(raw)
+			if stringValue(block["type"]) == "tool_use" {
+				if stringValue(block["name"]) == responsesToolWebSearch {
+					args, _ := json.Marshal(block["input"])
+					searches = append(searches, pendingWebSearch{id: stringValue(block["id"]), arguments: string(args)})
+					continue
+				}
+				otherCalls = true
+			}
+			kept = append(kept, raw)
+		}
+		if len(searches) > 0 && !otherCalls && round <= maxWebSearchRounds {
+			ran := e.searcher.runAll(ctx, logf, searches)
+			calls = append(calls, ran...)
+			msgReq = messagesRequestWithSearchResults(msgReq, blocks, searches, ran)
+			resp, err = e.next.DoMessages(ctx, msgReq)
+			if err != nil || resp.StatusCode >= 400 {
+				return resp, err
+			}
+			continue
+		}
+		if len(searches) > 0 {
+			logf("web_search calls dropped count=%d other_tool_calls=%t round=%d", len(searches), otherCalls, round)
+			msg["content"] = kept
+			if !otherCalls && stringValue(msg["stop_reason"]) == "tool_use" {
+				msg["stop_reason"] = "end_turn"
+			}
+		}
+		if len(calls) > 0 {
+			msg[webSearchCallsKey] = calls
+		}
+		if len(usage) > 0 {
+			msg["usage"] = usage
+		}
+		return chatJSONResponse(resp.StatusCode, msg), nil
+	}
+}
+
+// relay is webSearchExecutor.relay for Messages event streams. Block indexes
+// are renumbered so later rounds continue the client's sequence.
+func (e webSearchMessagesExecutor) relay(ctx context.Context, w io.Writer, msgReq map[string]any, resp *http.Response) error {
+	logf := requestLogFrom(ctx).logfOr(e.logf)
+	defer func() { _ = resp.Body.Close() }()
+	ew := &errWriter{w: w}
+	nextIndex := 0
+	var usage map[string]any
+	for round := 1; ; round++ {
+		r, err := relayMessagesRound(ew, resp.Body, round == 1, &nextIndex)
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		usage = addUsage(usage, r.usage)
+		if !r.stopped {
+			return nil
+		}
+		if len(r.searches) > 0 && !r.otherCalls && round <= maxWebSearchRounds {
+			ran := e.searcher.runAll(ctx, logf, r.searches)
+			writeAnthropicSSE(ew, webSearchCallsKey, map[string]any{"type": webSearchCallsKey, webSearchCallsKey: ran})
+			if ew.err != nil {
+				return ew.err
+			}
+			msgReq = messagesRequestWithSearchResults(msgReq, r.blocks, r.searches, ran)
+			if resp, err = e.next.DoMessages(ctx, msgReq); err != nil {
+				return err
+			}
+			if resp.StatusCode >= 400 {
+				return fmt.Errorf("upstream returned status %d after web search", resp.StatusCode)
+			}
+			continue
+		}
+		if len(r.searches) > 0 {
+			logf("web_search calls dropped count=%d other_tool_calls=%t round=%d", len(r.searches), r.otherCalls, round)
+		}
+		stop := r.stopReason
+		if stop == "tool_use" && !r.otherCalls {
+			stop = "end_turn"
+		}
+		writeAnthropicSSE(ew, "message_delta", map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": stop},
+			"usage": usage,
+		})
+		writeAnthropicSSE(ew, "message_stop", map[string]any{"type": "message_stop"})
+		return ew.err
+	}
+}
+
+// messagesRound is what relayMessagesRound held back from one upstream stream.
+type messagesRound struct {
+	stopReason string
+	stopped    bool
+	usage      map[string]any
+	// blocks are the round's finished content blocks, to replay upstream.
+	blocks     []any
+	searches   []pendingWebSearch
+	otherCalls bool
+}
+
+// messagesRelayBlock is a content block while it streams.
+type messagesRelayBlock struct {
+	block     map[string]any
+	index     int
+	search    bool
+	text      strings.Builder
+	signature strings.Builder
+	input     strings.Builder
+}
+
+// relayMessagesRound forwards one upstream Messages stream to w, minus
+// web_search tool_use blocks, message_delta and message_stop, and message_start
+// unless first is set.
+func relayMessagesRound(w *errWriter, body io.Reader, first bool, nextIndex *int) (*messagesRound, error) {
+	r := &messagesRound{usage: map[string]any{}}
+	blocks := map[int]*messagesRelayBlock{}
+	lines := newStreamLineReader(body)
+	defer lines.stop()
+	for line := range lines.lines {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			continue
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			continue
+		}
+		typ := stringValue(event["type"])
+		forward := true
+		switch typ {
+		case "message_start":
+			for k, v := range mapValue(mapValue(event["message"])["usage"]) {
+				r.usage[k] = v
+			}
+			forward = first
+		case "content_block_start":
+			cb := mapValue(event["content_block"])
+			b := &messagesRelayBlock{block: cb, index: *nextIndex}
+			if stringValue(cb["type"]) == "tool_use" {
+				b.search = stringValue(cb["name"]) == responsesToolWebSearch
+				r.otherCalls = r.otherCalls || !b.search
+			}
+			blocks[intFromAny(event["index"])] = b
+			if b.search {
+				forward = false
+			} else {
+				*nextIndex++
+				event["index"] = b.index
+			}
+		case "content_block_delta", "content_block_stop":
+			index := intFromAny(event["index"])
+			b := blocks[index]
+			if b == nil {
+				forward = false
+				break
+			}
+			if typ == "content_block_stop" {
+				delete(blocks, index)
+				r.finishBlock(b)
+			} else {
+				delta := mapValue(event["delta"])
+				b.text.WriteString(stringValue(delta["text"]))
+				b.text.WriteString(stringValue(delta["thinking"]))
+				b.signature.WriteString(stringValue(delta["signature"]))
+				b.input.WriteString(stringValue(delta["partial_json"]))
+			}
+			forward = !b.search
+			event["index"] = b.index
+		case "message_delta":
+			if v := stringValue(mapValue(event["delta"])["stop_reason"]); v != "" {
+				r.stopReason = v
+			}
+			for k, v := range mapValue(event["usage"]) {
+				r.usage[k] = v
+			}
+			forward = false
+		case "message_stop":
+			r.stopped = true
+		}
+		if r.stopped {
+			break
+		}
+		if forward {
+			writeAnthropicSSE(w, typ, event)
+		}
+		if w.err != nil {
+			return nil, w.err
+		}
+	}
+	return r, lines.err()
+}
+
+// finishBlock records a finished block in the form it is sent back upstream.
+func (r *messagesRound) finishBlock(b *messagesRelayBlock) {
+	block := map[string]any{}
+	for k, v := range b.block {
+		block[k] = v
+	}
+	switch stringValue(block["type"]) {
+	case "text":
+		block["text"] = stringValue(block["text"]) + b.text.String()
+	case "thinking":
+		block["thinking"] = stringValue(block["thinking"]) + b.text.String()
+		block["signature"] = stringValue(block["signature"]) + b.signature.String()
+	case "tool_use":
+		input := map[string]any{}
+		if raw := b.input.String(); raw != "" {
+			_ = json.Unmarshal([]byte(raw), &input)
+		}
+		block["input"] = input
+		if b.search {
+			args, _ := json.Marshal(input)
+			r.searches = append(r.searches, pendingWebSearch{id: stringValue(block["id"]), arguments: string(args)})
+		}
+	}
+	r.blocks = append(r.blocks, block)
+}
+
+// messagesRequestWithSearchResults returns msgReq continued with the assistant
+// turn that asked for searches and a user turn holding their results.
+func messagesRequestWithSearchResults(msgReq map[string]any, blocks []any, searches []pendingWebSearch, ran []webSearchCall) map[string]any {
+	out := make(map[string]any, len(msgReq))
+	for k, v := range msgReq {
+		out[k] = v
+	}
+	messages := append([]map[string]any(nil), chatMessagesList(msgReq["messages"])...)
+	results := make([]any, 0, len(searches))
+	for i, search := range searches {
+		results = append(results, map[string]any{
+			"type":        "tool_result",
+			"tool_use_id": search.id,
+			"content":     ran[i].output,
+		})
+	}
+	out["messages"] = append(messages,
+		map[string]any{"role": "assistant", "content": blocks},
+		map[string]any{"role": "user", "content": results},
+	)
+	return out
+}
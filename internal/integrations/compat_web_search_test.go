@@ -0,0 +1,344 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"spark/internal/config"
+)
+
+// startSearchBackend serves SearXNG-style results and records the queries.
+func startSearchBackend(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer search-key-123" || r.URL.Query().Get("format") != "json" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		queries = append(queries, r.URL.Query().Get("q"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"results":[`+
+			`{"title":"Go 1.24 Release Notes","url":"https://go.dev/doc/go1.24","content":"Go 1.24 arrives six months after Go 1.23."},`+
+			`{"title":"Second","url":"https://example.com/2","content":"more"}]}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &queries
+}
+
+func startWebSearchProxy(t *testing.T, profile *config.Profile, searchURL string) *responsesCompatProxy {
+	t.Helper()
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	t.Setenv("AGENT_LAUNCH_COMPAT_STORE_DIR", "")
+	profile.WebSearch = &config.WebSearch{URL: searchURL + "/search?format=json", APIKey: "search-key-123", MaxResults: 1}
+	p, err := startResponsesCompatProxy(profile, true)
+	if err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+// lastToolMessage returns the content of the last tool message in a chat
+// request, failing unless it follows an assistant web_search call.
+func lastToolMessage(t *testing.T, req map[string]any) string {
+	t.Helper()
+	msgs := chatMessagesList(req["messages"])
+	if len(msgs) < 2 {
+		t.Fatalf("expected search results in the follow-up request: %#v", msgs)
+	}
+	assistant, tool := msgs[len(msgs)-2], msgs[len(msgs)-1]
+	calls, _ := assistant["tool_calls"].([]any)
+	if len(calls) != 1 || mapValue(mapValue(calls[0])["function"])["name"] != "web_search" || tool["role"] != "tool" || tool["tool_call_id"] != mapValue(calls[0])["id"] {
+		t.Fatalf("expected assistant web_search call then tool result: %#v", msgs[len(msgs)-2:])
+	}
+	return stringValue(tool["content"])
+}
+
+func TestWebSearch_ChatNonStreamRunsSearchUpstream(t *testing.T) {
+	search, queries := startSearchBackend(t)
+	var mu sync.Mutex
+	var upstreamReqs []map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		upstreamReqs = append(upstreamReqs, req)
+		n := len(upstreamReqs)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = io.WriteString(w, `{"id":"c1","model":"m","choices":[{"message":{"content":null,"tool_calls":[`+
+				`{"id":"call_1","type":"function","function":{"name":"web_search","arguments":"{\"query\":\"go 1.24 release\"}"}}]},"finish_reason":"tool_calls"}],`+
+				`"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id":"c2","model":"m","choices":[{"message":{"content":"Go 1.24 is out."},"finish_reason":"stop"}],`+
+			`"usage":{"prompt_tokens":40,"completion_tokens":6,"total_tokens":46}}`)
+	}))
+	defer upstream.Close()
+	p := startWebSearchProxy(t, &config.Profile{OpenAIBaseURL: upstream.URL}, search.URL)
+
+	status, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"m","input":"what's new in go?","tools":[{"type":"web_search"}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var out map[string]any
+	_ = json.Unmarshal([]byte(body), &out)
+	items, _ := out["output"].([]any)
+	if len(items) != 2 {
+		t.Fatalf("expected web_search_call and message items, got %#v", out["output"])
+	}
+	call := mapValue(items[0])
+	if call["type"] != "web_search_call" || call["status"] != "completed" || mapValue(call["action"])["query"] != "go 1.24 release" {
+		t.Fatalf("unexpected web_search_call item: %#v", call)
+	}
+	if out["output_text"] != "Go 1.24 is out." {
+		t.Fatalf("unexpected output text: %#v", out)
+	}
+	if usage := mapValue(out["usage"]); intFromAny(usage["input_tokens"]) != 50 || intFromAny(usage["output_tokens"]) != 11 {
+		t.Fatalf("expected usage summed over both rounds: %#v", usage)
+	}
+	if len(*queries) != 1 || (*queries)[0] != "go 1.24 release" {
+		t.Fatalf("unexpected backend queries: %v", *queries)
+	}
+	if len(upstreamReqs) != 2 {
+		t.Fatalf("expected two upstream requests, got %d", len(upstreamReqs))
+	}
+	result := lastToolMessage(t, upstreamReqs[1])
+	if !strings.Contains(result, "https://go.dev/doc/go1.24") || strings.Contains(result, "example.com/2") {
+		t.Fatalf("expected the first result only (max_results=1): %s", result)
+	}
+}
+
+func TestWebSearch_ChatStreamContinuesInOneClientStream(t *testing.T) {
+	search, _ := startSearchBackend(t)
+	var mu sync.Mutex
+	var upstreamReqs []map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		upstreamReqs = append(upstreamReqs, req)
+		n := len(upstreamReqs)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		lines := []string{
+			`{"id":"c2","model":"m","choices":[{"delta":{"content":" It is out."}}]}`,
+			`{"id":"c2","model":"m","choices":[{"delta":{},"finish_reason":"stop"}]}`,
+			`{"id":"c2","model":"m","choices":[],"usage":{"prompt_tokens":40,"completion_tokens":6,"total_tokens":46}}`,
+		}
+		if n == 1 {
+			lines = []string{
+				`{"id":"c1","model":"m","choices":[{"delta":{"content":"Checking."}}]}`,
+				`{"id":"c1","model":"m","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"web_search","arguments":""}}]}}]}`,
+				`{"id":"c1","model":"m","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"query\":\"go 1.24\"}"}}]}}]}`,
+				`{"id":"c1","model":"m","choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+				`{"id":"c1","model":"m","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+			}
+		}
+		for _, line := range lines {
+			_, _ = io.WriteString(w, "data: "+line+"\n\n")
+		}
+		_, _ = io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+	p := startWebSearchProxy(t, &config.Profile{OpenAIBaseURL: upstream.URL}, search.URL)
+
+	status, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"m","stream":true,"input":"what's new in go?","tools":[{"type":"web_search"}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	if strings.Count(body, `"type":"response.completed"`) != 1 || strings.Count(body, "data: [DONE]") != 1 {
+		t.Fatalf("expected one completed response: %q", body)
+	}
+	assertContainsAll(t, body, `"type":"web_search_call"`, `"delta":"Checking."`, `"delta":" It is out."`)
+	if strings.Contains(body, `"type":"function_call"`) {
+		t.Fatalf("web_search must not reach the client as a function call: %q", body)
+	}
+	var completed map[string]any
+	for _, line := range strings.Split(body, "\n") {
+		var ev map[string]any
+		if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev) == nil && ev["type"] == "response.completed" {
+			completed = mapValue(ev["response"])
+		}
+	}
+	items, _ := completed["output"].([]any)
+	if len(items) != 2 || mapValue(items[0])["type"] != "web_search_call" || completed["output_text"] != "Checking. It is out." {
+		t.Fatalf("unexpected completed response: %#v", completed)
+	}
+	if usage := mapValue(completed["usage"]); intFromAny(usage["input_tokens"]) != 50 || intFromAny(usage["output_tokens"]) != 11 {
+		t.Fatalf("expected usage summed over both rounds: %#v", usage)
+	}
+	if len(upstreamReqs) != 2 {
+		t.Fatalf("expected two upstream requests, got %d", len(upstreamReqs))
+	}
+	if msgs := chatMessagesList(upstreamReqs[1]["messages"]); msgs[len(msgs)-2]["content"] != "Checking." {
+		t.Fatalf("expected the round's text in the replayed assistant turn: %#v", msgs)
+	}
+	if result := lastToolMessage(t, upstreamReqs[1]); !strings.Contains(result, "go.dev") {
+		t.Fatalf("unexpected search results: %s", result)
+	}
+}
+
+func TestWebSearch_MessagesStreamRunsSearchUpstream(t *testing.T) {
+	search, _ := startSearchBackend(t)
+	var mu sync.Mutex
+	var upstreamReqs []map[string]any
+	upstream := newFakeAnthropicServer(t, func(w http.ResponseWriter, req map[string]any) {
+		mu.Lock()
+		upstreamReqs = append(upstreamReqs, req)
+		n := len(upstreamReqs)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg_2","model":"claude-sonnet-4","usage":{"input_tokens":40,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"It is out."}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":6}}`,
+			`{"type":"message_stop"}`,
+		}
+		if n == 1 {
+			events = []string{
+				`{"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4","usage":{"input_tokens":10,"output_tokens":1}}}`,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking."}}`,
+				`{"type":"content_block_stop","index":0}`,
+				`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"web_search","input":{}}}`,
+				`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"query\":\"go 1.24\"}"}}`,
+				`{"type":"content_block_stop","index":1}`,
+				`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":5}}`,
+				`{"type":"message_stop"}`,
+			}
+		}
+		for _, ev := range events {
+			_, _ = io.WriteString(w, "data: "+ev+"\n\n")
+		}
+	})
+	t.Setenv("AGENT_LAUNCH_COMPAT_LOG", t.TempDir()+"/codex.log")
+	p := startWebSearchProxy(t, &config.Profile{AnthropicBaseURL: upstream.URL, AnthropicAuthToken: "sk-ant"}, search.URL)
+
+	status, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"claude-sonnet-4","stream":true,"input":"what's new in go?","tools":[{"type":"web_search"}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var completed map[string]any
+	for _, line := range strings.Split(body, "\n") {
+		var ev map[string]any
+		if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev) == nil && ev["type"] == "response.completed" {
+			completed = mapValue(ev["response"])
+		}
+	}
+	items, _ := completed["output"].([]any)
+	if len(items) != 3 || mapValue(items[1])["type"] != "web_search_call" || mapValue(mapValue(items[1])["action"])["query"] != "go 1.24" {
+		t.Fatalf("expected message, web_search_call, message: %#v", completed)
+	}
+	if completed["output_text"] != "Checking.It is out." {
+		t.Fatalf("unexpected output text: %#v", completed["output_text"])
+	}
+	if usage := mapValue(completed["usage"]); intFromAny(usage["input_tokens"]) != 50 || intFromAny(usage["output_tokens"]) != 11 {
+		t.Fatalf("expected usage summed over both rounds: %#v", usage)
+	}
+	if len(upstreamReqs) != 2 {
+		t.Fatalf("expected two upstream requests, got %d", len(upstreamReqs))
+	}
+	msgs := chatMessagesList(upstreamReqs[1]["messages"])
+	assistant, results := msgs[len(msgs)-2], msgs[len(msgs)-1]
+	blocks, _ := assistant["content"].([]any)
+	if assistant["role"] != "assistant" || len(blocks) != 2 || mapValue(mapValue(blocks[1])["input"])["query"] != "go 1.24" {
+		t.Fatalf("expected the round's blocks replayed: %#v", assistant)
+	}
+	resultBlocks, _ := results["content"].([]any)
+	if len(resultBlocks) != 1 || mapValue(resultBlocks[0])["tool_use_id"] != "toolu_1" || !strings.Contains(stringValue(mapValue(resultBlocks[0])["content"]), "go.dev") {
+		t.Fatalf("expected a tool_result with the search results: %#v", results)
+	}
+}
+
+func TestWebSearch_FailedSearchIsReportedToModel(t *testing.T) {
+	search := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer search.Close()
+	var upstreamReqs []map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		upstreamReqs = append(upstreamReqs, req)
+		w.Header().Set("Content-Type", "application/json")
+		if len(upstreamReqs) == 1 {
+			_, _ = io.WriteString(w, `{"choices":[{"message":{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"web_search","arguments":"{\"query\":\"x\"}"}}]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"choices":[{"message":{"content":"Search is down."},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+	p := startWebSearchProxy(t, &config.Profile{OpenAIBaseURL: upstream.URL}, search.URL)
+
+	_, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"m","input":"search x","tools":[{"type":"web_search"}]}`)
+	var out map[string]any
+	_ = json.Unmarshal([]byte(body), &out)
+	items, _ := out["output"].([]any)
+	if len(items) != 2 || mapValue(items[0])["status"] != "failed" {
+		t.Fatalf("expected a failed web_search_call item: %s", body)
+	}
+	if len(upstreamReqs) != 2 || !strings.Contains(lastToolMessage(t, upstreamReqs[1]), "status 500") {
+		t.Fatalf("expected the failure passed to the model: %#v", upstreamReqs)
+	}
+}
+
+func TestWebSearch_ClientFunctionNamedWebSearchIsNotIntercepted(t *testing.T) {
+	search, queries := startSearchBackend(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"choices":[{"message":{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"web_search","arguments":"{\"query\":\"x\"}"}}]},"finish_reason":"tool_calls"}]}`)
+	}))
+	defer upstream.Close()
+	p := startWebSearchProxy(t, &config.Profile{OpenAIBaseURL: upstream.URL}, search.URL)
+
+	_, body := postForBody(t, p.BaseURL()+"/responses", `{"model":"m","input":"x","tools":[{"type":"function","name":"web_search","parameters":{"type":"object"}}]}`)
+	if !strings.Contains(body, `"type":"function_call"`) || len(*queries) != 0 {
+		t.Fatalf("expected the client's own function call, got %s (queries %v)", body, *queries)
+	}
+}
+
+func TestResponsesToChatCompletions_SkipsWebSearchCallItems(t *testing.T) {
+	out := responsesToChatCompletions(map[string]any{
+		"model": "m",
+		"input": []any{
+			map[string]any{"role": "user", "content": "what's new in go?"},
+			map[string]any{"type": "web_search_call", "id": "ws_1", "status": "completed", "action": map[string]any{"type": "search", "query": "go"}},
+			map[string]any{"role": "assistant", "content": "Go 1.24 is out."},
+		},
+	})
+	msgs := chatMessagesList(out["messages"])
+	if len(msgs) != 2 || msgs[0]["role"] != "user" || msgs[1]["role"] != "assistant" {
+		t.Fatalf("expected web_search_call items left out of the chat history: %#v", msgs)
+	}
+}
+
+func TestWebSearchResults_ReadsBraveShape(t *testing.T) {
+	var body map[string]any
+	_ = json.Unmarshal([]byte(`{"web":{"results":[{"title":"A","url":"https://a","description":"about a"},{"title":"no url"}]}}`), &body)
+	got := webSearchResults(body, 5)
+	if len(got) != 1 || got[0].URL != "https://a" || got[0].Snippet != "about a" {
+		t.Fatalf("unexpected results: %#v", got)
+	}
+}
+
+func TestNewWebSearcher_RejectsInvalidURL(t *testing.T) {
+	if _, err := newWebSearcher(&config.Profile{WebSearch: &config.WebSearch{URL: "searx.local"}}); err == nil {
+		t.Fatal("expected an error for a URL without scheme")
+	}
+	if s, err := newWebSearcher(&config.Profile{}); s != nil || err != nil {
+		t.Fatalf("expected no searcher without config, got %v %v", s, err)
+	}
+}
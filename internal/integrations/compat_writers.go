@@ -14,12 +14,21 @@ func newCodexResponseWriter(proxy *responsesCompatProxy) codexResponseWriter {
 	return codexResponseWriter{proxy: proxy}
 }
 
-func (w codexResponseWriter) Write(wr http.ResponseWriter, upResp *http.Response, stream bool) {
+// Write forwards the upstream response and returns the final Responses object
+// sent to the client, or nil when an error was written instead.
+func (w codexResponseWriter) Write(wr http.ResponseWriter, upResp *http.Response, stream bool, tools responsesToolSet) map[string]any {
 	if stream {
-		w.proxy.forwardStream(wr, upResp)
-		return
+		return w.proxy.forwardStream(wr, upResp, tools)
+	}
+	return w.proxy.forwardNonStream(wr, upResp, tools)
+}
+
+// WriteMessages is Write for an Anthropic Messages upstream response.
+func (w codexResponseWriter) WriteMessages(wr http.ResponseWriter, upResp *http.Response, stream bool, tools responsesToolSet) map[string]any {
+	if stream {
+		return w.proxy.forwardMessagesStream(wr, upResp, tools)
 	}
-	w.proxy.forwardNonStream(wr, upResp)
+	return w.proxy.forwardMessagesNonStream(wr, upResp, tools)
 }
 
 type anthropicResponseWriter struct {
@@ -30,11 +39,12 @@ func newAnthropicResponseWriter(proxy *anthropicCompatProxy) anthropicResponseWr
 	return anthropicResponseWriter{proxy: proxy}
 }
 
-func (w anthropicResponseWriter) WriteStream(wr http.ResponseWriter, upBody io.Reader, requestedModel string) {
-	w.proxy.forwardAnthropicStream(wr, upBody, requestedModel)
+func (w anthropicResponseWriter) WriteStream(wr http.ResponseWriter, upBody io.Reader, requestedModel string, thinking bool) {
+	w.proxy.forwardAnthropicStream(wr, upBody, requestedModel, thinking)
 }
 
-func (w anthropicResponseWriter) WriteNonStream(wr http.ResponseWriter, upResp *http.Response, requestedModel string) {
+func (w anthropicResponseWriter) WriteNonStream(wr http.ResponseWriter, upResp *http.Response, requestedModel string, thinking bool) {
+	logf := requestLogOf(wr).logfOr(w.proxy.logf)
 	data, err := io.ReadAll(upResp.Body)
 	if err != nil {
 		writeAnthropicError(wr, http.StatusBadGateway, "invalid upstream response")
@@ -42,15 +52,18 @@ func (w anthropicResponseWriter) WriteNonStream(wr http.ResponseWriter, upResp *
 	}
 	var chatResp map[string]any
 	if err := json.Unmarshal(data, &chatResp); err != nil {
-		w.proxy.logf("upstream invalid json=%s", truncateForLog(string(data), 16*1024))
+		logf("upstream invalid json=%s", bodyForLog(string(data), 16*1024))
 		writeAnthropicError(wr, http.StatusBadGateway, "invalid upstream response")
 		return
 	}
-	w.proxy.logf("upstream response=%s", mustJSONForLog(chatResp))
-	respTranslator := newAnthropicResponseTranslator()
+	logf("upstream response=%s", mustJSONForLog(chatResp))
+	if usage := mapValue(chatResp["usage"]); len(usage) > 0 {
+		requestLogOf(wr).setUsage(intFromAny(usage["prompt_tokens"]), intFromAny(usage["completion_tokens"]), chatCachedPromptTokens(usage))
+	}
+	respTranslator := newAnthropicResponseTranslator(thinking)
 	msg, err := respTranslator.FromChat(chatResp, requestedModel)
 	if err != nil {
-		w.proxy.logf("response translate failed: %v", err)
+		logf("response translate failed: %v", err)
 		writeAnthropicError(wr, http.StatusBadGateway, "invalid upstream response")
 		return
 	}
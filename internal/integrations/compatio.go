@@ -1,6 +1,7 @@
 package integrations
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
@@ -46,7 +47,7 @@ func decodeResponsesRequest(r *http.Request) (map[string]any, string, error) {
 	if len(data) == 0 {
 		return nil, "", fmt.Errorf("empty body")
 	}
-	raw := truncateForLog(string(data), 16*1024)
+	raw := string(data)
 
 	var req map[string]any
 	if err := json.Unmarshal(data, &req); err == nil {
@@ -63,19 +64,29 @@ func decodeResponsesRequest(r *http.Request) (map[string]any, string, error) {
 	return nil, raw, fmt.Errorf("malformed object")
 }
 
+// truncateForLog shortens s to n bytes for a log line, with credentials
+// redacted first so a cut cannot leave part of a key behind.
 func truncateForLog(s string, n int) string {
+	if n > 0 && len(s) > n+truncateRedactMargin {
+		s = s[:n+truncateRedactMargin]
+	}
+	s = redactForLog(s)
 	if n <= 0 || len(s) <= n {
 		return s
 	}
 	return s[:n] + "..."
 }
 
-func mustJSONForLog(v any) string {
+// truncateRedactMargin is how far past the cut truncateForLog still looks
+// for a credential straddling it.
+const truncateRedactMargin = 512
+
+func mustJSONForLog(v any) logBody {
 	data, err := json.Marshal(v)
 	if err != nil {
-		return fmt.Sprintf("%v", v)
+		return bodyForLog(fmt.Sprintf("%v", v), 16*1024)
 	}
-	return truncateForLog(string(data), 16*1024)
+	return bodyForLog(string(data), 16*1024)
 }
 
 type dailyRollingLogWriter struct {
@@ -178,21 +189,67 @@ func (w *dailyRollingLogWriter) cleanupLocked(now time.Time) {
 	}
 }
 
-func openProxyLogFile(envKey, defaultFileName, prefix string) (io.WriteCloser, string, error) {
-	logPath := strings.TrimSpace(os.Getenv(envKey))
-	if logPath == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, "", err
-		}
-		logPath = filepath.Join(home, ".spark", "logs", defaultFileName)
+// proxyLogFile names a proxy's log: an env var that overrides its path, and
+// the file name used under ~/.spark/logs otherwise.
+type proxyLogFile struct {
+	envKey   string
+	fileName string
+}
+
+func (f proxyLogFile) path() (string, error) {
+	if logPath := strings.TrimSpace(os.Getenv(f.envKey)); logPath != "" {
+		return logPath, nil
 	}
-	w, rollingPath, err := newDailyRollingLogWriter(logPath, 7)
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".spark", "logs", f.fileName), nil
+}
+
+func openProxyLogFile(f proxyLogFile) (io.WriteCloser, string, error) {
+	logPath, err := f.path()
 	if err != nil {
 		return nil, "", err
 	}
-	_, _ = fmt.Fprintf(w, "%s [%s] logger initialized\n", time.Now().Format(time.RFC3339), prefix)
-	return w, rollingPath, nil
+	return newDailyRollingLogWriter(logPath, 7)
+}
+
+// PurgeLogs deletes every day's log of the Codex, Claude and chat proxies and
+// returns the removed paths.
+func PurgeLogs() ([]string, error) {
+	var removed []string
+	for _, f := range []proxyLogFile{codexCompatLog, anthropicCompatLog, chatCompatLog} {
+		logPath, err := f.path()
+		if err != nil {
+			return removed, err
+		}
+		dir := filepath.Dir(logPath)
+		ext := filepath.Ext(logPath)
+		prefix := strings.TrimSuffix(filepath.Base(logPath), ext) + "-"
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+				continue
+			}
+			if _, err := time.Parse("2006-01-02", strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)); err != nil {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if err := os.Remove(path); err != nil {
+				return removed, err
+			}
+			removed = append(removed, path)
+		}
+	}
+	return removed, nil
 }
 
 func newStreamingHTTPClient() *http.Client {
@@ -209,3 +266,60 @@ func newStreamingHTTPClient() *http.Client {
 		Transport: cloned,
 	}
 }
+
+// anthropicPingInterval is how often the Anthropic stream adapter sends a
+// ping while it waits on the upstream.
+var anthropicPingInterval = 15 * time.Second
+
+// streamLineReader reads an upstream body line by line on its own goroutine,
+// so a stream adapter can wait on the next line and on timers at once. Lines
+// have no length limit: a single SSE data line can carry a whole tool call's
+// arguments or an inline image.
+type streamLineReader struct {
+	lines chan string
+	done  chan struct{}
+	once  sync.Once
+	mu    sync.Mutex
+	scan  error
+}
+
+func newStreamLineReader(upBody io.Reader) *streamLineReader {
+	r := &streamLineReader{lines: make(chan string), done: make(chan struct{})}
+	go func() {
+		defer close(r.lines)
+		reader := bufio.NewReader(upBody)
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+				select {
+				case r.lines <- line:
+				case <-r.done:
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					r.mu.Lock()
+					r.scan = err
+					r.mu.Unlock()
+				}
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// err is the read error, if any, once lines has been closed.
+func (r *streamLineReader) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.scan
+}
+
+// stop releases the reading goroutine. It exits once its current read
+// returns, which closing the upstream body guarantees.
+func (r *streamLineReader) stop() {
+	r.once.Do(func() { close(r.done) })
+}
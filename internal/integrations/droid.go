@@ -78,8 +78,10 @@ func (d *Droid) Run(profile *config.Profile, model string, args []string) error
 	if _, err := exec.LookPath("droid"); err != nil {
 		return fmt.Errorf("droid is not installed, install from https://docs.factory.ai/cli/getting-started/quickstart")
 	}
-	if err := d.Edit(profile, []string{model}); err != nil {
+	stop, err := editWithChatProfile(d, profile, model)
+	if err != nil {
 		return err
 	}
+	defer stop()
 	return runCmd("droid", args, nil)
 }
@@ -0,0 +1,104 @@
+package integrations
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+)
+
+// editorConfigSnapshot holds an editor's config files as they were before a
+// run wrote to them, so the edit can be undone once the tool exits.
+type editorConfigSnapshot struct {
+	files []editorConfigFile
+}
+
+type editorConfigFile struct {
+	path     string
+	mode     os.FileMode
+	original []byte // nil when the file did not exist
+	written  []byte // contents right after Edit; nil when Edit left it alone
+}
+
+func snapshotEditorConfigs(paths []string) *editorConfigSnapshot {
+	s := &editorConfigSnapshot{}
+	for _, path := range paths {
+		f := editorConfigFile{path: path, mode: 0o644}
+		if data, err := os.ReadFile(path); err == nil {
+			f.original = data
+			if info, err := os.Stat(path); err == nil {
+				f.mode = info.Mode().Perm()
+			}
+		}
+		s.files = append(s.files, f)
+	}
+	return s
+}
+
+// recordWritten notes what Edit wrote, to tell it apart from changes the tool
+// makes to the same files while it runs.
+func (s *editorConfigSnapshot) recordWritten() {
+	for i := range s.files {
+		f := &s.files[i]
+		data, err := os.ReadFile(f.path)
+		if err != nil || (f.original != nil && bytes.Equal(data, f.original)) {
+			continue
+		}
+		f.written = data
+	}
+}
+
+// restore undoes Edit. A file the tool left alone gets its original contents
+// back (or is removed when Edit created it); in a file the tool rewrote, only
+// the top-level settings it did not touch are reverted.
+func (s *editorConfigSnapshot) restore() error {
+	var errs []error
+	for _, f := range s.files {
+		if err := f.restore(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f editorConfigFile) restore() error {
+	if f.written == nil {
+		return nil
+	}
+	current, err := os.ReadFile(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if bytes.Equal(current, f.written) {
+		if f.original == nil {
+			return os.Remove(f.path)
+		}
+		return os.WriteFile(f.path, f.original, f.mode)
+	}
+
+	var cur, wrote map[string]any
+	if json.Unmarshal(current, &cur) != nil || json.Unmarshal(f.written, &wrote) != nil {
+		return errors.New(f.path + " changed while the tool ran and is not JSON; left as is")
+	}
+	orig := map[string]any{}
+	_ = json.Unmarshal(f.original, &orig)
+	for key, value := range wrote {
+		if !reflect.DeepEqual(cur[key], value) {
+			continue
+		}
+		if prev, ok := orig[key]; ok {
+			cur[key] = prev
+		} else {
+			delete(cur, key)
+		}
+	}
+	data, err := json.MarshalIndent(cur, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, data, f.mode)
+}
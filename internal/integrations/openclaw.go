@@ -83,8 +83,10 @@ func (o *Openclaw) Run(profile *config.Profile, model string, args []string) err
 			return fmt.Errorf("openclaw is not installed, install from https://docs.openclaw.ai")
 		}
 	}
-	if err := o.Edit(profile, []string{model}); err != nil {
+	stop, err := editWithChatProfile(o, profile, model)
+	if err != nil {
 		return err
 	}
+	defer stop()
 	return runCmd(bin, append([]string{"gateway"}, args...), nil)
 }
@@ -89,8 +89,10 @@ func (o *OpenCode) Run(profile *config.Profile, model string, args []string) err
 	if _, err := exec.LookPath("opencode"); err != nil {
 		return fmt.Errorf("opencode is not installed, install from https://opencode.ai")
 	}
-	if err := o.Edit(profile, []string{model}); err != nil {
+	stop, err := editWithChatProfile(o, profile, model)
+	if err != nil {
 		return err
 	}
+	defer stop()
 	return runCmd("opencode", args, nil)
 }
@@ -79,8 +79,10 @@ func (p *Pi) Run(profile *config.Profile, model string, args []string) error {
 	if _, err := exec.LookPath("pi"); err != nil {
 		return fmt.Errorf("pi is not installed, install with: npm install -g @mariozechner/pi-coding-agent")
 	}
-	if err := p.Edit(profile, []string{model}); err != nil {
+	stop, err := editWithChatProfile(p, profile, model)
+	if err != nil {
 		return err
 	}
+	defer stop()
 	return runCmd("pi", args, nil)
 }
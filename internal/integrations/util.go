@@ -69,18 +69,59 @@ func ensureDir(path string) error {
 
 func profileBase(profile *config.Profile) string {
 	if profile == nil || profile.OpenAIBaseURL == "" {
+		switch profileProvider(profile) {
+		case config.ProviderGemini:
+			return geminiDefaultBaseURL
+		case config.ProviderOllama:
+			return ollamaDefaultBaseURL
+		}
 		return "https://api.openai.com/v1"
 	}
 	return profile.OpenAIBaseURL
 }
 
+// profileProvider returns the profile's upstream API kind, defaulting to
+// OpenAI chat/completions.
+func profileProvider(profile *config.Profile) string {
+	if profile == nil {
+		return config.ProviderOpenAI
+	}
+	switch p := strings.ToLower(strings.TrimSpace(profile.Provider)); p {
+	case "":
+		return config.ProviderOpenAI
+	default:
+		return p
+	}
+}
+
 func profileKey(profile *config.Profile) string {
 	if profile == nil {
 		return ""
 	}
+	if profile.OpenAIAPIKey == "" {
+		if keys := profileKeys(profile); len(keys) > 0 {
+			return keys[0]
+		}
+	}
 	return profile.OpenAIAPIKey
 }
 
+// profileUpstreamURL is the endpoint a compat proxy for profile talks to, for
+// display.
+func profileUpstreamURL(profile *config.Profile) string {
+	if anthropicOnlyProfile(profile) {
+		return strings.TrimRight(anthropicBaseURL(profile), "/")
+	}
+	return strings.TrimRight(profileBase(profile), "/")
+}
+
+// anthropicOnlyProfile reports whether a profile configures an Anthropic
+// endpoint and no other upstream.
+func anthropicOnlyProfile(profile *config.Profile) bool {
+	return profile != nil && profile.OpenAIBaseURL == "" && profile.AnthropicBaseURL != "" &&
+		profileProvider(profile) == config.ProviderOpenAI
+}
+
 func firstModel(models []string) (string, error) {
 	if len(models) == 0 || models[0] == "" {
 		return "", fmt.Errorf("no models selected")
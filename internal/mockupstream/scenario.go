@@ -0,0 +1,237 @@
+// Package mockupstream is a scriptable fake OpenAI-compatible upstream for
+// exercising the compat proxies offline. Each chat request is answered with
+// the next queued Scenario, which writes raw chunks exactly as scripted so
+// tests can reproduce split events, odd framings and broken connections.
+package mockupstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stream framings for Scenario.Format.
+const (
+	FormatSSE    = "sse"
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+// Scenario scripts one upstream response.
+type Scenario struct {
+	Name string `json:"name,omitempty"`
+	// Status defaults to 200.
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Format picks the Content-Type: sse (default when Chunks is set),
+	// ndjson, or json (default when only Body is set).
+	Format string `json:"format,omitempty"`
+	// Body is written in one piece; Chunks are written and flushed one by
+	// one, DelayMS apart. Chunks are sent verbatim, so a chunk may end in the
+	// middle of an event.
+	Body    string   `json:"body,omitempty"`
+	Chunks  []string `json:"chunks,omitempty"`
+	DelayMS int      `json:"delay_ms,omitempty"`
+	// Disconnect drops the connection after the last chunk instead of
+	// ending the response cleanly.
+	Disconnect bool `json:"disconnect,omitempty"`
+}
+
+func (s Scenario) contentType() string {
+	format := s.Format
+	if format == "" {
+		format = FormatJSON
+		if len(s.Chunks) > 0 {
+			format = FormatSSE
+		}
+	}
+	switch format {
+	case FormatSSE:
+		return "text/event-stream"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/json"
+	}
+}
+
+func chunkJSON(model string, delta map[string]any, finish string, usage map[string]any) string {
+	choice := map[string]any{"index": 0, "delta": delta}
+	if finish != "" {
+		choice["finish_reason"] = finish
+	}
+	chunk := map[string]any{
+		"id":      "chatcmpl-mock",
+		"object":  "chat.completion.chunk",
+		"model":   model,
+		"choices": []any{choice},
+	}
+	if usage != nil {
+		chunk["usage"] = usage
+	}
+	data, _ := json.Marshal(chunk)
+	return "data: " + string(data) + "\n\n"
+}
+
+func usage(prompt, completion int) map[string]any {
+	return map[string]any{"prompt_tokens": prompt, "completion_tokens": completion, "total_tokens": prompt + completion}
+}
+
+const doneEvent = "data: [DONE]\n\n"
+
+// TextStream streams parts as content deltas, then a stop chunk with usage
+// and [DONE].
+func TextStream(model string, parts ...string) Scenario {
+	sc := Scenario{Name: "text"}
+	for i, part := range parts {
+		delta := map[string]any{"content": part}
+		if i == 0 {
+			delta["role"] = "assistant"
+		}
+		sc.Chunks = append(sc.Chunks, chunkJSON(model, delta, "", nil))
+	}
+	sc.Chunks = append(sc.Chunks, chunkJSON(model, map[string]any{}, "stop", usage(12, len(parts))), doneEvent)
+	return sc
+}
+
+// ToolCallStream streams one function call whose arguments arrive in
+// argParts, with the event carrying the second part split across two
+// writes.
+func ToolCallStream(model, name string, argParts ...string) Scenario {
+	sc := Scenario{Name: "tool-call-split"}
+	for i, part := range argParts {
+		call := map[string]any{"index": 0, "function": map[string]any{"arguments": part}}
+		if i == 0 {
+			call["id"] = "call_mock"
+			call["type"] = "function"
+			call["function"] = map[string]any{"name": name, "arguments": part}
+		}
+		event := chunkJSON(model, map[string]any{"tool_calls": []any{call}}, "", nil)
+		if i == 1 {
+			cut := len(event) / 2
+			sc.Chunks = append(sc.Chunks, event[:cut], event[cut:])
+			continue
+		}
+		sc.Chunks = append(sc.Chunks, event)
+	}
+	sc.Chunks = append(sc.Chunks, chunkJSON(model, map[string]any{}, "tool_calls", usage(20, 8)), doneEvent)
+	return sc
+}
+
+// ReasoningStream streams reasoning_content deltas and no visible text.
+func ReasoningStream(model string, parts ...string) Scenario {
+	sc := Scenario{Name: "reasoning-only"}
+	for _, part := range parts {
+		sc.Chunks = append(sc.Chunks, chunkJSON(model, map[string]any{"reasoning_content": part}, "", nil))
+	}
+	sc.Chunks = append(sc.Chunks, chunkJSON(model, map[string]any{}, "stop", usage(10, len(parts))), doneEvent)
+	return sc
+}
+
+// NDJSONTextStream streams parts in Ollama's native /api/chat framing.
+func NDJSONTextStream(model string, parts ...string) Scenario {
+	sc := Scenario{Name: "ndjson", Format: FormatNDJSON}
+	for _, part := range parts {
+		line, _ := json.Marshal(map[string]any{
+			"model":   model,
+			"message": map[string]any{"role": "assistant", "content": part},
+			"done":    false,
+		})
+		sc.Chunks = append(sc.Chunks, string(line)+"\n")
+	}
+	last, _ := json.Marshal(map[string]any{
+		"model":             model,
+		"message":           map[string]any{"role": "assistant", "content": ""},
+		"done":              true,
+		"done_reason":       "stop",
+		"prompt_eval_count": 10,
+		"eval_count":        len(parts),
+	})
+	sc.Chunks = append(sc.Chunks, string(last)+"\n")
+	return sc
+}
+
+// DisconnectStream streams parts and then drops the connection without a
+// finish chunk or [DONE].
+func DisconnectStream(model string, parts ...string) Scenario {
+	sc := TextStream(model, parts...)
+	sc.Name = "disconnect"
+	sc.Chunks = sc.Chunks[:len(parts)]
+	sc.Disconnect = true
+	return sc
+}
+
+// RateLimited answers 429 with an OpenAI-style error and Retry-After.
+func RateLimited(retryAfterSeconds int) Scenario {
+	return Scenario{
+		Name:    "rate-limited",
+		Status:  http.StatusTooManyRequests,
+		Headers: map[string]string{"Retry-After": strconv.Itoa(retryAfterSeconds)},
+		Body:    `{"error":{"message":"Rate limit reached","type":"rate_limit_error","code":"rate_limit_exceeded"}}`,
+	}
+}
+
+// MalformedJSON streams one valid chunk followed by an event that is not
+// JSON.
+func MalformedJSON(model string) Scenario {
+	return Scenario{
+		Name: "malformed-json",
+		Chunks: []string{
+			chunkJSON(model, map[string]any{"role": "assistant", "content": "Hello"}, "", nil),
+			"data: {\"choices\":[{\"delta\":{\"content\":\"broken\"\n\n",
+			doneEvent,
+		},
+	}
+}
+
+// Completion answers a non-streaming chat request with text.
+func Completion(model, text string) Scenario {
+	data, _ := json.Marshal(map[string]any{
+		"id":     "chatcmpl-mock",
+		"object": "chat.completion",
+		"model":  model,
+		"choices": []any{map[string]any{
+			"index":         0,
+			"message":       map[string]any{"role": "assistant", "content": text},
+			"finish_reason": "stop",
+		}},
+		"usage": usage(12, 4),
+	})
+	return Scenario{Name: "completion", Body: string(data)}
+}
+
+// Builtin returns the named scenarios the mock-upstream command offers.
+func Builtin(model string) map[string]Scenario {
+	return map[string]Scenario{
+		"text":            TextStream(model, "Hello", " from", " the mock upstream."),
+		"tool-call-split": ToolCallStream(model, "shell", `{"command":`, `["ls",`, `"-la"]}`),
+		"reasoning-only":  ReasoningStream(model, "Thinking it over", " step by step."),
+		"ndjson":          NDJSONTextStream(model, "Hello", " over NDJSON."),
+		"disconnect":      DisconnectStream(model, "This answer", " stops here"),
+		"rate-limited":    RateLimited(1),
+		"malformed-json":  MalformedJSON(model),
+		"completion":      Completion(model, "Hello from the mock upstream."),
+	}
+}
+
+// BuiltinNames lists Builtin's scenario names in order.
+func BuiltinNames() []string {
+	names := make([]string, 0, 8)
+	for name := range Builtin("") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupBuiltin returns the builtin scenario called name.
+func LookupBuiltin(name, model string) (Scenario, error) {
+	sc, ok := Builtin(model)[strings.TrimSpace(name)]
+	if !ok {
+		return Scenario{}, fmt.Errorf("unknown scenario %q (available: %s)", name, strings.Join(BuiltinNames(), ", "))
+	}
+	return sc, nil
+}
@@ -0,0 +1,206 @@
+package mockupstream
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request is a chat request the server received.
+type Request struct {
+	Path   string
+	Header http.Header
+	Body   map[string]any
+}
+
+// Server is a fake OpenAI-compatible upstream. It serves chat/completions
+// (and Ollama's /api/chat) from a queue of scenarios, falling back to a
+// default scenario once the queue is empty, and lists Models on /models.
+type Server struct {
+	mu       sync.Mutex
+	queue    []Scenario
+	fallback *Scenario
+	requests []Request
+	models   []string
+
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewServer returns an unstarted server that serves scenarios in order.
+func NewServer(scenarios ...Scenario) *Server {
+	return &Server{queue: append([]Scenario(nil), scenarios...)}
+}
+
+// Start listens on addr ("127.0.0.1:0" picks a free port) and serves in the
+// background.
+func (s *Server) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+	s.server = &http.Server{Handler: s.Handler()}
+	go func() {
+		_ = s.server.Serve(ln)
+	}()
+	return nil
+}
+
+// URL is the OpenAI base URL of a started server, ending in /v1.
+func (s *Server) URL() string {
+	return "http://" + s.listener.Addr().String() + "/v1"
+}
+
+// Close stops a started server.
+func (s *Server) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// Enqueue appends scenarios to the queue.
+func (s *Server) Enqueue(scenarios ...Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, scenarios...)
+}
+
+// SetDefault serves sc whenever the queue is empty.
+func (s *Server) SetDefault(sc Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = &sc
+}
+
+// SetModels sets the IDs listed on /models.
+func (s *Server) SetModels(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = append([]string(nil), ids...)
+}
+
+// Requests returns the chat requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Handler serves the fake API; use it with httptest.NewServer or Start.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", s.handleChat)
+	mux.HandleFunc("/chat/completions", s.handleChat)
+	mux.HandleFunc("/api/chat", s.handleChat)
+	mux.HandleFunc("/v1/models", s.handleModels)
+	mux.HandleFunc("/models", s.handleModels)
+	return mux
+}
+
+func (s *Server) next() (Scenario, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) > 0 {
+		sc := s.queue[0]
+		s.queue = s.queue[1:]
+		return sc, true
+	}
+	if s.fallback != nil {
+		return *s.fallback, true
+	}
+	return Scenario{}, false
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body map[string]any
+	data, _ := io.ReadAll(r.Body)
+	_ = json.Unmarshal(data, &body)
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+	s.mu.Unlock()
+
+	sc, ok := s.next()
+	if !ok {
+		model, _ := body["model"].(string)
+		if stream, _ := body["stream"].(bool); stream {
+			sc = TextStream(model, "ok")
+		} else {
+			sc = Completion(model, "ok")
+		}
+	}
+	s.write(w, sc)
+}
+
+func (s *Server) write(w http.ResponseWriter, sc Scenario) {
+	for k, v := range sc.Headers {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Content-Type", sc.contentType())
+	status := sc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	flusher, _ := w.(http.Flusher)
+	if sc.Body != "" {
+		_, _ = io.WriteString(w, sc.Body)
+	}
+	for i, chunk := range sc.Chunks {
+		if i > 0 && sc.DelayMS > 0 {
+			time.Sleep(time.Duration(sc.DelayMS) * time.Millisecond)
+		}
+		if _, err := io.WriteString(w, chunk); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if sc.Disconnect {
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				_ = conn.Close()
+			}
+		}
+	}
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ids := append([]string(nil), s.models...)
+	s.mu.Unlock()
+	data := make([]any, 0, len(ids))
+	for _, id := range ids {
+		data = append(data, map[string]any{"id": id, "object": "model", "owned_by": "mock"})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+}
+
+// LoadScript decodes a JSON array of scenarios, as used by the
+// mock-upstream command's --script flag.
+func LoadScript(r io.Reader) ([]Scenario, error) {
+	var scenarios []Scenario
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&scenarios); err != nil {
+		return nil, err
+	}
+	for i := range scenarios {
+		scenarios[i].Format = strings.ToLower(strings.TrimSpace(scenarios[i].Format))
+	}
+	return scenarios, nil
+}
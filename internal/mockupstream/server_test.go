@@ -0,0 +1,87 @@
+package mockupstream
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func post(t *testing.T, url, body string) (*http.Response, string, error) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp, string(data), err
+}
+
+func TestServer_ServesQueueThenDefault(t *testing.T) {
+	srv := NewServer(RateLimited(3), ToolCallStream("m", "read", `{"path":`, `"a"}`))
+	srv.SetDefault(Completion("m", "fallback"))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, body, _ := post(t, ts.URL+"/v1/chat/completions", `{"model":"m"}`)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "3" || !strings.Contains(body, "rate_limit_exceeded") {
+		t.Fatalf("unexpected rate limit response %d %v %s", resp.StatusCode, resp.Header, body)
+	}
+	resp, body, _ = post(t, ts.URL+"/chat/completions", `{"model":"m","stream":true}`)
+	if resp.Header.Get("Content-Type") != "text/event-stream" || !strings.Contains(body, `\"path\":`) || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("unexpected stream %q", body)
+	}
+	for i := 0; i < 2; i++ {
+		if _, body, _ = post(t, ts.URL+"/v1/chat/completions", `{"model":"m"}`); !strings.Contains(body, "fallback") {
+			t.Fatalf("expected the default scenario, got %s", body)
+		}
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 4 || reqs[1].Path != "/chat/completions" || reqs[1].Body["stream"] != true {
+		t.Fatalf("unexpected recorded requests %+v", reqs)
+	}
+}
+
+func TestServer_DefaultsToEchoingStreamMode(t *testing.T) {
+	ts := httptest.NewServer(NewServer().Handler())
+	defer ts.Close()
+	if _, body, _ := post(t, ts.URL+"/v1/chat/completions", `{"model":"m","stream":true}`); !strings.HasPrefix(body, "data: ") {
+		t.Fatalf("expected an SSE answer, got %s", body)
+	}
+	if _, body, _ := post(t, ts.URL+"/v1/chat/completions", `{"model":"m"}`); !strings.Contains(body, `"object":"chat.completion"`) {
+		t.Fatalf("expected a JSON completion, got %s", body)
+	}
+}
+
+func TestServer_DisconnectBreaksTheStream(t *testing.T) {
+	ts := httptest.NewServer(NewServer(DisconnectStream("m", "partial")).Handler())
+	defer ts.Close()
+	_, body, err := post(t, ts.URL+"/v1/chat/completions", `{"stream":true}`)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected an unexpected EOF, got %v", err)
+	}
+	if !strings.Contains(body, "partial") || strings.Contains(body, "[DONE]") {
+		t.Fatalf("unexpected partial body %q", body)
+	}
+}
+
+func TestLoadScriptAndBuiltins(t *testing.T) {
+	scenarios, err := LoadScript(strings.NewReader(`[{"name":"x","format":"NDJSON","chunks":["{}\n"]},{"status":500,"body":"boom"}]`))
+	if err != nil || len(scenarios) != 2 || scenarios[0].contentType() != "application/x-ndjson" || scenarios[1].contentType() != "application/json" {
+		t.Fatalf("unexpected script %+v %v", scenarios, err)
+	}
+	if _, err := LoadScript(strings.NewReader(`[{"chunk":"typo"}]`)); err == nil {
+		t.Fatalf("expected unknown fields rejected")
+	}
+	for _, name := range BuiltinNames() {
+		if _, err := LookupBuiltin(name, "m"); err != nil {
+			t.Fatalf("builtin %s: %v", name, err)
+		}
+	}
+	if _, err := LookupBuiltin("nope", "m"); err == nil || !strings.Contains(err.Error(), "available:") {
+		t.Fatalf("expected unknown scenario error, got %v", err)
+	}
+}
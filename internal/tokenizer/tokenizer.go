@@ -0,0 +1,338 @@
+package tokenizer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Counter estimates how many tokens a piece of text occupies for a model.
+type Counter interface {
+	Name() string
+	Count(text string) int
+}
+
+const (
+	EncodingCL100K = "cl100k_base"
+	EncodingO200K  = "o200k_base"
+)
+
+// modelEncodings maps model-name prefixes to BPE encodings. Longest prefix wins.
+var modelEncodings = map[string]string{
+	"gpt-5":                  EncodingO200K,
+	"gpt-4.1":                EncodingO200K,
+	"gpt-4.5":                EncodingO200K,
+	"gpt-4o":                 EncodingO200K,
+	"chatgpt-4o":             EncodingO200K,
+	"o1":                     EncodingO200K,
+	"o3":                     EncodingO200K,
+	"o4":                     EncodingO200K,
+	"gpt-oss":                EncodingO200K,
+	"gpt-4":                  EncodingCL100K,
+	"gpt-3.5":                EncodingCL100K,
+	"text-embedding-3":       EncodingCL100K,
+	"text-embedding-ada-002": EncodingCL100K,
+}
+
+// defaultEncoding is used for models without a table entry. It is only an
+// approximation for non-OpenAI vocabularies, but a closer one than the
+// character heuristic.
+const defaultEncoding = EncodingCL100K
+
+// EncodingForModel returns the BPE encoding name used for model. Gateway
+// prefixes such as "openai/" are ignored.
+func EncodingForModel(model string) string {
+	m := strings.ToLower(strings.TrimSpace(model))
+	if idx := strings.LastIndex(m, "/"); idx >= 0 {
+		m = m[idx+1:]
+	}
+	best := ""
+	enc := defaultEncoding
+	for prefix, name := range modelEncodings {
+		if strings.HasPrefix(m, prefix) && len(prefix) > len(best) {
+			best = prefix
+			enc = name
+		}
+	}
+	return enc
+}
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = map[string]*encodingEntry{} // keyed by rank file path
+)
+
+// encodingEntry is a loaded, failed or still-downloading rank file. ready is
+// closed once enc or err is set.
+type encodingEntry struct {
+	ready chan struct{}
+	enc   *Encoding
+	err   error
+}
+
+// ForModel returns the BPE counter for model when its rank file is available,
+// and the heuristic counter otherwise. It never waits for a download: counts
+// fall back to the heuristic until the file is in place.
+func ForModel(model string) Counter {
+	e, err := encodingEntryFor(EncodingForModel(model))
+	if err != nil {
+		return Heuristic{}
+	}
+	select {
+	case <-e.ready:
+		if e.enc != nil {
+			return e.enc
+		}
+	default:
+	}
+	return Heuristic{}
+}
+
+// Prefetch starts loading the encodings used by models, downloading missing
+// rank files in the background, so later ForModel calls get exact counts.
+func Prefetch(models ...string) {
+	for _, model := range models {
+		_, _ = encodingEntryFor(EncodingForModel(model))
+	}
+}
+
+// LoadEncoding loads and caches the tiktoken rank file <name>.tiktoken from
+// EncodingDir, downloading it there first when it is missing.
+func LoadEncoding(name string) (*Encoding, error) {
+	e, err := encodingEntryFor(name)
+	if err != nil {
+		return nil, err
+	}
+	<-e.ready
+	return e.enc, e.err
+}
+
+func encodingEntryFor(name string) (*encodingEntry, error) {
+	dir, err := EncodingDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, name+".tiktoken")
+
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	if e, ok := encodings[path]; ok {
+		return e, nil
+	}
+	// Failures are cached too so a missing file is only probed (and
+	// downloaded) once per process.
+	e := &encodingEntry{ready: make(chan struct{})}
+	encodings[path] = e
+	url := encodingURL(name)
+	if _, err := os.Stat(path); err == nil || url == "" {
+		e.enc, e.err = ReadEncodingFile(name, path)
+		close(e.ready)
+		return e, nil
+	}
+	want := encodingSHA256[name]
+	go func() {
+		defer close(e.ready)
+		if e.err = downloadEncoding(url, path, want); e.err == nil {
+			e.enc, e.err = ReadEncodingFile(name, path)
+		}
+	}()
+	return e, nil
+}
+
+// defaultEncodingURL is where tiktoken publishes its rank files.
+const defaultEncodingURL = "https://openaipublic.blob.core.windows.net/encodings"
+
+// encodingSHA256 pins the published rank files; only these are downloaded.
+var encodingSHA256 = map[string]string{
+	EncodingCL100K: "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcf88c2a3f53",
+	EncodingO200K:  "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+}
+
+// encodingURL returns the download URL for name, or "" when downloads are
+// disabled or name has no pinned checksum. AGENT_LAUNCH_TOKENIZER_URL
+// overrides the base URL; "off" disables downloads.
+func encodingURL(name string) string {
+	if encodingSHA256[name] == "" {
+		return ""
+	}
+	base := strings.TrimSpace(os.Getenv("AGENT_LAUNCH_TOKENIZER_URL"))
+	switch {
+	case base == "":
+		base = defaultEncodingURL
+	case strings.EqualFold(base, "off"):
+		return ""
+	}
+	return strings.TrimRight(base, "/") + "/" + name + ".tiktoken"
+}
+
+var downloadClient = &http.Client{Timeout: 2 * time.Minute}
+
+// downloadEncoding fetches url, checks it against wantSHA256 and moves it
+// into place at path.
+func downloadEncoding(url, path, wantSHA256 string) error {
+	resp, err := downloadClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	sum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, sum), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("download %s: %w", url, err)
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != wantSHA256 {
+		return fmt.Errorf("download %s: checksum mismatch (got %s)", url, got)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// EncodingDir is where tiktoken rank files are looked up.
+// AGENT_LAUNCH_TOKENIZER_DIR overrides the default ~/.spark/tokenizers.
+func EncodingDir() (string, error) {
+	if dir := strings.TrimSpace(os.Getenv("AGENT_LAUNCH_TOKENIZER_DIR")); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".spark", "tokenizers"), nil
+}
+
+// ReadEncodingFile parses a tiktoken rank file: one "<base64 token> <rank>"
+// pair per line.
+func ReadEncodingFile(name, path string) (*Encoding, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ranks := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: malformed line %q", path, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid token %q", path, fields[0])
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid rank %q", path, fields[1])
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewEncoding(name, ranks), nil
+}
+
+// splitPattern approximates the tiktoken pre-tokenizer. RE2 has no negative
+// lookahead, so the `\s+(?!\S)` alternative is folded into `\s+`; that only
+// shifts the boundary of whitespace runs.
+var splitPattern = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// Encoding is a byte-level BPE vocabulary.
+type Encoding struct {
+	name  string
+	ranks map[string]int
+}
+
+func NewEncoding(name string, ranks map[string]int) *Encoding {
+	return &Encoding{name: name, ranks: ranks}
+}
+
+func (e *Encoding) Name() string { return e.name }
+
+func (e *Encoding) Count(text string) int {
+	n := 0
+	for _, piece := range splitPattern.FindAllString(text, -1) {
+		n += e.countPiece([]byte(piece))
+	}
+	return n
+}
+
+func (e *Encoding) countPiece(piece []byte) int {
+	if _, ok := e.ranks[string(piece)]; ok {
+		return 1
+	}
+	// parts holds the start offsets of the current tokens plus the end offset.
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+	for len(parts) > 2 {
+		bestRank := math.MaxInt
+		bestIdx := -1
+		for i := 0; i+2 < len(parts); i++ {
+			rank, ok := e.ranks[string(piece[parts[i]:parts[i+2]])]
+			if ok && rank < bestRank {
+				bestRank = rank
+				bestIdx = i
+			}
+		}
+		if bestIdx < 0 {
+			break
+		}
+		parts = append(parts[:bestIdx+1], parts[bestIdx+2:]...)
+	}
+	return len(parts) - 1
+}
+
+// Heuristic estimates tokens without a vocabulary: roughly four ASCII
+// characters per token, one token per CJK character and two bytes per token
+// for other scripts.
+type Heuristic struct{}
+
+func (Heuristic) Name() string { return "heuristic" }
+
+func (Heuristic) Count(text string) int {
+	ascii, cjk, other := 0, 0, 0
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf:
+			ascii++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			cjk++
+		default:
+			other += utf8.RuneLen(r)
+		}
+	}
+	return (ascii+3)/4 + cjk + (other+1)/2
+}
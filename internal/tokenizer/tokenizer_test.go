@@ -0,0 +1,160 @@
+package tokenizer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEncodingForModel(t *testing.T) {
+	cases := map[string]string{
+		"gpt-4o-mini":          EncodingO200K,
+		"openai/gpt-4.1":       EncodingO200K,
+		"gpt-4-turbo":          EncodingCL100K,
+		"gpt-3.5-turbo":        EncodingCL100K,
+		"o3-mini":              EncodingO200K,
+		"deepseek-chat":        defaultEncoding,
+		"qwen/qwen3-coder:32b": defaultEncoding,
+	}
+	for model, want := range cases {
+		if got := EncodingForModel(model); got != want {
+			t.Fatalf("EncodingForModel(%q)=%q want %q", model, got, want)
+		}
+	}
+}
+
+func TestEncodingCountMergesByRank(t *testing.T) {
+	ranks := map[string]int{}
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	ranks["he"] = 256
+	ranks["ll"] = 257
+	ranks["hell"] = 258
+	ranks["hello"] = 259
+	ranks[" w"] = 261
+	ranks["or"] = 262
+	ranks[" wor"] = 263
+	enc := NewEncoding("test", ranks)
+
+	if got := enc.Count("hello"); got != 1 {
+		t.Fatalf("expected whole-word token, got %d", got)
+	}
+	// " world" -> " w" + "or" -> " wor" + "l" + "d"
+	if got := enc.Count(" world"); got != 3 {
+		t.Fatalf("expected 3 tokens for ' world', got %d", got)
+	}
+	if got := enc.Count("hello world"); got != 4 {
+		t.Fatalf("expected 4 tokens, got %d", got)
+	}
+}
+
+func TestReadEncodingFileAndForModel(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AGENT_LAUNCH_TOKENIZER_DIR", dir)
+	t.Setenv("AGENT_LAUNCH_TOKENIZER_URL", "off")
+	var lines []string
+	for b := 0; b < 256; b++ {
+		lines = append(lines, fmt.Sprintf("%s %d", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b))
+	}
+	lines = append(lines, fmt.Sprintf("%s 256", base64.StdEncoding.EncodeToString([]byte("hi"))))
+	if err := os.WriteFile(filepath.Join(dir, EncodingO200K+".tiktoken"), []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := ForModel("gpt-4o")
+	if c.Name() != EncodingO200K {
+		t.Fatalf("expected o200k counter, got %s", c.Name())
+	}
+	if got := c.Count("hi"); got != 1 {
+		t.Fatalf("expected 1 token, got %d", got)
+	}
+	if c := ForModel("gpt-4-turbo"); c.Name() != "heuristic" {
+		t.Fatalf("expected heuristic fallback without rank file, got %s", c.Name())
+	}
+}
+
+func TestLoadEncodingDownloadsAndCachesRankFile(t *testing.T) {
+	var lines []string
+	for b := 0; b < 256; b++ {
+		lines = append(lines, fmt.Sprintf("%s %d", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b))
+	}
+	lines = append(lines, fmt.Sprintf("%s 256", base64.StdEncoding.EncodeToString([]byte("hi"))))
+	rankFile := []byte(strings.Join(lines, "\n"))
+	sum := sha256.Sum256(rankFile)
+	prev := encodingSHA256[EncodingCL100K]
+	encodingSHA256[EncodingCL100K] = hex.EncodeToString(sum[:])
+	t.Cleanup(func() { encodingSHA256[EncodingCL100K] = prev })
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/encodings/"+EncodingCL100K+".tiktoken" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(rankFile)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	t.Setenv("AGENT_LAUNCH_TOKENIZER_DIR", dir)
+	t.Setenv("AGENT_LAUNCH_TOKENIZER_URL", srv.URL+"/encodings/")
+
+	enc, err := LoadEncoding(EncodingCL100K)
+	if err != nil {
+		t.Fatalf("load encoding: %v", err)
+	}
+	if got := enc.Count("hi"); got != 1 {
+		t.Fatalf("expected 1 token, got %d", got)
+	}
+	if c := ForModel("gpt-4-turbo"); c.Name() != EncodingCL100K {
+		t.Fatalf("expected downloaded counter, got %s", c.Name())
+	}
+	if _, err := os.Stat(filepath.Join(dir, EncodingCL100K+".tiktoken")); err != nil {
+		t.Fatalf("expected rank file cached on disk: %v", err)
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected a single download, got %d", hits.Load())
+	}
+}
+
+func TestLoadEncodingRejectsChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("aGk= 0\n"))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	t.Setenv("AGENT_LAUNCH_TOKENIZER_DIR", dir)
+	t.Setenv("AGENT_LAUNCH_TOKENIZER_URL", srv.URL)
+
+	if _, err := LoadEncoding(EncodingO200K); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if c := ForModel("gpt-4o"); c.Name() != "heuristic" {
+		t.Fatalf("expected heuristic fallback, got %s", c.Name())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected no files left behind, got %v", entries)
+	}
+}
+
+func TestHeuristicCount(t *testing.T) {
+	h := Heuristic{}
+	if got := h.Count("abcdefgh"); got != 2 {
+		t.Fatalf("expected 2 tokens for 8 ascii chars, got %d", got)
+	}
+	if got := h.Count("你好世界"); got != 4 {
+		t.Fatalf("expected one token per CJK char, got %d", got)
+	}
+	if got := h.Count(""); got != 0 {
+		t.Fatalf("expected 0 tokens for empty text, got %d", got)
+	}
+}
@@ -35,10 +35,8 @@ func TestModelConnection(profile *config.Profile, model string) TestResult {
 		baseURL = "https://" + baseURL
 	}
 
-	// Construct chat completions endpoint
-	endpoint := strings.TrimSuffix(baseURL, "/") + "/chat/completions"
-
 	apiKey := strings.TrimSpace(profile.OpenAIAPIKey)
+	azure := strings.EqualFold(strings.TrimSpace(profile.Provider), config.ProviderAzure)
 
 	// Build minimal test request
 	testModel := model
@@ -52,6 +50,14 @@ func TestModelConnection(profile *config.Profile, model string) TestResult {
 		}
 	}
 
+	// Construct chat completions endpoint
+	endpoint := strings.TrimSuffix(baseURL, "/") + "/chat/completions"
+	if azure {
+		azureProfile := *profile
+		azureProfile.OpenAIBaseURL = baseURL
+		endpoint = azureProfile.AzureChatCompletionsURL(testModel)
+	}
+
 	reqBody := map[string]interface{}{
 		"model": testModel,
 		"messages": []map[string]string{
@@ -75,7 +81,9 @@ func TestModelConnection(profile *config.Profile, model string) TestResult {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if apiKey != "" {
+	if apiKey != "" && azure {
+		req.Header.Set("api-key", apiKey)
+	} else if apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+apiKey)
 	}
 	if profile.OpenAIOrg != "" {
@@ -148,15 +148,16 @@ func (m *pmModel) testConnection() tea.Cmd {
 		}
 	}
 
-	profileCopy := &config.Profile{
-		OpenAIBaseURL: strings.TrimSpace(m.fields[pmFieldOpenAIBaseURL].value),
-		OpenAIAPIKey:  strings.TrimSpace(m.fields[pmFieldOpenAIAPIKey].value),
-		Models:        parseCSVModels(m.fields[pmFieldModelsCSV].value),
-		DefaultModel:  model,
-	}
+	// Start from the saved profile so settings without a form field, such as
+	// the provider and Azure deployments, still apply.
+	profileCopy := *m.cfg.Profiles[name]
+	profileCopy.OpenAIBaseURL = strings.TrimSpace(m.fields[pmFieldOpenAIBaseURL].value)
+	profileCopy.OpenAIAPIKey = strings.TrimSpace(m.fields[pmFieldOpenAIAPIKey].value)
+	profileCopy.Models = parseCSVModels(m.fields[pmFieldModelsCSV].value)
+	profileCopy.DefaultModel = model
 
 	return func() tea.Msg {
-		result := TestModelConnection(profileCopy, model)
+		result := TestModelConnection(&profileCopy, model)
 		return testResultMsg{result: result}
 	}
 }
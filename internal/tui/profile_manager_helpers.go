@@ -78,6 +78,12 @@ func (m *pmModel) loadSelectedProfileFields() {
 func detectProviderType(p *config.Profile) string {
 	base := strings.ToLower(strings.TrimSpace(p.OpenAIBaseURL))
 	switch {
+	case strings.EqualFold(strings.TrimSpace(p.Provider), config.ProviderGemini):
+		return "Gemini"
+	case strings.EqualFold(strings.TrimSpace(p.Provider), config.ProviderAzure):
+		return "Azure OpenAI"
+	case strings.EqualFold(strings.TrimSpace(p.Provider), config.ProviderOllama):
+		return "Ollama (native)"
 	case strings.Contains(base, "localhost:11434") || strings.Contains(base, "127.0.0.1:11434"):
 		return "Ollama"
 	case base == "https://api.openai.com/v1" || base == "":